@@ -0,0 +1,126 @@
+package grab
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// archiveWriter moves finished downloads into a single tar or zip archive,
+// written sequentially so it can go to a pipe or object storage upload.
+type archiveWriter struct {
+	mu   sync.Mutex
+	path string
+	root string // Entries are named relative to root
+	file *os.File
+	zip  *zip.Writer
+	tar  *tar.Writer
+	gz   *gzip.Writer // Compresses tar for .tar.gz and .tgz
+}
+
+// openArchive creates the archive at path, its format chosen by the extension:
+// .zip, .tar, .tar.gz or .tgz. Entries are named after their path below root.
+func openArchive(path, root string) (*archiveWriter, error) {
+	name := strings.ToLower(path)
+	isZip := strings.HasSuffix(name, ".zip")
+	isTar := strings.HasSuffix(name, ".tar")
+	isTarGz := strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz")
+	if !isZip && !isTar && !isTarGz {
+		return nil, fmt.Errorf("unsupported archive format %s, use .zip, .tar, .tar.gz or .tgz", path)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create archive directory: %w", err)
+	}
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create archive: %w", err)
+	}
+
+	a := &archiveWriter{path: path, root: root, file: file}
+	switch {
+	case isZip:
+		a.zip = zip.NewWriter(file)
+	case isTar:
+		a.tar = tar.NewWriter(file)
+	default:
+		a.gz = gzip.NewWriter(file)
+		a.tar = tar.NewWriter(a.gz)
+	}
+	return a, nil
+}
+
+// add writes the file at path as an entry of the archive and removes it.
+func (a *archiveWriter) add(path string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	name, err := filepath.Rel(a.root, path)
+	if err != nil || strings.HasPrefix(name, "..") {
+		name = filepath.Base(path)
+	}
+	name = filepath.ToSlash(name)
+
+	if a.zip != nil {
+		header, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		header.Method = zip.Store // Media is already compressed
+		w, err := a.zip.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.Copy(w, f); err != nil {
+			return err
+		}
+	} else {
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+		if err := a.tar.WriteHeader(header); err != nil {
+			return err
+		}
+		if _, err := io.Copy(a.tar, f); err != nil {
+			return err
+		}
+	}
+	f.Close()
+	return os.Remove(path)
+}
+
+// Close writes the index or trailer of the archive and closes it.
+func (a *archiveWriter) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	var errs []error
+	if a.zip != nil {
+		errs = append(errs, a.zip.Close())
+	}
+	if a.tar != nil {
+		errs = append(errs, a.tar.Close())
+	}
+	if a.gz != nil {
+		errs = append(errs, a.gz.Close())
+	}
+	errs = append(errs, a.file.Close())
+	return errors.Join(errs...)
+}
@@ -0,0 +1,189 @@
+package aria2
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/hydrz/grab"
+)
+
+// newTestServer returns an RPC server downloading into dir, with a running
+// queue, and a file server with a single file.
+func newTestServer(t *testing.T, dir, secret string) (rpc, files *httptest.Server) {
+	t.Helper()
+	files = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.ServeContent(w, r, "clip.mp4", time.Time{}, bytes.NewReader(bytes.Repeat([]byte("grab"), 1024)))
+	}))
+	t.Cleanup(files.Close)
+
+	option := *grab.DefaultOptions
+	option.OutputPath = dir
+	option.Silent = true
+	option.NoCache = true
+	ctx := grab.NewContext(context.Background(), option)
+	queue := grab.NewQueue(ctx, 2)
+	runCtx, cancel := context.WithCancel(context.Background())
+	go queue.Run(runCtx)
+	t.Cleanup(cancel)
+
+	rpc = httptest.NewServer(NewServer(ctx, queue, secret))
+	t.Cleanup(rpc.Close)
+	return rpc, files
+}
+
+// call posts a JSON-RPC request and returns the decoded response.
+func call(t *testing.T, url, method string, params ...any) (result json.RawMessage, rpcErr *rpcError) {
+	t.Helper()
+	body, _ := json.Marshal(map[string]any{"jsonrpc": "2.0", "id": "1", "method": method, "params": params})
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("%s: %v", method, err)
+	}
+	defer resp.Body.Close()
+	var r struct {
+		Result json.RawMessage `json:"result"`
+		Error  *rpcError       `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		t.Fatalf("%s: invalid response: %v", method, err)
+	}
+	return r.Result, r.Error
+}
+
+// TestAddURI verifies a download added with aria2.addUri completes and is reported.
+func TestAddURI(t *testing.T) {
+	dir := t.TempDir()
+	rpc, files := newTestServer(t, dir, "s3cret")
+
+	if _, rpcErr := call(t, rpc.URL, "aria2.addUri", []string{files.URL + "/clip.mp4"}); rpcErr == nil || rpcErr.Message != "Unauthorized" {
+		t.Fatalf("addUri without token error = %v, want Unauthorized", rpcErr)
+	}
+	result, rpcErr := call(t, rpc.URL, "aria2.addUri", "token:s3cret", []string{files.URL + "/clip.mp4"})
+	if rpcErr != nil {
+		t.Fatalf("addUri error: %v", rpcErr)
+	}
+	var gid string
+	json.Unmarshal(result, &gid)
+	if len(gid) != 16 {
+		t.Fatalf("addUri GID = %q", gid)
+	}
+
+	var status map[string]any
+	for deadline := time.Now().Add(10 * time.Second); ; {
+		result, rpcErr = call(t, rpc.URL, "aria2.tellStatus", "token:s3cret", gid)
+		if rpcErr != nil {
+			t.Fatalf("tellStatus error: %v", rpcErr)
+		}
+		json.Unmarshal(result, &status)
+		if status["status"] == statusComplete || status["status"] == statusError || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if status["status"] != statusComplete || status["totalLength"] != "4096" || status["completedLength"] != "4096" {
+		t.Fatalf("tellStatus = %v", status)
+	}
+	if data, err := os.ReadFile(filepath.Join(dir, "clip.mp4")); err != nil || len(data) != 4096 {
+		t.Errorf("downloaded file: %d bytes, %v", len(data), err)
+	}
+
+	result, _ = call(t, rpc.URL, "aria2.tellStopped", "token:s3cret", 0, 10, []string{"gid", "status"})
+	var stopped []map[string]any
+	json.Unmarshal(result, &stopped)
+	if len(stopped) != 1 || len(stopped[0]) != 2 || stopped[0]["gid"] != gid {
+		t.Errorf("tellStopped = %s", result)
+	}
+	if _, rpcErr := call(t, rpc.URL, "aria2.pause", "token:s3cret", gid); rpcErr == nil {
+		t.Errorf("pause of a complete download should fail")
+	}
+}
+
+// TestMulticall verifies system.multicall wraps results and errors per call.
+func TestMulticall(t *testing.T) {
+	rpc, _ := newTestServer(t, t.TempDir(), "")
+	result, rpcErr := call(t, rpc.URL, "system.multicall", []map[string]any{
+		{"methodName": "aria2.getGlobalStat", "params": []any{}},
+		{"methodName": "aria2.tellStatus", "params": []any{"0123456789abcdef"}},
+		{"methodName": "aria2.nope", "params": []any{}},
+	})
+	if rpcErr != nil {
+		t.Fatalf("multicall error: %v", rpcErr)
+	}
+	var results []json.RawMessage
+	json.Unmarshal(result, &results)
+	if len(results) != 3 || !strings.Contains(string(results[0]), `"numActive":"0"`) ||
+		!strings.Contains(string(results[1]), "not found") || !strings.Contains(string(results[2]), `"code":1`) {
+		t.Errorf("multicall = %s", result)
+	}
+
+	if _, rpcErr := call(t, rpc.URL, "aria2.nope"); rpcErr == nil || rpcErr.Code != codeMethodNotFound {
+		t.Errorf("unknown method error = %v", rpcErr)
+	}
+}
+
+// TestOrigin verifies servers without secret only answer web pages of the
+// local machine, over HTTP and WebSocket, and don't allow every origin.
+func TestOrigin(t *testing.T) {
+	rpc, _ := newTestServer(t, t.TempDir(), "")
+	body := `{"jsonrpc":"2.0","id":"1","method":"aria2.getVersion","params":[]}`
+	tests := []struct {
+		origin     string
+		wantStatus int
+		wantAllow  string
+	}{
+		{"", http.StatusOK, ""},
+		{"http://localhost:8080", http.StatusOK, "http://localhost:8080"},
+		{"http://127.0.0.1", http.StatusOK, "http://127.0.0.1"},
+		{"https://evil.example", http.StatusForbidden, ""},
+		{"http://evil.example:6800", http.StatusForbidden, ""}, // Name rebound to 127.0.0.1
+		{"null", http.StatusForbidden, ""},
+	}
+	for _, tt := range tests {
+		req, _ := http.NewRequest(http.MethodPost, rpc.URL, strings.NewReader(body))
+		if tt.origin != "" {
+			req.Header.Set("Origin", tt.origin)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tt.wantStatus || resp.Header.Get("Access-Control-Allow-Origin") != tt.wantAllow {
+			t.Errorf("Origin %q: status %d, allowed origin %q, want %d, %q", tt.origin, resp.StatusCode,
+				resp.Header.Get("Access-Control-Allow-Origin"), tt.wantStatus, tt.wantAllow)
+		}
+	}
+
+	wsURL := "ws" + strings.TrimPrefix(rpc.URL, "http")
+	if ws, err := websocket.Dial(wsURL, "", "https://evil.example"); err == nil {
+		ws.Close()
+		t.Errorf("WebSocket handshake from another site accepted")
+	}
+	ws, err := websocket.Dial(wsURL, "", "http://localhost")
+	if err != nil {
+		t.Fatalf("WebSocket handshake from localhost: %v", err)
+	}
+	ws.Close()
+
+	secured, _ := newTestServer(t, t.TempDir(), "s3cret")
+	req, _ := http.NewRequest(http.MethodPost, secured.URL, strings.NewReader(body))
+	req.Header.Set("Origin", "https://ariang.example")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Access-Control-Allow-Origin") != "*" {
+		t.Errorf("server with secret: status %d, allowed origin %q", resp.StatusCode, resp.Header.Get("Access-Control-Allow-Origin"))
+	}
+}
@@ -0,0 +1,448 @@
+package aria2
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"sort"
+	"strconv"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/version"
+)
+
+// Statuses of aria2 downloads.
+const (
+	statusActive   = "active"
+	statusWaiting  = "waiting"
+	statusPaused   = "paused"
+	statusError    = "error"
+	statusComplete = "complete"
+	statusRemoved  = "removed"
+)
+
+// errMethodNotFound is returned for methods the server doesn't implement.
+var errMethodNotFound = errors.New("no such method")
+
+// methods are the aria2 methods by name, called with the parameters following
+// the secret token.
+var methods = map[string]func(*Server, []json.RawMessage) (any, error){
+	"aria2.addUri":               (*Server).addURI,
+	"aria2.remove":               (*Server).remove,
+	"aria2.forceRemove":          (*Server).remove,
+	"aria2.pause":                (*Server).pause,
+	"aria2.forcePause":           (*Server).pause,
+	"aria2.unpause":              (*Server).unpause,
+	"aria2.tellStatus":           (*Server).tellStatus,
+	"aria2.tellActive":           (*Server).tellActive,
+	"aria2.tellWaiting":          (*Server).tellWaiting,
+	"aria2.tellStopped":          (*Server).tellStopped,
+	"aria2.getGlobalStat":        (*Server).getGlobalStat,
+	"aria2.getVersion":           (*Server).getVersion,
+	"aria2.removeDownloadResult": (*Server).removeDownloadResult,
+	"aria2.purgeDownloadResult":  (*Server).purgeDownloadResult,
+}
+
+// methodNames returns the names of all methods, for system.listMethods.
+func methodNames() []string {
+	names := []string{"system.listMethods", "system.multicall"}
+	for name := range methods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// task is a download added with aria2.addUri.
+type task struct {
+	gid       string
+	uri       string
+	extracted bool     // Set once the medias of uri are queued, or extraction failed
+	jobs      []string // Queue job IDs of the medias of uri
+	paused    bool     // Paused before extraction finished
+	removed   bool
+	err       error // Extraction error
+}
+
+// addURI queues the first URI of params and returns the GID of the download.
+// The other URIs, which aria2 treats as mirrors, and the options are ignored.
+func (s *Server) addURI(params []json.RawMessage) (any, error) {
+	var uris []string
+	if err := param(params, 0, &uris); err != nil || len(uris) == 0 {
+		return nil, errors.New("addUri expects an array of URIs")
+	}
+	t := &task{gid: newGID(), uri: uris[0]}
+	s.mu.Lock()
+	s.tasks[t.gid] = t
+	s.order = append(s.order, t.gid)
+	s.mu.Unlock()
+
+	go s.extract(t)
+	return t.gid, nil
+}
+
+// extract queues the medias of the URI of t.
+func (s *Server) extract(t *task) {
+	name, extractor, medias, err := grab.ExtractURL(s.ctx, t.uri)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t.extracted = true
+	if err != nil {
+		t.err = err
+		return
+	}
+	if t.removed {
+		return
+	}
+	for _, media := range medias {
+		id := s.queue.AddFrom(media, 0, t.uri, name, extractor)
+		if t.paused {
+			s.queue.Pause(id)
+		}
+		t.jobs = append(t.jobs, id)
+	}
+}
+
+// remove cancels the download of a GID.
+func (s *Server) remove(params []json.RawMessage) (any, error) {
+	return s.update(params, func(t *task) error {
+		switch s.status(t) {
+		case statusComplete, statusError, statusRemoved:
+			return fmt.Errorf("GID %s cannot be removed now", t.gid)
+		}
+		t.removed = true
+		for _, id := range t.jobs {
+			s.queue.Cancel(id) // Fails for the jobs already finished
+		}
+		return nil
+	})
+}
+
+// pause stops the download of a GID until it is unpaused.
+func (s *Server) pause(params []json.RawMessage) (any, error) {
+	return s.update(params, func(t *task) error {
+		switch s.status(t) {
+		case statusActive, statusWaiting:
+		default:
+			return fmt.Errorf("GID %s cannot be paused now", t.gid)
+		}
+		t.paused = true
+		for _, id := range t.jobs {
+			s.queue.Pause(id) // Fails for the jobs already finished
+		}
+		return nil
+	})
+}
+
+// unpause queues the paused download of a GID again.
+func (s *Server) unpause(params []json.RawMessage) (any, error) {
+	return s.update(params, func(t *task) error {
+		if s.status(t) != statusPaused {
+			return fmt.Errorf("GID %s cannot be unpaused now", t.gid)
+		}
+		t.paused = false
+		for _, id := range t.jobs {
+			s.queue.Resume(id) // Fails for the jobs that aren't paused
+		}
+		return nil
+	})
+}
+
+// update calls fn with the task of the GID in params and returns the GID.
+func (s *Server) update(params []json.RawMessage, fn func(*task) error) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.taskLocked(params)
+	if err != nil {
+		return nil, err
+	}
+	if err := fn(t); err != nil {
+		return nil, err
+	}
+	return t.gid, nil
+}
+
+// tellStatus returns the status of the download of a GID.
+func (s *Server) tellStatus(params []json.RawMessage) (any, error) {
+	var keys []string
+	param(params, 1, &keys)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.taskLocked(params)
+	if err != nil {
+		return nil, err
+	}
+	return filterKeys(s.describe(t), keys), nil
+}
+
+// tellActive returns the status of the active downloads.
+func (s *Server) tellActive(params []json.RawMessage) (any, error) {
+	var keys []string
+	param(params, 0, &keys)
+	return s.list(0, -1, keys, statusActive), nil
+}
+
+// tellWaiting returns the status of waiting and paused downloads, num of
+// them from offset.
+func (s *Server) tellWaiting(params []json.RawMessage) (any, error) {
+	return s.tellRange(params, statusWaiting, statusPaused)
+}
+
+// tellStopped returns the status of finished, failed and removed downloads,
+// num of them from offset.
+func (s *Server) tellStopped(params []json.RawMessage) (any, error) {
+	return s.tellRange(params, statusComplete, statusError, statusRemoved)
+}
+
+// tellRange lists the downloads in statuses for the offset, num and keys of params.
+func (s *Server) tellRange(params []json.RawMessage, statuses ...string) (any, error) {
+	var offset, num int
+	var keys []string
+	if param(params, 0, &offset) != nil || param(params, 1, &num) != nil {
+		return nil, errors.New("expected offset and num")
+	}
+	param(params, 2, &keys)
+	return s.list(offset, num, keys, statuses...), nil
+}
+
+// list returns the status of num downloads in statuses, all if num is
+// negative, from offset. A negative offset counts from the last download
+// backwards, as in aria2.
+func (s *Server) list(offset, num int, keys []string, statuses ...string) []map[string]any {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var matching []*task
+	for _, gid := range s.order {
+		t := s.tasks[gid]
+		if slices.Contains(statuses, s.status(t)) {
+			matching = append(matching, t)
+		}
+	}
+	if offset < 0 {
+		slices.Reverse(matching)
+		offset = -offset - 1
+	}
+	result := []map[string]any{}
+	for i := offset; i < len(matching) && (num < 0 || len(result) < num); i++ {
+		result = append(result, filterKeys(s.describe(matching[i]), keys))
+	}
+	return result
+}
+
+// getGlobalStat returns the overall download speed and the number of downloads by status.
+func (s *Server) getGlobalStat(params []json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var speed int64
+	var active, waiting, stopped int
+	for _, t := range s.tasks {
+		switch s.status(t) {
+		case statusActive:
+			active++
+			for _, snapshot := range s.snapshots(t) {
+				speed += int64(snapshot.Speed)
+			}
+		case statusWaiting, statusPaused:
+			waiting++
+		default:
+			stopped++
+		}
+	}
+	return map[string]any{
+		"downloadSpeed":   strconv.FormatInt(speed, 10),
+		"uploadSpeed":     "0",
+		"numActive":       strconv.Itoa(active),
+		"numWaiting":      strconv.Itoa(waiting),
+		"numStopped":      strconv.Itoa(stopped),
+		"numStoppedTotal": strconv.Itoa(stopped),
+	}, nil
+}
+
+// getVersion returns the version of grab.
+func (s *Server) getVersion(params []json.RawMessage) (any, error) {
+	return map[string]any{"version": version.Version, "enabledFeatures": []string{}}, nil
+}
+
+// removeDownloadResult forgets the stopped download of a GID.
+func (s *Server) removeDownloadResult(params []json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.taskLocked(params)
+	if err != nil {
+		return nil, err
+	}
+	switch s.status(t) {
+	case statusComplete, statusError, statusRemoved:
+	default:
+		return nil, fmt.Errorf("GID %s is not stopped", t.gid)
+	}
+	s.forgetLocked(t.gid)
+	return "OK", nil
+}
+
+// purgeDownloadResult forgets all stopped downloads.
+func (s *Server) purgeDownloadResult(params []json.RawMessage) (any, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, gid := range slices.Clone(s.order) {
+		switch s.status(s.tasks[gid]) {
+		case statusComplete, statusError, statusRemoved:
+			s.forgetLocked(gid)
+		}
+	}
+	return "OK", nil
+}
+
+// forgetLocked removes the download of gid.
+func (s *Server) forgetLocked(gid string) {
+	delete(s.tasks, gid)
+	s.order = slices.DeleteFunc(s.order, func(g string) bool { return g == gid })
+}
+
+// taskLocked returns the task of the GID that is the first parameter of params.
+func (s *Server) taskLocked(params []json.RawMessage) (*task, error) {
+	var gid string
+	if err := param(params, 0, &gid); err != nil {
+		return nil, errors.New("expected a GID")
+	}
+	t, ok := s.tasks[gid]
+	if !ok {
+		return nil, fmt.Errorf("GID %s is not found", gid)
+	}
+	return t, nil
+}
+
+// status returns the aria2 status of t, summing up those of its jobs.
+func (s *Server) status(t *task) string {
+	switch {
+	case t.err != nil:
+		return statusError
+	case t.removed:
+		return statusRemoved
+	case !t.extracted && t.paused:
+		return statusPaused
+	case !t.extracted:
+		return statusWaiting
+	}
+	counts := make(map[grab.JobStatus]int)
+	for _, id := range t.jobs {
+		if job, err := s.queue.Job(id); err == nil {
+			counts[job.Status]++
+		}
+	}
+	switch {
+	case counts[grab.JobRunning] > 0:
+		return statusActive
+	case counts[grab.JobQueued] > 0:
+		return statusWaiting
+	case counts[grab.JobPaused] > 0:
+		return statusPaused
+	case counts[grab.JobFailed] > 0:
+		return statusError
+	case counts[grab.JobCanceled] > 0:
+		return statusRemoved
+	}
+	return statusComplete
+}
+
+// snapshots returns the transfers of the streams of all jobs of t.
+func (s *Server) snapshots(t *task) []grab.TransferSnapshot {
+	var snapshots []grab.TransferSnapshot
+	for _, id := range t.jobs {
+		jobSnapshots, _ := s.queue.Snapshot(id)
+		snapshots = append(snapshots, jobSnapshots...)
+	}
+	return snapshots
+}
+
+// describe returns the aria2 status structure of t. Numbers are strings, as in aria2.
+func (s *Server) describe(t *task) map[string]any {
+	status := s.status(t)
+	var total, completed, speed int64
+	files := []map[string]any{}
+	for i, snapshot := range s.snapshots(t) {
+		total += snapshot.Total
+		completed += snapshot.Current
+		if status == statusActive {
+			speed += int64(snapshot.Speed)
+		}
+		path := snapshot.Output
+		if path == "" {
+			path = snapshot.Title
+		}
+		files = append(files, map[string]any{
+			"index":           strconv.Itoa(i + 1),
+			"path":            path,
+			"length":          strconv.FormatInt(snapshot.Total, 10),
+			"completedLength": strconv.FormatInt(snapshot.Current, 10),
+			"selected":        "true",
+			"uris":            []map[string]string{{"uri": snapshot.URL, "status": "used"}},
+		})
+	}
+	if len(files) == 0 {
+		files = append(files, map[string]any{
+			"index": "1", "path": "", "length": "0", "completedLength": "0", "selected": "true",
+			"uris": []map[string]string{{"uri": t.uri, "status": "waiting"}},
+		})
+	}
+
+	result := map[string]any{
+		"gid":             t.gid,
+		"status":          status,
+		"totalLength":     strconv.FormatInt(total, 10),
+		"completedLength": strconv.FormatInt(completed, 10),
+		"uploadLength":    "0",
+		"downloadSpeed":   strconv.FormatInt(speed, 10),
+		"uploadSpeed":     "0",
+		"connections":     "0",
+		"numPieces":       "0",
+		"pieceLength":     "0",
+		"dir":             s.ctx.Option().OutputPath,
+		"files":           files,
+	}
+	if status == statusActive {
+		result["connections"] = "1"
+	}
+	if status == statusError {
+		result["errorCode"] = "1"
+		result["errorMessage"] = s.errorMessage(t)
+	}
+	return result
+}
+
+// errorMessage returns why t failed.
+func (s *Server) errorMessage(t *task) string {
+	if t.err != nil {
+		return t.err.Error()
+	}
+	for _, id := range t.jobs {
+		if job, err := s.queue.Job(id); err == nil && job.Err != nil {
+			return job.Err.Error()
+		}
+	}
+	return ""
+}
+
+// filterKeys returns the entries of status named in keys, all if keys is empty.
+func filterKeys(status map[string]any, keys []string) map[string]any {
+	if len(keys) == 0 {
+		return status
+	}
+	filtered := make(map[string]any, len(keys))
+	for _, key := range keys {
+		if value, ok := status[key]; ok {
+			filtered[key] = value
+		}
+	}
+	return filtered
+}
+
+// param decodes the parameter i of params into v. It fails if it is missing.
+func param(params []json.RawMessage, i int, v any) error {
+	if i >= len(params) {
+		return fmt.Errorf("missing parameter %d", i+1)
+	}
+	return json.Unmarshal(params[i], v)
+}
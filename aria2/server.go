@@ -0,0 +1,286 @@
+// Package aria2 serves the JSON-RPC interface of aria2 on top of a grab.Queue,
+// so aria2 frontends such as AriaNg or browser extensions can drive grab.
+//
+// Each aria2.addUri call becomes a download identified by a GID. Its URL is
+// extracted in the background and every media found is queued as a job of
+// the download, whose status, lengths and files are the sum of its jobs.
+package aria2
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"golang.org/x/net/websocket"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/utils"
+)
+
+// Error codes of JSON-RPC responses. aria2 itself reports every failure of a
+// method with code 1.
+const (
+	codeParseError     = -32700
+	codeInvalidRequest = -32600
+	codeMethodNotFound = -32601
+	codeFailure        = 1
+)
+
+// maxRequestSize limits the body of HTTP requests.
+const maxRequestSize = 1 << 20
+
+// errUnauthorized is returned for calls without the secret token of the server.
+var errUnauthorized = errors.New("Unauthorized")
+
+// request is a JSON-RPC 2.0 request.
+type request struct {
+	JSONRPC string            `json:"jsonrpc"`
+	ID      json.RawMessage   `json:"id,omitempty"`
+	Method  string            `json:"method"`
+	Params  []json.RawMessage `json:"params"`
+}
+
+// response is a JSON-RPC 2.0 response.
+type response struct {
+	JSONRPC string          `json:"jsonrpc"`
+	ID      json.RawMessage `json:"id"`
+	Result  json.RawMessage `json:"result,omitempty"` // Encoded, as null and empty results are valid
+	Error   *rpcError       `json:"error,omitempty"`
+}
+
+// rpcError is the error of a JSON-RPC response.
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// Server answers aria2 JSON-RPC calls over HTTP POST and WebSocket.
+type Server struct {
+	ctx    *grab.Context
+	queue  *grab.Queue
+	secret string
+
+	mu    sync.Mutex
+	tasks map[string]*task // Downloads by GID
+	order []string         // GIDs in the order downloads were added
+}
+
+// NewServer creates a server queuing downloads on queue. Calls must pass
+// "token:<secret>" as first parameter, as with aria2's --rpc-secret, unless
+// secret is empty, in which case only web pages of the local machine may call
+// it. The queue has to be run by the caller.
+func NewServer(ctx *grab.Context, queue *grab.Queue, secret string) *Server {
+	return &Server{
+		ctx:    ctx,
+		queue:  queue,
+		secret: secret,
+		tasks:  make(map[string]*task),
+	}
+}
+
+// ServeHTTP handles JSON-RPC requests, single or batched, posted to the server
+// or sent as messages of a WebSocket connection. Cross-origin requests of web
+// pages are allowed as frontends are usually served from another origin, from
+// any origin if the server has a secret and from the local machine otherwise,
+// see allowOrigin.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	origin := r.Header.Get("Origin")
+	if !s.allowOrigin(origin) {
+		http.Error(w, "origin not allowed, set a secret to accept other origins", http.StatusForbidden)
+		return
+	}
+	if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		websocket.Server{Handler: s.serveWebSocket, Handshake: s.checkWebSocketOrigin}.ServeHTTP(w, r)
+		return
+	}
+
+	if s.secret != "" {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+	} else if origin != "" {
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Add("Vary", "Origin")
+	}
+	switch r.Method {
+	case http.MethodOptions:
+		w.Header().Set("Access-Control-Allow-Methods", "POST, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		w.WriteHeader(http.StatusNoContent)
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", "POST, OPTIONS")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxRequestSize))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json-rpc")
+	w.Write(s.handle(body))
+}
+
+// allowOrigin reports whether web pages of origin may call the server. Any page
+// may if the server has a secret, which pages can't guess. Otherwise only pages
+// served from the local machine may, so that other sites the user visits, or
+// their names rebound to a local address, can't add downloads. Requests
+// without Origin don't come from web pages.
+func (s *Server) allowOrigin(origin string) bool {
+	if origin == "" || s.secret != "" {
+		return true
+	}
+	u, err := url.Parse(origin)
+	return err == nil && utils.IsLoopbackHost(u.Host)
+}
+
+// checkWebSocketOrigin refuses WebSocket handshakes from origins allowOrigin
+// refuses.
+func (s *Server) checkWebSocketOrigin(config *websocket.Config, r *http.Request) error {
+	if !s.allowOrigin(r.Header.Get("Origin")) {
+		return errors.New("origin not allowed")
+	}
+	return nil
+}
+
+// serveWebSocket answers each message of ws as an HTTP request body.
+func (s *Server) serveWebSocket(ws *websocket.Conn) {
+	defer ws.Close()
+	for {
+		var message []byte
+		if err := websocket.Message.Receive(ws, &message); err != nil {
+			return
+		}
+		if err := websocket.Message.Send(ws, string(s.handle(message))); err != nil {
+			return
+		}
+	}
+}
+
+// handle answers the single or batched requests encoded in body.
+func (s *Server) handle(body []byte) []byte {
+	var data any
+	if trimmed := strings.TrimSpace(string(body)); strings.HasPrefix(trimmed, "[") {
+		var batch []request
+		if err := json.Unmarshal(body, &batch); err != nil {
+			data = errorResponse(nil, codeParseError, err.Error())
+		} else {
+			responses := make([]response, len(batch))
+			for i, req := range batch {
+				responses[i] = s.call(req)
+			}
+			data = responses
+		}
+	} else {
+		var req request
+		if err := json.Unmarshal(body, &req); err != nil {
+			data = errorResponse(nil, codeParseError, err.Error())
+		} else {
+			data = s.call(req)
+		}
+	}
+	encoded, _ := json.Marshal(data)
+	return encoded
+}
+
+// call runs the method of req.
+func (s *Server) call(req request) response {
+	if req.Method == "" {
+		return errorResponse(req.ID, codeInvalidRequest, "missing method")
+	}
+	result, err := s.invoke(req.Method, req.Params)
+	if errors.Is(err, errMethodNotFound) {
+		return errorResponse(req.ID, codeMethodNotFound, "No such method: "+req.Method)
+	} else if err != nil {
+		return errorResponse(req.ID, codeFailure, err.Error())
+	}
+	encoded, err := json.Marshal(result)
+	if err != nil {
+		return errorResponse(req.ID, codeFailure, err.Error())
+	}
+	return response{JSONRPC: "2.0", ID: req.ID, Result: encoded}
+}
+
+// invoke checks the secret token of params and runs method with the rest.
+func (s *Server) invoke(method string, params []json.RawMessage) (any, error) {
+	if method == "system.listMethods" {
+		return methodNames(), nil
+	}
+	if method == "system.multicall" {
+		return s.multicall(params)
+	}
+	params, err := s.authorize(params)
+	if err != nil {
+		return nil, err
+	}
+	fn, ok := methods[method]
+	if !ok {
+		return nil, errMethodNotFound
+	}
+	return fn(s, params)
+}
+
+// authorize strips the "token:" parameter from params, failing if it doesn't
+// hold the secret of the server.
+func (s *Server) authorize(params []json.RawMessage) ([]json.RawMessage, error) {
+	var token string
+	if len(params) > 0 {
+		if json.Unmarshal(params[0], &token) == nil && strings.HasPrefix(token, "token:") {
+			params = params[1:]
+		} else {
+			token = ""
+		}
+	}
+	if s.secret != "" && token != "token:"+s.secret {
+		return nil, errUnauthorized
+	}
+	return params, nil
+}
+
+// multicall runs the calls of system.multicall, returning the result of each
+// wrapped in an array or its error.
+func (s *Server) multicall(params []json.RawMessage) (any, error) {
+	var calls []struct {
+		MethodName string            `json:"methodName"`
+		Params     []json.RawMessage `json:"params"`
+	}
+	if len(params) != 1 || json.Unmarshal(params[0], &calls) != nil {
+		return nil, errors.New("system.multicall expects an array of calls")
+	}
+	results := make([]any, len(calls))
+	for i, c := range calls {
+		if c.MethodName == "system.multicall" {
+			results[i] = rpcError{Code: codeFailure, Message: "Recursive system.multicall forbidden."}
+			continue
+		}
+		result, err := s.invoke(c.MethodName, c.Params)
+		if err != nil {
+			results[i] = rpcError{Code: codeFailure, Message: err.Error()}
+			continue
+		}
+		results[i] = []any{result}
+	}
+	return results, nil
+}
+
+// errorResponse returns the response of a failed call.
+func errorResponse(id json.RawMessage, code int, message string) response {
+	if id == nil {
+		id = json.RawMessage("null")
+	}
+	return response{JSONRPC: "2.0", ID: id, Error: &rpcError{Code: code, Message: message}}
+}
+
+// newGID returns a random aria2 GID, 16 hex digits.
+func newGID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
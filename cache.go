@@ -0,0 +1,159 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// cacheDirName is the directory of the HTTP cache inside the user cache directory.
+const cacheDirName = "grab/http"
+
+// DefaultCacheDir returns the directory of the HTTP cache used when
+// Option.CacheDir is empty: $XDG_CACHE_HOME/grab/http on Linux, with the
+// platform equivalents elsewhere, or a directory in os.TempDir as a last resort.
+func DefaultCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, filepath.FromSlash(cacheDirName))
+}
+
+// PurgeCache removes every entry of the HTTP cache in dir.
+func PurgeCache(dir string) error {
+	return os.RemoveAll(dir)
+}
+
+// diskCache is an httpcache.Cache keeping one file per response in a directory.
+// Entries older than ttl are evicted when read, and once the directory grows
+// beyond maxSize the oldest entries are removed until it's back under 90% of it.
+type diskCache struct {
+	dir     string
+	maxSize int64         // Maximum total size in bytes, 0 means unlimited
+	ttl     time.Duration // Maximum age of an entry, 0 means forever
+
+	mu   sync.Mutex
+	size int64 // Total size of the entries, -1 until the directory was scanned
+}
+
+// newDiskCache creates a disk cache in dir, which is created on first write.
+func newDiskCache(dir string, maxSize int64, ttl time.Duration) *diskCache {
+	return &diskCache{dir: dir, maxSize: maxSize, ttl: ttl, size: -1}
+}
+
+// path returns the file of the entry for key.
+func (c *diskCache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns the cached response for key, if present and not expired.
+func (c *diskCache) Get(key string) ([]byte, bool) {
+	path := c.path(key)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, false
+	}
+	if c.expired(fi, time.Now()) {
+		c.Delete(key)
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// Set stores the response for key, evicting old entries if the cache is full.
+func (c *diskCache) Set(key string, data []byte) {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(c.dir, ".tmp-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), c.path(key))
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.size >= 0 {
+		c.size += int64(len(data))
+	}
+	if c.maxSize > 0 && (c.size < 0 || c.size > c.maxSize) {
+		c.prune()
+	}
+}
+
+// Delete removes the entry for key.
+func (c *diskCache) Delete(key string) {
+	path := c.path(key)
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if os.Remove(path) == nil {
+		c.mu.Lock()
+		if c.size >= 0 {
+			c.size -= fi.Size()
+		}
+		c.mu.Unlock()
+	}
+}
+
+// expired reports whether the entry described by fi is older than the TTL.
+func (c *diskCache) expired(fi os.FileInfo, now time.Time) bool {
+	return c.ttl > 0 && now.Sub(fi.ModTime()) > c.ttl
+}
+
+// prune removes expired entries, then the oldest ones until the cache is
+// under 90% of its maximum size. It must be called with mu held.
+func (c *diskCache) prune() {
+	entries, err := os.ReadDir(c.dir)
+	if err != nil {
+		return
+	}
+	now := time.Now()
+	files := make([]os.FileInfo, 0, len(entries))
+	var size int64
+	for _, entry := range entries {
+		fi, err := entry.Info()
+		if err != nil || !fi.Mode().IsRegular() {
+			continue
+		}
+		if c.expired(fi, now) {
+			os.Remove(filepath.Join(c.dir, fi.Name()))
+			continue
+		}
+		files = append(files, fi)
+		size += fi.Size()
+	}
+
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	target := c.maxSize / 10 * 9
+	for _, fi := range files {
+		if size <= target {
+			break
+		}
+		if os.Remove(filepath.Join(c.dir, fi.Name())) == nil {
+			size -= fi.Size()
+		}
+	}
+	c.size = size
+}
@@ -0,0 +1,70 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chapterEnd returns the end of chapter i of chapters: its End, else the
+// start of the next chapter, else 0 for the end of the media.
+func chapterEnd(chapters []Chapter, i int) time.Duration {
+	if end := chapters[i].End; end > chapters[i].Start {
+		return end
+	}
+	if i+1 < len(chapters) {
+		return chapters[i+1].Start
+	}
+	return 0
+}
+
+// chapterPath returns the path the chapter number index, from 1, of the file
+// at path is cut to by splitChapters: "<name> - 001 <title>.<ext>".
+func (d *Downloader) chapterPath(path string, index int, title string) string {
+	ext := filepath.Ext(path)
+	name := fmt.Sprintf("%s - %03d", filepath.Base(strings.TrimSuffix(path, ext)), index)
+	if title = d.sanitizeFilename(title); title != "" {
+		name += " " + title
+	}
+	return filepath.Join(filepath.Dir(path), name+ext)
+}
+
+// splitChapters cuts the file at path into one file per chapter of media
+// next to it, without re-encoding, so cuts fall on the keyframe before each
+// chapter start. The file itself is kept. It returns the paths of the
+// chapter files.
+func (d *Downloader) splitChapters(ctx context.Context, media Media, path string) ([]string, error) {
+	ffmpegPath, err := findFFmpeg(d.ctx.option.FFmpegPath)
+	if err != nil {
+		return nil, err
+	}
+	var paths []string
+	for i, chapter := range media.Chapters {
+		chapterPath := d.chapterPath(path, i+1, chapter.Title)
+		args := []string{"-y", "-ss", ffmpegTime(chapter.Start)}
+		if end := chapterEnd(media.Chapters, i); end > 0 {
+			args = append(args, "-t", ffmpegTime(end-chapter.Start))
+		}
+		args = append(args, "-i", path, "-map", "0", "-c", "copy", "-map_chapters", "-1", "-avoid_negative_ts", "make_zero")
+		if chapter.Title != "" {
+			args = append(args, "-metadata", "title="+chapter.Title)
+		}
+		args = append(args, "-metadata", "track="+strconv.Itoa(i+1), chapterPath)
+		output, err := runFFmpeg(ctx, ffmpegPath, args, nil)
+		if err != nil {
+			os.Remove(chapterPath)
+			return paths, fmt.Errorf("ffmpeg failed to cut chapter %d: %v, output: %s", i+1, err, string(output))
+		}
+		paths = append(paths, chapterPath)
+	}
+	return paths, nil
+}
+
+// ffmpegTime formats d as the seconds ffmpeg reads in -ss and -t.
+func ffmpegTime(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', 3, 64)
+}
@@ -0,0 +1,223 @@
+package grab
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const checksumSuffix = ".sha256"
+
+// Keys of Stream.Extra holding the hex encoded digest a download of the
+// stream must match, set by extractors whose site publishes them.
+const (
+	ExtraSHA256 = "sha256"
+	ExtraMD5    = "md5"
+)
+
+// partFilePattern matches the .part and .partN suffixes of unfinished downloads.
+var partFilePattern = regexp.MustCompile(`\.part\d*$`)
+
+// ChecksumStatus describes the outcome of verifying a downloaded file.
+type ChecksumStatus string
+
+const (
+	ChecksumOK         ChecksumStatus = "ok"         // File matches its recorded checksum
+	ChecksumCorrupted  ChecksumStatus = "corrupted"  // File content differs from its recorded checksum
+	ChecksumMissing    ChecksumStatus = "missing"    // Checksum sidecar exists but the file is gone
+	ChecksumIncomplete ChecksumStatus = "incomplete" // Download was never finished (.part file left behind)
+)
+
+// ChecksumResult is the verification result for a single file.
+type ChecksumResult struct {
+	Path   string
+	Status ChecksumStatus
+	Err    error // Set if the file could not be verified
+}
+
+// fileSHA256 returns the hex encoded SHA-256 of the file at path.
+func fileSHA256(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum computes the SHA-256 of path and stores it in a sha256sum
+// compatible sidecar next to it.
+func writeChecksum(path string) error {
+	sum, err := fileSHA256(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash %s: %w", path, err)
+	}
+	line := fmt.Sprintf("%s  %s\n", sum, filepath.Base(path))
+	return os.WriteFile(path+checksumSuffix, []byte(line), 0644)
+}
+
+// readChecksum reads the expected hash from a sidecar written by writeChecksum.
+func readChecksum(sidecar string) (string, error) {
+	f, err := os.Open(sidecar)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	sum, _, _ := strings.Cut(strings.TrimSpace(line), " ")
+	if len(sum) != sha256.Size*2 {
+		return "", fmt.Errorf("malformed checksum file %s", sidecar)
+	}
+	return strings.ToLower(sum), nil
+}
+
+// VerifyDir re-hashes every file under dir that has a checksum sidecar and
+// reports leftover .part files as incomplete downloads.
+func VerifyDir(dir string) ([]ChecksumResult, error) {
+	var results []ChecksumResult
+	incomplete := make(map[string]bool)
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if entry.IsDir() {
+			return nil
+		}
+		switch {
+		case partFilePattern.MatchString(path):
+			// Older versions left one .partN file per chunk, report the target once
+			target := partFilePattern.ReplaceAllString(path, "")
+			if !incomplete[target] {
+				incomplete[target] = true
+				results = append(results, ChecksumResult{Path: target, Status: ChecksumIncomplete})
+			}
+		case strings.HasSuffix(path, checksumSuffix):
+			results = append(results, verifyChecksum(strings.TrimSuffix(path, checksumSuffix)))
+		}
+		return nil
+	})
+	return results, err
+}
+
+// verifyChecksum compares the file at path with its sidecar.
+func verifyChecksum(path string) ChecksumResult {
+	result := ChecksumResult{Path: path}
+	want, err := readChecksum(path + checksumSuffix)
+	if err != nil {
+		result.Status, result.Err = ChecksumCorrupted, err
+		return result
+	}
+	got, err := fileSHA256(path)
+	switch {
+	case os.IsNotExist(err):
+		result.Status = ChecksumMissing
+	case err != nil:
+		result.Status, result.Err = ChecksumCorrupted, err
+	case got != want:
+		result.Status = ChecksumCorrupted
+	default:
+		result.Status = ChecksumOK
+	}
+	return result
+}
+
+// expectedHash is a digest a download must match.
+type expectedHash struct {
+	algo string // ExtraSHA256 or ExtraMD5
+	sum  string // Lower case hex
+}
+
+// parseExpectedHash parses "sha256:<hex>" or "md5:<hex>". Without a prefix the
+// algorithm is told by the length of the digest.
+func parseExpectedHash(s string) (expectedHash, error) {
+	algo, sum, ok := strings.Cut(strings.TrimSpace(s), ":")
+	if !ok {
+		algo, sum = "", algo
+	}
+	sum = strings.ToLower(sum)
+	if _, err := hex.DecodeString(sum); err != nil {
+		return expectedHash{}, fmt.Errorf("malformed hash %q", s)
+	}
+	switch strings.ToLower(algo) {
+	case ExtraSHA256, "sha-256":
+		algo = ExtraSHA256
+	case ExtraMD5:
+		algo = ExtraMD5
+	case "":
+		switch len(sum) {
+		case sha256.Size * 2:
+			algo = ExtraSHA256
+		case md5.Size * 2:
+			algo = ExtraMD5
+		}
+	default:
+		return expectedHash{}, fmt.Errorf("unsupported hash algorithm %q, use sha256 or md5", algo)
+	}
+	if (algo == ExtraSHA256 && len(sum) != sha256.Size*2) || (algo == ExtraMD5 && len(sum) != md5.Size*2) || algo == "" {
+		return expectedHash{}, fmt.Errorf("malformed hash %q", s)
+	}
+	return expectedHash{algo: algo, sum: sum}, nil
+}
+
+// streamHasher hashes a download while it is written, to compare it with the
+// digest its stream expects.
+type streamHasher struct {
+	expected expectedHash
+	hash     hash.Hash
+}
+
+// newStreamHasher returns a hasher for the SHA-256 or else MD5 digest in the
+// Extra of stream, nil if it has none.
+func newStreamHasher(stream Stream) (*streamHasher, error) {
+	for _, algo := range []string{ExtraSHA256, ExtraMD5} {
+		sum := stream.Extra[algo]
+		if sum == "" {
+			continue
+		}
+		expected, err := parseExpectedHash(algo + ":" + sum)
+		if err != nil {
+			return nil, err
+		}
+		h := &streamHasher{expected: expected, hash: sha256.New()}
+		if algo == ExtraMD5 {
+			h.hash = md5.New()
+		}
+		return h, nil
+	}
+	return nil, nil
+}
+
+// writer returns w, also feeding the hasher if there is one.
+func (h *streamHasher) writer(w io.Writer) io.Writer {
+	if h == nil {
+		return w
+	}
+	return io.MultiWriter(w, h.hash)
+}
+
+// verify compares what was written with the expected digest.
+func (h *streamHasher) verify() error {
+	if h == nil {
+		return nil
+	}
+	if got := hex.EncodeToString(h.hash.Sum(nil)); got != h.expected.sum {
+		return fmt.Errorf("%w: %s is %s, expected %s", ErrChecksumMismatch, h.expected.algo, got, h.expected.sum)
+	}
+	return nil
+}
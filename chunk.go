@@ -0,0 +1,112 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// ChunkOptions configures ChunkDownload.
+type ChunkOptions struct {
+	Output    string           // Destination file path (required)
+	Threads   int              // Number of concurrent range requests, DefaultOptions.Threads if 0
+	ChunkSize int64            // Minimum chunk size in bytes, DefaultOptions.ChunkSize if 0
+	StatePath string           // File persisting the chunk layout and checksums, Output + ".part.state" if empty
+	Resume    bool             // Reuse the verified chunks of an interrupted download instead of starting over
+	Header    http.Header      // Extra request headers
+	Option    *Option          // Network options such as Proxy, Timeout or RateLimit (optional)
+	Progress  ProgressCallback // Progress callback (optional)
+	Logger    *slog.Logger     // Logger replacing the text logger to stdout (optional), see Context.SetLogger
+}
+
+// ChunkDownload downloads url to opts.Output with parallel range requests,
+// without any extractor involved. Servers without range support are downloaded
+// over a single connection. The file is written to Output + ".part" and renamed
+// once complete; with Resume, an interrupted download continues where it stopped.
+func ChunkDownload(ctx context.Context, url string, opts ChunkOptions) (err error) {
+	if opts.Output == "" {
+		return errors.New("chunk download: output path is required")
+	}
+
+	option := *DefaultOptions
+	if opts.Option != nil {
+		option.Combine(*opts.Option)
+	}
+	option.Combine(Option{Threads: opts.Threads, ChunkSize: opts.ChunkSize})
+	option.OutputPath = filepath.Dir(opts.Output)
+
+	c := NewContext(ctx, option)
+	defer func() {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	if opts.Logger != nil {
+		c.SetLogger(opts.Logger)
+	}
+	if opts.Progress != nil {
+		c.Subscribe(ProgressEvents(opts.Progress))
+	}
+	d := NewDownloader(c)
+
+	tempPath := opts.Output + downloadingSuffix
+	statePath := opts.StatePath
+	if statePath == "" {
+		statePath = tempPath + stateSuffix
+	}
+
+	if err := os.MkdirAll(filepath.Dir(opts.Output), 0755); err != nil {
+		return fmt.Errorf("failed to create output directory: %w", err)
+	}
+	lock, err := utils.TryLock(opts.Output + lockSuffix)
+	if errors.Is(err, utils.ErrLocked) {
+		return fmt.Errorf("%w: %s", ErrFileLocked, opts.Output)
+	} else if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	if !opts.Resume {
+		removePartFiles(tempPath)
+		os.Remove(statePath)
+	}
+
+	header := opts.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	stream := Stream{
+		ID:     url,
+		Title:  filepath.Base(opts.Output),
+		URL:    url,
+		Header: header,
+	}
+	if err := d.downloadSingleThread(ctx, stream, tempPath, statePath); err != nil {
+		return err
+	}
+	if err := os.Rename(tempPath, opts.Output); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// removePartFiles removes the chunk files of tempPath (tempPath.partN).
+func removePartFiles(tempPath string) {
+	entries, err := os.ReadDir(filepath.Dir(tempPath))
+	if err != nil {
+		return
+	}
+	prefix := filepath.Base(tempPath) + ".part"
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && partFilePattern.MatchString(entry.Name()) {
+			os.Remove(filepath.Join(filepath.Dir(tempPath), entry.Name()))
+		}
+	}
+}
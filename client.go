@@ -1,6 +1,9 @@
 package grab
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
 	"strings"
 	"time"
 
@@ -20,9 +23,17 @@ func newClient(o Option) *resty.Client {
 		client.SetTimeout(30 * time.Second) // Default 30 second timeout
 	}
 
-	// Set proxy if configured
+	// Set proxy if configured, credentials are sent preemptively as Proxy-Authorization
 	if o.Proxy != "" {
-		client.SetProxy(o.Proxy)
+		if proxyURL, err := utils.ProxyURL(o.Proxy, o.ProxyUser, o.ProxyPass); err == nil {
+			client.SetProxy(proxyURL.String())
+		} else {
+			client.SetProxy(o.Proxy)
+		}
+	} else if o.NoProxyEnv {
+		client.RemoveProxy()
+	} else if transport, err := client.Transport(); err == nil {
+		transport.Proxy = proxyFromEnvironment(o.ProxyUser, o.ProxyPass)
 	}
 
 	// Authentication setup
@@ -40,42 +51,35 @@ func newClient(o Option) *resty.Client {
 		}
 	}
 
-	// Load cookies from file if specified
+	// Load cookies from file if specified, into a jar that can be saved, see Context.SaveCookies
+	cookieJar := utils.NewCookieJar()
 	if o.Cookie != "" {
-		cookieJar, err := utils.CookieJarFromFile(o.Cookie)
+		var err error
+		cookieJar, err = utils.CookieJarFromFile(o.Cookie)
 		if err != nil {
 			panic("Failed to load cookie file: " + o.Cookie)
 		}
-		client.SetCookieJar(cookieJar)
+	}
+	client.SetCookieJar(cookieJar)
+
+	// Logins of the .netrc file, for requests without credentials of their own
+	if o.Netrc {
+		if netrc, err := utils.LoadNetrc(o.netrcPath()); err == nil {
+			client.SetPreRequestHook(func(_ *resty.Client, req *http.Request) error {
+				if req.URL.User != nil || req.Header.Get("Authorization") != "" {
+					return nil
+				}
+				if m, ok := netrc.Lookup(req.URL.Hostname()); ok {
+					req.SetBasicAuth(m.Login, m.Password)
+				}
+				return nil
+			})
+		}
 	}
 
 	// Configure retry behavior
 	if o.RetryCount > 0 {
-		client.SetRetryCount(o.RetryCount)
-		client.SetRetryWaitTime(3 * time.Second)
-		client.SetRetryMaxWaitTime(30 * time.Second)
-
-		// Add retry conditions - don't retry on 4xx errors except specific ones
-		client.AddRetryCondition(func(r *resty.Response, _ error) bool {
-			// Don't retry on client errors except for specific cases
-			if r.StatusCode() >= 400 && r.StatusCode() < 500 {
-				// Retry on rate limiting and some temporary client errors
-				switch r.StatusCode() {
-				case 408, 429: // Request Timeout, Too Many Requests
-					return true
-				default:
-					return false
-				}
-			}
-
-			// Don't retry on 304 Not Modified - it's not an error
-			if r.StatusCode() == 304 {
-				return false
-			}
-
-			// Retry on 5xx server errors
-			return r.StatusCode() >= 500
-		})
+		retryPolicyOf(o).configure(client)
 	}
 
 	// Set custom headers
@@ -90,6 +94,15 @@ func newClient(o Option) *resty.Client {
 	}
 	client.SetHeader("User-Agent", userAgent)
 
+	// Pretend requests originate from the given country for region-locked services
+	if o.GeoBypassCountry != "" {
+		if ip, ok := utils.GeoBypassIP(o.GeoBypassCountry); ok {
+			client.SetHeader("X-Forwarded-For", ip)
+			client.SetHeader("X-Real-IP", ip)
+			client.SetHeader("CF-IPCountry", strings.ToUpper(o.GeoBypassCountry))
+		}
+	}
+
 	// Disable debug by default, enable only if explicitly requested
 	if o.Debug {
 		client.SetDebug(true)
@@ -97,8 +110,70 @@ func newClient(o Option) *resty.Client {
 
 	// Set additional headers for better compatibility
 	client.SetHeader("Accept", "*/*")
-	client.SetHeader("Accept-Encoding", "gzip, deflate")
+	client.SetHeader("Accept-Encoding", acceptEncoding)
 	client.SetHeader("Connection", "keep-alive")
 
+	// Decode brotli and zstd as well, net/http only handles gzip it asked for itself
+	if transport, err := client.Transport(); err == nil {
+		client.SetTransport(&decodingTransport{base: transport})
+	}
+
 	return client
 }
+
+// retryableResponse reports whether the request of r is worth retrying:
+// don't retry on 4xx errors except specific ones.
+func retryableResponse(r *resty.Response) bool {
+	// Don't retry on client errors except for specific cases
+	if r.StatusCode() >= 400 && r.StatusCode() < 500 {
+		// Retry on rate limiting and some temporary client errors
+		switch r.StatusCode() {
+		case 408, 429: // Request Timeout, Too Many Requests
+			return true
+		default:
+			return false
+		}
+	}
+
+	// Don't retry on 304 Not Modified - it's not an error
+	if r.StatusCode() == 304 {
+		return false
+	}
+
+	// Retry on 5xx server errors
+	return r.StatusCode() >= 500
+}
+
+// proxyFromEnvironment uses the proxy of HTTP_PROXY or HTTPS_PROXY unless the
+// request host matches NO_PROXY (host names, domain suffixes, IPs and CIDRs),
+// with the given credentials if user is not empty.
+func proxyFromEnvironment(user, pass string) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		proxyURL, err := http.ProxyFromEnvironment(req)
+		if proxyURL == nil || err != nil || user == "" {
+			return proxyURL, err
+		}
+		withUser := *proxyURL
+		withUser.User = url.UserPassword(user, pass)
+		return &withUser, nil
+	}
+}
+
+// netrcPath returns the .netrc file read with Netrc.
+func (o Option) netrcPath() string {
+	if o.NetrcFile != "" {
+		return o.NetrcFile
+	}
+	return utils.DefaultNetrcPath()
+}
+
+// SaveCookies writes the cookies of the client of the context, those loaded
+// from Cookie and those set by servers since, e.g. by a login, to path in
+// Netscape cookies.txt format, so a later run can reuse them with Cookie.
+func (c *Context) SaveCookies(path string) error {
+	jar, ok := c.Client().GetClient().Jar.(*utils.CookieJar)
+	if !ok {
+		return errors.New("failed to save cookies: the client has no grab cookie jar")
+	}
+	return jar.Save(path)
+}
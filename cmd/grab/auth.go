@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+
+	"github.com/hydrz/grab"
+)
+
+// createAuthCommand creates the command managing the credentials of extractors.
+func createAuthCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "auth",
+		Short: "Manage the credentials extractors log in with",
+		Long: "Store tokens and passwords of extractors in the OS keyring, or with --plaintext in a private file where there is none.\n\n" +
+			"Environment variables such as GAODUN_AUTH_TOKEN, NAME_USERNAME and NAME_PASSWORD still override stored credentials.",
+	}
+
+	var username, token string
+	var plaintext bool
+	login := &cobra.Command{
+		Use:   "login EXTRACTOR",
+		Short: "Store the token, or username and password, of an extractor",
+		Long: "Store the credential of an extractor. Values not given as flags are prompted for, without echo for secrets.\n\n" +
+			"Extractors able to log in exchange the username and password for a session token first.",
+		Args: cobra.ExactArgs(1),
+		// Failed logins are not usage errors
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			name := args[0]
+			if err := checkExtractorName(name); err != nil {
+				return err
+			}
+			in := bufio.NewReader(os.Stdin)
+			credential := grab.Credential{Username: username, Token: token}
+			if credential.Username == "" && credential.Token == "" {
+				value, err := prompt(in, "Token (leave empty to use a username and password): ", true)
+				if err != nil {
+					return err
+				}
+				credential.Token = value
+			}
+			if credential.Token == "" {
+				var err error
+				if credential.Username == "" {
+					if credential.Username, err = prompt(in, "Username: ", false); err != nil {
+						return err
+					}
+				}
+				if credential.Password, err = prompt(in, "Password: ", true); err != nil {
+					return err
+				}
+				if credential.Username == "" || credential.Password == "" {
+					return errors.New("a token, or a username and password, is required")
+				}
+			}
+			ctx := grab.NewContext(cmd.Context(), option)
+			defer ctx.Close()
+			extractor, err := grab.NewExtractor(ctx, name)
+			if err != nil {
+				return err
+			}
+			if authenticator, ok := extractor.(grab.Authenticator); ok {
+				if credential, err = authenticator.Login(credential); err != nil {
+					return fmt.Errorf("failed to log in to %s: %w", name, err)
+				}
+			}
+			store := grab.DefaultCredentialStore()
+			if plaintext {
+				store = grab.FileCredentialStore(grab.DefaultCredentialsPath())
+			}
+			if err := store.Set(name, credential); err != nil {
+				if plaintext {
+					return fmt.Errorf("failed to store credential: %w", err)
+				}
+				return fmt.Errorf("%w; pass --plaintext to store it unencrypted in %s instead", err, grab.DefaultCredentialsPath())
+			}
+			fmt.Printf("Stored credential of %s\n", name)
+			return nil
+		},
+	}
+	login.Flags().StringVar(&username, "username", "", "Username, the password is prompted for")
+	login.Flags().StringVar(&token, "token", "", "Token, e.g. copied from a logged in browser session")
+	login.Flags().BoolVar(&plaintext, "plaintext", false, "Store the credential unencrypted in a file readable by you only instead of the OS keyring, e.g. on Windows or headless servers")
+
+	logout := &cobra.Command{
+		Use:   "logout EXTRACTOR",
+		Short: "Remove the stored credential of an extractor",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := grab.DefaultCredentialStore().Delete(args[0]); err != nil {
+				return fmt.Errorf("failed to remove credential: %w", err)
+			}
+			fmt.Printf("Removed credential of %s\n", args[0])
+			return nil
+		},
+	}
+
+	cmd.AddCommand(login, logout)
+	return cmd
+}
+
+// checkExtractorName returns an error if no extractor is registered as name.
+func checkExtractorName(name string) error {
+	names := grab.ListExtractors()
+	if slices.Contains(names, name) {
+		return nil
+	}
+	slices.Sort(names)
+	return fmt.Errorf("unknown extractor %q (available: %s)", name, strings.Join(names, ", "))
+}
+
+// prompt asks for a line on stderr and reads it from in, without echo if
+// secret and stdin is a terminal.
+func prompt(in *bufio.Reader, label string, secret bool) (string, error) {
+	fmt.Fprint(os.Stderr, label)
+	if fd := int(os.Stdin.Fd()); secret && term.IsTerminal(fd) {
+		value, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return strings.TrimSpace(string(value)), err
+	}
+	line, err := in.ReadString('\n')
+	if err != nil && line == "" {
+		return "", fmt.Errorf("failed to read input: %w", err)
+	}
+	return strings.TrimSpace(line), nil
+}
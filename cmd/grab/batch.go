@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// batchFile holds --batch-file.
+var batchFile string
+
+// eachURL calls fn with the URLs of args in order, reading the URLs of the
+// batch file first and those of stdin where an argument is "-". Lists are
+// read as they are processed, so a producer can feed grab through a pipe.
+// It stops at the first error returned by fn.
+func eachURL(args []string, fn func(url string) error) error {
+	if batchFile != "" {
+		if err := eachBatchURL(batchFile, fn); err != nil {
+			return err
+		}
+	}
+	for _, arg := range args {
+		if arg == "-" {
+			if err := eachListedURL(os.Stdin, fn); err != nil {
+				return err
+			}
+			continue
+		}
+		if url := strings.TrimSpace(arg); url != "" {
+			if err := fn(url); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// eachBatchURL calls fn with the URLs listed in the file at path, stdin if path is "-".
+func eachBatchURL(path string, fn func(url string) error) error {
+	if path == "-" {
+		return eachListedURL(os.Stdin, fn)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open batch file: %w", err)
+	}
+	defer f.Close()
+	return eachListedURL(f, fn)
+}
+
+// eachListedURL calls fn with each URL of r, one per line. Blank lines and
+// lines starting with # are skipped.
+func eachListedURL(r io.Reader, fn func(url string) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if err := fn(line); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read URL list: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// createCacheCommand creates the command managing the HTTP cache of extractor API calls.
+func createCacheCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the HTTP cache of extractor API calls",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "dir",
+		Short: "Print the cache directory",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println(cacheDir())
+		},
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "purge",
+		Short: "Remove all cached responses",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := cacheDir()
+			if err := grab.PurgeCache(dir); err != nil {
+				return fmt.Errorf("failed to purge cache %s: %w", dir, err)
+			}
+			fmt.Printf("Purged %s\n", dir)
+			return nil
+		},
+	})
+	return cmd
+}
+
+// cacheDir returns the cache directory selected by --cache-dir or the default one.
+func cacheDir() string {
+	if option.CacheDir != "" {
+		return option.CacheDir
+	}
+	return grab.DefaultCacheDir()
+}
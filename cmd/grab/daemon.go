@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/aria2"
+	"github.com/hydrz/grab/utils"
+)
+
+// createDaemonCommand creates the command serving the aria2 JSON-RPC interface.
+func createDaemonCommand() *cobra.Command {
+	var headerFlags, siteHeaderFlags []string
+	var listen, secret string
+	var jobs int
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Run in the background, controlled through an aria2 compatible JSON-RPC interface",
+		Long: "Run in the background, controlled through an aria2 compatible JSON-RPC interface.\n\n" +
+			"Frontends such as AriaNg connect to http://<listen>/jsonrpc or ws://<listen>/jsonrpc.\n" +
+			"Added URLs are downloaded with the download flags of this command.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepareOption(cmd, headerFlags, siteHeaderFlags); err != nil {
+				return err
+			}
+			return runDaemon(cmd, listen, secret, jobs)
+		},
+	}
+	cmd.Flags().StringVar(&listen, "rpc-listen", "127.0.0.1:6800", "Address the JSON-RPC interface listens on")
+	cmd.Flags().StringVar(&secret, "rpc-secret", "", "Secret token required from JSON-RPC clients, as aria2's --rpc-secret; required unless --rpc-listen is a loopback address, without it only pages of this machine may call the interface")
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 3, "Number of medias downloaded concurrently")
+	setupDownloadFlags(cmd, &headerFlags, &siteHeaderFlags)
+	return cmd
+}
+
+// runDaemon serves the JSON-RPC interface on listen until the command is interrupted.
+func runDaemon(cmd *cobra.Command, listen, secret string, jobs int) (err error) {
+	ctx := grab.NewContext(cmd.Context(), option)
+	defer func() {
+		if closeErr := ctx.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	if secret == "" && !utils.IsLoopbackHost(listen) {
+		return fmt.Errorf("--rpc-secret is required to listen on %s, which other machines can reach", listen)
+	}
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+	queue := grab.NewQueue(ctx, jobs)
+	mux := http.NewServeMux()
+	mux.Handle("/jsonrpc", aria2.NewServer(ctx, queue, secret))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+
+	serveDone := make(chan error, 1)
+	go func() { serveDone <- server.Serve(listener) }()
+	if !ctx.Option().Silent {
+		fmt.Fprintf(os.Stderr, "Listening on http://%s/jsonrpc\n", listener.Addr())
+	}
+
+	runCtx, stop := context.WithCancel(cmd.Context())
+	defer stop()
+	queueDone := make(chan struct{})
+	go func() {
+		defer close(queueDone)
+		queue.Run(runCtx) // Only returns once runCtx is done
+	}()
+
+	select {
+	case err = <-serveDone:
+	case <-cmd.Context().Done():
+	}
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	server.Shutdown(shutdownCtx)
+	stop()
+	<-queueDone
+	if errors.Is(err, http.ErrServerClosed) {
+		err = nil
+	}
+	return err
+}
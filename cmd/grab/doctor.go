@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// createDoctorCommand creates the command that diagnoses common setup problems.
+func createDoctorCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Diagnose ffmpeg, network, proxy, cookies and permissions",
+		Long:  "Check the environment grab runs in and print how to fix the problems found. Pass the options you download with, e.g. --proxy or --cookies, to check them too.",
+		Args:  cobra.NoArgs,
+		// Failed checks are not a usage error
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := applyProfile(cmd); err != nil {
+				return err
+			}
+			failed := 0
+			for _, d := range grab.Diagnose(cmd.Context(), option) {
+				fmt.Printf("%-8s %-20s %s\n", d.Status, d.Check, d.Detail)
+				if d.Fix != "" {
+					fmt.Printf("%-8s %-20s -> %s\n", "", "", d.Fix)
+				}
+				if d.Status == grab.DiagnosisError {
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d checks failed", failed)
+			}
+			return nil
+		},
+	}
+	// The download options that are checked
+	cmd.Flags().StringVarP(&option.OutputPath, "output-dir", "o", option.OutputPath, "Output directory for downloaded files")
+	cmd.Flags().StringVarP(&option.Proxy, "proxy", "x", option.Proxy, "HTTP proxy URL")
+	cmd.Flags().StringVar(&option.ProxyUser, "proxy-user", option.ProxyUser, "Username for proxy basic auth")
+	cmd.Flags().StringVar(&option.ProxyPass, "proxy-pass", option.ProxyPass, "Password for proxy basic auth")
+	cmd.Flags().BoolVar(&option.NoProxyEnv, "no-proxy-env", option.NoProxyEnv, "Ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
+	cmd.Flags().StringVarP(&option.Cookie, "cookies", "c", option.Cookie, "Path to cookie file for authentication")
+	return cmd
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/utils"
+)
+
+// exitPartialSuccess is the exit code used when some downloads failed under --ignore-errors.
+const exitPartialSuccess = 2
+
+// failuresFile is the name of the failure report written to the output directory.
+const failuresFile = "failures.txt"
+
+// urlFailure is a download failure along with the URL it was extracted from.
+type urlFailure struct {
+	URL string
+	grab.Failure
+}
+
+// partialSuccessError is returned when downloads failed but were skipped because of --ignore-errors.
+type partialSuccessError struct {
+	failed int
+	report string
+}
+
+func (e *partialSuccessError) Error() string {
+	if e.report == "" {
+		return fmt.Sprintf("%d downloads failed", e.failed)
+	}
+	return fmt.Sprintf("%d downloads failed, see %s", e.failed, e.report)
+}
+
+// reportFailures prints a summary of the failures and writes a failures.txt that
+// lists the source URLs once each, so it can be fed back to grab to retry them.
+func reportFailures(failures []urlFailure) error {
+	fmt.Fprintf(os.Stderr, "\n%d downloads failed:\n", len(failures))
+	for _, f := range failures {
+		fmt.Fprintf(os.Stderr, "  %s\n", describeFailure(f))
+	}
+
+	var b strings.Builder
+	b.WriteString("# Downloads that failed, retry with: grab -a failures.txt\n")
+	seen := make(map[string]bool)
+	for _, f := range failures {
+		fmt.Fprintf(&b, "# %s\n", describeFailure(f))
+		if !seen[f.URL] {
+			seen[f.URL] = true
+			fmt.Fprintf(&b, "%s\n", f.URL)
+		}
+	}
+
+	path := filepath.Join(option.OutputPath, failuresFile)
+	if err := os.MkdirAll(option.OutputPath, 0755); err != nil {
+		return &partialSuccessError{failed: len(failures)}
+	}
+	if err := os.WriteFile(path, []byte(b.String()), 0644); err != nil {
+		return &partialSuccessError{failed: len(failures)}
+	}
+	return &partialSuccessError{failed: len(failures), report: path}
+}
+
+func describeFailure(f urlFailure) string {
+	if f.Media == "" {
+		return fmt.Sprintf("%s: %v", f.URL, f.Err)
+	}
+	if f.StreamID == "" {
+		return fmt.Sprintf("%s: %v", f.Media, f.Err)
+	}
+	return fmt.Sprintf("%s [%s]: %v", f.Media, f.StreamID, f.Err)
+}
+
+// reportDowngrades prints which media were downloaded in a lower quality than selected.
+func reportDowngrades(downgrades []grab.Downgrade) {
+	if len(downgrades) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "\n%d downloads fell back to a lower quality:\n", len(downgrades))
+	for _, g := range downgrades {
+		fmt.Fprintf(os.Stderr, "  %s: %s -> %s (%v)\n", g.Media, g.From, g.To, g.Err)
+	}
+}
+
+// reportResults prints a summary of the files downloaded in elapsed.
+func reportResults(results []grab.DownloadResult, elapsed time.Duration) {
+	var downloaded, skipped, converted int
+	var bytes int64
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+		case r.Skipped:
+			skipped++
+		default:
+			downloaded++
+			bytes += r.Bytes
+			if r.Converted {
+				converted++
+			}
+		}
+	}
+	if downloaded == 0 && skipped == 0 {
+		return
+	}
+	var summary []string
+	if downloaded > 0 {
+		line := fmt.Sprintf("Downloaded %d files, %s in %s", downloaded, utils.FormatBytes(bytes), elapsed.Round(10*time.Millisecond))
+		if seconds := elapsed.Seconds(); seconds > 0 && bytes > 0 {
+			line += fmt.Sprintf(" (%s/s)", utils.FormatBytes(int64(float64(bytes)/seconds)))
+		}
+		summary = append(summary, line)
+	}
+	if converted > 0 {
+		summary = append(summary, fmt.Sprintf("%d converted", converted))
+	}
+	if skipped > 0 {
+		summary = append(summary, fmt.Sprintf("%d already downloaded", skipped))
+	}
+	fmt.Fprintf(os.Stderr, "\n%s\n", strings.Join(summary, ", "))
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"text/tabwriter"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/utils"
+)
+
+var (
+	listFormats  bool // Print a table of available streams (--list-formats, -F)
+	dumpJSON     bool // Print media info as JSON (--json)
+	checkStreams bool // Probe whether every stream is still alive (--check-streams)
+)
+
+// printMediaInfo prints extracted media without downloading them, as JSON,
+// as a table of formats or as plain text.
+func printMediaInfo(ctx *grab.Context, medias []grab.Media) error {
+	// Master playlists offer qualities and codec info the extractors don't know about
+	downloader := grab.NewDownloader(ctx)
+	for i := range medias {
+		streams := make([]grab.Stream, 0, len(medias[i].Streams))
+		for _, stream := range medias[i].Streams {
+			variants, err := downloader.Variants(stream)
+			if err != nil {
+				ctx.Logger().Debug("Failed to list stream variants", "id", stream.ID, "error", err)
+			}
+			streams = append(streams, variants...)
+		}
+		medias[i].Streams = streams
+	}
+
+	if checkStreams {
+		grab.NewDownloader(ctx).CheckStreams(ctx.Context(), medias)
+	}
+
+	switch {
+	case dumpJSON:
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(medias)
+	case listFormats:
+		for _, media := range medias {
+			printFormats(media)
+		}
+		return nil
+	default:
+		fmt.Println("Media information:")
+		for _, media := range medias {
+			fmt.Println(media.String())
+		}
+		return nil
+	}
+}
+
+// printFormats prints the streams of a media as a table for format selection.
+func printFormats(media grab.Media) {
+	fmt.Printf("Formats for %s:\n", media.Title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprint(w, "ID\tTYPE\tFORMAT\tQUALITY\tCODEC\tFPS\tCHANNELS\tBITRATE\tLANGUAGE\tSIZE")
+	if checkStreams {
+		fmt.Fprint(w, "\tSTATUS")
+	}
+	fmt.Fprintln(w)
+	for _, s := range media.Streams {
+		size := s.Size
+		if s.Check != nil && s.Check.Size > 0 {
+			size = s.Check.Size
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s",
+			s.ID, s.Type, orDash(s.Format), orDash(s.Quality), orDash(s.Codec),
+			orDash(formatNonZero(s.FPS > 0, strconv.FormatFloat(s.FPS, 'g', -1, 64))),
+			orDash(formatNonZero(s.AudioChannels > 0, strconv.Itoa(s.AudioChannels))),
+			orDash(formatNonZero(s.Bitrate > 0, utils.FormatBitrate(s.Bitrate))),
+			orDash(s.Language),
+			orDash(formatNonZero(size > 0, utils.FormatBytes(size))))
+		if s.Check != nil {
+			fmt.Fprintf(w, "\t%s", checkStatus(s.Check))
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	fmt.Println()
+}
+
+// formatNonZero returns value if ok, or an empty string.
+func formatNonZero(ok bool, value string) string {
+	if ok {
+		return value
+	}
+	return ""
+}
+
+// orDash returns s, or "-" for unknown values.
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+// checkStatus summarizes a liveness probe for the formats table.
+func checkStatus(check *grab.StreamCheck) string {
+	switch {
+	case check.Alive && check.FinalURL != "":
+		return "alive (redirected)"
+	case check.Alive:
+		return "alive"
+	case check.Status > 0:
+		return fmt.Sprintf("dead (HTTP %d)", check.Status)
+	default:
+		return "dead"
+	}
+}
@@ -2,24 +2,40 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
+	"os/exec"
 	"os/signal"
+	"slices"
 	"strings"
 	"sync"
 	"syscall"
+	"time"
 
 	"github.com/schollz/progressbar/v3"
 	"github.com/spf13/cobra"
+	"golang.org/x/term"
 
 	"github.com/hydrz/grab"
 	_ "github.com/hydrz/grab/extractors"
+	"github.com/hydrz/grab/utils"
 	"github.com/hydrz/grab/version"
 )
 
 var option grab.Option
 
+// ffmpegArgs are the space separated arguments of option.FFmpegArgs (--ffmpeg-args).
+var ffmpegArgs string
+
+// externalDownloaderArgs are the space separated arguments of
+// option.ExternalDownloaderArgs (--external-downloader-args).
+var externalDownloaderArgs string
+
+// startAt is the time option.StartAt is parsed from (--start-at).
+var startAt string
+
 func init() {
 	// Set default values for options
 	option = *grab.DefaultOptions
@@ -27,8 +43,9 @@ func init() {
 
 // ProgressManager manages multiple progress bars
 type ProgressManager struct {
-	bars map[string]*progressbar.ProgressBar
-	mu   sync.RWMutex
+	bars       map[string]*progressbar.ProgressBar // By download ID
+	extracting *progressbar.ProgressBar            // Spinner of a long extraction, nil if none
+	mu         sync.RWMutex
 }
 
 func NewProgressManager() *ProgressManager {
@@ -37,17 +54,89 @@ func NewProgressManager() *ProgressManager {
 	}
 }
 
-func (pm *ProgressManager) createProgressCallback() grab.ProgressCallback {
-	return func(current, total int64, description string) {
+// handleEvent draws the progress events of the downloads as progress bars,
+// and those of long extractions as a spinner.
+func (pm *ProgressManager) handleEvent(e grab.Event) {
+	switch e := e.(type) {
+	case grab.ExtractProgressEvent:
+		pm.extractProgress(e)
+		return
+	case grab.ExtractFinishedEvent:
 		pm.mu.Lock()
-		defer pm.mu.Unlock()
+		pm.stopExtracting()
+		pm.mu.Unlock()
+		return
+	case grab.ConvertProgressEvent:
+		pm.convertProgress(e)
+		return
+	}
+	p, ok := e.(grab.ProgressEvent)
+	if !ok {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
 
-		bar, exists := pm.bars[description]
-		if !exists {
-			bar = progressbar.DefaultBytes(total, description)
-			pm.bars[description] = bar
+	bar, exists := pm.bars[p.ID]
+	if !exists {
+		bar = progressbar.DefaultBytes(p.Total, "Downloading "+p.Stream.Title)
+		pm.bars[p.ID] = bar
+	}
+	bar.Set64(p.Current)
+}
+
+// convertProgress shows the conversion of a download with its percent and
+// remaining time, or a spinner if ffmpeg doesn't know the duration.
+func (pm *ProgressManager) convertProgress(e grab.ConvertProgressEvent) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	key := e.ID + "/convert"
+	bar, exists := pm.bars[key]
+	if !exists {
+		total := e.Total.Milliseconds()
+		if total <= 0 {
+			total = -1
 		}
-		bar.Set64(current)
+		bar = progressbar.NewOptions64(total,
+			progressbar.OptionSetDescription("Converting "+e.Stream.Title),
+			progressbar.OptionSetPredictTime(true),
+			progressbar.OptionShowElapsedTimeOnFinish(),
+			progressbar.OptionFullWidth(),
+		)
+		pm.bars[key] = bar
+	}
+	bar.Set64(e.Current.Milliseconds())
+}
+
+// extractProgress shows the counts of an extraction next to a spinner on a
+// terminal, replaced by the download bars once it is done.
+func (pm *ProgressManager) extractProgress(e grab.ExtractProgressEvent) {
+	if !term.IsTerminal(int(os.Stderr.Fd())) {
+		return
+	}
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+	description := fmt.Sprintf("Extracting: %d found, %d API calls", e.Items, e.Requests)
+	if e.Node != "" {
+		description += " - " + e.Node
+	}
+	if pm.extracting == nil {
+		pm.extracting = progressbar.NewOptions(-1,
+			progressbar.OptionSetWriter(os.Stderr),
+			progressbar.OptionSpinnerType(14),
+			progressbar.OptionClearOnFinish(),
+			progressbar.OptionSetDescription(description),
+		)
+	}
+	pm.extracting.Describe(description)
+}
+
+// stopExtracting clears the spinner of the extraction, if any.
+func (pm *ProgressManager) stopExtracting() {
+	if pm.extracting != nil {
+		pm.extracting.Finish()
+		pm.extracting = nil
 	}
 }
 
@@ -55,79 +144,296 @@ func (pm *ProgressManager) finish() {
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
 
+	pm.stopExtracting()
+
 	for _, bar := range pm.bars {
 		bar.Finish()
 	}
 	pm.bars = make(map[string]*progressbar.ProgressBar)
 }
 
+// parseStartAt parses the time of --start-at: a time of day such as 23:30,
+// the next one after now, a date and time such as "2024-06-01 23:30", a
+// RFC 3339 time, or a delay such as +2h.
+func parseStartAt(s string, now time.Time) (time.Time, error) {
+	s = strings.TrimSpace(s)
+	if delay, ok := strings.CutPrefix(s, "+"); ok {
+		d, err := time.ParseDuration(delay)
+		if err != nil || d < 0 {
+			return time.Time{}, fmt.Errorf("invalid --start-at delay %q, use e.g. +2h30m", s)
+		}
+		return now.Add(d), nil
+	}
+	for _, layout := range []string{"15:04", "15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			at := time.Date(now.Year(), now.Month(), now.Day(), t.Hour(), t.Minute(), t.Second(), 0, now.Location())
+			if !at.After(now) {
+				at = at.AddDate(0, 0, 1)
+			}
+			return at, nil
+		}
+	}
+	for _, layout := range []string{"2006-01-02 15:04", time.DateTime, "2006-01-02T15:04", "2006-01-02T15:04:05"} {
+		if t, err := time.ParseInLocation(layout, s, now.Location()); err == nil {
+			return t, nil
+		}
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("invalid --start-at time %q, use e.g. 23:30, \"2024-06-01 23:30\" or +2h", s)
+}
+
 // createRootCommand creates the main command.
 func createRootCommand() *cobra.Command {
-	var headerFlags []string
+	var headerFlags, siteHeaderFlags []string
 	cmd := &cobra.Command{
 		Use:     "grab [URL...]",
 		Short:   "A versatile media downloader",
-		Long:    `grab - Download videos, audios and other media from various sites`,
+		Long:    "grab - Download videos, audios and other media from various sites\n\nUse - as URL to read URLs from stdin, one per line.",
 		Version: version.Version,
-		Args:    cobra.MinimumNArgs(1),
+		Args: func(cmd *cobra.Command, args []string) error {
+			if batchFile == "" && len(args) == 0 {
+				return errors.New("requires at least 1 URL or --batch-file")
+			}
+			return nil
+		},
 		RunE: func(cmd *cobra.Command, args []string) error {
-			if err := processHeaders(headerFlags); err != nil {
+			if err := prepareOption(cmd, headerFlags, siteHeaderFlags); err != nil {
 				return err
 			}
-			return runRootCommand(cmd, args)
+			err := runRootCommand(cmd, args)
+			if errors.Is(err, context.Canceled) {
+				// Chunk files and their layout are already on disk, only the command line is missing
+				if saveErr := saveResumeState(); saveErr == nil {
+					fmt.Fprintln(os.Stderr, "Interrupted, resume with: grab resume")
+				}
+			} else if err == nil {
+				clearResumeState()
+			}
+			return err
 		},
 	}
-	setupFlags(cmd, &headerFlags)
+	setupFlags(cmd, &headerFlags, &siteHeaderFlags)
+	cmd.AddCommand(createResumeCommand(), createVerifyCommand(), createCacheCommand(), createDoctorCommand(), createQueueCommand(), createDaemonCommand(), createWatchCommand(), createAuthCommand())
 	return cmd
 }
 
-// runRootCommand executes the grab command with the provided context and URLs.
-func runRootCommand(cmd *cobra.Command, urls []string) error {
-	ctx := grab.NewContext(cmd.Context(), option)
-
-	// Setup progress manager if not in silent mode
-	var progressManager *ProgressManager
-	if !ctx.Option().Silent {
-		progressManager = NewProgressManager()
-		ctx.SetProgressCallback(progressManager.createProgressCallback())
-		defer progressManager.finish()
+// prepareOption completes option with the headers and profile of the command
+// line and validates the proxy and geo bypass settings.
+func prepareOption(cmd *cobra.Command, headerFlags, siteHeaderFlags []string) error {
+	if err := processHeaders(headerFlags); err != nil {
+		return err
+	}
+	if err := processSiteHeaders(siteHeaderFlags); err != nil {
+		return err
+	}
+	if err := applyProfile(cmd); err != nil {
+		return err
+	}
+	if listFormats {
+		option.AllFormats = true
 	}
+	if cmd.Flags().Changed("ffmpeg-args") {
+		option.FFmpegArgs = strings.Fields(ffmpegArgs)
+	}
+	if cmd.Flags().Changed("external-downloader-args") {
+		option.ExternalDownloaderArgs = strings.Fields(externalDownloaderArgs)
+	}
+	if startAt != "" {
+		at, err := parseStartAt(startAt, time.Now())
+		if err != nil {
+			return err
+		}
+		option.StartAt = at
+	}
+	if option.RateSchedule != "" {
+		if _, err := utils.ParseRateSchedule(option.RateSchedule); err != nil {
+			return err
+		}
+	}
+	if err := validateProgressFormat(); err != nil {
+		return err
+	}
+	if option.NetrcFile != "" {
+		option.Netrc = true
+	}
+	if option.Netrc {
+		path := option.NetrcFile
+		if path == "" {
+			path = utils.DefaultNetrcPath()
+		}
+		if _, err := utils.LoadNetrc(path); err != nil {
+			return err
+		}
+	}
+	if option.LogFormat != "" && option.LogFormat != "text" && option.LogFormat != "json" {
+		return fmt.Errorf("unsupported log format: %s, use text or json", option.LogFormat)
+	}
+	if option.PlaylistItems != "" {
+		if _, err := grab.ParsePlaylistItems(option.PlaylistItems); err != nil {
+			return err
+		}
+	}
+	if option.DownloadSections != "" {
+		if _, err := grab.ParseTimeRange(option.DownloadSections); err != nil {
+			return err
+		}
+	}
+	if option.HLSKey != "" {
+		if _, err := grab.ParseHLSKey(option.HLSKey); err != nil {
+			return fmt.Errorf("invalid --hls-key: %w", err)
+		}
+	}
+	if option.HLSIV != "" {
+		if _, err := grab.ParseHLSKey(option.HLSIV); err != nil {
+			return fmt.Errorf("invalid --hls-iv: %w", err)
+		}
+	}
+	if option.ExternalDownloader != "" {
+		if _, err := exec.LookPath(option.ExternalDownloader); err != nil {
+			return fmt.Errorf("external downloader %s not found: %w", option.ExternalDownloader, err)
+		}
+		if _, err := grab.ExternalDownloaderCommand(option.ExternalDownloader, option.ExternalDownloaderArgs, "", "", ""); err != nil {
+			return err
+		}
+	}
+	if option.PlaylistStart > 0 && option.PlaylistEnd > 0 && option.PlaylistStart > option.PlaylistEnd {
+		return fmt.Errorf("--playlist-start %d is after --playlist-end %d", option.PlaylistStart, option.PlaylistEnd)
+	}
+	if option.Proxy != "" {
+		if _, err := utils.ProxyURL(option.Proxy, option.ProxyUser, option.ProxyPass); err != nil {
+			return err
+		}
+	}
+	if option.GeoBypassCountry != "" {
+		if _, ok := utils.GeoBypassIP(option.GeoBypassCountry); !ok {
+			return fmt.Errorf("unsupported geo bypass country: %s", option.GeoBypassCountry)
+		}
+	}
+	return nil
+}
 
-	for _, url := range urls {
-		url = strings.TrimSpace(url)
-		if url == "" {
-			continue
+// runRootCommand executes the grab command with the provided context and URLs.
+func runRootCommand(cmd *cobra.Command, urls []string) (err error) {
+	runCtx := cmd.Context()
+	var ui *tui
+	if tuiMode {
+		if batchFile == "-" || slices.Contains(urls, "-") {
+			return errors.New("--tui cannot read URLs from stdin")
 		}
-		extractor, err := grab.FindExtractor(ctx, url)
-		if err != nil {
-			return fmt.Errorf("failed to find extractor for URL %s: %w", url, err)
+		var cancel context.CancelFunc
+		runCtx, cancel = context.WithCancel(runCtx)
+		defer cancel()
+		if ui, err = startTUI(cancel); err != nil {
+			return err
 		}
+		defer ui.stop()
+	}
 
-		medias, err := extractor.Extract(url)
-		if err != nil {
-			return fmt.Errorf("failed to extract media from URL %s: %w", url, err)
+	ctx := grab.NewContext(runCtx, option)
+	defer func() {
+		if closeErr := ctx.Close(); err == nil {
+			err = closeErr
 		}
+	}()
+
+	if ui != nil {
+		ctx.SetLogOutput(ui.logs)
+		activeTUI = ui
+		defer func() { activeTUI = nil }()
+	} else {
+		defer startProgress(ctx)()
+	}
 
-		if ctx.Option().ExtractOnly {
-			if len(medias) == 0 {
-				fmt.Printf("No media found for URL: %s\n", url)
-				continue
+	// Waiting doesn't count in the time of the run
+	if err := ctx.WaitStart(); err != nil {
+		return err
+	}
+	started := time.Now()
+	var failures []urlFailure
+	var downgrades []grab.Downgrade
+	var results []grab.DownloadResult
+	var processed, failed int
+	err = eachURL(urls, func(url string) error {
+		processed++
+		downloader, err := processURL(ctx, url)
+		if downloader != nil {
+			downgrades = append(downgrades, downloader.Downgrades()...)
+			results = append(results, downloader.Results()...)
+			for _, f := range downloader.Failures() {
+				failures = append(failures, urlFailure{URL: url, Failure: f})
 			}
-			fmt.Println("Media information:")
-			for _, media := range medias {
-				fmt.Println(media.String())
+			if len(downloader.Failures()) > 0 && err == nil {
+				failed++
 			}
-			return nil
 		}
+		if err != nil {
+			if errors.Is(err, grab.ErrMaxDownloads) {
+				if downloader == nil || len(downloader.Results()) == 0 {
+					processed-- // Not started
+				}
+				return err
+			}
+			if !ctx.Option().IgnoreErrors || errors.Is(err, context.Canceled) {
+				return err
+			}
+			ctx.Logger().Error("Failed to process URL", "url", url, "error", err)
+			failed++
+			failures = append(failures, urlFailure{URL: url, Failure: grab.Failure{Err: err}})
+		}
+		return nil
+	})
+	ui.stop() // Reports go to the normal screen
+	if errors.Is(err, grab.ErrMaxDownloads) {
+		// A successful run that was asked to stop early
+		fmt.Fprintf(os.Stderr, "Stopped after %d downloads (--max-downloads)\n", ctx.Option().MaxDownloads)
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+	reportDowngrades(downgrades)
+	if !ctx.Option().Silent {
+		reportResults(results, time.Since(started))
+	}
+	if processed > 1 && !ctx.Option().Silent {
+		fmt.Fprintf(os.Stderr, "\nProcessed %d URLs: %d succeeded, %d failed\n", processed, processed-failed, failed)
+	}
 
-		downloader := grab.NewDownloader(ctx)
+	if len(failures) > 0 {
+		return reportFailures(failures)
+	}
+	return nil
+}
 
-		if err := downloader.Download(medias); err != nil {
-			return fmt.Errorf("failed to download media for URL %s: %w", url, err)
+// processURL extracts the medias of url and downloads them, or prints them when
+// only info was asked for. The returned downloader is nil if nothing was downloaded.
+func processURL(ctx *grab.Context, url string) (*grab.Downloader, error) {
+	extractorName, extractor, medias, err := grab.ExtractURL(ctx, url)
+	if err != nil {
+		return nil, err
+	}
+
+	if ctx.Option().ExtractOnly || listFormats || dumpJSON || checkStreams {
+		if len(medias) == 0 {
+			fmt.Printf("No media found for URL: %s\n", url)
+			return nil, nil
 		}
+		return nil, printMediaInfo(ctx, medias)
 	}
 
-	return nil
+	downloader := grab.NewDownloader(ctx)
+	activeTUI.watch(downloader)
+	downloader.SetSource(url, extractorName)
+	if refresher, ok := extractor.(grab.Refresher); ok {
+		downloader.SetRefresher(refresher)
+	}
+
+	if _, err := downloader.Download(medias); err != nil {
+		return downloader, fmt.Errorf("failed to download media for URL %s: %w", url, err)
+	}
+	return downloader, nil
 }
 
 // processHeaders parses and validates HTTP headers from command line flags.
@@ -145,21 +451,78 @@ func processHeaders(headerFlags []string) error {
 	return nil
 }
 
+// processSiteHeaders parses per-site default headers of the form "pattern=Name: value".
+func processSiteHeaders(siteHeaderFlags []string) error {
+	for _, h := range siteHeaderFlags {
+		pattern, header, ok := strings.Cut(h, "=")
+		parts := strings.SplitN(header, ":", 2)
+		if !ok || strings.TrimSpace(pattern) == "" || len(parts) != 2 {
+			return fmt.Errorf("invalid site header format: %s", h)
+		}
+		if option.SiteHeaders == nil {
+			option.SiteHeaders = make(map[string]http.Header)
+		}
+		pattern = strings.TrimSpace(pattern)
+		if option.SiteHeaders[pattern] == nil {
+			option.SiteHeaders[pattern] = make(http.Header)
+		}
+		option.SiteHeaders[pattern].Set(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return nil
+}
+
 // setupFlags configures command line flags using the current values in option as defaults.
-func setupFlags(cmd *cobra.Command, headerFlags *[]string) {
+func setupFlags(cmd *cobra.Command, headerFlags, siteHeaderFlags *[]string) {
+	cmd.PersistentFlags().StringVar(&profileName, "profile", profileName, "Load cookies, credentials, proxy and output directory from a saved profile")
+	cmd.PersistentFlags().StringVar(&profilesPath, "profiles-file", profilesPath, "Profiles file (default: user config dir/grab/profiles.json)")
+	cmd.Flags().StringVarP(&batchFile, "batch-file", "a", batchFile, "File with URLs to download, one per line, '#' starts a comment, - for stdin")
+	cmd.Flags().BoolVarP(&option.ExtractOnly, "info", "i", option.ExtractOnly, "Only extract media info, do not download")
+	cmd.Flags().BoolVarP(&listFormats, "list-formats", "F", listFormats, "List available formats with codec, fps and bitrate, do not download")
+	cmd.Flags().BoolVar(&dumpJSON, "json", dumpJSON, "Print media info as JSON, do not download")
+	cmd.Flags().BoolVar(&checkStreams, "check-streams", checkStreams, "Probe every stream and report whether it is alive, its size and redirect target, do not download")
+	setupDownloadFlags(cmd, headerFlags, siteHeaderFlags)
+}
+
+// setupDownloadFlags configures the flags shared by the commands that download,
+// using the current values in option as defaults.
+func setupDownloadFlags(cmd *cobra.Command, headerFlags, siteHeaderFlags *[]string) {
 	// Output options
 	cmd.Flags().StringVarP(&option.OutputPath, "output-dir", "o", option.OutputPath, "Output directory for downloaded files")
-	cmd.Flags().StringVarP(&option.OutputName, "output-filename", "O", option.OutputName, "Output filename")
+	cmd.Flags().StringVarP(&option.OutputName, "output-filename", "O", option.OutputName, "Output filename, or a template with {title}, {id}, {uploader}, {upload_date}, {series}, {season}, {episode}, {quality}, {ext}...")
 	// Quality and format
 	cmd.Flags().StringVarP(&option.Quality, "quality", "q", option.Quality, "Preferred video quality")
 	cmd.Flags().StringVarP(&option.Format, "format", "f", option.Format, "Output format")
+	cmd.Flags().StringVar(&option.FFmpegPath, "ffmpeg-path", option.FFmpegPath, "Path of the ffmpeg executable or of its directory (default: looked up in PATH)")
+	cmd.Flags().StringVar(&option.ExternalDownloader, "external-downloader", option.ExternalDownloader, "Download plain HTTP files with aria2c, curl, wget or the path of another program; aria2c and wget get the headers in a configuration file replacing theirs, which starts with a copy of the user's aria2.conf or wgetrc")
+	cmd.Flags().StringVar(&externalDownloaderArgs, "external-downloader-args", strings.Join(option.ExternalDownloaderArgs, " "), "Arguments of --external-downloader, with {url}, {output}, {dir}, {file} and {headers} placeholders (default: built-in ones for aria2c, curl and wget)")
+	cmd.Flags().StringVar(&ffmpegArgs, "ffmpeg-args", strings.Join(option.FFmpegArgs, " "), "ffmpeg arguments of --format conversions, e.g. \"-c:v libx264 -crf 23\", those before a -i apply to the input (default: copy the streams when the container can hold them)")
+	cmd.Flags().StringVar(&option.RemuxVideo, "remux-video", option.RemuxVideo, "Container to remux MPEG-TS HLS downloads to without re-encoding, e.g. mp4 or mkv, defaults to their extension, \"none\" to keep MPEG-TS")
+	cmd.Flags().BoolVar(&option.QualityFallback, "quality-fallback", option.QualityFallback, "Download the next lower quality when a stream keeps failing")
 	// Network options
 	cmd.Flags().StringArrayVarP(headerFlags, "header", "H", nil, "Custom HTTP headers")
+	cmd.Flags().StringArrayVar(siteHeaderFlags, "site-header", nil, "Default HTTP header for a site as 'example.com=Referer: https://example.com/'")
 	cmd.Flags().StringVarP(&option.UserAgent, "user-agent", "u", option.UserAgent, "Custom user agent")
 	cmd.Flags().StringVarP(&option.Proxy, "proxy", "x", option.Proxy, "HTTP proxy URL")
+	cmd.Flags().StringVar(&option.ProxyUser, "proxy-user", option.ProxyUser, "Username for proxy basic auth")
+	cmd.Flags().StringVar(&option.ProxyPass, "proxy-pass", option.ProxyPass, "Password for proxy basic auth")
+	cmd.Flags().BoolVar(&option.NoProxyEnv, "no-proxy-env", option.NoProxyEnv, "Ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment variables")
 	cmd.Flags().IntVarP(&option.RetryCount, "retry", "r", option.RetryCount, "Number of retry attempts")
+	cmd.Flags().DurationVar(&option.RetryDelay, "retry-delay", option.RetryDelay, "Delay before the first retry, doubled for each next one (default: 1s)")
+	cmd.Flags().DurationVar(&option.RetryMaxDelay, "retry-max-delay", option.RetryMaxDelay, "Longest delay between two attempts, Retry-After included (default: 30s)")
+	cmd.Flags().DurationVar(&option.RetryMaxTime, "retry-max-time", option.RetryMaxTime, "Stop retrying a download or segment after this long (default: no limit)")
 	cmd.Flags().DurationVarP(&option.Timeout, "timeout", "t", option.Timeout, "Request timeout")
+	cmd.PersistentFlags().StringVar(&option.CacheDir, "cache-dir", option.CacheDir, "Directory of the HTTP cache for extractor API calls (default: user cache dir)")
+	cmd.Flags().Int64Var(&option.CacheMaxSize, "cache-max-size", option.CacheMaxSize, "Maximum HTTP cache size in bytes, 0 means unlimited")
+	cmd.Flags().DurationVar(&option.CacheTTL, "cache-ttl", option.CacheTTL, "Maximum age of cached responses, 0 means forever")
+	cmd.Flags().BoolVar(&option.NoCache, "no-cache", option.NoCache, "Disable the HTTP cache")
+	cmd.Flags().StringVar(&option.GeoBypassCountry, "geo-bypass-country", option.GeoBypassCountry, "Fake requests as originating from the given two-letter country code")
+	cmd.Flags().Int64Var(&option.RateLimit, "limit-rate", option.RateLimit, "Total download speed limit in bytes per second across all streams")
+	cmd.Flags().Int64Var(&option.RateLimitPerStream, "limit-rate-per-stream", option.RateLimitPerStream, "Download speed limit in bytes per second for each stream")
 	cmd.Flags().Int64Var(&option.RateLimit, "rate-limit", option.RateLimit, "Download speed limit in bytes per second")
+	cmd.Flags().StringVar(&option.RateSchedule, "rate-schedule", option.RateSchedule, "Total speed limits by time of day overriding --limit-rate, e.g. \"09:00-18:00=1M,22:00-06:00=0\", 0 being unlimited")
+	cmd.Flags().MarkDeprecated("rate-limit", "use --limit-rate instead")
+	cmd.Flags().DurationVar(&option.ChunkTimeout, "chunk-timeout", option.ChunkTimeout, "Maximum duration per segment/chunk attempt (0 derives it from --min-speed)")
+	cmd.Flags().Int64Var(&option.MinSpeed, "min-speed", option.MinSpeed, "Minimum speed in bytes per second before a segment/chunk attempt is abandoned")
 
 	// Advanced authentication
 	cmd.Flags().StringVar(&option.AuthType, "auth-type", option.AuthType, "Authentication type (basic, bearer, header)")
@@ -169,26 +532,70 @@ func setupFlags(cmd *cobra.Command, headerFlags *[]string) {
 	cmd.Flags().StringVar(&option.AuthHeader, "auth-header", option.AuthHeader, "Custom header for auth (e.g. 'X-API-Key: ...')")
 	// Cookie handling
 	cmd.Flags().StringVarP(&option.Cookie, "cookies", "c", option.Cookie, "Path to cookie file for authentication")
+	cmd.Flags().StringVar(&option.SaveCookies, "save-cookies", option.SaveCookies, "Write the cookies, those set during the run included, to this Netscape cookie file at exit")
+	cmd.Flags().BoolVar(&option.Netrc, "netrc", option.Netrc, "Send the login of the host in ~/.netrc as basic auth")
+	cmd.Flags().StringVar(&option.NetrcFile, "netrc-file", option.NetrcFile, "Read --netrc logins from this file instead of $NETRC or ~/.netrc")
 	cmd.Flags().MarkHidden("cookies") // Hide this flag from help output
 
 	// Download options
 	cmd.Flags().IntVarP(&option.Threads, "threads", "n", option.Threads, "Number of concurrent download threads")
-	cmd.Flags().Int64Var(&option.ChunkSize, "chunk-size", option.ChunkSize, "Download chunk size in bytes")
+	cmd.Flags().Int64Var(&option.ChunkSize, "chunk-size", option.ChunkSize, "Minimum chunk size in bytes, smaller files use fewer threads")
+	cmd.Flags().Int64Var(&option.MaxMemory, "max-memory", option.MaxMemory, "Maximum bytes held in download buffers across all streams, 0 means unlimited")
+	cmd.Flags().Int64Var(&option.HLSBufferSize, "hls-buffer-size", option.HLSBufferSize, "Bytes of prefetched HLS segments each stream keeps in memory, further ones wait in temporary files (default 64 MiB)")
+	cmd.Flags().StringVar(&startAt, "start-at", startAt, "Wait until this time before starting, e.g. 23:30, \"2024-06-01 23:30\" or +2h")
+	cmd.Flags().IntVar(&option.MaxDownloads, "max-downloads", option.MaxDownloads, "Stop after downloading this many medias, already downloaded ones not counted")
+	cmd.Flags().Int64Var(&option.MinFilesize, "min-filesize", option.MinFilesize, "Skip streams smaller than this many bytes, e.g. stubs")
+	cmd.Flags().Int64Var(&option.MaxFilesize, "max-filesize", option.MaxFilesize, "Skip streams larger than this many bytes, e.g. raw recordings")
 	cmd.Flags().BoolVarP(&option.NoSkipExisting, "no-skip", "S", option.NoSkipExisting, "Do not skip existing files")
-	// Behavior options
-	cmd.Flags().BoolVarP(&option.ExtractOnly, "info", "i", option.ExtractOnly, "Only extract media info, do not download")
-	cmd.Flags().BoolVarP(&option.Playlist, "playlist", "p", option.Playlist, "Download all videos in playlist")
-	cmd.Flags().IntVar(&option.PlaylistStart, "playlist-start", option.PlaylistStart, "Playlist start index")
-	cmd.Flags().IntVar(&option.PlaylistEnd, "playlist-end", option.PlaylistEnd, "Playlist end index")
+	cmd.Flags().BoolVar(&option.NoCheckSpace, "no-check-space", option.NoCheckSpace, "Do not check the free disk space before downloading")
+	cmd.Flags().BoolVarP(&option.NoOverwrite, "no-overwrite", "w", option.NoOverwrite, "Never replace existing files, skip their streams instead")
+	cmd.Flags().BoolVar(&option.ForceOverwrite, "force-overwrite", option.ForceOverwrite, "Download again and replace existing files, even complete ones")
+	cmd.Flags().BoolVar(&option.AutoNumber, "auto-number", option.AutoNumber, "Save as 'title (1).mp4' instead of replacing a different file with the same name")
+	cmd.Flags().BoolVar(&option.RestrictFilenames, "restrict-filenames", option.RestrictFilenames, "Keep only ASCII letters, digits, '.', '-' and '_' in filenames, transliterating accented letters")
+	cmd.Flags().StringVar(&option.FilenameReplacement, "filename-replacement", option.FilenameReplacement, "Character replacing the others with --restrict-filenames (default: _)")
+	cmd.Flags().BoolVar(&option.Sync, "sync", option.Sync, "Only extract lessons not downloaded by earlier --sync runs, recorded in a manifest per course (gaodun)")
+	cmd.MarkFlagsMutuallyExclusive("no-overwrite", "force-overwrite", "auto-number")
+	cmd.Flags().BoolVar(&option.LongPaths, "long-paths", option.LongPaths, "Use \\\\?\\ prefixed paths on Windows for deep directory trees")
+	cmd.Flags().BoolVar(&option.Checksum, "checksum", option.Checksum, "Record a SHA-256 .sha256 file next to each download")
+	cmd.Flags().StringVar(&option.ExpectedHash, "expect-hash", option.ExpectedHash, "Fail unless the downloaded file matches this sha256:<hex> or md5:<hex> digest")
+	cmd.Flags().BoolVar(&option.KeepFragments, "keep-fragments", option.KeepFragments, "Keep downloaded HLS segments and a manifest next to the merged output")
+	cmd.Flags().StringVar(&option.HLSKey, "hls-key", option.HLSKey, "AES-128 key of encrypted HLS segments as 32 hex digits, instead of downloading it from the playlist's key URI")
+	cmd.Flags().StringVar(&option.HLSIV, "hls-iv", option.HLSIV, "IV of encrypted HLS segments as 32 hex digits, instead of the playlist's IV or media sequence number")
+	cmd.Flags().IntVar(&option.HLSAllowMissingSegments, "hls-allow-missing-segments", option.HLSAllowMissingSegments, "Leave out up to this many HLS segments the server no longer has (404 or 410) instead of failing, with a warning for each gap")
+	cmd.Flags().BoolVar(&option.HLSSkipAds, "hls-skip-ads", option.HLSSkipAds, "Skip HLS segments between discontinuities whose paths look like ads, e.g. /ads/ or preroll")
+	cmd.Flags().StringVar(&option.DownloadArchive, "download-archive", option.DownloadArchive, "Record downloaded media in this file and skip media already recorded in it")
+	cmd.Flags().StringVar(&option.ArchiveOutput, "archive-output", option.ArchiveOutput, "Write finished files into a .zip, .tar, .tar.gz or .tgz archive instead of the output directory")
+	cmd.Flags().BoolVar(&option.EmbedMetadata, "embed-metadata", option.EmbedMetadata, "Write title, description, chapters, source URL, extractor, date and grab version into file tags and extended attributes")
+	cmd.Flags().BoolVar(&option.EmbedSubs, "embed-subs", option.EmbedSubs, "Download subtitles and embed them into the MP4/MKV/WebM video")
+	cmd.Flags().StringVar(&option.DownloadSections, "download-sections", option.DownloadSections, "Only download a section of videos, e.g. \"00:10:00-00:25:00\" or \"90-inf\"")
+	cmd.Flags().BoolVar(&option.SplitChapters, "split-chapters", option.SplitChapters, "Also cut videos and audios into one file per chapter, named \"<name> - 001 <chapter>.<ext>\"")
+	cmd.Flags().BoolVar(&option.Update, "update", option.Update, "Re-download existing files when the remote copy changed (ETag/Last-Modified)")
+	// Playlist options
+	cmd.Flags().BoolVarP(&option.Playlist, "playlist", "p", option.Playlist, "Download the whole playlist of a URL pointing to one of its entries")
+	cmd.Flags().IntVar(&option.PlaylistStart, "playlist-start", option.PlaylistStart, "Index, from 1, of the first playlist or course entry to download (default: first)")
+	cmd.Flags().IntVar(&option.PlaylistEnd, "playlist-end", option.PlaylistEnd, "Index of the last playlist or course entry to download (default: last)")
+	cmd.Flags().StringVar(&option.PlaylistItems, "playlist-items", option.PlaylistItems, "Playlist or course entries to download, e.g. 1,3,7-12,-5 (negative indexes count from the end)")
+	cmd.Flags().IntVar(&option.ExtractorConcurrency, "extractor-concurrency", option.ExtractorConcurrency, "Concurrent API requests while extracting a course or playlist (default: 8 for gaodun)")
 	// Content options
 	cmd.Flags().BoolVar(&option.Subtitle, "subtitle", option.Subtitle, "Download subtitles")
+	cmd.Flags().StringVar(&option.SubtitleFormat, "sub-format", option.SubtitleFormat, "Convert subtitles to srt, vtt or ttml")
 	cmd.Flags().BoolVar(&option.VideoOnly, "video-only", option.VideoOnly, "Download video only, no audio")
 	cmd.Flags().BoolVar(&option.AudioOnly, "audio-only", option.AudioOnly, "Download audio only")
+	// Remote monitoring
+	cmd.Flags().StringVar(&option.WebhookURL, "webhook", option.WebhookURL, "URL to POST job progress and completion events to")
+	cmd.Flags().StringVar(&option.WebhookSecret, "webhook-secret", option.WebhookSecret, "Secret used to sign webhook events with HMAC-SHA256")
+	cmd.Flags().DurationVar(&option.WebhookInterval, "webhook-interval", option.WebhookInterval, "Interval between webhook progress snapshots (default 10s)")
 	// Error handling and logging
 	cmd.Flags().BoolVar(&option.IgnoreErrors, "ignore-errors", option.IgnoreErrors, "Continue on errors")
 	cmd.Flags().BoolVarP(&option.Debug, "debug", "d", option.Debug, "Enable debug logging")
 	cmd.Flags().BoolVarP(&option.Verbose, "verbose", "v", option.Verbose, "Enable verbose output")
 	cmd.Flags().BoolVar(&option.Silent, "silent", option.Silent, "Suppress all output except errors")
+	cmd.Flags().StringVar(&progressFormat, "progress-format", progressFormat, "Show progress as bar, or json for newline-delimited JSON events on stdout")
+	cmd.Flags().StringVar(&option.LogFile, "log-file", option.LogFile, "Also write logs to this file, at least at info level")
+	cmd.Flags().StringVar(&option.LogFormat, "log-format", option.LogFormat, "Format of the log file, text or json (default: text)")
+	cmd.Flags().Int64Var(&option.LogMaxSize, "log-max-size", option.LogMaxSize, "Size in bytes past which the log file is rotated (default: 10 MB)")
+	cmd.Flags().IntVar(&option.LogMaxFiles, "log-max-files", option.LogMaxFiles, "Number of rotated log files kept (default: 3)")
+	cmd.Flags().BoolVar(&tuiMode, "tui", tuiMode, "Show an interactive table of downloads to pause or cancel them, with a log pane")
 }
 
 func main() {
@@ -196,8 +603,13 @@ func main() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
 	defer cancel()
 
+	rawArgs = os.Args[1:]
 	rootCmd := createRootCommand()
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		var partial *partialSuccessError
+		if errors.As(err, &partial) {
+			os.Exit(exitPartialSuccess)
+		}
 		os.Exit(1)
 	}
 }
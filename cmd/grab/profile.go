@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// profileName holds --profile, profilesPath holds --profiles-file.
+var profileName, profilesPath string
+
+// applyProfile loads the profile selected by --profile into option. Flags given
+// on the command line take precedence over the profile.
+func applyProfile(cmd *cobra.Command) error {
+	if profileName == "" {
+		return nil
+	}
+	path := profilesPath
+	if path == "" {
+		path = grab.DefaultProfilesPath()
+	}
+	p, err := grab.LoadProfile(path, profileName)
+	if err != nil {
+		return err
+	}
+
+	flags := cmd.Flags()
+	set := func(flag string, dst *string, value string) {
+		if value != "" && !flags.Changed(flag) {
+			*dst = value
+		}
+	}
+	set("output-dir", &option.OutputPath, p.OutputPath)
+	set("cookies", &option.Cookie, p.Cookie)
+	set("user-agent", &option.UserAgent, p.UserAgent)
+	set("proxy", &option.Proxy, p.Proxy)
+	set("proxy-user", &option.ProxyUser, p.ProxyUser)
+	set("proxy-pass", &option.ProxyPass, p.ProxyPass)
+	set("auth-type", &option.AuthType, p.AuthType)
+	set("auth-user", &option.AuthUser, p.AuthUser)
+	set("auth-pass", &option.AuthPass, p.AuthPass)
+	set("auth-token", &option.AuthToken, p.AuthToken)
+	set("auth-header", &option.AuthHeader, p.AuthHeader)
+
+	// Headers of --header and --site-header are already parsed and win
+	if option.Headers == nil {
+		option.Headers = make(http.Header)
+	}
+	for name, value := range p.Headers {
+		if option.Headers.Get(name) == "" {
+			option.Headers.Set(name, value)
+		}
+	}
+	for pattern, header := range p.SiteHeaders {
+		if option.SiteHeaders == nil {
+			option.SiteHeaders = make(map[string]http.Header)
+		}
+		if option.SiteHeaders[pattern] == nil {
+			option.SiteHeaders[pattern] = make(http.Header)
+		}
+		for name, value := range header {
+			if option.SiteHeaders[pattern].Get(name) == "" {
+				option.SiteHeaders[pattern].Set(name, value)
+			}
+		}
+	}
+
+	for key, value := range p.Env {
+		if err := os.Setenv(key, value); err != nil {
+			return fmt.Errorf("failed to set %s of profile %s: %w", key, profileName, err)
+		}
+	}
+	return nil
+}
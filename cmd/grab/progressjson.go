@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hydrz/grab"
+)
+
+// Values of --progress-format.
+const (
+	progressBar  = "bar"
+	progressJSON = "json"
+)
+
+// progressFormat holds --progress-format.
+var progressFormat = progressBar
+
+// validateProgressFormat checks --progress-format and its combination with
+// the other output flags.
+func validateProgressFormat() error {
+	switch progressFormat {
+	case progressBar:
+		return nil
+	case progressJSON:
+		if tuiMode {
+			return fmt.Errorf("--tui cannot be combined with --progress-format %s", progressJSON)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported progress format: %s, use %s or %s", progressFormat, progressBar, progressJSON)
+	}
+}
+
+// startProgress shows the progress of the downloads of ctx in the format of
+// --progress-format and returns a function to call once they are done. With
+// JSON progress, stdout only carries events and the logs go to stderr.
+func startProgress(ctx *grab.Context) (finish func()) {
+	switch {
+	case progressFormat == progressJSON:
+		ctx.SetLogOutput(os.Stderr)
+		ctx.Subscribe(newJSONProgress(os.Stdout).handleEvent)
+		return func() {}
+	case ctx.Option().Silent:
+		return func() {}
+	default:
+		progressManager := NewProgressManager()
+		ctx.Subscribe(progressManager.handleEvent)
+		return progressManager.finish
+	}
+}
+
+// progressSpeedSmoothing is the weight of the latest sample in the speed of a
+// JSON progress event, smoothing the bursts of the network.
+const progressSpeedSmoothing = 0.3
+
+// jsonProgressEvent is a line of --progress-format json.
+type jsonProgressEvent struct {
+	Time     time.Time `json:"time"`
+	State    string    `json:"state"` // extracting, started, downloading, converting, retrying, completed or failed
+	ID       string    `json:"id"`    // Download ID, unique in the run, empty when extracting
+	StreamID string    `json:"stream_id"`
+	Title    string    `json:"title,omitempty"`
+	Bytes    int64     `json:"bytes"`
+	Total    int64     `json:"total"`             // 0 if unknown
+	Speed    float64   `json:"speed"`             // Bytes per second
+	Attempt  int       `json:"attempt,omitempty"` // Coming attempt, when retrying
+	Delay    float64   `json:"delay,omitempty"`   // Seconds before the attempt, when retrying
+	Path     string    `json:"path,omitempty"`    // Final file, when completed
+	Error    string    `json:"error,omitempty"`   // When retrying or failed
+
+	// When extracting
+	URL      string `json:"url,omitempty"`
+	Node     string `json:"node,omitempty"`     // What the extractor is working on
+	Items    int    `json:"items,omitempty"`    // Medias found so far
+	Requests int    `json:"requests,omitempty"` // API requests made so far
+
+	// When converting, in seconds of the media
+	Position float64 `json:"position,omitempty"`
+	Duration float64 `json:"duration,omitempty"` // 0 if unknown
+}
+
+// jsonProgress writes the events of the downloads as newline-delimited JSON,
+// one object per line, for programs tracking them.
+type jsonProgress struct {
+	mu        sync.Mutex
+	enc       *json.Encoder
+	downloads map[string]*jsonDownload // By download ID
+}
+
+// jsonDownload is the last state reported for a download.
+type jsonDownload struct {
+	bytes, total int64
+	speed        float64
+	at           time.Time
+}
+
+func newJSONProgress(w io.Writer) *jsonProgress {
+	return &jsonProgress{
+		enc:       json.NewEncoder(w),
+		downloads: make(map[string]*jsonDownload),
+	}
+}
+
+// handleEvent writes the stream events of the downloads and the progress of
+// long extractions.
+func (p *jsonProgress) handleEvent(e grab.Event) {
+	now := time.Now()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	var line jsonProgressEvent
+	switch e := e.(type) {
+	case grab.ExtractProgressEvent:
+		line = jsonProgressEvent{Time: now, State: "extracting", URL: e.URL, Node: e.Node, Items: e.Items, Requests: e.Requests}
+	case grab.StreamStartedEvent:
+		p.downloads[e.ID] = &jsonDownload{total: e.Stream.Size, at: now}
+		line = p.line(now, "started", e.ID, e.Stream)
+	case grab.ProgressEvent:
+		d := p.download(e.ID, now)
+		if elapsed := now.Sub(d.at).Seconds(); elapsed > 0 && e.Current >= d.bytes {
+			speed := float64(e.Current-d.bytes) / elapsed
+			if d.speed == 0 {
+				d.speed = speed
+			} else {
+				d.speed += progressSpeedSmoothing * (speed - d.speed)
+			}
+		}
+		d.bytes, d.total, d.at = e.Current, e.Total, now
+		line = p.line(now, "downloading", e.ID, e.Stream)
+	case grab.ConvertProgressEvent:
+		line = p.line(now, "converting", e.ID, e.Stream)
+		line.Position = e.Current.Seconds()
+		line.Duration = e.Total.Seconds()
+	case grab.RetryEvent:
+		p.download(e.ID, now).speed = 0
+		line = p.line(now, "retrying", e.ID, e.Stream)
+		line.Attempt = e.Attempt
+		line.Delay = e.Delay.Seconds()
+		line.Error = errorString(e.Err)
+	case grab.StreamCompletedEvent:
+		line = p.line(now, "completed", e.ID, e.Stream)
+		line.Path = e.Path
+		delete(p.downloads, e.ID)
+	case grab.StreamFailedEvent:
+		line = p.line(now, "failed", e.ID, e.Stream)
+		line.Error = errorString(e.Err)
+		delete(p.downloads, e.ID)
+	default:
+		return
+	}
+	p.enc.Encode(line)
+}
+
+// download returns the state of the download id, created if none was started.
+func (p *jsonProgress) download(id string, now time.Time) *jsonDownload {
+	d, ok := p.downloads[id]
+	if !ok {
+		d = &jsonDownload{at: now}
+		p.downloads[id] = d
+	}
+	return d
+}
+
+// line returns an event of the download id of stream in state, with the
+// bytes and speed last reported for it.
+func (p *jsonProgress) line(now time.Time, state, id string, stream grab.Stream) jsonProgressEvent {
+	line := jsonProgressEvent{Time: now, State: state, ID: id, StreamID: stream.ID, Title: stream.Title}
+	if d, ok := p.downloads[id]; ok {
+		line.Bytes, line.Total, line.Speed = d.bytes, d.total, d.speed
+	}
+	return line
+}
+
+func errorString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// queuePath holds --queue-file.
+var queuePath string
+
+// createQueueCommand creates the command managing the persisted download queue.
+func createQueueCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "queue",
+		Short: "Manage a persisted queue of URLs to download later",
+	}
+	cmd.PersistentFlags().StringVar(&queuePath, "queue-file", queuePath, "Queue file (default: user config dir/grab/queue.json)")
+	cmd.AddCommand(createQueueAddCommand(), createQueueListCommand(), createQueueRemoveCommand(), createQueueRunCommand())
+	return cmd
+}
+
+// queueFile returns the queue file selected by --queue-file or the default one.
+func queueFile() string {
+	if queuePath != "" {
+		return queuePath
+	}
+	return grab.DefaultQueuePath()
+}
+
+// createQueueAddCommand creates the command adding URLs to the queue.
+func createQueueAddCommand() *cobra.Command {
+	var priority int
+	cmd := &cobra.Command{
+		Use:   "add URL...",
+		Short: "Add URLs to the queue, - reads them from stdin",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var urls []string
+			for _, arg := range args {
+				if arg == "-" {
+					if err := eachListedURL(os.Stdin, func(url string) error {
+						urls = append(urls, url)
+						return nil
+					}); err != nil {
+						return err
+					}
+					continue
+				}
+				urls = append(urls, arg)
+			}
+
+			var added []grab.QueueEntry
+			err := grab.UpdateQueueFile(queueFile(), func(entries []grab.QueueEntry) ([]grab.QueueEntry, error) {
+				for _, url := range urls {
+					entry := grab.QueueEntry{ID: grab.NextQueueID(entries), URL: url, Priority: priority, Added: time.Now()}
+					entries = append(entries, entry)
+					added = append(added, entry)
+				}
+				return entries, nil
+			})
+			if err != nil {
+				return fmt.Errorf("failed to update queue: %w", err)
+			}
+			for _, entry := range added {
+				fmt.Printf("Queued %s: %s\n", entry.ID, entry.URL)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&priority, "priority", 0, "Priority of the URLs, higher priorities download first")
+	return cmd
+}
+
+// createQueueListCommand creates the command listing the queue.
+func createQueueListCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "list",
+		Short: "List queued URLs in download order",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			entries, err := grab.LoadQueueFile(queueFile())
+			if err != nil {
+				return fmt.Errorf("failed to read queue: %w", err)
+			}
+			if len(entries) == 0 {
+				fmt.Println("Queue is empty")
+				return nil
+			}
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tPRIORITY\tADDED\tURL\tLAST ERROR")
+			for _, entry := range entries {
+				fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\n", entry.ID, entry.Priority,
+					entry.Added.Format(time.DateTime), entry.URL, entry.Error)
+			}
+			return w.Flush()
+		},
+	}
+}
+
+// createQueueRemoveCommand creates the command removing entries from the queue.
+func createQueueRemoveCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "remove ID...",
+		Short: "Remove URLs from the queue by ID",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return grab.UpdateQueueFile(queueFile(), func(entries []grab.QueueEntry) ([]grab.QueueEntry, error) {
+				for _, id := range args {
+					i := slices.IndexFunc(entries, func(entry grab.QueueEntry) bool { return entry.ID == id })
+					if i < 0 {
+						return nil, fmt.Errorf("%w: %s", grab.ErrJobNotFound, id)
+					}
+					entries = slices.Delete(entries, i, i+1)
+				}
+				return entries, nil
+			})
+		},
+	}
+}
+
+// createQueueRunCommand creates the command draining the queue. It takes the
+// download flags of the root command, applied to every queued URL.
+func createQueueRunCommand() *cobra.Command {
+	var headerFlags, siteHeaderFlags []string
+	var jobs int
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Download the queued URLs, highest priority first",
+		Long: "Download the queued URLs, highest priority first. Finished URLs leave the queue,\n" +
+			"failed ones stay with their error and are tried again by the next run.",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepareOption(cmd, headerFlags, siteHeaderFlags); err != nil {
+				return err
+			}
+			return runQueue(cmd, jobs)
+		},
+	}
+	cmd.Flags().IntVarP(&jobs, "jobs", "j", 1, "Number of medias downloaded concurrently")
+	setupDownloadFlags(cmd, &headerFlags, &siteHeaderFlags)
+	return cmd
+}
+
+// runQueue downloads the entries of the queue file with up to jobs medias at a
+// time and updates the file with the outcome of each entry.
+func runQueue(cmd *cobra.Command, jobs int) (err error) {
+	path := queueFile()
+	entries, err := grab.LoadQueueFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read queue: %w", err)
+	}
+	if len(entries) == 0 {
+		fmt.Println("Queue is empty")
+		return nil
+	}
+
+	ctx := grab.NewContext(cmd.Context(), option)
+	defer func() {
+		if closeErr := ctx.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	defer startProgress(ctx)()
+
+	// Entries are extracted in queue order while earlier ones already download
+	queue := grab.NewQueue(ctx, jobs)
+	runDone := make(chan error, 1)
+	go func() { runDone <- queue.Run(cmd.Context()) }()
+
+	jobIDs := make(map[string][]string) // Job IDs by entry ID, of the extracted entries
+	failed := make(map[string]error)    // Errors by entry ID
+	for _, entry := range entries {
+		if cmd.Context().Err() != nil {
+			break
+		}
+		name, extractor, medias, err := grab.ExtractURL(ctx, entry.URL)
+		if err != nil {
+			failed[entry.ID] = err
+			continue
+		}
+		jobIDs[entry.ID] = []string{}
+		for _, media := range medias {
+			jobIDs[entry.ID] = append(jobIDs[entry.ID], queue.AddFrom(media, entry.Priority, entry.URL, name, extractor))
+		}
+	}
+	queue.Close()
+	runErr := <-runDone
+
+	status := make(map[string]grab.Job)
+	for _, job := range queue.Jobs() {
+		status[job.ID] = job
+	}
+	finished := make(map[string]bool)
+	for entryID, ids := range jobIDs {
+		done := true
+		for _, id := range ids {
+			switch job := status[id]; job.Status {
+			case grab.JobFailed:
+				failed[entryID] = job.Err
+				done = false
+			case grab.JobDone:
+			default:
+				done = false
+			}
+		}
+		finished[entryID] = done
+	}
+
+	err = grab.UpdateQueueFile(path, func(entries []grab.QueueEntry) ([]grab.QueueEntry, error) {
+		for i, entry := range entries {
+			if err := failed[entry.ID]; err != nil {
+				entries[i].Error = err.Error()
+			}
+		}
+		return slices.DeleteFunc(entries, func(entry grab.QueueEntry) bool { return finished[entry.ID] }), nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update queue: %w", err)
+	}
+	for id, err := range failed {
+		ctx.Logger().Error("Failed to download queued URL", "id", id, "error", err)
+	}
+	if runErr != nil {
+		return runErr
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%d queued URLs failed, they stay in the queue", len(failed))
+	}
+	return nil
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+// rawArgs holds the arguments of the current run, saved on interrupt for `grab resume`.
+var rawArgs []string
+
+// resumeState describes an interrupted run.
+type resumeState struct {
+	Dir     string      `json:"dir"`               // Working directory of the interrupted run
+	Args    []string    `json:"args"`              // Command line arguments of the interrupted run, without secrets
+	Secrets []secretArg `json:"secrets,omitempty"` // Flags of Args whose values were left out
+}
+
+// secretArg is a flag of an interrupted run whose value is not saved, grab
+// resume asks for it again.
+type secretArg struct {
+	Flag   string `json:"flag"`             // Flag name, e.g. "auth-pass"
+	Prefix string `json:"prefix,omitempty"` // Start of the value that is not secret, e.g. "Authorization: " of a --header
+}
+
+// secretFlags are the flags whose values are never saved for grab resume.
+var secretFlags = []string{"auth-pass", "auth-token", "auth-header", "proxy-pass", "webhook-secret", "hls-key"}
+
+// secretHeaders are the headers whose values in --header flags are never saved.
+var secretHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+// shortFlags maps the shorthands of the flags splitSecretArgs looks at to their names.
+var shortFlags = map[string]string{"H": "header", "x": "proxy"}
+
+// splitSecretArgs returns args without the secret values of flags, see
+// secretFlags and secretHeaders, and of --proxy URLs with a password, along
+// with the flags they were given to.
+func splitSecretArgs(args []string) ([]string, []secretArg) {
+	var kept []string
+	var secrets []secretArg
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			kept = append(kept, args[i:]...)
+			break
+		}
+		var name, value string
+		var hasValue bool
+		if rest, ok := strings.CutPrefix(arg, "--"); ok {
+			name, value, hasValue = strings.Cut(rest, "=")
+		} else if len(arg) >= 2 && arg[0] == '-' && shortFlags[arg[1:2]] != "" {
+			name, value, hasValue = shortFlags[arg[1:2]], strings.TrimPrefix(arg[2:], "="), len(arg) > 2
+		}
+		if name != "header" && name != "proxy" && !slices.Contains(secretFlags, name) {
+			kept = append(kept, arg)
+			continue
+		}
+		if !hasValue {
+			if i+1 == len(args) {
+				kept = append(kept, arg)
+				break
+			}
+			i++
+			value = args[i]
+		}
+		if prefix, secret := secretValue(name, value); secret {
+			secrets = append(secrets, secretArg{Flag: name, Prefix: prefix})
+			continue
+		}
+		kept = append(kept, "--"+name+"="+value)
+	}
+	return kept, secrets
+}
+
+// secretValue reports whether value of flag name is secret, and which start
+// of it is not.
+func secretValue(name, value string) (string, bool) {
+	switch name {
+	case "header":
+		header, _, _ := strings.Cut(value, ":")
+		header = strings.TrimSpace(header)
+		if slices.ContainsFunc(secretHeaders, func(h string) bool { return strings.EqualFold(h, header) }) {
+			return header + ": ", true
+		}
+		return "", false
+	case "proxy":
+		u, err := url.Parse(value)
+		if err != nil || u.User == nil {
+			return "", false
+		}
+		_, hasPassword := u.User.Password()
+		return "", hasPassword
+	}
+	return "", true
+}
+
+// askSecretArgs prompts for the values of secrets on in and returns them as
+// flags, leaving out those answered with nothing.
+func askSecretArgs(in *bufio.Reader, secrets []secretArg) ([]string, error) {
+	var args []string
+	for _, s := range secrets {
+		label := "--" + s.Flag
+		if s.Prefix != "" {
+			label += " " + strings.TrimSpace(s.Prefix)
+		}
+		value, err := prompt(in, fmt.Sprintf("Value of %s, not saved with the interrupted run (empty to leave it out): ", label), true)
+		if err != nil {
+			return nil, err
+		}
+		if value != "" {
+			args = append(args, "--"+s.Flag+"="+s.Prefix+value)
+		}
+	}
+	return args, nil
+}
+
+// resumeStatePath returns the location of the resume state file.
+func resumeStatePath() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "grab", "resume.json"), nil
+}
+
+// saveResumeState records the current run so it can be continued with `grab resume`.
+// Secrets are left out, and the file is readable by the user only.
+func saveResumeState() error {
+	path, err := resumeStatePath()
+	if err != nil {
+		return err
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	args, secrets := splitSecretArgs(rawArgs)
+	data, err := json.Marshal(resumeState{Dir: wd, Args: args, Secrets: secrets})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	os.Remove(tmp) // Created anew with the mode below
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+// clearResumeState removes the resume state after a run completed.
+func clearResumeState() {
+	if path, err := resumeStatePath(); err == nil {
+		os.Remove(path)
+	}
+}
+
+// createResumeCommand creates the command that continues the last interrupted run.
+func createResumeCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "resume",
+		Short: "Resume the last interrupted download",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := resumeStatePath()
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) {
+				return fmt.Errorf("nothing to resume")
+			} else if err != nil {
+				return fmt.Errorf("failed to read resume state: %w", err)
+			}
+			var state resumeState
+			if err := json.Unmarshal(data, &state); err != nil {
+				return fmt.Errorf("invalid resume state %s: %w", path, err)
+			}
+			secretArgs, err := askSecretArgs(bufio.NewReader(os.Stdin), state.Secrets)
+			if err != nil {
+				return err
+			}
+			if err := os.Chdir(state.Dir); err != nil {
+				return fmt.Errorf("failed to enter %s: %w", state.Dir, err)
+			}
+
+			// Flags first, arguments after a "--" are not flags
+			rawArgs = append(secretArgs, state.Args...)
+			root := createRootCommand()
+			root.SetArgs(rawArgs)
+			root.SilenceErrors = true // Reported by this command instead
+			root.SilenceUsage = true
+			return root.ExecuteContext(cmd.Context())
+		},
+	}
+}
@@ -0,0 +1,307 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/term"
+
+	"github.com/hydrz/grab"
+	"github.com/hydrz/grab/utils"
+)
+
+// tuiMode holds --tui.
+var tuiMode bool
+
+// activeTUI is the interface of a run with --tui, nil otherwise.
+var activeTUI *tui
+
+// tuiRefresh is the interval between two redraws of the interface.
+const tuiRefresh = 500 * time.Millisecond
+
+// tuiLogLines is the number of log lines kept for the log pane.
+const tuiLogLines = 200
+
+// tui is the full screen interface of --tui: a table of the stream downloads
+// of the run, on which the selected download can be paused or canceled, above
+// a pane with the latest log lines.
+type tui struct {
+	cancel       context.CancelFunc // Interrupts the run
+	state        *term.State        // Terminal state to restore
+	in           *os.File           // Keys, see openTUIInput
+	restoreInput func()
+	logs         *logPane
+
+	mu          sync.Mutex
+	downloaders []*grab.Downloader
+	rows        []tuiRow // Rows of the last redraw, in display order
+	selected    int
+
+	drawMu   sync.Mutex // Serializes redraws and the restore of the terminal
+	done     chan struct{}
+	drawn    chan struct{} // Closed once the redraw loop returned
+	stopOnce sync.Once
+}
+
+// tuiRow is a stream download shown in the table.
+type tuiRow struct {
+	downloader *grab.Downloader
+	snapshot   grab.TransferSnapshot
+}
+
+// startTUI switches the terminal to the interface of --tui. Quitting it calls cancel.
+func startTUI(cancel context.CancelFunc) (*tui, error) {
+	in, out := int(os.Stdin.Fd()), int(os.Stdout.Fd())
+	if !term.IsTerminal(in) || !term.IsTerminal(out) {
+		return nil, errors.New("--tui needs an interactive terminal")
+	}
+	state, err := term.MakeRaw(in)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up terminal: %w", err)
+	}
+	t := &tui{
+		cancel: cancel,
+		state:  state,
+		logs:   &logPane{},
+		done:   make(chan struct{}),
+		drawn:  make(chan struct{}),
+	}
+	t.in, t.restoreInput = openTUIInput()
+	// Alternate screen, hidden cursor
+	fmt.Print("\x1b[?1049h\x1b[?25l")
+	go t.readKeys()
+	go t.redrawLoop()
+	return t, nil
+}
+
+// watch adds the streams of d to the table.
+func (t *tui) watch(d *grab.Downloader) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.downloaders = append(t.downloaders, d)
+}
+
+// stop restores the terminal. It can be called more than once.
+func (t *tui) stop() {
+	if t == nil {
+		return
+	}
+	t.stopOnce.Do(func() {
+		// Redraws wait for drawMu and return once done is closed, drawMu is
+		// only held to restore the terminal after the last one
+		close(t.done)
+		t.in.SetReadDeadline(time.Now())
+		<-t.drawn
+		t.drawMu.Lock()
+		defer t.drawMu.Unlock()
+		t.restoreInput()
+		fmt.Print("\x1b[?25h\x1b[?1049l")
+		term.Restore(int(os.Stdin.Fd()), t.state)
+	})
+}
+
+// readKeys handles the keys pressed until the interface stops.
+func (t *tui) readKeys() {
+	buf := make([]byte, 16)
+	for {
+		n, err := t.in.Read(buf)
+		if err != nil {
+			return
+		}
+		select {
+		case <-t.done:
+			return
+		default:
+		}
+		switch key := string(buf[:n]); key {
+		case "\x1b[A", "k":
+			t.move(-1)
+		case "\x1b[B", "j":
+			t.move(1)
+		case "p", " ":
+			t.act(func(row tuiRow) error {
+				if row.snapshot.Paused {
+					return row.downloader.Resume(row.snapshot.StreamID)
+				}
+				return row.downloader.Pause(row.snapshot.StreamID)
+			})
+		case "c", "x":
+			t.act(func(row tuiRow) error { return row.downloader.Cancel(row.snapshot.StreamID) })
+		case "q", "\x03": // Ctrl+C doesn't raise SIGINT in raw mode
+			t.cancel()
+		default:
+			continue
+		}
+		t.redraw()
+	}
+}
+
+// move moves the selection by delta rows.
+func (t *tui) move(delta int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.selected = max(0, min(len(t.rows)-1, t.selected+delta))
+}
+
+// act applies fn to the selected row, logging its error.
+func (t *tui) act(fn func(tuiRow) error) {
+	t.mu.Lock()
+	if t.selected >= len(t.rows) {
+		t.mu.Unlock()
+		return
+	}
+	row := t.rows[t.selected]
+	t.mu.Unlock()
+	if err := fn(row); err != nil {
+		fmt.Fprintf(t.logs, "%s: %v\n", row.snapshot.Title, err)
+	}
+}
+
+// redrawLoop redraws the interface until it stops.
+func (t *tui) redrawLoop() {
+	defer close(t.drawn)
+	ticker := time.NewTicker(tuiRefresh)
+	defer ticker.Stop()
+	for {
+		t.redraw()
+		select {
+		case <-t.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// redraw renders the table and the log pane over the whole terminal.
+func (t *tui) redraw() {
+	t.drawMu.Lock()
+	defer t.drawMu.Unlock()
+	select {
+	case <-t.done:
+		return
+	default:
+	}
+	width, height, err := term.GetSize(int(os.Stdout.Fd()))
+	if err != nil {
+		width, height = 80, 24
+	}
+
+	t.mu.Lock()
+	var rows []tuiRow
+	for _, d := range t.downloaders {
+		for _, snapshot := range d.Snapshot() {
+			rows = append(rows, tuiRow{downloader: d, snapshot: snapshot})
+		}
+	}
+	t.rows = rows
+	t.selected = max(0, min(len(rows)-1, t.selected))
+	selected := t.selected
+	t.mu.Unlock()
+
+	// The table takes up to two thirds of the screen, the log pane the rest
+	tableHeight := max(1, height*2/3-2)
+	first := max(0, min(selected-tableHeight/2, len(rows)-tableHeight))
+	nameWidth := max(10, width-56)
+
+	var lines []string
+	lines = append(lines, fit("grab  ↑/↓ select  p pause/resume  c cancel  q quit", width))
+	lines = append(lines, fit(fmt.Sprintf("  %-*s %-11s %6s %10s %10s %9s", nameWidth, "NAME", "PHASE", "DONE", "SIZE", "SPEED", "ETA"), width))
+	for i := first; i < len(rows) && i < first+tableHeight; i++ {
+		line := fit(formatRow(rows[i].snapshot, nameWidth), width-2)
+		if i == selected {
+			line = "\x1b[7m> " + line + "\x1b[0m"
+		} else {
+			line = "  " + line
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, fit("── log "+strings.Repeat("─", max(0, width-7)), width))
+	logHeight := max(0, height-len(lines))
+	for _, line := range t.logs.last(logHeight) {
+		lines = append(lines, fit(line, width))
+	}
+
+	// Home, then every line cleared up to its end, then the rest of the screen
+	fmt.Print("\x1b[H" + strings.Join(lines, "\x1b[K\r\n") + "\x1b[K\x1b[J")
+}
+
+// formatRow formats the table columns of a stream download.
+func formatRow(s grab.TransferSnapshot, nameWidth int) string {
+	phase := string(s.Phase)
+	if s.Paused {
+		phase = "paused"
+	} else if s.Phase == grab.PhaseFailed && strings.HasSuffix(s.Error, grab.ErrStreamCanceled.Error()) {
+		phase = "canceled"
+	}
+	done, size, speed, eta := "", "", "", ""
+	if s.Phase == grab.PhaseFailed {
+		// The byte counts of failed transfers aren't meaningful
+		size = utils.FormatBytes(s.Total)
+	} else if s.Total > 0 {
+		done = fmt.Sprintf("%.1f%%", float64(s.Current)*100/float64(s.Total))
+		size = utils.FormatBytes(s.Total)
+		if s.Speed > 0 && s.Current < s.Total {
+			eta = (time.Duration(float64(s.Total-s.Current)/s.Speed) * time.Second).String()
+		}
+	} else {
+		size = utils.FormatBytes(s.Current)
+	}
+	if s.Speed > 0 {
+		speed = utils.FormatBytes(int64(s.Speed)) + "/s"
+	}
+	name := s.Title
+	if name == "" {
+		name = s.StreamID
+	}
+	return fmt.Sprintf("%-*s %-11s %6s %10s %10s %9s", nameWidth, fit(name, nameWidth), phase, done, size, speed, eta)
+}
+
+// fit truncates s to width runes.
+func fit(s string, width int) string {
+	runes := []rune(s)
+	if len(runes) <= width {
+		return s
+	}
+	return string(runes[:max(0, width)])
+}
+
+// logPane keeps the latest lines written to it.
+type logPane struct {
+	mu      sync.Mutex
+	lines   []string
+	partial []byte // Last line, not terminated yet
+}
+
+func (p *logPane) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.partial = append(p.partial, b...)
+	for {
+		i := bytes.IndexByte(p.partial, '\n')
+		if i < 0 {
+			break
+		}
+		p.lines = append(p.lines, string(p.partial[:i]))
+		p.partial = p.partial[i+1:]
+	}
+	if len(p.lines) > tuiLogLines {
+		p.lines = p.lines[len(p.lines)-tuiLogLines:]
+	}
+	return len(b), nil
+}
+
+// last returns the latest n lines.
+func (p *logPane) last(n int) []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.lines[max(0, len(p.lines)-n):]...)
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// openTUIInput returns stdin as a file whose reads return at its read
+// deadline, so that stopping the interface ends the read of readKeys, and a
+// function putting stdin, shared with the shell, back in blocking mode.
+func openTUIInput() (*os.File, func()) {
+	fd := int(os.Stdin.Fd())
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		return os.Stdin, func() {}
+	}
+	return os.NewFile(uintptr(fd), "/dev/stdin"), func() { syscall.SetNonblock(fd, false) }
+}
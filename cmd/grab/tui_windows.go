@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "os"
+
+// openTUIInput returns stdin. Console reads have no deadline on Windows, the
+// read of readKeys only returns with the next key.
+func openTUIInput() (*os.File, func()) {
+	return os.Stdin, func() {}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// createVerifyCommand creates the command that checks downloaded files against their checksums.
+func createVerifyCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "verify [DIR]",
+		Short: "Verify downloaded files against their .sha256 checksums",
+		Long:  "Re-hash files downloaded with --checksum and report corrupted, missing or incomplete files.",
+		Args:  cobra.MaximumNArgs(1),
+		// Failed verification is not a usage error
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := option.OutputPath
+			if len(args) > 0 {
+				dir = args[0]
+			}
+			results, err := grab.VerifyDir(dir)
+			if err != nil {
+				return fmt.Errorf("failed to verify %s: %w", dir, err)
+			}
+
+			bad := 0
+			for _, result := range results {
+				if result.Status != grab.ChecksumOK {
+					bad++
+				}
+				if result.Err != nil {
+					fmt.Printf("%-10s %s (%v)\n", result.Status, result.Path, result.Err)
+				} else {
+					fmt.Printf("%-10s %s\n", result.Status, result.Path)
+				}
+			}
+			if bad > 0 {
+				return fmt.Errorf("%d of %d files need to be downloaded again", bad, len(results))
+			}
+			return nil
+		},
+	}
+}
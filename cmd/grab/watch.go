@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/hydrz/grab"
+)
+
+// Subdirectories of a watched directory its entries are moved to once processed
+const (
+	watchDoneDir   = "done"
+	watchFailedDir = "failed"
+)
+
+// createWatchCommand creates the command downloading the URLs dropped into a
+// directory or appended to a file.
+func createWatchCommand() *cobra.Command {
+	var headerFlags, siteHeaderFlags []string
+	var interval time.Duration
+	cmd := &cobra.Command{
+		Use:   "watch <directory|file>",
+		Short: "Download the URLs dropped into a directory or appended to a file",
+		Long: "Download the URLs dropped into a directory or appended to a file, until interrupted.\n\n" +
+			"In a directory, each .url shortcut or .txt list of URLs is read once it stopped changing,\n" +
+			"then moved to done/ or, if a download failed, to failed/. A file is read as a list of URLs,\n" +
+			"one per line, and lines appended later are downloaded as they come.\n" +
+			"URLs are downloaded with the download flags of this command.",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := prepareOption(cmd, headerFlags, siteHeaderFlags); err != nil {
+				return err
+			}
+			return runWatch(cmd, args[0], interval)
+		},
+	}
+	cmd.Flags().DurationVar(&interval, "interval", 5*time.Second, "How often to look for new URLs")
+	setupDownloadFlags(cmd, &headerFlags, &siteHeaderFlags)
+	return cmd
+}
+
+// runWatch downloads the URLs added to path every interval until the command
+// is interrupted.
+func runWatch(cmd *cobra.Command, path string, interval time.Duration) (err error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to watch %s: %w", path, err)
+	}
+	if interval <= 0 {
+		return fmt.Errorf("invalid --interval %s", interval)
+	}
+
+	ctx := grab.NewContext(cmd.Context(), option)
+	defer func() {
+		if closeErr := ctx.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	finishProgress := startProgress(ctx)
+	defer finishProgress()
+
+	w := &watcher{ctx: ctx, path: path, interval: interval, finishProgress: finishProgress}
+	scan := w.scanFile
+	if info.IsDir() {
+		scan = w.scanDir
+	}
+	if !ctx.Option().Silent {
+		fmt.Fprintf(os.Stderr, "Watching %s for URLs\n", path)
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := scan(); err != nil {
+			if errors.Is(err, context.Canceled) {
+				return nil
+			}
+			return err
+		}
+		select {
+		case <-ticker.C:
+		case <-cmd.Context().Done():
+			return nil
+		}
+	}
+}
+
+// watcher finds the URLs added to a watched directory or file.
+type watcher struct {
+	ctx            *grab.Context
+	path           string
+	interval       time.Duration
+	finishProgress func()
+	offset         int64 // Bytes of the watched file read so far
+}
+
+// scanDir processes the entries of the watched directory that stopped changing.
+func (w *watcher) scanDir() error {
+	entries, err := os.ReadDir(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", w.path, err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		ext := strings.ToLower(filepath.Ext(name))
+		if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") || (ext != ".url" && ext != ".txt") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil || time.Since(info.ModTime()) < w.interval {
+			continue // Possibly still being written
+		}
+		path := filepath.Join(w.path, name)
+		urls, err := readInboxEntry(path)
+		if err != nil {
+			w.ctx.Logger().Error("Failed to read inbox entry", "file", path, "error", err)
+			w.move(path, watchFailedDir)
+			continue
+		}
+		failed, err := w.download(urls)
+		if err != nil {
+			return err
+		}
+		if failed {
+			w.move(path, watchFailedDir)
+		} else {
+			w.move(path, watchDoneDir)
+		}
+	}
+	return nil
+}
+
+// scanFile processes the complete lines appended to the watched file since
+// the last scan, all of them again if it was truncated.
+func (w *watcher) scanFile() error {
+	f, err := os.Open(w.path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", w.path, err)
+	}
+	defer f.Close()
+	if info, err := f.Stat(); err == nil && info.Size() < w.offset {
+		w.offset = 0
+	}
+	if _, err := f.Seek(w.offset, io.SeekStart); err != nil {
+		return err
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return err
+	}
+	end := bytes.LastIndexByte(data, '\n')
+	if end < 0 {
+		return nil // No complete line yet
+	}
+	var urls []string
+	eachListedURL(bytes.NewReader(data[:end+1]), func(url string) error {
+		urls = append(urls, url)
+		return nil
+	})
+	w.offset += int64(end + 1)
+	_, err = w.download(urls)
+	return err
+}
+
+// download downloads urls in order, reporting whether any failed. It only
+// returns an error if the command is interrupted.
+func (w *watcher) download(urls []string) (failed bool, err error) {
+	defer w.finishProgress()
+	for _, url := range urls {
+		downloader, err := processURL(w.ctx, url)
+		if errors.Is(err, context.Canceled) {
+			return true, err
+		}
+		if err != nil {
+			w.ctx.Logger().Error("Failed to process URL", "url", url, "error", err)
+			failed = true
+		} else if downloader != nil && len(downloader.Failures()) > 0 {
+			failed = true
+		}
+	}
+	return failed, nil
+}
+
+// move moves the processed entry at path into the subdirectory dir of the
+// watched directory, keeping entries of the same name already there.
+func (w *watcher) move(path, dir string) {
+	target := filepath.Join(w.path, dir, filepath.Base(path))
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		w.ctx.Logger().Error("Failed to move inbox entry", "file", path, "error", err)
+		return
+	}
+	if _, err := os.Stat(target); err == nil {
+		target = filepath.Join(w.path, dir, time.Now().Format("20060102-150405-")+filepath.Base(path))
+	}
+	if err := os.Rename(path, target); err != nil {
+		w.ctx.Logger().Error("Failed to move inbox entry", "file", path, "error", err)
+	}
+}
+
+// readInboxEntry returns the URLs of a dropped file: the URL of a .url
+// Internet shortcut, or the URLs listed one per line in other files.
+func readInboxEntry(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	var urls []string
+	if strings.EqualFold(filepath.Ext(path), ".url") {
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			if key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "="); ok && strings.EqualFold(key, "URL") {
+				urls = append(urls, strings.TrimSpace(value))
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	} else if err := eachListedURL(f, func(url string) error {
+		urls = append(urls, url)
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if len(urls) == 0 {
+		return nil, errors.New("no URL found")
+	}
+	return urls, nil
+}
@@ -0,0 +1,154 @@
+// Command libgrab builds grab as a C shared library for desktop applications
+// written in other languages:
+//
+//	go build -buildmode=c-shared -o libgrab.so ./cmd/libgrab
+//
+// The build also writes libgrab.h declaring the exported functions. Options
+// are passed as a JSON object of grab.Option fields, e.g.
+// {"OutputPath": "downloads", "Quality": "720p"}, durations in nanoseconds.
+// Strings returned by the library must be released with grab_free.
+package main
+
+/*
+#include <stdint.h>
+#include <stdlib.h>
+
+typedef void (*grab_progress_cb)(int64_t current, int64_t total, const char *description, void *user_data);
+
+static inline void grab_call_progress(grab_progress_cb cb, int64_t current, int64_t total, const char *description, void *user_data) {
+	cb(current, total, description, user_data);
+}
+*/
+import "C"
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"unsafe"
+
+	"github.com/hydrz/grab"
+	_ "github.com/hydrz/grab/extractors"
+)
+
+// progressHandler is the callback registered with grab_set_progress_callback.
+var progressHandler struct {
+	mu       sync.Mutex
+	cb       C.grab_progress_cb
+	userData unsafe.Pointer
+}
+
+func main() {}
+
+// grab_set_progress_callback registers cb to receive the progress of later
+// downloads, or removes it if cb is NULL. cb may be called from several
+// threads at once and must not keep description after returning.
+//
+//export grab_set_progress_callback
+func grab_set_progress_callback(cb C.grab_progress_cb, userData unsafe.Pointer) {
+	progressHandler.mu.Lock()
+	defer progressHandler.mu.Unlock()
+	progressHandler.cb = cb
+	progressHandler.userData = userData
+}
+
+// grab_extract_json extracts the medias of url without downloading them and
+// returns {"medias": [...]} on success or {"error": "..."} on failure.
+//
+//export grab_extract_json
+func grab_extract_json(url, optionsJSON *C.char) *C.char {
+	var result struct {
+		Medias []grab.Media `json:"medias,omitempty"`
+		Error  string       `json:"error,omitempty"`
+	}
+	medias, err := extract(C.GoString(url), C.GoString(optionsJSON))
+	if err != nil {
+		result.Error = err.Error()
+	} else {
+		result.Medias = medias
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		data, _ = json.Marshal(map[string]string{"error": err.Error()})
+	}
+	return C.CString(string(data))
+}
+
+// grab_download downloads the medias of url. It blocks until the download
+// finished and returns NULL on success or the error message on failure.
+//
+//export grab_download
+func grab_download(url, optionsJSON *C.char) *C.char {
+	if err := download(C.GoString(url), C.GoString(optionsJSON)); err != nil {
+		return C.CString(err.Error())
+	}
+	return nil
+}
+
+// grab_free releases a string returned by the library.
+//
+//export grab_free
+func grab_free(s *C.char) {
+	C.free(unsafe.Pointer(s))
+}
+
+// parseOptions returns the options given as JSON on top of the defaults.
+func parseOptions(optionsJSON string) (grab.Option, error) {
+	option := *grab.DefaultOptions
+	option.Silent = true // Host applications get progress through the callback, not stderr
+	if optionsJSON != "" {
+		if err := json.Unmarshal([]byte(optionsJSON), &option); err != nil {
+			return grab.Option{}, fmt.Errorf("invalid options: %w", err)
+		}
+	}
+	return option, nil
+}
+
+func extract(url, optionsJSON string) ([]grab.Media, error) {
+	option, err := parseOptions(optionsJSON)
+	if err != nil {
+		return nil, err
+	}
+	option.ExtractOnly = true
+	results, err := grab.Fetch(context.Background(), url, grab.WithOption(option))
+	if err != nil {
+		return nil, err
+	}
+	medias := make([]grab.Media, 0, len(results))
+	for _, result := range results {
+		medias = append(medias, result.Media)
+	}
+	return medias, nil
+}
+
+func download(url, optionsJSON string) error {
+	option, err := parseOptions(optionsJSON)
+	if err != nil {
+		return err
+	}
+	option.ExtractOnly = false
+	results, err := grab.Fetch(context.Background(), url, grab.WithOption(option), grab.WithProgress(reportProgress))
+	if err != nil {
+		return err
+	}
+	for _, result := range results {
+		if len(result.Failures) > 0 {
+			return fmt.Errorf("%d stream(s) of %s failed, first: %s", len(result.Failures), result.Media.Title, result.Failures[0].Err)
+		}
+	}
+	return nil
+}
+
+// reportProgress forwards progress to the registered C callback, if any.
+func reportProgress(_ string, current, total int64, description string) {
+	progressHandler.mu.Lock()
+	cb, userData := progressHandler.cb, progressHandler.userData
+	progressHandler.mu.Unlock()
+	if cb == nil {
+		return
+	}
+	desc := C.CString(description)
+	defer C.free(unsafe.Pointer(desc))
+	C.grab_call_progress(cb, C.int64_t(current), C.int64_t(total), desc, userData)
+}
@@ -2,9 +2,16 @@ package grab
 
 import (
 	"context"
+	"io"
 	"log/slog"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/go-resty/resty/v2"
+	"github.com/gregjones/httpcache"
+	"github.com/hydrz/grab/utils"
 )
 
 // Context implements the Context for internal use.
@@ -13,19 +20,96 @@ type Context struct {
 	option           Option
 	client           *resty.Client
 	logger           *slog.Logger
-	progressCallback ProgressCallback
+	logFile          *utils.RotatingFile // Receives the logs too, nil unless LogFile is set
+	events           eventBus
+	extraction       extraction       // Progress of the running ExtractURL
+	interceptors     interceptors     // Installed on client, see Intercept
+	retry            RetryPolicy      // Set with SetRetryPolicy, derived from option if zero
+	transferIDs      atomic.Int64     // Last TransferSnapshot.ID given out
+	downloads        atomic.Int64     // Medias downloaded so far, for MaxDownloads
+	progressCallback ProgressCallback // Set with SetProgressCallback
+	progressCancel   func()           // Unsubscribes progressCallback
+	rateLimiter      *utils.Bucket    // Shared by every download of this context
+	memory           *utils.Budget    // Bytes of download buffers shared by every download of this context
+	cacheOnce        sync.Once
+	cache            httpcache.Cache
+	archiveOnce      sync.Once
+	archive          *archiveWriter // Archive receiving finished files, nil unless ArchiveOutput is set
+	archiveErr       error
+	downloadedOnce   sync.Once
+	downloaded       *downloadArchive // Medias downloaded by earlier runs, nil unless DownloadArchive is set
+	downloadedErr    error
+	credentialsMu    sync.Mutex
+	credentialStore  CredentialStore       // Set with SetCredentialStore, DefaultCredentialStore if nil
+	credentials      map[string]Credential // Stored credentials read so far, by extractor name
 }
 
 // NewContext creates a new Context with the provided options.
 func NewContext(ctx context.Context, option Option) *Context {
-	client := newClient(option)
-	logger := newLogger(option)
-	return &Context{
+	c := &Context{
 		ctx:    ctx,
 		option: option,
-		client: client,
-		logger: logger,
+		client: newClient(option),
+
+		memory: utils.NewBudget(option.MaxMemory),
+	}
+	schedule, scheduleErr := c.rateSchedule()
+	c.rateLimiter = utils.NewScheduledBucket(option.RateLimit, schedule)
+	var logFileErr error
+	if option.LogFile != "" {
+		maxSize, maxFiles := option.LogMaxSize, option.LogMaxFiles
+		if maxSize <= 0 {
+			maxSize = defaultLogMaxSize
+		}
+		if maxFiles <= 0 {
+			maxFiles = defaultLogMaxFiles
+		}
+		c.logFile, logFileErr = utils.OpenRotatingFile(option.LogFile, maxSize, maxFiles)
+	}
+	c.logger = c.newLogger(os.Stdout)
+	c.client.SetLogger(restyLogger{c})
+	if logFileErr != nil {
+		c.logger.Warn("Logging to the console only", "file", option.LogFile, "error", logFileErr)
+	}
+	if scheduleErr != nil {
+		c.logger.Warn("Ignoring the rate schedule", "error", scheduleErr)
+	}
+	c.interceptors.install(c.client)
+	return c
+}
+
+// rateSchedule returns the periods of RateSchedule, nil if none.
+func (c *Context) rateSchedule() (utils.RateSchedule, error) {
+	if c.option.RateSchedule == "" {
+		return nil, nil
+	}
+	return utils.ParseRateSchedule(c.option.RateSchedule)
+}
+
+// WaitStart blocks until Option.StartAt, returning early with the error of
+// the context if it is done meanwhile. ExtractURL calls it first.
+func (c *Context) WaitStart() error {
+	delay := time.Until(c.option.StartAt)
+	if c.option.StartAt.IsZero() || delay <= 0 {
+		return nil
+	}
+	c.logger.Info("Waiting to start", "at", c.option.StartAt.Format(time.DateTime), "in", delay.Round(time.Second))
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-c.Context().Done():
+		return c.Context().Err()
+	}
+}
+
+// newLogger returns a logger writing to w and to the log file, if any.
+func (c *Context) newLogger(w io.Writer) *slog.Logger {
+	if c.logFile == nil {
+		return newLogger(c.Option(), w, nil)
 	}
+	return newLogger(c.Option(), w, c.logFile)
 }
 
 // Context returns the context associated with this Context.
@@ -45,24 +129,117 @@ func (c *Context) Option() Option {
 func (c *Context) Client() *resty.Client {
 	if c.client == nil {
 		c.client = newClient(c.Option())
+		c.client.SetLogger(restyLogger{c})
+		c.interceptors.install(c.client)
 	}
 	return c.client
 }
 
+// HTTPCache returns the HTTP cache extractors should use for API calls, e.g.
+// with httpcache.NewTransport, or nil if caching is disabled.
+func (c *Context) HTTPCache() httpcache.Cache {
+	c.cacheOnce.Do(func() {
+		if c.option.NoCache {
+			return
+		}
+		dir := c.option.CacheDir
+		if dir == "" {
+			dir = DefaultCacheDir()
+		}
+		c.cache = newDiskCache(dir, c.option.CacheMaxSize, c.option.CacheTTL)
+	})
+	return c.cache
+}
+
 // Logger returns the logger associated with this Context.
 func (c *Context) Logger() *slog.Logger {
 	if c.logger == nil {
-		c.logger = newLogger(c.Option())
+		c.logger = c.newLogger(os.Stdout)
 	}
 	return c.logger
 }
 
-// SetProgressCallback sets the progress callback for the context
+// SetLogOutput makes the logger of the context write to w instead of stdout.
+// The log file, if any, keeps receiving the logs.
+func (c *Context) SetLogOutput(w io.Writer) {
+	c.logger = c.newLogger(w)
+}
+
+// SetLogger makes the context log to logger, e.g. one of the application
+// embedding grab or slog.New with a handler of its own, instead of the text
+// logger it creates. Levels are then up to logger: Debug, Verbose, Silent and
+// the log file no longer apply. A nil logger restores the default one.
+func (c *Context) SetLogger(logger *slog.Logger) {
+	if logger == nil {
+		logger = c.newLogger(os.Stdout)
+	}
+	c.logger = logger
+}
+
+// SetProgressCallback sets the progress callback for the context, replacing
+// the previous one.
+//
+// Deprecated: Use Subscribe, ProgressEvents(callback) passes progress events
+// to a ProgressCallback.
 func (c *Context) SetProgressCallback(callback ProgressCallback) {
+	if c.progressCancel != nil {
+		c.progressCancel()
+		c.progressCancel = nil
+	}
 	c.progressCallback = callback
+	if callback != nil {
+		c.progressCancel = c.Subscribe(ProgressEvents(callback))
+	}
 }
 
 // GetProgressCallback returns the progress callback
+//
+// Deprecated: Use Subscribe.
 func (c *Context) GetProgressCallback() ProgressCallback {
 	return c.progressCallback
 }
+
+// archiveWriter returns the archive of ArchiveOutput, creating it on first use,
+// or nil if files are kept loose in the output directory.
+func (c *Context) archiveWriter() (*archiveWriter, error) {
+	c.archiveOnce.Do(func() {
+		if c.option.ArchiveOutput == "" {
+			return
+		}
+		c.archive, c.archiveErr = openArchive(c.option.ArchiveOutput, c.option.OutputPath)
+	})
+	return c.archive, c.archiveErr
+}
+
+// downloadArchive returns the download archive of DownloadArchive, reading it
+// on first use, or nil if none is used.
+func (c *Context) downloadArchive() (*downloadArchive, error) {
+	c.downloadedOnce.Do(func() {
+		if c.option.DownloadArchive == "" {
+			return
+		}
+		c.downloaded, c.downloadedErr = openDownloadArchive(c.option.DownloadArchive)
+	})
+	return c.downloaded, c.downloadedErr
+}
+
+// Close finishes the archive of ArchiveOutput, if any, writes the cookies to
+// SaveCookies, if set, and closes the log file. It must be called once all
+// downloads of the context are done, or the archive is left truncated.
+func (c *Context) Close() error {
+	var err error
+	if c.archive != nil {
+		err = c.archive.Close()
+	}
+	if c.option.SaveCookies != "" {
+		if saveErr := c.SaveCookies(c.option.SaveCookies); err == nil {
+			err = saveErr
+		}
+	}
+	if c.logFile != nil {
+		if closeErr := c.logFile.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
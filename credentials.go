@@ -0,0 +1,337 @@
+package grab
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// credentialsFileName is the credentials file inside the user config
+// directory, written on request where no OS keyring is available.
+const credentialsFileName = "grab/credentials.json"
+
+// keyringService is the service the credentials are stored under in the OS keyring.
+const keyringService = "grab"
+
+// Credential is what an extractor needs to log in to its site: a token, or a
+// username and password.
+type Credential struct {
+	Username string `json:"username,omitempty"`
+	Password string `json:"password,omitempty"`
+	Token    string `json:"token,omitempty"`
+}
+
+// IsZero reports whether c holds nothing.
+func (c Credential) IsZero() bool {
+	return c == Credential{}
+}
+
+// CredentialStore keeps the credentials of extractors by extractor name.
+type CredentialStore interface {
+	// Get returns the credential of name, ErrNoCredential if none is stored.
+	Get(name string) (Credential, error)
+	// Set stores c as the credential of name, replacing the previous one.
+	Set(name string, c Credential) error
+	// Delete removes the credential of name, if any.
+	Delete(name string) error
+}
+
+// DefaultCredentialsPath returns the credentials file: $XDG_CONFIG_HOME/grab/credentials.json
+// on Linux, with the platform equivalents elsewhere.
+func DefaultCredentialsPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, filepath.FromSlash(credentialsFileName))
+}
+
+// DefaultCredentialStore returns the store of the credentials saved with
+// grab auth login: the OS keyring, through the security command on macOS and
+// secret-tool (libsecret) on Linux. Credentials are also read from, and
+// deleted from, the private file DefaultCredentialsPath, which is only written
+// on request with FileCredentialStore, e.g. on Windows or headless servers.
+// Set fails where the keyring is missing or fails rather than write secrets
+// to a file.
+func DefaultCredentialStore() CredentialStore {
+	return &fallbackStore{primary: keyringStore{}, fallback: FileCredentialStore(DefaultCredentialsPath())}
+}
+
+// fallbackStore reads from fallback what primary lacks, but only writes to
+// primary.
+type fallbackStore struct {
+	primary, fallback CredentialStore
+}
+
+func (s *fallbackStore) Get(name string) (Credential, error) {
+	if c, err := s.primary.Get(name); err == nil {
+		return c, nil
+	}
+	return s.fallback.Get(name)
+}
+
+func (s *fallbackStore) Set(name string, c Credential) error {
+	if err := s.primary.Set(name, c); err != nil {
+		return fmt.Errorf("failed to store the credential of %s in the OS keyring: %w", name, err)
+	}
+	// Don't leave an older copy in the file that would win if the keyring fails later
+	s.fallback.Delete(name)
+	return nil
+}
+
+func (s *fallbackStore) Delete(name string) error {
+	s.primary.Delete(name) // Fails for missing items and unusable keyrings alike
+	if err := s.fallback.Delete(name); err != nil {
+		return err
+	}
+	if _, err := s.primary.Get(name); err == nil {
+		return fmt.Errorf("failed to remove the credential of %s from the keyring", name)
+	}
+	return nil
+}
+
+// errNoKeyring is returned by keyringStore where no keyring command is available.
+var errNoKeyring = errors.New("no supported OS keyring available, the macOS Keychain or the Secret Service through secret-tool")
+
+// keyringStore stores credentials as JSON secrets in the OS keyring.
+type keyringStore struct{}
+
+func (keyringStore) Get(name string) (Credential, error) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("security", "find-generic-password", "-s", keyringService, "-a", name, "-w")
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "lookup", "service", keyringService, "account", name)
+	default:
+		return Credential{}, errNoKeyring
+	}
+	out, err := cmd.Output()
+	if errors.Is(err, exec.ErrNotFound) {
+		return Credential{}, errNoKeyring
+	}
+	secret := bytes.TrimSpace(out)
+	if err != nil || len(secret) == 0 {
+		return Credential{}, ErrNoCredential
+	}
+	var c Credential
+	if err := json.Unmarshal(secret, &c); err != nil {
+		return Credential{}, fmt.Errorf("invalid credential of %s in the keyring: %w", name, err)
+	}
+	return c, nil
+}
+
+func (keyringStore) Set(name string, c Credential) error {
+	secret, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		if strings.ContainsAny(name, "\"\\\n") {
+			return fmt.Errorf("invalid credential name %q", name)
+		}
+		// Arguments are visible to other users, the secret is passed in a
+		// command of the interactive mode instead, hex-encoded to need no quoting
+		cmd = exec.Command("security", "-i")
+		cmd.Stdin = strings.NewReader(fmt.Sprintf("add-generic-password -U -s %s -a \"%s\" -X %s\n",
+			keyringService, name, hex.EncodeToString(secret)))
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "store", "--label", "grab "+name, "service", keyringService, "account", name)
+		cmd.Stdin = bytes.NewReader(secret)
+	default:
+		return errNoKeyring
+	}
+	if err := runKeyring(cmd); err != nil {
+		return err
+	}
+	if runtime.GOOS == "darwin" {
+		// The interactive mode reports failed commands on its output only
+		if stored, err := (keyringStore{}).Get(name); err != nil || stored != c {
+			return errors.New("keyring command failed to store the credential")
+		}
+	}
+	return nil
+}
+
+func (keyringStore) Delete(name string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		if _, err := (keyringStore{}).Get(name); err != nil {
+			return nil // Deleting a missing item fails
+		}
+		cmd = exec.Command("security", "delete-generic-password", "-s", keyringService, "-a", name)
+	case "linux", "freebsd", "openbsd", "netbsd":
+		cmd = exec.Command("secret-tool", "clear", "service", keyringService, "account", name)
+	default:
+		return errNoKeyring
+	}
+	return runKeyring(cmd)
+}
+
+// runKeyring runs a keyring command, returning its error output on failure.
+func runKeyring(cmd *exec.Cmd) error {
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); errors.Is(err, exec.ErrNotFound) {
+		return errNoKeyring
+	} else if err != nil {
+		return fmt.Errorf("keyring command failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// FileCredentialStore returns a store keeping the credentials in the JSON file
+// at path, readable by the user only but not encrypted.
+func FileCredentialStore(path string) CredentialStore {
+	return &fileStore{path: path}
+}
+
+type fileStore struct {
+	mu   sync.Mutex
+	path string
+}
+
+func (s *fileStore) load() (map[string]Credential, error) {
+	data, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Credential{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var credentials map[string]Credential
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("invalid credentials file %s: %w", s.path, err)
+	}
+	if credentials == nil {
+		credentials = map[string]Credential{}
+	}
+	return credentials, nil
+}
+
+func (s *fileStore) save(credentials map[string]Credential) error {
+	data, err := json.MarshalIndent(credentials, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create credentials directory: %w", err)
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return nil
+}
+
+func (s *fileStore) Get(name string) (Credential, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credentials, err := s.load()
+	if err != nil {
+		return Credential{}, err
+	}
+	c, ok := credentials[name]
+	if !ok {
+		return Credential{}, ErrNoCredential
+	}
+	return c, nil
+}
+
+func (s *fileStore) Set(name string, c Credential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credentials, err := s.load()
+	if err != nil {
+		return err
+	}
+	credentials[name] = c
+	return s.save(credentials)
+}
+
+func (s *fileStore) Delete(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	credentials, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := credentials[name]; !ok {
+		return nil
+	}
+	delete(credentials, name)
+	return s.save(credentials)
+}
+
+// credentialEnv returns the prefix of the environment variables overriding
+// the stored credential of name, e.g. GAODUN for GAODUN_AUTH_TOKEN.
+func credentialEnv(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		default:
+			return '_'
+		}
+	}, name)
+}
+
+// Credential returns the credential of the extractor name: the environment
+// variables NAME_AUTH_TOKEN, NAME_USERNAME and NAME_PASSWORD if any is set,
+// e.g. by a profile, otherwise the credential saved in the credential store
+// with grab auth login. It is zero if there is none. Lookups are cached for
+// the life of the context.
+func (c *Context) Credential(name string) Credential {
+	prefix := credentialEnv(name)
+	env := Credential{
+		Username: os.Getenv(prefix + "_USERNAME"),
+		Password: os.Getenv(prefix + "_PASSWORD"),
+		Token:    os.Getenv(prefix + "_AUTH_TOKEN"),
+	}
+	if !env.IsZero() {
+		return env
+	}
+
+	c.credentialsMu.Lock()
+	defer c.credentialsMu.Unlock()
+	if cred, ok := c.credentials[name]; ok {
+		return cred
+	}
+	if c.credentialStore == nil {
+		c.credentialStore = DefaultCredentialStore()
+	}
+	cred, err := c.credentialStore.Get(name)
+	if err != nil && !errors.Is(err, ErrNoCredential) {
+		c.Logger().Warn("Failed to read stored credential", "extractor", name, "error", err)
+	}
+	if c.credentials == nil {
+		c.credentials = make(map[string]Credential)
+	}
+	c.credentials[name] = cred
+	return cred
+}
+
+// SetCredentialStore makes Credential read stored credentials from s instead
+// of DefaultCredentialStore, e.g. a secret manager of the application.
+func (c *Context) SetCredentialStore(s CredentialStore) {
+	c.credentialsMu.Lock()
+	defer c.credentialsMu.Unlock()
+	c.credentialStore = s
+	c.credentials = nil
+}
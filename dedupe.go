@@ -0,0 +1,99 @@
+package grab
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// dedupeStreams collapses streams that point at the same content. Streams are
+// first compared by normalized URL; streams of the same type and known size are
+// then compared by ETag, which is only probed when sizes collide.
+// The first stream of each duplicate group is kept.
+func (d *Downloader) dedupeStreams(ctx context.Context, streams []Stream) []Stream {
+	if len(streams) < 2 {
+		return streams
+	}
+
+	seenURL := make(map[string]Stream, len(streams))
+	type sizeKey struct {
+		typ  StreamType
+		size int64
+	}
+	bySize := make(map[sizeKey][]Stream)
+	etags := make(map[string]string) // ETag cache keyed by URL
+	result := make([]Stream, 0, len(streams))
+	for _, stream := range streams {
+		key := normalizeStreamURL(stream.URL)
+		if kept, ok := seenURL[key]; ok && key != "" {
+			d.ctx.logger.Info("Skipping duplicate stream", "id", stream.ID, "duplicate_of", kept.ID, "reason", "url")
+			continue
+		}
+		seenURL[key] = stream
+
+		if stream.Size > 0 && stream.Type != StreamTypeM3u8 {
+			key := sizeKey{stream.Type, stream.Size}
+			if kept, ok := d.findSameETag(ctx, stream, bySize[key], etags); ok {
+				d.ctx.logger.Info("Skipping duplicate stream", "id", stream.ID, "duplicate_of", kept.ID, "reason", "etag")
+				continue
+			}
+			bySize[key] = append(bySize[key], stream)
+		}
+		result = append(result, stream)
+	}
+	return result
+}
+
+// findSameETag returns the candidate whose ETag matches the stream's ETag.
+func (d *Downloader) findSameETag(ctx context.Context, stream Stream, candidates []Stream, etags map[string]string) (Stream, bool) {
+	if len(candidates) == 0 {
+		return Stream{}, false
+	}
+	probe := func(s Stream) string {
+		if etag, ok := etags[s.URL]; ok {
+			return etag
+		}
+		etag := d.probeETag(ctx, s)
+		etags[s.URL] = etag
+		return etag
+	}
+	etag := probe(stream)
+	if etag == "" {
+		return Stream{}, false
+	}
+	for _, candidate := range candidates {
+		if probe(candidate) == etag {
+			return candidate, true
+		}
+	}
+	return Stream{}, false
+}
+
+// probeETag issues a HEAD request and returns the strong ETag, or "" if unavailable.
+func (d *Downloader) probeETag(ctx context.Context, stream Stream) string {
+	req := d.ctx.client.R().SetContext(ctx)
+	req.Header = stream.Header.Clone()
+	resp, err := req.Head(stream.URL)
+	if err != nil || resp.StatusCode() != http.StatusOK {
+		return ""
+	}
+	etag := resp.Header().Get("ETag")
+	if strings.HasPrefix(etag, "W/") {
+		return "" // Weak ETags don't guarantee byte-identical content
+	}
+	return etag
+}
+
+// normalizeStreamURL returns a canonical form of rawURL for comparison.
+func normalizeStreamURL(rawURL string) string {
+	u, err := url.Parse(strings.TrimSpace(rawURL))
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme = strings.ToLower(u.Scheme)
+	u.Host = strings.ToLower(u.Host)
+	u.Fragment = ""
+	u.RawQuery = u.Query().Encode()
+	return u.String()
+}
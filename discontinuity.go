@@ -0,0 +1,56 @@
+package grab
+
+import (
+	"net/url"
+	"regexp"
+)
+
+// hlsAdPattern matches the paths of ad segments spliced into HLS playlists,
+// which name them after ads, adverts, pre-rolls and the like.
+var hlsAdPattern = regexp.MustCompile(`(?i)(^|[/_.-])(ads?|adverts?|advertising|advertisements?|adjump|commercials?|preroll|midroll|postroll)([/_.-]|$)`)
+
+// isAdSegment reports whether the path of the URI of segment matches hlsAdPattern.
+func isAdSegment(segment *segmentInfo) bool {
+	u, err := url.Parse(segment.URI)
+	return err == nil && hlsAdPattern.MatchString(u.Path)
+}
+
+// skipAdSegments drops the runs of segments between EXT-X-DISCONTINUITY tags
+// whose segments all look like ads, and returns the others with the number of
+// segments dropped. If every run looks like ads, none is dropped.
+func skipAdSegments(segments []*segmentInfo) ([]*segmentInfo, int) {
+	var kept []*segmentInfo
+	for start := 0; start < len(segments); {
+		end := start + 1
+		for end < len(segments) && !segments[end].Discontinuity {
+			end++
+		}
+		ads := true
+		for _, segment := range segments[start:end] {
+			if !isAdSegment(segment) {
+				ads = false
+				break
+			}
+		}
+		if !ads {
+			kept = append(kept, segments[start:end]...)
+		}
+		start = end
+	}
+	if len(kept) == 0 {
+		return segments, 0
+	}
+	return kept, len(segments) - len(kept)
+}
+
+// discontinuities returns the offsets in the output of Read of the segments
+// starting with a discontinuity, where timestamps restart, except the first.
+func (r *m3U8Reader) discontinuities() []int64 {
+	var offsets []int64
+	for i, segment := range r.segments {
+		if i > 0 && segment.Discontinuity && segment.offset > 0 {
+			offsets = append(offsets, segment.offset)
+		}
+	}
+	return offsets
+}
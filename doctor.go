@@ -0,0 +1,227 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// DiagnosisStatus is the outcome of a single environment check.
+type DiagnosisStatus string
+
+const (
+	DiagnosisOK      DiagnosisStatus = "ok"      // Nothing to do
+	DiagnosisWarning DiagnosisStatus = "warning" // Some features or sites won't work
+	DiagnosisError   DiagnosisStatus = "error"   // Downloads will fail
+)
+
+// Diagnosis is the result of a single environment check.
+type Diagnosis struct {
+	Check  string // What was checked, e.g. "ffmpeg"
+	Status DiagnosisStatus
+	Detail string // What was found
+	Fix    string // How to fix it, empty if Status is DiagnosisOK
+}
+
+// doctorEndpoints are well known CDNs checked for reachability and clock skew.
+var doctorEndpoints = []string{
+	"https://www.cloudflare.com/",
+	"https://www.akamai.com/",
+	"https://www.fastly.com/",
+}
+
+// maxClockSkew is the clock difference beyond which signed URLs start to fail.
+const maxClockSkew = 5 * time.Minute
+
+// Diagnose checks the environment grab runs in with the given options: ffmpeg,
+// proxy, cookie file, write permissions, network reachability and clock skew.
+func Diagnose(ctx context.Context, option Option) []Diagnosis {
+	var results []Diagnosis
+	results = append(results, diagnoseFFmpeg(ctx, option.FFmpegPath))
+	results = append(results, diagnoseProxy(ctx, option))
+	if option.Cookie != "" {
+		results = append(results, diagnoseCookies(option.Cookie))
+	}
+	results = append(results, diagnoseWritable("output directory", option.OutputPath, true))
+	results = append(results, diagnoseWritable("temp directory", os.TempDir(), false))
+	if !option.NoCache {
+		dir := option.CacheDir
+		if dir == "" {
+			dir = DefaultCacheDir()
+		}
+		results = append(results, diagnoseWritable("cache directory", dir, true))
+	}
+	return append(results, diagnoseNetwork(ctx, option)...)
+}
+
+// diagnoseFFmpeg checks that ffmpeg is installed, at location if not empty,
+// and runs.
+func diagnoseFFmpeg(ctx context.Context, location string) Diagnosis {
+	d := Diagnosis{Check: "ffmpeg"}
+	path, err := findFFmpeg(location)
+	if err != nil {
+		d.Status = DiagnosisWarning
+		d.Detail = err.Error()
+		d.Fix = "Install ffmpeg (https://ffmpeg.org/download.html) and add it to PATH, it is needed for --format"
+		return d
+	}
+	out, err := exec.CommandContext(ctx, path, "-version").Output()
+	if err != nil {
+		d.Status = DiagnosisWarning
+		d.Detail = fmt.Sprintf("%s fails to run: %v", path, err)
+		d.Fix = "Reinstall ffmpeg"
+		return d
+	}
+	version, _, _ := strings.Cut(string(out), "\n")
+	d.Status = DiagnosisOK
+	d.Detail = fmt.Sprintf("%s (%s)", strings.TrimSpace(version), path)
+	return d
+}
+
+// diagnoseProxy checks that the configured proxy parses and accepts connections.
+func diagnoseProxy(ctx context.Context, option Option) Diagnosis {
+	d := Diagnosis{Check: "proxy"}
+	if option.Proxy == "" {
+		d.Status = DiagnosisOK
+		d.Detail = "no proxy configured"
+		if !option.NoProxyEnv {
+			for _, env := range []string{"HTTPS_PROXY", "https_proxy", "HTTP_PROXY", "http_proxy"} {
+				if v := os.Getenv(env); v != "" {
+					d.Detail = fmt.Sprintf("using %s from the environment", env)
+					break
+				}
+			}
+		}
+		return d
+	}
+
+	proxyURL, err := utils.ProxyURL(option.Proxy, option.ProxyUser, option.ProxyPass)
+	if err != nil {
+		d.Status = DiagnosisError
+		d.Detail = err.Error()
+		d.Fix = "Pass --proxy as scheme://host:port, e.g. http://127.0.0.1:8080 or socks5://127.0.0.1:1080"
+		return d
+	}
+	host := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if strings.HasPrefix(proxyURL.Scheme, "socks5") {
+			port = "1080"
+		} else if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	dialer := net.Dialer{Timeout: 5 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", host)
+	if err != nil {
+		d.Status = DiagnosisError
+		d.Detail = fmt.Sprintf("cannot connect to %s: %v", host, err)
+		d.Fix = "Check that the proxy is running and the address is correct"
+		return d
+	}
+	conn.Close()
+	d.Status = DiagnosisOK
+	d.Detail = fmt.Sprintf("%s accepts connections", host)
+	return d
+}
+
+// diagnoseCookies checks that the cookie file parses.
+func diagnoseCookies(path string) Diagnosis {
+	d := Diagnosis{Check: "cookies"}
+	if _, err := utils.CookieJarFromFile(path); err != nil {
+		d.Status = DiagnosisError
+		d.Detail = err.Error()
+		d.Fix = "Export the cookies again in Netscape cookies.txt format, e.g. with a browser extension"
+		return d
+	}
+	d.Status = DiagnosisOK
+	d.Detail = fmt.Sprintf("%s parsed", path)
+	return d
+}
+
+// diagnoseWritable checks that files can be created in dir, creating it first if create is set.
+func diagnoseWritable(check, dir string, create bool) Diagnosis {
+	d := Diagnosis{Check: check}
+	if create {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			d.Status = DiagnosisError
+			d.Detail = err.Error()
+			d.Fix = fmt.Sprintf("Create %s or choose another directory", dir)
+			return d
+		}
+	}
+	f, err := os.CreateTemp(dir, ".grab-doctor-*")
+	if err != nil {
+		d.Status = DiagnosisError
+		d.Detail = err.Error()
+		d.Fix = fmt.Sprintf("Grant write permission on %s or choose another directory", dir)
+		return d
+	}
+	f.Close()
+	os.Remove(f.Name())
+	d.Status = DiagnosisOK
+	d.Detail = fmt.Sprintf("%s is writable", dir)
+	return d
+}
+
+// diagnoseNetwork checks that common CDNs are reachable and compares their
+// clock with the local one.
+func diagnoseNetwork(ctx context.Context, option Option) []Diagnosis {
+	// The cookie file is checked separately, a broken one must not stop the network checks
+	option.Cookie = ""
+	option.RetryCount = 0
+	option.Timeout = 10 * time.Second
+	client := newClient(option)
+
+	results := make([]Diagnosis, len(doctorEndpoints))
+	skews := make([]time.Duration, len(doctorEndpoints))
+	var wg sync.WaitGroup
+	for i, endpoint := range doctorEndpoints {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d := Diagnosis{Check: "network " + utils.URLHost(endpoint)}
+			start := time.Now()
+			resp, err := client.R().SetContext(ctx).Head(endpoint)
+			if err != nil {
+				d.Status = DiagnosisWarning
+				d.Detail = err.Error()
+				d.Fix = "Check your internet connection, firewall and proxy settings"
+				results[i] = d
+				return
+			}
+			if date, err := http.ParseTime(resp.Header().Get("Date")); err == nil {
+				skews[i] = time.Since(date) - time.Since(start)/2
+			}
+			d.Status = DiagnosisOK
+			d.Detail = fmt.Sprintf("%s in %v", resp.Status(), time.Since(start).Round(time.Millisecond))
+			results[i] = d
+		}()
+	}
+	wg.Wait()
+
+	clock := Diagnosis{Check: "clock", Status: DiagnosisWarning, Detail: "no server reported its time", Fix: "Check your internet connection"}
+	for i, d := range results {
+		if d.Status != DiagnosisOK {
+			continue
+		}
+		skew := skews[i].Round(time.Second)
+		if skew.Abs() > maxClockSkew {
+			clock.Detail = fmt.Sprintf("local clock is off by %v", skew)
+			clock.Fix = "Enable time synchronization (NTP), signed download URLs are rejected otherwise"
+		} else {
+			clock = Diagnosis{Check: "clock", Status: DiagnosisOK, Detail: fmt.Sprintf("off by %v", skew)}
+		}
+		break
+	}
+	return append(results, clock)
+}
@@ -0,0 +1,78 @@
+package grab
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// downloadArchive records the medias downloaded so far, one "extractor id" line
+// each, so later runs with the same file skip them (--download-archive).
+type downloadArchive struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]bool
+}
+
+// openDownloadArchive reads the download archive at path. A missing file is empty.
+func openDownloadArchive(path string) (*downloadArchive, error) {
+	a := &downloadArchive{path: path, entries: make(map[string]bool)}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return a, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to open download archive: %w", err)
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if line := strings.TrimSpace(scanner.Text()); line != "" {
+			a.entries[line] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read download archive: %w", err)
+	}
+	return a, nil
+}
+
+func downloadArchiveKey(extractor, id string) string {
+	return extractor + " " + id
+}
+
+// has reports whether the media id of extractor was downloaded before.
+func (a *downloadArchive) has(extractor, id string) bool {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.entries[downloadArchiveKey(extractor, id)]
+}
+
+// add records the media id of extractor as downloaded.
+func (a *downloadArchive) add(extractor, id string) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	key := downloadArchiveKey(extractor, id)
+	if a.entries[key] {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(a.path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(a.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.WriteString(key + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	a.entries[key] = true
+	return nil
+}
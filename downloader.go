@@ -2,11 +2,16 @@ package grab
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -15,7 +20,11 @@ import (
 	"github.com/hydrz/grab/utils"
 )
 
-const downloadingSuffix = ".part"
+const (
+	downloadingSuffix = ".part"
+	lockSuffix        = ".lock"
+	stateSuffix       = ".state"
+)
 
 // Downloader manages high-level download logic with support for HTTP range requests,
 // resumable downloads, and multi-threaded downloads.
@@ -23,42 +32,172 @@ type Downloader struct {
 	ctx    *Context
 	mu     sync.RWMutex
 	cancel context.CancelFunc
+	state  *stateDB
+
+	validators sync.Map // Stream URL -> remoteValidators of the running download
+
+	failuresMu sync.Mutex
+	failures   []Failure        // Failures skipped because of IgnoreErrors
+	downgrades []Downgrade      // Streams replaced by a lower quality because of QualityFallback
+	results    []DownloadResult // Outcome of every stream downloaded, for Results
+
+	transfersMu sync.Mutex
+	transfers   map[string]*transfer // Output path -> progress of its download, for Snapshot
+
+	namesMu    sync.Mutex
+	names      map[string]string // Output path and stream ID -> file name chosen by AutoNumber
+	namesTaken map[string]string // Output paths chosen by AutoNumber -> key in names of their stream
+
+	refresher Refresher // Optional, used to renew expired stream URLs
+	webhook   *webhook  // Optional, receives job events
+
+	sourceURL string // URL the medias were extracted from, for provenance metadata
+	extractor string // Name of the extractor of the medias, for provenance metadata
+}
+
+// Failure describes a media or stream that failed to download while IgnoreErrors was set.
+type Failure struct {
+	Media    string // Title of the media
+	StreamID string // ID of the failed stream, empty if the whole media failed
+	URL      string // URL of the failed stream, empty if the whole media failed
+	Err      error
+}
+
+// Downgrade describes a stream that kept failing and was replaced by a stream
+// of lower quality of the same media because QualityFallback is set.
+type Downgrade struct {
+	Media string // Title of the media
+	From  string // Quality that failed
+	To    string // Quality downloaded instead
+	Err   error  // Why From failed
 }
 
 // NewDownloader creates a new Downloader instance with the provided context.
 func NewDownloader(ctx *Context) *Downloader {
-	return &Downloader{ctx: ctx}
+	return &Downloader{
+		ctx:     ctx,
+		state:   openStateDB(ctx.option.OutputPath),
+		webhook: newWebhook(ctx.option, ctx.Logger()),
+	}
 }
 
 // Download downloads all streams from the extracted media for the given URL.
-// If ExtractOnly is set, only prints media info without downloading.
-func (d *Downloader) Download(medias []Media) error {
+// It returns the outcome of every stream it went through, in order, also when
+// it fails; streams merged into one file are reported as a single result.
+func (d *Downloader) Download(medias []Media) ([]DownloadResult, error) {
+	return d.download(d.ctx.Context(), medias)
+}
+
+// download downloads medias until done or parent is canceled.
+func (d *Downloader) download(parent context.Context, medias []Media) ([]DownloadResult, error) {
 	d.mu.Lock()
 	defer d.mu.Unlock()
+	start := len(d.Results())
+	results := func() []DownloadResult { return d.Results()[start:] }
 
 	// Create cancellable context for graceful shutdown
-	ctx, cancel := context.WithCancel(d.ctx.Context())
+	ctx, cancel := context.WithCancel(parent)
 	d.cancel = cancel
 	defer cancel()
 
+	go d.webhook.run(ctx)
+
 	for _, media := range medias {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			return results(), ctx.Err()
 		default:
 		}
 
+		if limit := d.ctx.option.MaxDownloads; limit > 0 && d.ctx.downloads.Load() >= int64(limit) {
+			d.ctx.logger.Info("Maximum number of downloads reached, stopping", "max_downloads", limit)
+			return results(), ErrMaxDownloads
+		}
+
+		archive, err := d.ctx.downloadArchive()
+		if err != nil {
+			return results(), err
+		}
+		if archive != nil && media.ID != "" && archive.has(d.extractor, media.ID) {
+			d.ctx.logger.Info("Media already in download archive, skipping", "title", media.Title, "id", media.ID)
+			d.addResult(DownloadResult{Media: media.Title, Skipped: true})
+			continue
+		}
+
 		d.ctx.logger.Debug("Downloading media", "title", media.Title)
-		if err := d.downloadMedia(ctx, media); err != nil {
+		failures, streams := len(d.Failures()), len(d.Results())
+		err = d.downloadMedia(ctx, media)
+		if downloadedAny(d.Results()[streams:]) {
+			d.ctx.downloads.Add(1)
+		}
+		if err != nil {
 			d.ctx.logger.Error("Failed to download media", "title", media.Title, "error", err)
-			if d.ctx.option.IgnoreErrors {
+			if d.ctx.option.IgnoreErrors && ctx.Err() == nil {
+				d.addFailure(Failure{Media: media.Title, Err: err})
 				continue
 			}
-			return fmt.Errorf("failed to download media %s: %w", media.Title, err)
+			return results(), fmt.Errorf("failed to download media %s: %w", media.Title, err)
+		}
+		if archive != nil && media.ID != "" && len(d.Failures()) == failures {
+			if err := archive.add(d.extractor, media.ID); err != nil {
+				d.ctx.logger.Warn("Failed to record media in download archive", "title", media.Title, "error", err)
+			}
 		}
 	}
 
-	return nil
+	return results(), nil
+}
+
+// downloadedAny reports whether results have a stream actually downloaded,
+// rather than skipped or failed.
+func downloadedAny(results []DownloadResult) bool {
+	for _, r := range results {
+		if !r.Skipped && r.Err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// SetRefresher sets the Refresher used to obtain fresh stream URLs when a
+// download fails because its URL expired. Typically the extractor of the media.
+func (d *Downloader) SetRefresher(r Refresher) {
+	d.refresher = r
+}
+
+// SetSource records the URL the medias were extracted from and the name of
+// the extractor, embedded into downloaded files when EmbedMetadata is set.
+func (d *Downloader) SetSource(url, extractor string) {
+	d.sourceURL = url
+	d.extractor = extractor
+}
+
+// Failures returns the media and streams that were skipped after failing
+// because IgnoreErrors is set.
+func (d *Downloader) Failures() []Failure {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	return append([]Failure(nil), d.failures...)
+}
+
+// Downgrades returns the streams that were downloaded in a lower quality
+// after failing because QualityFallback is set.
+func (d *Downloader) Downgrades() []Downgrade {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	return append([]Downgrade(nil), d.downgrades...)
+}
+
+func (d *Downloader) addDowngrade(g Downgrade) {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	d.downgrades = append(d.downgrades, g)
+}
+
+func (d *Downloader) addFailure(f Failure) {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	d.failures = append(d.failures, f)
 }
 
 // Stop gracefully cancels all ongoing downloads
@@ -74,66 +213,206 @@ func (d *Downloader) downloadMedia(ctx context.Context, media Media) error {
 		return fmt.Errorf("no streams available for media %s", media.Title)
 	}
 
+	media = d.applyOutputTemplate(media)
 	filters := d.ctx.option.filtersForStreams(media.Streams)
+	selected := make([]Stream, 0, len(media.Streams))
 	for _, stream := range media.Streams {
+		if !d.shouldSkipStream(stream, filters) && d.fitsSizeLimits(stream) {
+			selected = append(selected, d.withSiteHeaders(d.nameSubtitle(media, stream)))
+		}
+	}
+	selected = d.dedupeStreams(ctx, selected)
+	selected, groups := d.planMux(media, selected)
+	if d.ctx.option.ExpectedHash != "" {
+		if len(selected) != 1 {
+			return fmt.Errorf("an expected checksum needs a single stream to download, media %s has %d", media.Title, len(selected))
+		}
+		expected, err := parseExpectedHash(d.ctx.option.ExpectedHash)
+		if err != nil {
+			return fmt.Errorf("invalid expected checksum: %w", err)
+		}
+		selected[0].Extra = maps.Clone(selected[0].Extra)
+		if selected[0].Extra == nil {
+			selected[0].Extra = make(map[string]string)
+		}
+		delete(selected[0].Extra, ExtraMD5)
+		delete(selected[0].Extra, ExtraSHA256)
+		selected[0].Extra[expected.algo] = expected.sum
+	}
+	if d.ctx.option.EmbedSubs || d.ctx.option.EmbedMetadata || d.ctx.option.SplitChapters {
+		e := &embedding{media: media}
+		ctx = withEmbedding(ctx, e)
+		defer func() { d.finishHeld(ctx, e.rest()) }()
+		if d.ctx.option.EmbedSubs {
+			// Subtitles are held until their video is downloaded
+			sort.SliceStable(selected, func(i, j int) bool {
+				return selected[i].Type == StreamTypeSubtitle && selected[j].Type != StreamTypeSubtitle
+			})
+		}
+	}
+
+	failed := make(map[string]bool) // IDs of the streams that failed, to skip their mux groups
+	refreshed := false
+	for _, stream := range selected {
 		select {
 		case <-ctx.Done():
 			return ctx.Err()
 		default:
 		}
 
-		if d.shouldSkipStream(stream, filters) {
-			continue
-		}
-
 		d.ctx.logger.Debug("Downloading stream", "id", stream.ID, "type", stream.Type, "quality", stream.Quality)
-		if err := d.downloadStreamWithRetry(ctx, stream); err != nil {
+		d.webhook.started(media, stream)
+		d.ctx.publish(StreamStartedEvent{ID: d.startTransfer(stream).id(), Media: media, Stream: stream})
+		downloaded := stream // Stream actually downloaded, after refreshes and fallbacks
+		err := d.downloadStreamWithRetry(ctx, stream)
+		if isExpiredURLError(err) && d.refresher != nil && !refreshed {
+			// Signed URLs expire, retrying them is pointless, ask the extractor for new ones once
+			refreshed = true
+			d.ctx.logger.Info("Stream URL looks expired, extracting media again", "title", media.Title, "id", stream.ID, "error", err)
+			if fresh, refreshErr := d.refresher.Refresh(media); refreshErr != nil {
+				d.ctx.logger.Warn("Failed to refresh media", "title", media.Title, "error", refreshErr)
+			} else {
+				media = d.applyOutputTemplate(fresh)
+			}
+		}
+		if refreshed && err != nil {
+			if fresh, ok := findStream(media.Streams, stream.ID); ok && fresh.URL != stream.URL {
+				downloaded = d.withSiteHeaders(d.nameSubtitle(media, fresh))
+				err = d.downloadStreamWithRetry(ctx, downloaded)
+			}
+		}
+		if err != nil && d.ctx.option.QualityFallback && ctx.Err() == nil && !errors.Is(err, ErrFileLocked) && !errors.Is(err, ErrStreamCanceled) && !errors.Is(err, ErrDRMProtected) {
+			downloaded, err = d.downloadLowerQuality(ctx, media, downloaded, filters, err)
+		}
+		if err != nil || !isMuxPart(stream) {
+			// Merged parts are reported once merged, see muxGroups
+			d.addResult(d.streamResult(media, downloaded, err))
+		}
+		d.webhook.done(stream, err)
+		id := d.transferOf(stream).id()
+		if err != nil {
+			d.ctx.publish(StreamFailedEvent{ID: id, Media: media, Stream: stream, Err: err})
+		} else {
+			d.ctx.publish(StreamCompletedEvent{ID: id, Media: media, Stream: stream, Path: d.outputOf(stream)})
+		}
+		if errors.Is(err, ErrFileLocked) {
+			d.ctx.logger.Warn("Skipping stream being downloaded by another process", "id", stream.ID, "error", err)
+			failed[stream.ID] = true
+			continue
+		} else if errors.Is(err, ErrStreamCanceled) {
+			d.ctx.logger.Warn("Stream canceled", "id", stream.ID)
+			failed[stream.ID] = true
+			continue
+		} else if err != nil {
 			d.ctx.logger.Error("Failed to download stream", "id", stream.ID, "error", err)
-			if d.ctx.option.IgnoreErrors {
+			if d.ctx.option.IgnoreErrors && ctx.Err() == nil {
+				d.addFailure(Failure{Media: media.Title, StreamID: stream.ID, URL: stream.URL, Err: err})
+				failed[stream.ID] = true
 				continue
 			}
 			return fmt.Errorf("failed to download stream %s: %w", stream.ID, err)
 		}
 	}
 
-	return nil
+	return d.muxGroups(ctx, media, groups, failed)
+}
+
+// downloadLowerQuality downloads the best stream of media below the quality of
+// stream that failed with err, of the same type and passing the other filters.
+// It moves further down while those fail too and returns the last stream tried
+// with its error if every quality failed.
+func (d *Downloader) downloadLowerQuality(ctx context.Context, media Media, stream Stream, filters []Filter, err error) (Stream, error) {
+	tried := stream
+	for _, quality := range lowerQualities(media.Streams, stream.Quality) {
+		candidate, ok := findFallback(media.Streams, stream.Type, quality, filters)
+		if !ok {
+			continue
+		}
+		d.ctx.logger.Warn("Stream keeps failing, falling back to a lower quality",
+			"title", media.Title, "id", stream.ID, "from", stream.Quality, "to", quality, "error", err)
+		tried = d.withSiteHeaders(d.nameSubtitle(media, candidate))
+		fallbackErr := d.downloadStreamWithRetry(ctx, tried)
+		if fallbackErr == nil {
+			d.addDowngrade(Downgrade{Media: media.Title, From: stream.Quality, To: quality, Err: err})
+			return tried, nil
+		}
+		if ctx.Err() != nil || errors.Is(fallbackErr, ErrFileLocked) {
+			return tried, fallbackErr
+		}
+		err = fallbackErr
+	}
+	return tried, err
+}
+
+// findFallback returns the first stream of the given type and quality passing
+// filters, the quality filter aside.
+func findFallback(streams []Stream, streamType StreamType, quality string, filters []Filter) (Stream, bool) {
+	for _, stream := range streams {
+		if stream.Type != streamType || stream.Quality != quality {
+			continue
+		}
+		passes := true
+		for _, filter := range filters {
+			if _, ok := filter.(qualityFilter); !ok && !filter.Filter(stream) {
+				passes = false
+				break
+			}
+		}
+		if passes {
+			return stream, true
+		}
+	}
+	return Stream{}, false
 }
 
 // downloadStreamWithRetry wraps downloadStream with retry logic and intelligent error handling
 func (d *Downloader) downloadStreamWithRetry(ctx context.Context, stream Stream) error {
-	maxRetries := d.ctx.option.RetryCount
-	if maxRetries <= 0 {
-		maxRetries = 1
-	}
+	policy := d.ctx.RetryPolicy()
+	maxRetries := policy.Attempts
+
+	t := d.startTransfer(stream)
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+	t.setCancel(cancel)
+	ctx = withTransfer(ctx, t)
 
 	var lastErr error
+	started := time.Now()
+	ctx = withRetryStart(ctx, started)
 	for attempt := 0; attempt < maxRetries; attempt++ {
 		select {
 		case <-ctx.Done():
-			return ctx.Err()
+			t.finish(context.Cause(ctx))
+			return context.Cause(ctx)
 		default:
 		}
 
 		if attempt > 0 {
-			d.ctx.logger.Info("Retrying download", "stream", stream.ID, "attempt", attempt+1, "maxRetries", maxRetries)
-			// Exponential backoff with jitter
-			backoffDuration := time.Duration(attempt*attempt) * time.Second
-			if backoffDuration > 30*time.Second {
-				backoffDuration = 30 * time.Second
+			delay := policy.Delay(attempt, lastErr)
+			if policy.MaxElapsed > 0 && time.Since(started)+delay > policy.MaxElapsed {
+				d.ctx.logger.Debug("Retry time exhausted, giving up", "stream", stream.ID, "elapsed", time.Since(started))
+				break
 			}
+			d.ctx.logger.Info("Retrying download", "stream", stream.ID, "attempt", attempt+1, "maxRetries", maxRetries, "delay", delay)
+			d.ctx.publish(RetryEvent{ID: t.id(), Stream: stream, Attempt: attempt + 1, Err: lastErr, Delay: delay})
 
-			select {
-			case <-ctx.Done():
-				return ctx.Err()
-			case <-time.After(backoffDuration):
+			if !policy.wait(ctx, started, delay) {
+				t.finish(context.Cause(ctx))
+				return context.Cause(ctx)
 			}
 		}
 
+		t.attempt()
 		err := d.downloadStream(ctx, stream)
 		if err == nil {
+			t.finish(nil)
 			return nil
 		}
 
+		if cause := context.Cause(ctx); errors.Is(cause, ErrStreamCanceled) {
+			t.finish(cause)
+			return cause
+		}
 		lastErr = err
 		d.ctx.logger.Warn("Download attempt failed", "stream", stream.ID, "attempt", attempt+1, "error", err)
 
@@ -141,8 +420,9 @@ func (d *Downloader) downloadStreamWithRetry(ctx context.Context, stream Stream)
 		if strings.Contains(err.Error(), "416") {
 			d.ctx.logger.Debug("Range request failed, trying without range", "stream", stream.ID)
 			// Try one more time without range support
-			err = d.downloadSingleThreadNoRange(ctx, stream)
+			err = d.downloadSingleThreadNoRange(ctx, stream, d.getOutputPath(stream)+downloadingSuffix)
 			if err == nil {
+				t.finish(nil)
 				return nil
 			}
 			lastErr = err
@@ -155,7 +435,33 @@ func (d *Downloader) downloadStreamWithRetry(ctx context.Context, stream Stream)
 		}
 	}
 
-	return fmt.Errorf("download failed after %d attempts: %w", maxRetries, lastErr)
+	err := fmt.Errorf("download failed after %d attempts: %w", t.take().Attempts, lastErr)
+	t.finish(err)
+	return err
+}
+
+// isExpiredURLError reports whether err looks like the stream URL expired or its signature became invalid.
+func isExpiredURLError(err error) bool {
+	if err == nil {
+		return false
+	}
+	errStr := strings.ToLower(err.Error())
+	for _, s := range []string{"403", "410", "expired", "signature"} {
+		if strings.Contains(errStr, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// findStream returns the stream with the given ID.
+func findStream(streams []Stream, id string) (Stream, bool) {
+	for _, stream := range streams {
+		if stream.ID == id {
+			return stream, true
+		}
+	}
+	return Stream{}, false
 }
 
 // isNonRetryableError checks if an error should not be retried
@@ -164,6 +470,16 @@ func isNonRetryableError(err error) bool {
 		return false
 	}
 
+	// A stalled segment or chunk is worth another attempt on a fresh connection,
+	// a corrupted download another download
+	if errors.Is(err, ErrChunkDeadline) || errors.Is(err, ErrChecksumMismatch) {
+		return false
+	}
+	if errors.Is(err, ErrFileLocked) || errors.Is(err, ErrNoSpace) ||
+		errors.Is(err, ErrDRMProtected) {
+		return true
+	}
+
 	errStr := strings.ToLower(err.Error())
 	nonRetryableErrors := []string{
 		"404", "401", "403", "410", // HTTP client errors
@@ -194,16 +510,43 @@ func (d *Downloader) shouldSkipStream(stream Stream, filters []Filter) bool {
 	return false
 }
 
+// fitsSizeLimits reports whether the size of stream is within MinFilesize
+// and MaxFilesize. Streams of unknown size and subtitles always fit.
+func (d *Downloader) fitsSizeLimits(stream Stream) bool {
+	if stream.Size <= 0 || stream.Type == StreamTypeSubtitle {
+		return true
+	}
+	if limit := d.ctx.option.MinFilesize; limit > 0 && stream.Size < limit {
+		d.ctx.logger.Info("Skipping stream below the minimum file size", "title", stream.Title, "id", stream.ID, "size", utils.FormatBytes(stream.Size), "min_filesize", utils.FormatBytes(limit))
+		return false
+	}
+	if limit := d.ctx.option.MaxFilesize; limit > 0 && stream.Size > limit {
+		d.ctx.logger.Info("Skipping stream above the maximum file size", "title", stream.Title, "id", stream.ID, "size", utils.FormatBytes(stream.Size), "max_filesize", utils.FormatBytes(limit))
+		return false
+	}
+	return true
+}
+
 // downloadStream dispatches the download logic based on stream type and server capabilities.
 func (d *Downloader) downloadStream(ctx context.Context, stream Stream) error {
-	outputDir := d.getOutputDir(stream)
-	filename := d.getOutputFilename(stream)
-	outputPath := filepath.Join(outputDir, filename)
+	outputPath := d.getOutputPath(stream)
+	outputDir := filepath.Dir(outputPath)
 	tempPath := outputPath + downloadingSuffix // Use .part suffix for incomplete downloads
 
-	if !d.ctx.option.NoSkipExisting {
-		if fi, err := os.Stat(outputPath); err == nil && fi.Size() == stream.Size {
-			d.ctx.logger.Debug("File already exists, skipping", "path", outputPath)
+	if d.skipExisting() {
+		if path := d.findDownloaded(stream, outputPath); path != "" {
+			if !d.ctx.option.Update || !d.remoteChanged(ctx, stream, path) {
+				d.ctx.logger.Debug("File already exists, skipping", "path", path)
+				transferFrom(ctx).skip(path)
+				return nil
+			}
+			d.ctx.logger.Info("Remote file changed, downloading again", "path", path)
+		}
+	}
+	if d.noOverwrite() {
+		if path := d.existingOutput(stream, outputPath); path != "" {
+			d.ctx.logger.Warn("File exists, not overwriting", "path", path)
+			transferFrom(ctx).skip(path)
 			return nil
 		}
 	}
@@ -212,16 +555,30 @@ func (d *Downloader) downloadStream(ctx context.Context, stream Stream) error {
 		return fmt.Errorf("failed to create output directory %s: %w", outputDir, err)
 	}
 
-	var err error
+	// Guard the output and its .part files against other grab processes
+	lock, err := utils.TryLock(outputPath + lockSuffix)
+	if errors.Is(err, utils.ErrLocked) {
+		return fmt.Errorf("%w: %s", ErrFileLocked, outputPath)
+	} else if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
 	if stream.Type == StreamTypeM3u8 {
 		err = d.downloadM3U8Stream(ctx, stream, tempPath)
+	} else if transport := transportFor(stream.URL); transport != nil {
+		err = d.downloadTransport(ctx, transport, stream, tempPath)
+	} else if d.clipsWithFFmpeg(stream) {
+		err = d.downloadClip(ctx, stream, tempPath)
+	} else if d.usesExternalDownloader(stream) {
+		err = d.downloadExternal(ctx, stream, tempPath)
 	} else {
-		err = d.downloadSingleThread(ctx, stream, tempPath)
+		err = d.downloadSingleThread(ctx, stream, tempPath, tempPath+stateSuffix)
 	}
 
 	if err != nil {
-		// Clean up empty or partial file on error
-		if fi, statErr := os.Stat(tempPath); statErr == nil && fi.Size() == 0 {
+		// Clean up empty, partial or corrupted file on error
+		if fi, statErr := os.Stat(tempPath); statErr == nil && (fi.Size() == 0 || errors.Is(err, ErrChecksumMismatch)) {
 			os.Remove(tempPath)
 		}
 		return err
@@ -232,32 +589,205 @@ func (d *Downloader) downloadStream(ctx context.Context, stream Stream) error {
 		return fmt.Errorf("failed to rename temp file: %w", renameErr)
 	}
 
+	if isMuxPart(stream) {
+		// Post-processing happens once the parts are merged
+		transferFrom(ctx).setOutput(outputPath)
+		return nil
+	}
+	return d.finishOutput(ctx, stream, outputPath)
+}
+
+// finishOutput post-processes the finished file of stream at outputPath:
+// format conversion, metadata, chapter splitting, completion record, checksum
+// and archiving.
+func (d *Downloader) finishOutput(ctx context.Context, stream Stream, outputPath string) error {
+	if stream.Type == StreamTypeSubtitle && d.ctx.option.EmbedSubs {
+		if e := embeddingFrom(ctx); e != nil && e.hold(ctx, stream, outputPath) {
+			// Embedded into its video, or finished by finishHeld if that is not downloaded
+			transferFrom(ctx).setOutput(outputPath)
+			return nil
+		}
+	}
+
+	outputPath = d.remux(ctx, stream, outputPath)
+
 	// Format conversion if requested
-	if d.ctx.option.Format != "" && d.ctx.option.Format != stream.Format {
-		d.ctx.logger.Info("Converting format", "from", stream.Format, "to", d.ctx.option.Format)
-		convertedPath, convErr := convertFormat(outputPath, d.ctx.option.Format)
+	if format := d.outputFormat(stream); format != "" {
+		d.ctx.logger.Info("Converting format", "from", stream.Format, "to", format)
+		transferFrom(ctx).setPhase(PhaseConverting)
+		var convertedPath string
+		var convErr error
+		if stream.Type == StreamTypeSubtitle {
+			convertedPath, convErr = convertSubtitle(outputPath, format)
+		} else {
+			var ffmpegPath string
+			if ffmpegPath, convErr = findFFmpeg(d.ctx.option.FFmpegPath); convErr == nil {
+				convertedPath, convErr = convertFormat(ctx, ffmpegPath, d.ctx.option.FFmpegArgs, outputPath, format, d.convertProgress(ctx, stream))
+			}
+		}
 		if convErr != nil {
 			return fmt.Errorf("format conversion failed: %w", convErr)
 		}
 		d.ctx.logger.Info("Format conversion completed", "output", convertedPath)
+		transferFrom(ctx).setConverted()
 
 		// Remove original file after successful conversion
 		if err := os.Remove(outputPath); err != nil {
 			d.ctx.logger.Warn("Failed to remove original file after conversion", "file", outputPath, "error", err)
 		}
+		outputPath = convertedPath
+	}
+
+	if d.ctx.option.EmbedMetadata || d.ctx.option.EmbedSubs {
+		transferFrom(ctx).setPhase(PhaseConverting)
+		if err := d.embed(ctx, stream, outputPath); err != nil {
+			d.ctx.logger.Warn("Failed to embed metadata", "file", outputPath, "error", err)
+		}
 	}
 
+	if d.ctx.option.SplitChapters && (stream.Type == StreamTypeVideo || stream.Type == StreamTypeAudio || stream.Type == StreamTypeM3u8) {
+		if e := embeddingFrom(ctx); e != nil && len(e.media.Chapters) > 0 {
+			transferFrom(ctx).setPhase(PhaseConverting)
+			paths, err := d.splitChapters(ctx, e.media, outputPath)
+			if err != nil {
+				d.ctx.logger.Warn("Failed to split chapters", "file", outputPath, "error", err)
+			} else {
+				d.ctx.logger.Info("Split into chapters", "file", outputPath, "chapters", len(paths))
+			}
+		}
+	}
+
+	validators, _ := d.validators.LoadAndDelete(stream.URL)
+	v, _ := validators.(remoteValidators)
+	if err := d.state.markCompleted(outputPath, stream, v); err != nil {
+		d.ctx.logger.Warn("Failed to record completed download", "file", outputPath, "error", err)
+	}
+
+	if d.ctx.option.Checksum {
+		if err := writeChecksum(outputPath); err != nil {
+			d.ctx.logger.Warn("Failed to record checksum", "file", outputPath, "error", err)
+		}
+	}
+
+	archive, err := d.ctx.archiveWriter()
+	if err != nil {
+		return err
+	}
+	if archive != nil {
+		if err := archive.add(outputPath); err != nil {
+			return fmt.Errorf("failed to add %s to archive: %w", outputPath, err)
+		}
+		if d.ctx.option.Checksum {
+			if err := archive.add(outputPath + checksumSuffix); err != nil {
+				d.ctx.logger.Warn("Failed to add checksum to archive", "file", outputPath, "error", err)
+			}
+		}
+		outputPath = archive.path
+	}
+
+	transferFrom(ctx).setOutput(outputPath)
 	return nil
 }
 
-// downloadSingleThread performs single-threaded download with resume capability
-// downloadSingleThread performs single-threaded or multi-threaded (if supported) download with resume capability
-func (d *Downloader) downloadSingleThread(ctx context.Context, stream Stream, tempPath string) error {
+// findDownloaded returns the path stream was already fully downloaded to: outputPath,
+// or the path it is converted or remuxed to when a Format, SubtitleFormat or RemuxVideo
+// is requested. It returns "" if none.
+// Files recorded in the state database must still match their size and quick hash.
+// Other files are only trusted if no partial download or its state is lying around
+// and their size matches the stream exactly: those of unknown size, or of a mere
+// estimate as for M3U8, are downloaded again since nothing proves they are complete.
+func (d *Downloader) findDownloaded(stream Stream, outputPath string) string {
+	path := outputPath
+	if format := d.outputFormat(stream); format != "" {
+		path = convertedPath(outputPath, format)
+	} else if format := d.remuxFormat(stream); format != "" {
+		path = convertedPath(outputPath, format)
+	}
+
+	fi, err := os.Stat(path)
+	if err != nil || fi.Size() == 0 {
+		return ""
+	}
+	if entry := d.state.completed(path); entry != nil {
+		if entry.Size != fi.Size() {
+			return ""
+		}
+		if hash, err := quickHash(path); err != nil || hash != entry.QuickHash {
+			return ""
+		}
+		return path
+	}
+	if hasPartialFiles(outputPath) {
+		return ""
+	}
+	if _, err := os.Stat(outputPath + downloadingSuffix + stateSuffix); err == nil {
+		return ""
+	}
+	if stream.Size > 0 && stream.Type != StreamTypeM3u8 && fi.Size() == stream.Size {
+		return path
+	}
+	return ""
+}
+
+// remoteChanged reports whether the remote copy of stream changed since it was
+// downloaded to path, using the validators stored in the state database.
+// Without stored validators the remote is assumed unchanged.
+func (d *Downloader) remoteChanged(ctx context.Context, stream Stream, path string) bool {
+	entry := d.state.completed(path)
+	if entry == nil || (entry.ETag == "" && entry.LastModified == "") {
+		return false
+	}
+
+	req := newMediaRequest(ctx, d.ctx.client, stream.Header)
+	if entry.ETag != "" {
+		req.SetHeader("If-None-Match", entry.ETag)
+	}
+	if entry.LastModified != "" {
+		req.SetHeader("If-Modified-Since", entry.LastModified)
+	}
+	resp, err := req.Get(stream.URL)
+	if err != nil {
+		d.ctx.logger.Warn("Failed to check for remote changes", "url", stream.URL, "error", err)
+		return false
+	}
+	resp.RawBody().Close()
+	return resp.StatusCode() == http.StatusOK
+}
+
+// rememberValidators keeps the ETag and Last-Modified of a download response
+// until the download completes and they are stored in the state database.
+func (d *Downloader) rememberValidators(stream Stream, header http.Header) {
+	d.validators.Store(stream.URL, validatorsOf(header))
+}
+
+// validatorsOf returns the ETag and Last-Modified of a response.
+func validatorsOf(header http.Header) remoteValidators {
+	return remoteValidators{
+		ETag:         header.Get("ETag"),
+		LastModified: header.Get("Last-Modified"),
+	}
+}
+
+// hasPartialFiles reports whether .part or .partN files of outputPath exist.
+func hasPartialFiles(outputPath string) bool {
+	entries, err := os.ReadDir(filepath.Dir(outputPath))
+	if err != nil {
+		return false
+	}
+	prefix := filepath.Base(outputPath) + downloadingSuffix
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && partFilePattern.MatchString(entry.Name()) {
+			return true
+		}
+	}
+	return false
+}
+
+// downloadSingleThread performs single-threaded or multi-threaded (if supported) download with resume capability.
+// The chunk layout and checksums are persisted in statePath.
+func (d *Downloader) downloadSingleThread(ctx context.Context, stream Stream, tempPath, statePath string) error {
 	// Step 1: Probe server for Range support and file size
-	req := d.ctx.client.R().
-		SetContext(ctx).
-		SetDoNotParseResponse(true)
-	req.Header = stream.Header.Clone()
+	req := newMediaRequest(ctx, d.ctx.client, stream.Header)
 	req.SetHeader("Range", "bytes=0-0")
 	resp, err := req.Get(stream.URL)
 	if err != nil {
@@ -265,16 +795,18 @@ func (d *Downloader) downloadSingleThread(ctx context.Context, stream Stream, te
 	}
 	defer resp.RawBody().Close()
 
+	d.rememberValidators(stream, resp.Header())
+
 	supportRange := false
 	var totalSize int64 = stream.Size
 	if resp.StatusCode() == http.StatusPartialContent {
-		supportRange = true
-		if cr := resp.Header().Get("Content-Range"); cr != "" {
-			if parts := strings.Split(cr, "/"); len(parts) == 2 {
-				if sz, err := strconv.ParseInt(parts[1], 10, 64); err == nil {
-					totalSize = sz
-				}
-			}
+		// Only trust servers that answer with exactly the requested window and a known size
+		start, end, total, err := utils.ParseContentRange(resp.Header().Get("Content-Range"))
+		if err == nil && start == 0 && end == 0 && total > 0 {
+			supportRange = true
+			totalSize = total
+		} else {
+			d.ctx.logger.Debug("Unexpected Content-Range, not using ranges", "stream", stream.ID, "content_range", resp.Header().Get("Content-Range"))
 		}
 	} else if resp.StatusCode() == http.StatusOK {
 		if cl := resp.Header().Get("Content-Length"); cl != "" {
@@ -283,138 +815,369 @@ func (d *Downloader) downloadSingleThread(ctx context.Context, stream Stream, te
 			}
 		}
 	} else {
-		return fmt.Errorf("HTTP error: %s", resp.Status())
+		return httpStatusError(resp)
 	}
 
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+
 	// Step 2: If not support range or Threads <= 1, fallback to original single-thread logic
 	if !supportRange || d.ctx.option.Threads <= 1 || totalSize <= 0 {
-		return d.downloadSingleThreadNoRange(ctx, stream)
+		if err := d.checkSpace(tempPath, totalSize, 0); err != nil {
+			return err
+		}
+		if supportRange && totalSize > 0 {
+			return d.downloadSingleThreadResume(ctx, stream, tempPath, statePath, totalSize, validatorsOf(resp.Header()))
+		}
+		return d.downloadSingleThreadNoRange(ctx, stream, tempPath)
 	}
 
-	// Step 3: Multi-threaded download
-	threads := d.ctx.option.Threads
-	chunkSize := totalSize / int64(threads)
-	if chunkSize < 1 {
-		chunkSize = totalSize
+	// Step 3: Multi-threaded download, reusing the chunk layout of an interrupted attempt
+	mirrors := newMirrorSet(stream)
+	chunks := d.ctx.option.Threads
+	if mirrors.multi() {
+		chunks *= mirrorChunksPerThread
+	}
+	// Chunks of another remote copy are never resumed
+	validators := validatorsOf(resp.Header())
+	state, err := loadChunkState(statePath)
+	if err != nil || state.Total != totalSize || !state.matches(validators) {
+		state = newChunkState(totalSize, chunks, d.ctx.option.ChunkSize)
+		state.remoteValidators = validators
+		if err := state.save(statePath); err != nil {
+			d.ctx.logger.Warn("Failed to save chunk state", "path", statePath, "error", err)
+		}
+	}
+	threads := len(state.Ranges)
+	sizes := make([]int64, threads)
+	for i, r := range state.Ranges {
+		sizes[i] = r[1] - r[0] + 1
 	}
+	// Chunk files of older versions are never resumed
+	removePartFiles(tempPath)
+	// The .part file is sparse, only the verified bytes of its chunks take room
+	if err := d.checkSpace(tempPath, totalSize, partialSize(tempPath)-state.verified()); err != nil {
+		return err
+	}
+	t.setParts(sizes)
 
-	// Progress tracking
-	progress := newProgress(totalSize, fmt.Sprintf("Downloading %s", stream.Title))
-	if callback := d.ctx.GetProgressCallback(); callback != nil {
-		progress.SetCallback(callback)
+	// Chunks are written in place into the .part file, sized up front
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(totalSize); err != nil {
+		return fmt.Errorf("failed to allocate output file: %w", err)
 	}
 
-	// Prepare temp files for each chunk
-	tempFiles := make([]string, threads)
-	var wg sync.WaitGroup
-	errCh := make(chan error, threads)
+	// Progress tracking
+	progress := d.newProgress(ctx, stream, totalSize)
+
+	// All chunks of the stream share its limit
+	streamLimiter := utils.NewBucket(d.ctx.option.RateLimitPerStream)
 
-	for i := 0; i < threads; i++ {
-		start := int64(i) * chunkSize
-		end := start + chunkSize - 1
-		if i == threads-1 {
-			end = totalSize - 1
+	// Connections finishing early take over part of the slowest chunks
+	splitter := newChunkSplitter(d.ctx.option.Threads, d.ctx.option.ChunkSize)
+	var wg sync.WaitGroup
+	var splitMu sync.Mutex // Keeps the chunk state and the parts of the snapshot in step
+	var errsMu sync.Mutex
+	var errs []error
+	report := func(err error) {
+		errsMu.Lock()
+		defer errsMu.Unlock()
+		errs = append(errs, err)
+	}
+	connections := make(chan struct{}, d.ctx.option.Threads)
+
+	// A chunk detecting a misbehaving server stops the others
+	chunksCtx, cancelChunks := context.WithCancel(ctx)
+	defer cancelChunks()
+
+	// fetch writes the bytes of chunk c missing from w, received from url
+	fetch := func(url string, c *liveChunk, w *chunkWriter) error {
+		idx := c.idx
+		start, end := c.bounds()
+		from := start + w.size
+		if from > end {
+			return nil // Its rest was split off
+		}
+		chunkCtx, cancel := withChunkDeadline(chunksCtx, d.ctx.option.chunkDeadline(end-from+1))
+		defer cancel()
+
+		req := newMediaRequest(chunkCtx, d.ctx.client, stream.Header)
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-%d", from, end))
+		// Mirrors have validators of their own
+		ifRange := ""
+		if url == stream.URL {
+			ifRange = validators.ifRange()
+		}
+		if ifRange != "" {
+			req.SetHeader("If-Range", ifRange)
 		}
-		tempFiles[i] = fmt.Sprintf("%s.part%d", tempPath, i)
 
-		wg.Add(1)
-		go func(idx int, start, end int64, tempFile string) {
-			defer wg.Done()
-			// Check if chunk already exists and is complete
-			var existSize int64
-			if fi, err := os.Stat(tempFile); err == nil {
-				existSize = fi.Size()
+		resp, err := req.Get(url)
+		if err != nil {
+			return fmt.Errorf("chunk %d request failed: %w", idx, chunkDeadlineError(chunkCtx, err))
+		}
+		defer resp.RawBody().Close()
+		if resp.StatusCode() == http.StatusOK {
+			if ifRange != "" && !validators.matches(validatorsOf(resp.Header())) {
+				return fmt.Errorf("chunk %d: %w", idx, ErrRemoteChanged)
 			}
-			if existSize >= (end - start + 1) {
-				progress.Add(end - start + 1)
-				return
+			// Some CDNs ignore Range and send the full body for every chunk
+			return fmt.Errorf("chunk %d: %w (HTTP %s)", idx, ErrRangeIgnored, resp.Status())
+		}
+		if resp.StatusCode() == http.StatusTooManyRequests || resp.StatusCode() == http.StatusServiceUnavailable {
+			return withRetryAfter(fmt.Errorf("chunk %d: %w (HTTP %s)", idx, errThrottled, resp.Status()), resp.StatusCode(), resp.Header())
+		}
+		if resp.StatusCode() != http.StatusPartialContent {
+			return fmt.Errorf("chunk %d HTTP error: %s", idx, resp.Status())
+		}
+		gotStart, gotEnd, gotTotal, err := utils.ParseContentRange(resp.Header().Get("Content-Range"))
+		if err != nil || gotStart != from || gotEnd != end || (gotTotal >= 0 && gotTotal != totalSize) {
+			return fmt.Errorf("chunk %d: %w (requested %d-%d, got %q)", idx, ErrRangeIgnored,
+				from, end, resp.Header().Get("Content-Range"))
+		}
+
+		// Progress tracking for this chunk, never writing past the chunk end even if it moves
+		reader := progress.NewReader(c.reader(resp.RawBody(), from))
+		defer c.idle()
+		reader = t.partReader(idx, d.limitReader(chunkCtx, reader, streamLimiter))
+		defer func() {
+			if c, ok := reader.(io.Closer); ok {
+				c.Close()
 			}
+		}()
 
-			req := d.ctx.client.R().
-				SetContext(ctx).
-				SetDoNotParseResponse(true)
-			req.Header = stream.Header.Clone()
-			req.SetHeader("Range", fmt.Sprintf("bytes=%d-%d", start+existSize, end))
+		_, err = d.copyWithContext(chunkCtx, w, reader)
+		// Make sure what was received survives an interrupt for resume
+		if flushErr := w.flush(); flushErr != nil {
+			d.ctx.logger.Warn("Failed to checkpoint chunk", "chunk", idx, "error", flushErr)
+		}
+		if err != nil {
+			return fmt.Errorf("chunk %d write failed: %w", idx, chunkDeadlineError(chunkCtx, err))
+		}
+		return nil
+	}
 
-			resp, err := req.Get(stream.URL)
-			if err != nil {
-				errCh <- fmt.Errorf("chunk %d request failed: %w", idx, err)
-				return
+	// acquire waits for a free connection
+	acquire := func() bool {
+		splitter.waiting.Add(1)
+		defer splitter.waiting.Add(-1)
+		select {
+		case connections <- struct{}{}:
+			return true
+		case <-chunksCtx.Done():
+			return false
+		}
+	}
+
+	var run func(c *liveChunk)
+	run = func(c *liveChunk) {
+		defer wg.Done()
+		idx := c.idx
+		start, end := c.bounds()
+		// Reuse only the part of an existing chunk matching its recorded checksum
+		existSize, h := state.verifyChunk(file, idx)
+		if existSize >= (end - start + 1) {
+			progress.Add(end - start + 1)
+			t.partAdd(idx, end-start+1)
+			return
+		}
+		progress.Add(existSize)
+		t.partAdd(idx, existSize)
+
+		if !acquire() {
+			report(chunksCtx.Err())
+			return
+		}
+		release := true
+		defer func() {
+			if release {
+				<-connections
 			}
-			defer resp.RawBody().Close()
-			if resp.StatusCode() != http.StatusPartialContent && resp.StatusCode() != http.StatusOK {
-				errCh <- fmt.Errorf("chunk %d HTTP error: %s", idx, resp.Status())
-				return
+		}()
+
+		w := &chunkWriter{f: file, start: start, h: h, size: existSize, checkpoint: func(sum chunkSum) error {
+			return state.checkpoint(statePath, idx, sum)
+		}}
+
+		// Move on to another mirror when one fails, keeping what was received
+		var err error
+		for {
+			m := mirrors.acquire()
+			if m == nil {
+				if err == nil {
+					err = fmt.Errorf("chunk %d: every mirror failed", idx)
+				}
+				report(err)
+				break
 			}
-
-			// Open file for append
-			f, err := os.OpenFile(tempFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
-			if err != nil {
-				errCh <- fmt.Errorf("chunk %d open file failed: %w", idx, err)
-				return
+			before, began := w.size, time.Now()
+			err = fetch(m.url, c, w)
+			var mirrorErr error // Errors caused by canceling the download or throttling don't count against the mirror
+			if chunksCtx.Err() == nil && !errors.Is(err, errThrottled) {
+				mirrorErr = err
 			}
-			defer f.Close()
-
-			// Progress tracking for this chunk
-			reader := progress.NewReader(resp.RawBody())
-			if d.ctx.option.RateLimit > 0 {
-				reader = utils.NewRateLimiter(reader, d.ctx.option.RateLimit/int64(threads))
+			mirrors.release(m, w.size-before, time.Since(began), mirrorErr)
+			if err == nil {
+				break
 			}
-			defer func() {
-				if c, ok := reader.(io.Closer); ok {
-					c.Close()
+			if errors.Is(err, errThrottled) && splitter.throttle() {
+				// Keep this connection slot taken for good and wait for another one
+				d.ctx.logger.Warn("Server throttles connections, using fewer", "stream", stream.ID,
+					"connections", splitter.limit.Load(), "error", err)
+				release = false
+				if !acquire() {
+					report(chunksCtx.Err())
+					return
 				}
-			}()
-
-			_, err = d.copyWithContext(ctx, f, reader)
-			if err != nil {
-				errCh <- fmt.Errorf("chunk %d write failed: %w", idx, err)
-				return
+				release = true
+				continue
+			}
+			if mirrorErr == nil || !mirrors.multi() || errors.Is(err, ErrRemoteChanged) {
+				report(err)
+				break
+			}
+			d.ctx.logger.Warn("Mirror failed, continuing chunk on another", "stream", stream.ID, "chunk", idx, "mirror", m.url, "error", err)
+		}
+		if err != nil {
+			if errors.Is(err, ErrRangeIgnored) || errors.Is(err, ErrRemoteChanged) {
+				cancelChunks()
 			}
-		}(i, start, end, tempFiles[i])
+			return
+		}
+
+		// Help the chunk expected to finish last
+		splitMu.Lock()
+		defer splitMu.Unlock()
+		victim, splitStart, splitEnd, ok := splitter.split()
+		if !ok {
+			return
+		}
+		newIdx, err := state.split(statePath, victim.idx, splitStart)
+		if err != nil {
+			d.ctx.logger.Warn("Failed to save chunk state", "path", statePath, "error", err)
+		}
+		t.splitPart(victim.idx, splitEnd-splitStart+1)
+		d.ctx.logger.Debug("Splitting slow chunk", "stream", stream.ID, "chunk", victim.idx, "new_chunk", newIdx,
+			"start", splitStart, "end", splitEnd)
+		wg.Add(1)
+		go run(splitter.add(newIdx, splitStart, splitEnd))
+	}
+
+	for i, r := range slices.Clone(state.Ranges) {
+		wg.Add(1)
+		go run(splitter.add(i, r[0], r[1]))
 	}
 
 	wg.Wait()
-	close(errCh)
-	for e := range errCh {
-		if e != nil {
+	for _, e := range errs {
+		if errors.Is(e, ErrRemoteChanged) {
+			// The next attempt starts over with the new copy
+			file.Close()
+			os.Remove(statePath)
 			return e
 		}
 	}
+	for _, e := range errs {
+		if errors.Is(e, ErrRangeIgnored) {
+			d.ctx.logger.Warn("Server mishandles range requests, falling back to a single connection", "stream", stream.ID, "error", e)
+			file.Close()
+			os.Remove(statePath)
+			return d.downloadSingleThreadNoRange(ctx, stream, tempPath)
+		}
+	}
+	if len(errs) > 0 {
+		return errs[0]
+	}
 
-	// Step 4: Merge chunks
-	outFile, err := os.Create(tempPath)
+	// Step 4: Check the whole file if the stream has an expected checksum
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("failed to close output file: %w", err)
+	}
+	os.Remove(statePath)
+	hasher, err := newStreamHasher(stream)
 	if err != nil {
-		return fmt.Errorf("failed to create output file: %w", err)
+		return err
 	}
-	defer outFile.Close()
-	for i := 0; i < threads; i++ {
-		f, err := os.Open(tempFiles[i])
-		if err != nil {
-			return fmt.Errorf("failed to open chunk %d: %w", i, err)
+	if hasher != nil {
+		t.setPhase(PhaseVerifying)
+		if err := hashFile(hasher, tempPath); err != nil {
+			return err
 		}
-		_, err = io.Copy(outFile, f)
-		f.Close()
-		if err != nil {
-			return fmt.Errorf("failed to merge chunk %d: %w", i, err)
-		}
-		os.Remove(tempFiles[i])
 	}
 
 	progress.Finish()
+	return hasher.verify()
+}
+
+// downloadSingleThreadResume downloads stream over a single connection to a
+// server supporting ranges, resuming the .part file of an interrupted attempt
+// when statePath shows it was saved from the same remote copy. Otherwise the
+// download restarts from the beginning.
+func (d *Downloader) downloadSingleThreadResume(ctx context.Context, stream Stream, tempPath, statePath string, totalSize int64, validators remoteValidators) error {
+	var offset int64
+	state, err := loadResumeState(statePath)
+	if fi, statErr := os.Stat(tempPath); statErr == nil && err == nil &&
+		state.Total == totalSize && state.matches(validators) && fi.Size() <= totalSize {
+		offset = fi.Size()
+	} else {
+		state = &resumeState{Total: totalSize, remoteValidators: validators}
+		if err := state.save(statePath); err != nil {
+			d.ctx.logger.Warn("Failed to save resume state", "path", statePath, "error", err)
+		}
+	}
+
+	// A complete file only needs to be verified
+	if offset == totalSize {
+		hasher, err := newStreamHasher(stream)
+		if err != nil {
+			return err
+		}
+		if err := hashFile(hasher, tempPath); err != nil {
+			return err
+		}
+		if err := hasher.verify(); err == nil {
+			os.Remove(statePath)
+			return nil
+		}
+		d.ctx.logger.Debug("Partial file doesn't match its checksum, restarting", "stream", stream.ID)
+		offset = 0
+	}
+	if offset > 0 {
+		d.ctx.logger.Debug("Resuming download", "stream", stream.ID, "offset", offset, "total", totalSize)
+	}
+	if err := d.downloadSingleThreadFrom(ctx, stream, tempPath, offset, validators); err != nil {
+		return err
+	}
+	os.Remove(statePath)
 	return nil
 }
 
 // downloadSingleThreadNoRange performs download without range requests
-func (d *Downloader) downloadSingleThreadNoRange(ctx context.Context, stream Stream) error {
-	outputDir := d.getOutputDir(stream)
-	filename := d.getOutputFilename(stream)
-	tempPath := filepath.Join(outputDir, filename) + downloadingSuffix
+func (d *Downloader) downloadSingleThreadNoRange(ctx context.Context, stream Stream, tempPath string) error {
+	return d.downloadSingleThreadFrom(ctx, stream, tempPath, 0, remoteValidators{})
+}
 
-	req := d.ctx.client.R().
-		SetContext(ctx).
-		SetDoNotParseResponse(true)
-	req.Header = stream.Header.Clone()
+// downloadSingleThreadFrom downloads stream over a single connection, keeping
+// the first offset bytes already in tempPath and requesting the rest with a
+// range request conditioned on validators. A server answering with the whole
+// content, because it ignores ranges or the content changed, restarts the file.
+func (d *Downloader) downloadSingleThreadFrom(ctx context.Context, stream Stream, tempPath string, offset int64, validators remoteValidators) error {
+	hasher, err := newStreamHasher(stream)
+	if err != nil {
+		return err
+	}
+
+	req := newMediaRequest(ctx, d.ctx.client, stream.Header)
+	if offset > 0 {
+		req.SetHeader("Range", fmt.Sprintf("bytes=%d-", offset))
+		if v := validators.ifRange(); v != "" {
+			req.SetHeader("If-Range", v)
+		}
+	}
 
 	resp, err := req.Get(stream.URL)
 	if err != nil {
@@ -422,74 +1185,134 @@ func (d *Downloader) downloadSingleThreadNoRange(ctx context.Context, stream Str
 	}
 	defer resp.RawBody().Close()
 
-	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status())
+	switch {
+	case offset > 0 && resp.StatusCode() == http.StatusPartialContent:
+		start, _, _, err := utils.ParseContentRange(resp.Header().Get("Content-Range"))
+		if err != nil || start != offset {
+			return fmt.Errorf("%w (requested %d-, got %q)", ErrRangeIgnored, offset, resp.Header().Get("Content-Range"))
+		}
+	case resp.StatusCode() == http.StatusOK:
+		if offset > 0 {
+			d.ctx.logger.Debug("Server sent the whole file, restarting", "stream", stream.ID)
+			offset = 0
+		}
+	default:
+		return httpStatusError(resp)
 	}
+	d.rememberValidators(stream, resp.Header())
 
-	// Create output file (overwrite existing)
-	file, err := os.Create(tempPath)
+	// Keep the bytes already downloaded, overwrite the rest
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if offset > 0 {
+		if err := hashFile(hasher, tempPath); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek output file: %w", err)
+	}
 
 	// Get total size for progress tracking
 	var totalSize int64 = stream.Size
 	if contentLength := resp.Header().Get("Content-Length"); contentLength != "" {
 		if size, err := strconv.ParseInt(contentLength, 10, 64); err == nil {
-			totalSize = size
+			totalSize = offset + size
 		}
 	}
 
 	// Progress tracking
-	progress := newProgress(totalSize, fmt.Sprintf("Downloading %s", stream.Title))
-	if callback := d.ctx.GetProgressCallback(); callback != nil {
-		progress.SetCallback(callback)
-	}
+	progress := d.newProgress(ctx, stream, totalSize)
+	progress.Add(offset)
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+	t.setParts([]int64{max(totalSize, 0)})
+	t.partAdd(0, offset)
 
 	reader := progress.NewReader(resp.RawBody())
-	if d.ctx.option.RateLimit > 0 {
-		reader = utils.NewRateLimiter(reader, d.ctx.option.RateLimit)
-	}
+	reader = t.partReader(0, d.limitReader(ctx, reader, utils.NewBucket(d.ctx.option.RateLimitPerStream)))
 	defer func() {
 		if c, ok := reader.(io.Closer); ok {
 			c.Close()
 		}
 	}()
 
-	_, err = d.copyWithContext(ctx, file, reader)
+	_, err = d.copyWithContext(ctx, hasher.writer(file), reader)
 	if err != nil {
 		return fmt.Errorf("failed to write to output file: %w", err)
 	}
 
+	return hasher.verify()
+}
+
+// hashFile feeds the content of the file at path to hasher, if there is one.
+func hashFile(hasher *streamHasher, path string) error {
+	if hasher == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open partial file: %w", err)
+	}
+	defer f.Close()
+	if _, err := io.Copy(hasher.hash, f); err != nil {
+		return fmt.Errorf("failed to read partial file: %w", err)
+	}
 	return nil
 }
 
 // downloadM3U8Stream handles M3U8 streams
 func (d *Downloader) downloadM3U8Stream(ctx context.Context, stream Stream, tempPath string) error {
-	data, err := d.processM3U8(stream)
+	data, err := d.processM3U8(ctx, stream, tempPath)
 	if err != nil {
 		return fmt.Errorf("failed to process M3U8 stream: %w", err)
 	}
 	defer data.Close()
+	m3u8Reader, _ := data.(*m3U8Reader)
 
-	// Create output file
-	file, err := os.Create(tempPath)
+	// Create output file, keeping the segments of an interrupted download the reader resumes after
+	var resumed int64
+	if m3u8Reader != nil {
+		resumed = m3u8Reader.written
+	}
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
 		return fmt.Errorf("failed to create output file: %w", err)
 	}
 	defer file.Close()
+	if err := file.Truncate(resumed); err != nil {
+		return fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if _, err := file.Seek(resumed, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek output file: %w", err)
+	}
 
 	// Progress tracking
-	progress := newProgress(stream.Size, fmt.Sprintf("Downloading %s", stream.Title))
-	if callback := d.ctx.GetProgressCallback(); callback != nil {
-		progress.SetCallback(callback)
+	total := stream.Size
+	if m3u8Reader != nil {
+		total = m3u8Reader.size
+	}
+	progress := d.newProgress(ctx, stream, total)
+	progress.Add(resumed)
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+	if m3u8Reader != nil {
+		t.setParts(make([]int64, len(m3u8Reader.segments)))
+		for i, segment := range m3u8Reader.segments[:m3u8Reader.currentIdx] {
+			t.partAdd(i, segment.size)
+			t.partComplete(i, 0)
+		}
+		m3u8Reader.transfer = t
 	}
 
+	// Segments are rate limited as they are fetched, prefetching included
 	reader := progress.NewReader(data)
-	if d.ctx.option.RateLimit > 0 {
-		reader = utils.NewRateLimiter(reader, d.ctx.option.RateLimit)
-	}
 	defer func() {
 		if c, ok := reader.(io.Closer); ok {
 			c.Close()
@@ -501,18 +1324,140 @@ func (d *Downloader) downloadM3U8Stream(ctx context.Context, stream Stream, temp
 		return fmt.Errorf("failed to write to output file: %w", err)
 	}
 
+	if m3u8Reader != nil {
+		if err := file.Close(); err != nil {
+			return fmt.Errorf("failed to close output file: %w", err)
+		}
+		t.setPhase(PhaseVerifying)
+		d.checkContinuity(stream, m3u8Reader, tempPath)
+		if len(m3u8Reader.missing) > 0 {
+			d.ctx.logger.Warn("HLS download has gaps where segments are missing", "stream", stream.ID,
+				"missing", len(m3u8Reader.missing), "segments", m3u8Reader.missing)
+		}
+		if d.ctx.option.KeepFragments {
+			dir := fragmentsDir(strings.TrimSuffix(tempPath, downloadingSuffix))
+			if err := m3u8Reader.saveFragments(tempPath, dir); err != nil {
+				d.ctx.logger.Warn("Failed to keep fragments", "dir", dir, "error", err)
+			}
+		}
+		merged, err := d.downloadRenditions(ctx, stream, m3u8Reader.renditions, tempPath)
+		if err != nil {
+			return err
+		}
+		// Kept until the renditions are in, so that a failure resumes after the segments
+		os.Remove(m3u8Reader.statePath)
+		if !merged {
+			// Merging audio moves the segments away from their offsets
+			t.setDiscontinuities(m3u8Reader.discontinuities())
+		}
+	}
+
 	return nil
 }
 
+// checkContinuity looks for segments of a merged MPEG-TS stream that were
+// dropped, duplicated or truncated, and downloads them once more. Damage that
+// survives the second pass is only reported, the file is still kept.
+func (d *Downloader) checkContinuity(stream Stream, reader *m3U8Reader, path string) {
+	damaged := reader.ts.damaged()
+	if len(damaged) == 0 {
+		return
+	}
+	d.ctx.logger.Info("TS continuity errors detected, downloading segments again",
+		"stream", stream.ID, "segments", damaged, "issue", reader.ts.issues[damaged[0]])
+
+	checker, err := reader.repairSegments(path, damaged)
+	if err != nil {
+		d.ctx.logger.Warn("Failed to repair TS stream, the file may skip or stutter", "stream", stream.ID, "error", err)
+		return
+	}
+	if damaged = checker.damaged(); len(damaged) > 0 {
+		d.ctx.logger.Warn("TS stream still has continuity errors, the file may skip or stutter",
+			"stream", stream.ID, "segments", damaged, "issue", checker.issues[damaged[0]])
+	}
+}
+
+// limitReader throttles reader by the global limit shared by all downloads
+// of the context and by the given per-stream limit, until ctx is done.
+func (d *Downloader) limitReader(ctx context.Context, reader io.ReadCloser, streamLimiter *utils.Bucket) io.ReadCloser {
+	if d.ctx.rateLimiter == nil && streamLimiter == nil {
+		return reader
+	}
+	return utils.NewSharedRateLimiter(reader, d.ctx.rateLimiter, streamLimiter).WithContext(ctx)
+}
+
+// withChunkDeadline derives a context for a single segment/chunk attempt.
+// A non-positive deadline only adds cancellation.
+func withChunkDeadline(ctx context.Context, deadline time.Duration) (context.Context, context.CancelFunc) {
+	if deadline <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeoutCause(ctx, deadline, ErrChunkDeadline)
+}
+
+// chunkDeadlineError marks err as ErrChunkDeadline when ctx expired because of
+// the per-attempt deadline rather than the caller giving up, so it gets retried.
+func chunkDeadlineError(ctx context.Context, err error) error {
+	if err != nil && errors.Is(context.Cause(ctx), ErrChunkDeadline) {
+		return fmt.Errorf("%w: %v", ErrChunkDeadline, err)
+	}
+	return err
+}
+
+// newProgress creates the progress tracker of a stream download, reporting to
+// the context's subscribers and the webhook.
+func (d *Downloader) newProgress(ctx context.Context, stream Stream, total int64) *progress {
+	p := newProgress(total, fmt.Sprintf("Downloading %s", stream.Title))
+	t := transferFrom(ctx)
+	t.setProgress(p)
+	if !d.ctx.hasSubscribers() && d.webhook == nil {
+		return p
+	}
+	// Downloads without a transfer, like ChunkDownload, have a single stream
+	id := t.id()
+	if id == "" {
+		id = stream.ID
+	}
+	p.SetCallback(func(current, total int64) {
+		d.ctx.publish(ProgressEvent{ID: id, Stream: stream, Current: current, Total: total})
+		d.webhook.progress(stream, current, total)
+	})
+	return p
+}
+
+// convertProgress returns the callback publishing the progress of ffmpeg
+// converting stream to the context's subscribers, nil if there are none.
+func (d *Downloader) convertProgress(ctx context.Context, stream Stream) func(current, total time.Duration) {
+	if !d.ctx.hasSubscribers() {
+		return nil
+	}
+	id := transferFrom(ctx).id()
+	if id == "" {
+		id = stream.ID
+	}
+	return func(current, total time.Duration) {
+		d.ctx.publish(ConvertProgressEvent{ID: id, Stream: stream, Current: current, Total: total})
+	}
+}
+
 // copyWithContext copies data with context cancellation support
 func (d *Downloader) copyWithContext(ctx context.Context, dst io.Writer, src io.Reader) (written int64, err error) {
-	buf := make([]byte, 32*1024) // 32KB buffer
+	const bufSize = 32 * 1024 // 32KB buffer
+	if err := d.ctx.memory.Acquire(ctx, bufSize); err != nil {
+		return 0, err
+	}
+	defer d.ctx.memory.Release(bufSize)
+	buf := make([]byte, bufSize)
+	t := transferFrom(ctx)
 	for {
 		select {
 		case <-ctx.Done():
 			return written, ctx.Err()
 		default:
 		}
+		if err := t.wait(ctx); err != nil {
+			return written, err
+		}
 
 		nr, er := src.Read(buf)
 		if nr > 0 {
@@ -543,21 +1488,55 @@ func (d *Downloader) copyWithContext(ctx context.Context, dst io.Writer, src io.
 	return written, err
 }
 
+// getOutputPath returns the full output path for a stream, with a long path prefix if requested.
+func (d *Downloader) getOutputPath(stream Stream) string {
+	outputPath := filepath.Join(d.getOutputDir(stream), d.getOutputFilename(stream))
+	if d.ctx.option.LongPaths {
+		outputPath = utils.LongPath(outputPath)
+	}
+	return outputPath
+}
+
 // getOutputDir returns the output directory for a stream, considering SaveAs and OutputPath.
+// SaveAs comes from extractors and is untrusted, so it is always confined to OutputPath.
 func (d *Downloader) getOutputDir(stream Stream) string {
 	if stream.SaveAs != "" {
-		dir := filepath.Dir(stream.SaveAs)
-		if filepath.IsAbs(dir) {
-			return dir
+		dir := path.Dir(saveAsPath(stream))
+		if d.ctx.option.RestrictFilenames {
+			parts := strings.Split(dir, "/")
+			for i, p := range parts {
+				if p != "." && p != ".." {
+					parts[i] = d.sanitizeFilename(p)
+				}
+			}
+			dir = strings.Join(parts, "/")
 		}
-		return filepath.Join(d.ctx.option.OutputPath, dir)
+		return utils.SafeJoin(d.ctx.option.OutputPath, dir)
 	}
 	return d.ctx.option.OutputPath
 }
 
-// getOutputFilename returns the output filename for a stream, considering OutputName and SaveAs.
+// saveAsPath returns stream.SaveAs with '/' as the only separator, whatever the platform.
+func saveAsPath(stream Stream) string {
+	return strings.ReplaceAll(stream.SaveAs, "\\", "/")
+}
+
+// getOutputFilename returns the output filename for a stream, considering
+// OutputName, SaveAs and AutoNumber.
 func (d *Downloader) getOutputFilename(stream Stream) string {
-	if d.ctx.option.OutputName != "" {
+	name := d.outputFilename(stream)
+	// Subtitles and mux parts follow the name of their video
+	if d.ctx.option.AutoNumber && !d.ctx.option.ForceOverwrite && stream.Type != StreamTypeSubtitle && !isMuxPart(stream) {
+		return d.numberedFilename(stream, d.getOutputDir(stream), name)
+	}
+	return name
+}
+
+// outputFilename returns the output filename for a stream, considering OutputName and SaveAs.
+func (d *Downloader) outputFilename(stream Stream) string {
+	// Subtitles are named after their video by nameSubtitle and mux parts by muxPart instead
+	// Templates are expanded into SaveAs by applyOutputTemplate
+	if d.ctx.option.OutputName != "" && !isOutputTemplate(d.ctx.option.OutputName) && stream.Type != StreamTypeSubtitle && !isMuxPart(stream) {
 		ext := utils.FileExtension(d.ctx.option.OutputName)
 		if ext == "" {
 			ext = "." + stream.Format
@@ -566,12 +1545,15 @@ func (d *Downloader) getOutputFilename(stream Stream) string {
 		if !strings.HasSuffix(name, ext) {
 			name += ext
 		}
-		return utils.SanitizeFilename(name)
+		return d.sanitizeFilename(name)
 	}
 	if stream.SaveAs != "" {
-		return utils.SanitizeFilename(filepath.Base(stream.SaveAs))
+		if name := d.sanitizeFilename(path.Base(saveAsPath(stream))); name != "" {
+			return name
+		}
 	}
-	title := stream.Title
+	// A title may have no character left once sanitized, e.g. a Chinese one with RestrictFilenames
+	title := d.sanitizeFilename(stream.Title)
 	if title == "" {
 		title = "download"
 	}
@@ -579,5 +1561,18 @@ func (d *Downloader) getOutputFilename(stream Stream) string {
 	if ext == "" {
 		ext = "mp4"
 	}
-	return fmt.Sprintf("%s.%s", utils.SanitizeFilename(title), ext)
+	return utils.TruncateFilename(fmt.Sprintf("%s.%s", title, ext), utils.MaxFilenameBytes)
+}
+
+// sanitizeFilename makes name a valid filename, restricted to portable ASCII
+// characters with RestrictFilenames.
+func (d *Downloader) sanitizeFilename(name string) string {
+	if d.ctx.option.RestrictFilenames {
+		replacement := d.ctx.option.FilenameReplacement
+		if replacement == "" {
+			replacement = "_"
+		}
+		return utils.RestrictFilename(name, replacement)
+	}
+	return utils.SanitizeFilename(name)
 }
@@ -0,0 +1,64 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// TestDownloadLongTitle verifies media whose titles exceed the file name
+// limit are saved, along with their temporary files, and numbered when taken.
+func TestDownloadLongTitle(t *testing.T) {
+	content := bytes.Repeat([]byte("0123456789"), 100000)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.m3u8" {
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXTINF:2,\n0.ts\n#EXTINF:2,\n1.ts\n#EXT-X-ENDLIST\n")
+			return
+		}
+		http.ServeContent(w, r, "video.mp4", time.Time{}, bytes.NewReader(content))
+	}))
+	defer server.Close()
+
+	title := strings.Repeat("课", 100) // 300 bytes
+	tests := []struct {
+		name   string
+		stream Stream
+	}{
+		{"chunked", Stream{ID: "file", URL: server.URL + "/video.mp4", Format: "mp4", Size: int64(len(content))}},
+		{"single connection", Stream{ID: "file", URL: server.URL + "/video.mp4", Format: "mp4"}},
+		{"m3u8", Stream{ID: "hls", Type: StreamTypeM3u8, URL: server.URL + "/index.m3u8", Format: "ts"}},
+	}
+	for _, tt := range tests {
+		dir := t.TempDir()
+		c := NewContext(context.Background(), Option{OutputPath: dir, NoCache: true, Silent: true, RetryCount: 1, Threads: 4, AutoNumber: true})
+		tt.stream.Title, tt.stream.Header = title, http.Header{}
+		// Taken by another file, so the download is numbered
+		taken := filepath.Join(dir, utils.TruncateFilename(title+"."+tt.stream.Format, utils.MaxFilenameBytes))
+		if err := os.WriteFile(taken, []byte("other"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		results, err := NewDownloader(c).Download([]Media{{Title: title, Streams: []Stream{tt.stream}}})
+		c.Close()
+		if err != nil {
+			t.Errorf("%s: Download failed: %v", tt.name, err)
+			continue
+		}
+		name := filepath.Base(results[0].Path)
+		if !strings.HasSuffix(name, " (1)."+tt.stream.Format) || len(name) > 255 {
+			t.Errorf("%s: saved as %q (%d bytes), want a numbered name of at most 255 bytes", tt.name, name, len(name))
+		}
+		if fi, err := os.Stat(results[0].Path); err != nil || fi.Size() == 0 {
+			t.Errorf("%s: downloaded file: %v, want its content", tt.name, err)
+		}
+	}
+}
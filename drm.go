@@ -0,0 +1,86 @@
+package grab
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/grafov/m3u8"
+)
+
+// drmSystems maps the KEYFORMAT of HLS keys and the schemeIdUri of DASH
+// ContentProtection elements to the DRM systems they stand for.
+var drmSystems = map[string]string{
+	"urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed": "Widevine",
+	"urn:uuid:9a04f079-9840-4286-ab92-e65be0885f95": "PlayReady",
+	"com.microsoft.playready":                       "PlayReady",
+	"urn:uuid:94ce86fb-07ff-4f43-adb8-93d2fa968ca2": "FairPlay",
+	"com.apple.streamingkeydelivery":                "FairPlay",
+}
+
+// drmError returns ErrDRMProtected explaining that the media is encrypted
+// with scheme, whose keys only licensed players get.
+func drmError(scheme string) error {
+	return fmt.Errorf("%w: it is encrypted with %s, whose keys are only given to licensed players, grab can't download it",
+		ErrDRMProtected, scheme)
+}
+
+// hlsKeyDRM returns an error wrapping ErrDRMProtected if segments encrypted
+// with key need a DRM system, named by the key format or an skd:// URI of
+// FairPlay, or use a SAMPLE-AES method. Only AES-128 can be decrypted.
+func hlsKeyDRM(key *m3u8.Key) error {
+	if key == nil || key.Method == "" || strings.EqualFold(key.Method, "NONE") {
+		return nil
+	}
+	if system := drmSystems[strings.ToLower(key.Keyformat)]; system != "" {
+		return drmError(system + " DRM")
+	}
+	if strings.HasPrefix(strings.ToLower(key.URI), "skd://") {
+		return drmError("FairPlay DRM")
+	}
+	if strings.HasPrefix(strings.ToUpper(key.Method), "SAMPLE-AES") {
+		return fmt.Errorf("%w: HLS segments are encrypted with %s, used by DRM systems, grab only decrypts AES-128",
+			ErrDRMProtected, key.Method)
+	}
+	return nil
+}
+
+// dashDRM returns an error wrapping ErrDRMProtected if the DASH manifest
+// read from r protects its content (ContentProtection), naming the DRM
+// systems it lists.
+func dashDRM(r io.Reader) error {
+	var systems []string
+	protected := false
+	decoder := xml.NewDecoder(r)
+	for {
+		token, err := decoder.Token()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return fmt.Errorf("failed to parse DASH manifest: %w", err)
+		}
+		element, ok := token.(xml.StartElement)
+		if !ok || element.Name.Local != "ContentProtection" {
+			continue
+		}
+		protected = true
+		for _, attr := range element.Attr {
+			if attr.Name.Local != "schemeIdUri" {
+				continue
+			}
+			if system := drmSystems[strings.ToLower(attr.Value)]; system != "" && !slices.Contains(systems, system) {
+				systems = append(systems, system)
+			}
+		}
+	}
+	if !protected {
+		return nil
+	}
+	if len(systems) == 0 {
+		return drmError("Common Encryption")
+	}
+	return drmError(strings.Join(systems, " and ") + " DRM")
+}
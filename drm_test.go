@@ -0,0 +1,72 @@
+package grab
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/grafov/m3u8"
+)
+
+// TestHLSKeyDRM verifies only keys grab can't decrypt are reported as DRM.
+func TestHLSKeyDRM(t *testing.T) {
+	tests := []struct {
+		name string
+		key  *m3u8.Key
+		drm  bool
+	}{
+		{"no key", nil, false},
+		{"NONE", &m3u8.Key{Method: "NONE"}, false},
+		{"AES-128", &m3u8.Key{Method: "AES-128", URI: "https://example.com/key"}, false},
+		{"AES-128 identity", &m3u8.Key{Method: "AES-128", URI: "key.bin", Keyformat: "identity"}, false},
+		{"SAMPLE-AES identity", &m3u8.Key{Method: "SAMPLE-AES", URI: "key.bin", Keyformat: "identity"}, true},
+		{"SAMPLE-AES-CTR", &m3u8.Key{Method: "SAMPLE-AES-CTR", URI: "data:text/plain;base64,AAAA"}, true},
+		{"Widevine", &m3u8.Key{Method: "SAMPLE-AES-CTR", Keyformat: "urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"}, true},
+		{"FairPlay skd", &m3u8.Key{Method: "SAMPLE-AES", URI: "skd://asset"}, true},
+		{"PlayReady AES-128", &m3u8.Key{Method: "AES-128", Keyformat: "com.microsoft.playready"}, true},
+	}
+	for _, tt := range tests {
+		err := hlsKeyDRM(tt.key)
+		if got := errors.Is(err, ErrDRMProtected); got != tt.drm {
+			t.Errorf("hlsKeyDRM(%s) = %v, want DRM %v", tt.name, err, tt.drm)
+		}
+	}
+}
+
+// TestDashDRM verifies protected manifests are reported with the DRM
+// systems they list, and as Common Encryption when none is known.
+func TestDashDRM(t *testing.T) {
+	tests := []struct {
+		name     string
+		manifest string
+		want     string // Part of the error, "" if not protected
+	}{
+		{"clear", `<MPD><Period><AdaptationSet><Representation id="1"/></AdaptationSet></Period></MPD>`, ""},
+		{"Widevine and PlayReady", `<MPD><Period><AdaptationSet>
+			<ContentProtection schemeIdUri="urn:mpeg:dash:mp4protection:2011" value="cenc"/>
+			<ContentProtection schemeIdUri="urn:uuid:EDEF8BA9-79D6-4ACE-A3C8-27DCD51D21ED"/>
+			<ContentProtection schemeIdUri="urn:uuid:9a04f079-9840-4286-ab92-e65be0885f95"/>
+			<ContentProtection schemeIdUri="urn:uuid:edef8ba9-79d6-4ace-a3c8-27dcd51d21ed"/>
+		</AdaptationSet></Period></MPD>`, "Widevine and PlayReady DRM"},
+		{"unknown scheme", `<MPD><Period><AdaptationSet>
+			<ContentProtection schemeIdUri="urn:uuid:00000000-0000-0000-0000-000000000000"/>
+		</AdaptationSet></Period></MPD>`, "Common Encryption"},
+		{"no scheme", `<MPD><ContentProtection/></MPD>`, "Common Encryption"},
+	}
+	for _, tt := range tests {
+		err := dashDRM(strings.NewReader(tt.manifest))
+		if tt.want == "" {
+			if err != nil {
+				t.Errorf("dashDRM(%s) = %v, want nil", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrDRMProtected) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("dashDRM(%s) = %v, want ErrDRMProtected naming %q", tt.name, err, tt.want)
+		}
+	}
+
+	if err := dashDRM(strings.NewReader("<MPD><Period>")); err == nil || errors.Is(err, ErrDRMProtected) {
+		t.Errorf("dashDRM(truncated) = %v, want a parse error", err)
+	}
+}
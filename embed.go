@@ -0,0 +1,290 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydrz/grab/subtitle"
+	"github.com/hydrz/grab/utils"
+)
+
+// embedding carries, through the context of the streams of a media, what
+// --embed-subs and --embed-metadata write into its video files.
+type embedding struct {
+	media     Media
+	mu        sync.Mutex
+	subtitles map[string][]heldSubtitle // Downloaded subtitles by ID of the stream they belong to
+}
+
+// heldSubtitle is a downloaded subtitle waiting for its video.
+type heldSubtitle struct {
+	stream   Stream
+	path     string
+	transfer *transfer
+}
+
+type embeddingKey struct{}
+
+// withEmbedding returns ctx carrying e.
+func withEmbedding(ctx context.Context, e *embedding) context.Context {
+	return context.WithValue(ctx, embeddingKey{}, e)
+}
+
+// embeddingFrom returns the embedding carried by ctx, nil if none.
+func embeddingFrom(ctx context.Context) *embedding {
+	e, _ := ctx.Value(embeddingKey{}).(*embedding)
+	return e
+}
+
+// hold keeps the subtitle downloaded to path until its video is downloaded.
+// It reports false if the subtitle belongs to no video.
+func (e *embedding) hold(ctx context.Context, stream Stream, path string) bool {
+	parent, ok := e.media.SubtitleParent(stream)
+	if !ok {
+		return false
+	}
+	e.holdFor(parent.ID, heldSubtitle{stream: stream, path: path, transfer: transferFrom(ctx)})
+	return true
+}
+
+// holdFor keeps sub until the stream of the given ID is downloaded.
+func (e *embedding) holdFor(streamID string, sub heldSubtitle) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.subtitles == nil {
+		e.subtitles = make(map[string][]heldSubtitle)
+	}
+	e.subtitles[streamID] = append(e.subtitles[streamID], sub)
+}
+
+// take returns and forgets the subtitles held for the stream of the given ID.
+func (e *embedding) take(streamID string) []heldSubtitle {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	subs := e.subtitles[streamID]
+	delete(e.subtitles, streamID)
+	return subs
+}
+
+// rest returns and forgets all the subtitles still held.
+func (e *embedding) rest() []heldSubtitle {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	var subs []heldSubtitle
+	for _, held := range e.subtitles {
+		subs = append(subs, held...)
+	}
+	e.subtitles = nil
+	return subs
+}
+
+// finishHeld post-processes the subtitles of e whose video was not downloaded
+// or could not take them, as if they had not been held.
+func (d *Downloader) finishHeld(ctx context.Context, subs []heldSubtitle) {
+	for _, sub := range subs {
+		subCtx := withEmbedding(withTransfer(ctx, sub.transfer), nil)
+		if err := d.finishOutput(subCtx, sub.stream, sub.path); err != nil {
+			d.ctx.logger.Warn("Failed to finish subtitle", "file", sub.path, "error", err)
+		}
+	}
+}
+
+// embed writes into the file of stream at path the subtitles held for it with
+// EmbedSubs, and with EmbedMetadata the title, description and chapters of its
+// media and the provenance of the download. Files other than video and audio
+// only get the provenance, as extended attributes.
+func (d *Downloader) embed(ctx context.Context, stream Stream, path string) error {
+	var p *provenance
+	if d.ctx.option.EmbedMetadata {
+		p = &provenance{SourceURL: d.sourceURL, Extractor: d.extractor, Date: time.Now()}
+		if p.SourceURL == "" {
+			p.SourceURL = stream.URL
+		}
+	}
+	var media Media
+	var subs []heldSubtitle
+	if e := embeddingFrom(ctx); e != nil {
+		media = e.media
+		if d.ctx.option.EmbedSubs {
+			subs = e.take(stream.ID)
+		}
+	}
+	if len(subs) > 0 && subtitleCodec(path) == "" {
+		d.ctx.logger.Warn("Container cannot hold subtitles, keeping them as files", "file", path)
+		d.finishHeld(ctx, subs)
+		subs = nil
+	}
+
+	var tagErr error
+	if stream.Type == StreamTypeVideo || stream.Type == StreamTypeAudio || stream.Type == StreamTypeM3u8 {
+		if len(subs) > 0 || p != nil {
+			tagErr = tagMedia(ctx, d.ctx.option.FFmpegPath, path, media, subs, p)
+		}
+		if tagErr == nil {
+			for _, sub := range subs {
+				os.Remove(sub.path)
+				sub.transfer.setOutput(path)
+			}
+		} else {
+			d.finishHeld(ctx, subs)
+		}
+	}
+	if p == nil {
+		return tagErr
+	}
+	// The provenance needs only one of tags and extended attributes, subtitles need tags
+	xattrErr := utils.SetXattrs(path, p.xattrs())
+	if tagErr != nil && (xattrErr != nil || len(subs) > 0) {
+		return errors.Join(tagErr, xattrErr)
+	}
+	return nil
+}
+
+// subtitleCodec returns the ffmpeg codec of subtitle tracks in the container
+// of the file at path, "" if it cannot hold text subtitles.
+func subtitleCodec(path string) string {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		return "mov_text"
+	case ".mkv", ".mka":
+		return "srt"
+	case ".webm":
+		return "webvtt"
+	}
+	return ""
+}
+
+// tagMedia remuxes the file at path with the ffmpeg at ffmpegLocation, see
+// findFFmpeg, without re-encoding, adding subs as subtitle tracks and, for a
+// non-nil p, the title, description and chapters of media and the provenance
+// tags.
+func tagMedia(ctx context.Context, ffmpegLocation, path string, media Media, subs []heldSubtitle, p *provenance) error {
+	ffmpegPath, err := findFFmpeg(ffmpegLocation)
+	if err != nil {
+		return err
+	}
+	tmpDir, err := os.MkdirTemp("", "grab-embed-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	args := []string{"-y", "-i", path}
+	for i, sub := range subs {
+		// ffmpeg reads SRT everywhere, TTML not at all
+		data, err := os.ReadFile(sub.path)
+		if err != nil {
+			return err
+		}
+		srt, err := subtitle.Convert(data, subtitle.SRT)
+		if err != nil {
+			return fmt.Errorf("failed to convert subtitle %s: %w", sub.path, err)
+		}
+		srtPath := filepath.Join(tmpDir, strconv.Itoa(i)+".srt")
+		if err := os.WriteFile(srtPath, srt, 0644); err != nil {
+			return err
+		}
+		args = append(args, "-i", srtPath)
+	}
+	chaptersInput := -1
+	if p != nil && len(media.Chapters) > 0 {
+		metadataPath := filepath.Join(tmpDir, "chapters.txt")
+		if err := os.WriteFile(metadataPath, []byte(ffmetadataChapters(media.Chapters)), 0644); err != nil {
+			return err
+		}
+		args = append(args, "-i", metadataPath)
+		chaptersInput = len(subs) + 1
+	}
+
+	args = append(args, "-map", "0")
+	for i := range subs {
+		args = append(args, "-map", strconv.Itoa(i+1))
+	}
+	args = append(args, "-c", "copy")
+	if len(subs) > 0 {
+		args = append(args, "-c:s", subtitleCodec(path))
+	}
+	for i, sub := range subs {
+		if sub.stream.Language != "" {
+			args = append(args, fmt.Sprintf("-metadata:s:s:%d", i), "language="+sub.stream.Language)
+		}
+	}
+	if chaptersInput >= 0 {
+		args = append(args, "-map_chapters", strconv.Itoa(chaptersInput))
+	}
+	if p != nil {
+		if media.Title != "" {
+			args = append(args, "-metadata", "title="+media.Title)
+		}
+		if media.Description != "" {
+			args = append(args, "-metadata", "description="+media.Description)
+		}
+		args = append(args, mediaTags(media)...)
+		args = append(args, "-metadata", "comment="+p.comment(), "-metadata", "purl="+p.SourceURL)
+	}
+
+	ext := filepath.Ext(path)
+	taggedPath := strings.TrimSuffix(path, ext) + ".tagged" + ext
+	switch strings.ToLower(ext) {
+	case ".mp4", ".m4a", ".m4v", ".mov":
+		// Keep the non-standard purl tag in MP4 containers
+		args = append(args, "-movflags", "use_metadata_tags")
+	}
+	args = append(args, taggedPath)
+
+	output, err := exec.CommandContext(ctx, ffmpegPath, args...).CombinedOutput()
+	if err != nil {
+		os.Remove(taggedPath)
+		return fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	return os.Rename(taggedPath, path)
+}
+
+// mediaTags returns the ffmpeg arguments tagging a file with the structured
+// metadata of media, under the names players read in MP4 and Matroska files.
+func mediaTags(media Media) []string {
+	var args []string
+	tag := func(name, value string) {
+		if value != "" {
+			args = append(args, "-metadata", name+"="+value)
+		}
+	}
+	tag("artist", media.Uploader)
+	if !media.UploadDate.IsZero() {
+		tag("date", media.UploadDate.Format(time.DateOnly))
+	}
+	tag("show", media.Series)
+	tag("album", media.Series)
+	tag("season", media.Season)
+	if media.Episode > 0 {
+		tag("episode_sort", strconv.Itoa(media.Episode))
+		tag("track", strconv.Itoa(media.Episode))
+	}
+	tag("keywords", strings.Join(media.Tags, ","))
+	if media.Language != "" {
+		args = append(args, "-metadata:s:a", "language="+media.Language)
+	}
+	return args
+}
+
+// ffmetadataChapters returns chapters in the FFMETADATA format read by ffmpeg.
+// Chapters without an end last until the next one, see chapterEnd.
+func ffmetadataChapters(chapters []Chapter) string {
+	escape := strings.NewReplacer(`\`, `\\`, "=", `\=`, ";", `\;`, "#", `\#`, "\n", "\\\n")
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+	for i, chapter := range chapters {
+		end := max(chapterEnd(chapters, i), chapter.Start)
+		fmt.Fprintf(&b, "[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%s\n",
+			chapter.Start.Milliseconds(), end.Milliseconds(), escape.Replace(chapter.Title))
+	}
+	return b.String()
+}
@@ -0,0 +1,91 @@
+package grab
+
+import (
+	"compress/flate"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/andybalholm/brotli"
+	"github.com/go-resty/resty/v2"
+	"github.com/klauspost/compress/zstd"
+)
+
+// acceptEncoding lists the content encodings decodingTransport understands, best first.
+const acceptEncoding = "br, zstd, gzip, deflate"
+
+// decodingTransport transparently decodes brotli, zstd, gzip and deflate
+// response bodies. Encodings it does not know and partial content, which
+// can't be decoded on its own, are passed through untouched.
+type decodingTransport struct {
+	base http.RoundTripper
+}
+
+func (t *decodingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil || resp.StatusCode == http.StatusPartialContent || req.Method == http.MethodHead {
+		return resp, err
+	}
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	var body io.ReadCloser
+	switch encoding {
+	case "br":
+		body = &decodedBody{Reader: brotli.NewReader(resp.Body), body: resp.Body}
+	case "zstd":
+		decoder, err := zstd.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		body = &decodedBody{Reader: decoder, body: resp.Body, close: decoder.Close}
+	case "gzip", "x-gzip":
+		decoder, err := gzip.NewReader(resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return nil, err
+		}
+		body = &decodedBody{Reader: decoder, body: resp.Body}
+	case "deflate":
+		decoder := flate.NewReader(resp.Body)
+		body = &decodedBody{Reader: decoder, body: resp.Body, close: func() { decoder.Close() }}
+	default:
+		return resp, nil
+	}
+	resp.Body = body
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// decodedBody reads a decoded response body and closes both the decoder and the original body.
+type decodedBody struct {
+	io.Reader
+	body  io.ReadCloser
+	close func() // Releases the decoder, optional
+}
+
+func (b *decodedBody) Close() error {
+	if b.close != nil {
+		b.close()
+	}
+	return b.body.Close()
+}
+
+// newMediaRequest returns a request for media bytes whose body is streamed to
+// the caller. Content encoding is refused: media is already compressed and
+// servers compressing it anyway break ranges and corrupt the output.
+func newMediaRequest(ctx context.Context, client *resty.Client, header http.Header) *resty.Request {
+	req := client.R().
+		SetContext(withRetryStart(ctx, time.Now())).
+		SetDoNotParseResponse(true)
+	if header != nil {
+		req.Header = header.Clone()
+	}
+	req.SetHeader("Accept-Encoding", "identity")
+	return req
+}
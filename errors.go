@@ -5,5 +5,16 @@ import "errors"
 var (
 	ErrNoExtractorFound = errors.New("no extractor found for the given URL")
 	ErrInvalidURL       = errors.New("invalid URL provided")
-	ErrFFmpegNotFound   = errors.New("ffmpeg executable not found in PATH")
+	ErrFFmpegNotFound   = errors.New("ffmpeg executable not found")
+	ErrChunkDeadline    = errors.New("segment/chunk deadline exceeded")
+	ErrFileLocked       = errors.New("output file is locked by another process")
+	ErrRangeIgnored     = errors.New("server did not honor the range request")
+	ErrStreamNotFound   = errors.New("no download of the given stream is in progress")
+	ErrChecksumMismatch = errors.New("downloaded file does not match the expected checksum")
+	ErrStreamCanceled   = errors.New("stream download canceled")
+	ErrNoSpace          = errors.New("not enough free disk space")
+	ErrRemoteChanged    = errors.New("remote file changed since the download started")
+	ErrNoCredential     = errors.New("no credential stored")
+	ErrMaxDownloads     = errors.New("maximum number of downloads reached")
+	ErrDRMProtected     = errors.New("media is protected by DRM")
 )
@@ -0,0 +1,170 @@
+package grab
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is published to the subscribers of a Context, see Context.Subscribe.
+// It is one of the *Event types of this package, told apart with a type switch:
+//
+//	ctx.Subscribe(func(e grab.Event) {
+//		switch e := e.(type) {
+//		case grab.ProgressEvent:
+//			fmt.Println(e.Stream.Title, e.Current, e.Total)
+//		case grab.StreamFailedEvent:
+//			fmt.Println(e.Stream.Title, e.Err)
+//		}
+//	})
+type Event interface {
+	isEvent()
+}
+
+// ExtractStartedEvent is published by ExtractURL before extracting url.
+type ExtractStartedEvent struct {
+	URL       string
+	Extractor string // Name of the extractor handling URL
+}
+
+// ExtractFinishedEvent is published by ExtractURL once url is extracted,
+// with the medias found or the error.
+type ExtractFinishedEvent struct {
+	URL       string
+	Extractor string
+	Medias    []Media
+	Err       error
+	Duration  time.Duration
+}
+
+// ExtractProgressEvent reports the progress of an extraction walking many
+// pages or API calls, as the extractor calls Context.ReportExtractProgress, at
+// most every 100ms. Counts are totals since the ExtractStartedEvent.
+type ExtractProgressEvent struct {
+	URL       string
+	Extractor string
+	Node      string // What the extractor is working on, e.g. a chapter of a course
+	Items     int    // Medias found so far
+	Requests  int    // API requests made so far
+}
+
+// StreamStartedEvent is published when the download of a stream starts.
+type StreamStartedEvent struct {
+	ID     string // Identifies the download, as in the ProgressEvents of the stream
+	Media  Media
+	Stream Stream
+}
+
+// ProgressEvent reports the bytes received of a stream, at most every 100ms
+// per stream. Total is 0 if the size is unknown.
+type ProgressEvent struct {
+	ID      string // Identifies the download among all those of the context, see TransferSnapshot.ID
+	Stream  Stream
+	Current int64
+	Total   int64
+}
+
+// ConvertProgressEvent reports the progress of ffmpeg converting the download
+// of a stream, see Option.Format, as often as ffmpeg reports it. Current is
+// the position reached in the media, Total its duration, 0 if unknown.
+type ConvertProgressEvent struct {
+	ID      string // Identifies the download, see ProgressEvent.ID
+	Stream  Stream
+	Current time.Duration
+	Total   time.Duration
+}
+
+// RetryEvent is published before a failed stream download is attempted again.
+type RetryEvent struct {
+	ID      string // Identifies the download, see ProgressEvent.ID
+	Stream  Stream
+	Attempt int           // Number of the coming attempt, from 2
+	Err     error         // Error of the failed attempt
+	Delay   time.Duration // Backoff before the attempt
+}
+
+// StreamCompletedEvent is published when a stream is downloaded and post-processed.
+type StreamCompletedEvent struct {
+	ID     string // Identifies the download, see StreamStartedEvent.ID
+	Media  Media
+	Stream Stream
+	Path   string // Final file, empty if it was replaced by a lower quality or merged
+}
+
+// StreamFailedEvent is published when a stream finally failed, after retries
+// and quality fallbacks.
+type StreamFailedEvent struct {
+	ID     string // Identifies the download, see StreamStartedEvent.ID
+	Media  Media
+	Stream Stream
+	Err    error
+}
+
+func (ExtractStartedEvent) isEvent()  {}
+func (ExtractFinishedEvent) isEvent() {}
+func (ExtractProgressEvent) isEvent() {}
+func (StreamStartedEvent) isEvent()   {}
+func (ProgressEvent) isEvent()        {}
+func (ConvertProgressEvent) isEvent() {}
+func (RetryEvent) isEvent()           {}
+func (StreamCompletedEvent) isEvent() {}
+func (StreamFailedEvent) isEvent()    {}
+
+// eventBus delivers events to subscribers. The zero value has no subscribers.
+type eventBus struct {
+	mu          sync.RWMutex
+	subscribers map[int]func(Event)
+	next        int
+}
+
+// Subscribe registers fn to receive the events of every extraction and
+// download using the context and returns a function unregistering it. fn is
+// called on the goroutine publishing the event, concurrently for parallel
+// downloads, and must not block.
+func (c *Context) Subscribe(fn func(Event)) (unsubscribe func()) {
+	b := &c.events
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(Event))
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}
+}
+
+// publish delivers e to the subscribers of the context.
+func (c *Context) publish(e Event) {
+	b := &c.events
+	b.mu.RLock()
+	subscribers := make([]func(Event), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.RUnlock()
+	for _, fn := range subscribers {
+		fn(e)
+	}
+}
+
+// hasSubscribers reports whether events of the context are delivered anywhere.
+func (c *Context) hasSubscribers() bool {
+	b := &c.events
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return len(b.subscribers) > 0
+}
+
+// ProgressEvents returns a subscriber passing the progress events it receives
+// to callback, described as "Downloading <stream title>".
+func ProgressEvents(callback ProgressCallback) func(Event) {
+	return func(e Event) {
+		if p, ok := e.(ProgressEvent); ok {
+			callback(p.ID, p.Current, p.Total, "Downloading "+p.Stream.Title)
+		}
+	}
+}
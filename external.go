@@ -0,0 +1,286 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// externalProgressInterval is how often the file an external downloader
+// writes is polled for progress.
+const externalProgressInterval = 500 * time.Millisecond
+
+// externalDownloaderArgs are the argument templates of the external
+// downloaders grab knows, see ExternalDownloaderCommand.
+var externalDownloaderArgs = map[string][]string{
+	"aria2c": {"--continue=true", "--auto-file-renaming=false", "--allow-overwrite=true", "--file-allocation=none",
+		"--summary-interval=0", "--console-log-level=warn", "--download-result=hide", "--dir={dir}", "--out={file}", "{headers}", "{url}"},
+	"curl": {"--location", "--fail", "--silent", "--show-error", "--continue-at", "-", "--output", "{output}", "{headers}", "{url}"},
+	"wget": {"--continue", "--quiet", "--output-document={output}", "{headers}", "{url}"},
+}
+
+// externalDownloaderName returns the name of the external downloader at
+// path, e.g. "aria2c" for /usr/bin/aria2c or aria2c.exe.
+func externalDownloaderName(path string) string {
+	name := strings.ToLower(filepath.Base(path))
+	return strings.TrimSuffix(name, ".exe")
+}
+
+// ExternalDownloaderCommand returns the command line running the external
+// downloader tool, a name or path, to download rawURL into output. args is
+// the argument template, the default one of aria2c, curl and wget if empty,
+// where {url}, {output}, {dir} and {file} are replaced and a {headers}
+// argument becomes the option of the tool reading the headers to send from
+// headerFile, see ExternalHeaderFile, or the path of headerFile for other
+// tools. {headers} is dropped if headerFile is "".
+func ExternalDownloaderCommand(tool string, args []string, rawURL, headerFile, output string) ([]string, error) {
+	name := externalDownloaderName(tool)
+	if len(args) == 0 {
+		if args = externalDownloaderArgs[name]; args == nil {
+			return nil, fmt.Errorf("unknown external downloader %q, give its arguments, e.g. \"{headers} -o {output} {url}\"", tool)
+		}
+	}
+	replacer := strings.NewReplacer(
+		"{url}", rawURL,
+		"{output}", output,
+		"{dir}", filepath.Dir(output),
+		"{file}", filepath.Base(output),
+	)
+	command := []string{tool}
+	for _, arg := range args {
+		if arg == "{headers}" {
+			if headerFile != "" {
+				command = append(command, externalHeaderArgs(name, headerFile)...)
+			}
+			continue
+		}
+		command = append(command, replacer.Replace(arg))
+	}
+	return command, nil
+}
+
+// externalHeaderArgs returns the options of the external downloader name
+// reading the headers of headerFile. Options of aria2c and wget load it as
+// their configuration, in place of that of the user, which the file carries
+// on, see externalUserConfig.
+func externalHeaderArgs(name, headerFile string) []string {
+	switch name {
+	case "aria2c":
+		return []string{"--conf-path=" + headerFile}
+	case "curl":
+		return []string{"--header", "@" + headerFile}
+	case "wget":
+		return []string{"--config=" + headerFile}
+	}
+	return []string{headerFile}
+}
+
+// ExternalHeaderFile returns the content of the file from which the external
+// downloader tool reads header, in a stable order: a configuration file for
+// aria2c and wget, and one "Name: value" line per header for curl and other
+// tools. Values spanning lines are dropped. Cookies and credentials are
+// written there rather than on a command line other users can read.
+func ExternalHeaderFile(tool string, header http.Header) []byte {
+	prefix := ""
+	switch externalDownloaderName(tool) {
+	case "aria2c":
+		prefix = "header="
+	case "wget":
+		prefix = "header = "
+	}
+	keys := make([]string, 0, len(header))
+	for key := range header {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var b bytes.Buffer
+	for _, key := range keys {
+		for _, value := range header[key] {
+			if strings.ContainsAny(key+value, "\r\n") {
+				continue
+			}
+			fmt.Fprintf(&b, "%s%s: %s\n", prefix, key, value)
+		}
+	}
+	return b.Bytes()
+}
+
+// externalUserConfig returns the configuration the external downloader name
+// reads when it isn't given one: that of aria2c, the system and user wgetrc of
+// wget, nil for other tools. The header file, loaded in its place, starts with it.
+func externalUserConfig(name string) []byte {
+	home, _ := os.UserHomeDir()
+	var paths []string
+	switch name {
+	case "aria2c":
+		// aria2c reads the first that exists
+		configDir := os.Getenv("XDG_CONFIG_HOME")
+		if configDir == "" {
+			configDir = filepath.Join(home, ".config")
+		}
+		for _, path := range []string{filepath.Join(home, ".aria2", "aria2.conf"), filepath.Join(configDir, "aria2", "aria2.conf")} {
+			if _, err := os.Stat(path); err == nil {
+				paths = []string{path}
+				break
+			}
+		}
+	case "wget":
+		user := os.Getenv("WGETRC")
+		if user == "" {
+			user = filepath.Join(home, ".wgetrc")
+		}
+		paths = []string{"/etc/wgetrc", user}
+	}
+	var b bytes.Buffer
+	for _, path := range paths {
+		if data, err := os.ReadFile(path); err == nil && len(data) > 0 {
+			b.Write(data)
+			if data[len(data)-1] != '\n' {
+				b.WriteByte('\n')
+			}
+		}
+	}
+	return b.Bytes()
+}
+
+// usesExternalDownloader reports whether stream is handed to the external
+// downloader of the options: plain HTTP files, not HLS playlists or sections.
+func (d *Downloader) usesExternalDownloader(stream Stream) bool {
+	return d.ctx.option.ExternalDownloader != "" && stream.Type != StreamTypeM3u8 &&
+		transportFor(stream.URL) == nil && !d.clipsWithFFmpeg(stream)
+}
+
+// externalHeader returns the headers of the client and of stream, with the
+// cookies of the client for its URL, which the external downloader sends.
+func (d *Downloader) externalHeader(stream Stream) http.Header {
+	header := d.ctx.client.Header.Clone()
+	for key, values := range stream.Header {
+		header[key] = values
+	}
+	if jar := d.ctx.client.GetClient().Jar; jar != nil && header.Get("Cookie") == "" {
+		if u, err := url.Parse(stream.URL); err == nil {
+			var cookies []string
+			for _, cookie := range jar.Cookies(u) {
+				cookies = append(cookies, cookie.String())
+			}
+			if len(cookies) > 0 {
+				header.Set("Cookie", strings.Join(cookies, "; "))
+			}
+		}
+	}
+	// The downloader negotiates these itself
+	header.Del("Accept-Encoding")
+	header.Del("Connection")
+	return header
+}
+
+// writeExternalHeaderFile writes content to a new temporary file only the
+// user can read and returns its path.
+func writeExternalHeaderFile(content []byte) (string, error) {
+	file, err := os.CreateTemp("", "grab-headers-*")
+	if err != nil {
+		return "", fmt.Errorf("failed to create header file: %w", err)
+	}
+	_, err = file.Write(content)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return "", fmt.Errorf("failed to write header file: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// downloadExternal downloads stream into tempPath with the external
+// downloader, reporting the size of tempPath as progress, and fails if it
+// exits with an error or the file doesn't match the checksum of stream.
+func (d *Downloader) downloadExternal(ctx context.Context, stream Stream, tempPath string) error {
+	hasher, err := newStreamHasher(stream)
+	if err != nil {
+		return err
+	}
+	var headerFile string
+	downloader := d.ctx.option.ExternalDownloader
+	if content := ExternalHeaderFile(downloader, d.externalHeader(stream)); len(content) > 0 {
+		content = append(externalUserConfig(externalDownloaderName(downloader)), content...)
+		if headerFile, err = writeExternalHeaderFile(content); err != nil {
+			return err
+		}
+		defer os.Remove(headerFile)
+	}
+	command, err := ExternalDownloaderCommand(downloader, d.ctx.option.ExternalDownloaderArgs, stream.URL, headerFile, tempPath)
+	if err != nil {
+		return err
+	}
+	if err := d.checkSpace(tempPath, stream.Size, 0); err != nil {
+		return err
+	}
+
+	cmd := exec.CommandContext(ctx, command[0], command[1:]...)
+	var output bytes.Buffer // Only read once Wait returned
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	d.ctx.logger.Debug("Running external downloader", "command", command[0], "stream", stream.ID)
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to run external downloader: %w", err)
+	}
+
+	progress := d.newProgress(ctx, stream, stream.Size)
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+	t.setParts([]int64{max(stream.Size, 0)})
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	ticker := time.NewTicker(externalProgressInterval)
+	defer ticker.Stop()
+	for running := true; running; {
+		select {
+		case err = <-done:
+			running = false
+		case <-ticker.C:
+		}
+		if fi, statErr := os.Stat(tempPath); statErr == nil {
+			if delta := fi.Size() - progress.Current.Load(); delta > 0 {
+				progress.Add(delta)
+				t.partAdd(0, delta)
+			}
+		}
+	}
+
+	tool := filepath.Base(command[0])
+	if err != nil {
+		if ctx.Err() != nil {
+			return context.Cause(ctx)
+		}
+		// The last lines of the output explain the failure
+		message := strings.TrimSpace(output.String())
+		if lines := strings.Split(message, "\n"); len(lines) > 5 {
+			message = strings.Join(lines[len(lines)-5:], "\n")
+		}
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return fmt.Errorf("%s exited with status %d: %s", tool, exitErr.ExitCode(), message)
+		}
+		return fmt.Errorf("%s failed: %v: %s", tool, err, message)
+	}
+	fi, err := os.Stat(tempPath)
+	if err != nil {
+		return fmt.Errorf("%s did not write the file: %w", tool, err)
+	}
+	if err := hashFile(hasher, tempPath); err != nil {
+		return err
+	}
+	progress.Total = fi.Size()
+	progress.Finish()
+	return hasher.verify()
+}
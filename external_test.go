@@ -0,0 +1,86 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+// TestExternalDownloader verifies the built-in arguments of aria2c, curl and
+// wget download the file with the headers and cookies grab would send, while
+// the tools still read the configuration of the user.
+func TestExternalDownloader(t *testing.T) {
+	content := bytes.Repeat([]byte("external"), 10000)
+	var mu sync.Mutex
+	var got http.Header // Headers of the last request
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		got = r.Header.Clone()
+		mu.Unlock()
+		w.Write(content)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		tool   string
+		config string // User configuration file, relative to HOME
+		line   string // Its line sending X-From-Config: yes
+	}{
+		{"aria2c", ".aria2/aria2.conf", "header=X-From-Config: yes"},
+		{"curl", ".curlrc", `header = "X-From-Config: yes"`},
+		{"wget", ".wgetrc", "header = X-From-Config: yes"},
+	}
+	for _, tt := range tests {
+		if _, err := exec.LookPath(tt.tool); err != nil {
+			t.Logf("%s not installed, skipped", tt.tool)
+			continue
+		}
+		home := t.TempDir()
+		t.Setenv("HOME", home)
+		t.Setenv("XDG_CONFIG_HOME", "")
+		t.Setenv("WGETRC", "")
+		config := filepath.Join(home, filepath.FromSlash(tt.config))
+		if err := os.MkdirAll(filepath.Dir(config), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(config, []byte(tt.line+"\n"), 0600); err != nil {
+			t.Fatal(err)
+		}
+
+		c := NewContext(context.Background(), Option{
+			OutputPath: t.TempDir(), NoCache: true, Silent: true, RetryCount: 1,
+			ExternalDownloader: tt.tool,
+			AuthToken:          "secret-token",
+			Headers:            http.Header{"Cookie": {"session=abc"}},
+		})
+		stream := Stream{ID: "file", Title: "external", URL: server.URL + "/file.bin", Format: "bin", Header: http.Header{"Referer": {"https://example.com/"}}}
+		results, err := NewDownloader(c).Download([]Media{{Title: "external", Streams: []Stream{stream}}})
+		c.Close()
+		if err != nil {
+			t.Errorf("%s: Download failed: %v", tt.tool, err)
+			continue
+		}
+		if data, err := os.ReadFile(results[0].Path); err != nil || !bytes.Equal(data, content) {
+			t.Errorf("%s: downloaded %d bytes (%v), want %d", tt.tool, len(data), err, len(content))
+		}
+		want := map[string]string{
+			"Authorization": "Bearer secret-token",
+			"Cookie":        "session=abc",
+			"Referer":       "https://example.com/",
+			"X-From-Config": "yes",
+		}
+		mu.Lock()
+		for name, value := range want {
+			if got.Get(name) != value {
+				t.Errorf("%s: sent %s: %q, want %q", tt.tool, name, got.Get(name), value)
+			}
+		}
+		mu.Unlock()
+	}
+}
@@ -30,6 +30,7 @@ type Stream struct {
 	Title    string            // Title or name of the stream
 	Type     StreamType        // Type of the stream (video, audio, etc.)
 	URL      string            // Direct URL to this stream
+	Mirrors  []string          // Other URLs serving the same bytes, downloaded from concurrently (optional)
 	Format   string            // Format (e.g., "mp4", "webm", "mp3")
 	Quality  string            // Quality/bitrate info (e.g., "1080p", "320kbps")
 	Size     int64             // Size in bytes (if known)
@@ -37,26 +38,103 @@ type Stream struct {
 	Header   http.Header       // Custom headers for this stream (optional)
 	Extra    map[string]string // Extensible fields (e.g., codec info)
 	SaveAs   string            // Suggested filename to save this stream
+	Language string            // Language tag of a subtitle or audio stream (e.g., "zh-CN")
+	ParentID string            // ID of the stream a subtitle belongs to, defaults to the first video stream
+	Group    string            // Video-only and audio-only streams of a Group are merged into one file (optional)
+
+	// Technical metadata for format selection (zero if unknown)
+	Codec         string  // Codecs in RFC 6381 notation (e.g., "avc1.64001f,mp4a.40.2")
+	FPS           float64 // Video frame rate
+	AudioChannels int     // Number of audio channels
+	Bitrate       int64   // Peak bitrate in bits per second
+
+	Check *StreamCheck `json:",omitempty"` // Liveness probe result, nil unless checked
+}
+
+// Chapter is a named section of a media.
+type Chapter struct {
+	Title string
+	Start time.Duration
+	End   time.Duration // The start of the next chapter if zero
 }
 
 // Media represents a downloadable media resource with multiple streams.
 type Media struct {
+	ID          string            // Stable identifier within its extractor, e.g. the site's video ID (optional)
 	Title       string            // Media title or name
 	Streams     []Stream          // All available streams (keyed by quality or id)
 	Thumbnail   string            // URL to thumbnail image (optional)
 	Description string            // Description of the media (optional)
+	Chapters    []Chapter         // Chapters of the media, embedded with --embed-metadata and cut with --split-chapters (optional)
 	Extra       map[string]string // Additional info for extensibility
+
+	// Structured metadata, shown by --info, usable in output templates and
+	// embedded with --embed-metadata (all optional)
+	Uploader   string        `json:",omitempty"` // Author, channel or teacher
+	UploadDate time.Time     `json:",omitzero"`  // Publication date
+	Duration   time.Duration `json:",omitempty"` // Length of the media, the longest of its streams if zero
+	Tags       []string      `json:",omitempty"` // Keywords or categories
+	Series     string        `json:",omitempty"` // Show, course or playlist the media is part of
+	Season     string        `json:",omitempty"` // Season or chapter of Series
+	Episode    int           `json:",omitempty"` // Number of the media within Season, from 1
+	Language   string        `json:",omitempty"` // Main spoken language tag (e.g., "zh-CN")
+}
+
+// duration returns Duration, or the longest duration of the streams of m if
+// it is zero.
+func (m *Media) duration() time.Duration {
+	if m.Duration > 0 {
+		return m.Duration
+	}
+	var longest time.Duration
+	for _, s := range m.Streams {
+		longest = max(longest, s.Duration)
+	}
+	return longest
 }
 
 func (m *Media) String() string {
 	output := strings.Builder{}
 	output.WriteString(fmt.Sprintf("Title: %s\n", m.Title))
+	if m.ID != "" {
+		output.WriteString(fmt.Sprintf("ID: %s\n", m.ID))
+	}
 	if m.Description != "" {
 		output.WriteString(fmt.Sprintf("Description: %s\n", m.Description))
 	}
 	if m.Thumbnail != "" {
 		output.WriteString(fmt.Sprintf("Thumbnail: %s\n", m.Thumbnail))
 	}
+	if m.Uploader != "" {
+		output.WriteString(fmt.Sprintf("Uploader: %s\n", m.Uploader))
+	}
+	if !m.UploadDate.IsZero() {
+		output.WriteString(fmt.Sprintf("Upload Date: %s\n", m.UploadDate.Format(time.DateOnly)))
+	}
+	if d := m.duration(); d > 0 {
+		output.WriteString(fmt.Sprintf("Duration: %s\n", utils.FormatDuration(d)))
+	}
+	if m.Series != "" {
+		output.WriteString(fmt.Sprintf("Series: %s\n", m.Series))
+	}
+	if m.Season != "" {
+		output.WriteString(fmt.Sprintf("Season: %s\n", m.Season))
+	}
+	if m.Episode > 0 {
+		output.WriteString(fmt.Sprintf("Episode: %d\n", m.Episode))
+	}
+	if m.Language != "" {
+		output.WriteString(fmt.Sprintf("Language: %s\n", m.Language))
+	}
+	if len(m.Tags) > 0 {
+		output.WriteString(fmt.Sprintf("Tags: %s\n", strings.Join(m.Tags, ", ")))
+	}
+	if len(m.Chapters) > 0 {
+		output.WriteString("Chapters:\n")
+		for _, chapter := range m.Chapters {
+			output.WriteString(fmt.Sprintf("  %s %s\n", utils.FormatDuration(chapter.Start), chapter.Title))
+		}
+	}
 	output.WriteString("Streams:\n")
 	if len(m.Streams) == 0 {
 		output.WriteString("  No streams available.\n")
@@ -72,6 +150,18 @@ func (m *Media) String() string {
 			if stream.Format != "" {
 				output.WriteString(fmt.Sprintf("    Format: %s\n", stream.Format))
 			}
+			if stream.Codec != "" {
+				output.WriteString(fmt.Sprintf("    Codec: %s\n", stream.Codec))
+			}
+			if stream.FPS > 0 {
+				output.WriteString(fmt.Sprintf("    FPS: %g\n", stream.FPS))
+			}
+			if stream.AudioChannels > 0 {
+				output.WriteString(fmt.Sprintf("    Audio Channels: %d\n", stream.AudioChannels))
+			}
+			if stream.Bitrate > 0 {
+				output.WriteString(fmt.Sprintf("    Bitrate: %s\n", utils.FormatBitrate(stream.Bitrate)))
+			}
 			if stream.Size > 0 {
 				output.WriteString(fmt.Sprintf("    Size: %s\n", utils.FormatBytes(stream.Size)))
 			}
@@ -81,9 +171,34 @@ func (m *Media) String() string {
 			if stream.URL != "" {
 				output.WriteString(fmt.Sprintf("    URL: %s\n", stream.URL))
 			}
+			if stream.Language != "" {
+				output.WriteString(fmt.Sprintf("    Language: %s\n", stream.Language))
+			}
+			if stream.ParentID != "" {
+				output.WriteString(fmt.Sprintf("    Subtitle Of: %s\n", stream.ParentID))
+			}
 			if stream.SaveAs != "" {
 				output.WriteString(fmt.Sprintf("    Save As: %s\n", stream.SaveAs))
 			}
+			if check := stream.Check; check != nil {
+				status := "dead"
+				if check.Alive {
+					status = "alive"
+				}
+				if check.Status > 0 {
+					status += fmt.Sprintf(" (HTTP %d)", check.Status)
+				}
+				if check.Error != "" {
+					status += ": " + check.Error
+				}
+				output.WriteString(fmt.Sprintf("    Status: %s\n", status))
+				if check.Size > 0 && check.Size != stream.Size {
+					output.WriteString(fmt.Sprintf("    Checked Size: %s\n", utils.FormatBytes(check.Size)))
+				}
+				if check.FinalURL != "" {
+					output.WriteString(fmt.Sprintf("    Redirects To: %s\n", check.FinalURL))
+				}
+			}
 			if len(stream.Extra) > 0 {
 				output.WriteString(fmt.Sprintf("    Extra: %v\n", stream.Extra))
 			}
@@ -101,6 +216,20 @@ type Extractor interface {
 	Extract(url string) ([]Media, error)
 }
 
+// Refresher is implemented by extractors whose stream URLs expire, e.g. signed
+// CDN URLs. Refresh extracts a single media again to obtain fresh stream URLs.
+type Refresher interface {
+	Refresh(media Media) (Media, error)
+}
+
+// Authenticator is implemented by extractors that log in to their site. grab
+// auth login passes Login the credential entered by the user, e.g. a username
+// and password, and stores the credential it returns, e.g. the session token
+// the site issued, for Context.Credential.
+type Authenticator interface {
+	Login(credential Credential) (Credential, error)
+}
+
 var extractors = make(map[string]extractorFactory)
 var lock sync.RWMutex
 
@@ -113,16 +242,115 @@ func Register(name string, f extractorFactory) {
 
 // FindExtractor finds a suitable extractor for the given URL.
 func FindExtractor(ctx *Context, url string) (Extractor, error) {
+	_, extractor, err := FindNamedExtractor(ctx, url)
+	return extractor, err
+}
+
+// FindNamedExtractor finds a suitable extractor for the given URL and returns
+// it along with the name it was registered under.
+// http(s) URLs no registered extractor handles go to the feed extractor if
+// they look like RSS or Atom feeds, otherwise to the generic extractor, which
+// treats them as direct links to a media file or M3U8 playlist.
+func FindNamedExtractor(ctx *Context, url string) (string, Extractor, error) {
 	lock.RLock()
 	defer lock.RUnlock()
 	for name, factory := range extractors {
 		extractor := factory(ctx)
 		if extractor.CanExtract(url) {
 			ctx.logger.Debug("Using extractor", "name", name, "url", url)
-			return extractor, nil
+			return name, extractor, nil
 		}
 	}
-	return nil, ErrNoExtractorFound
+	if feed := (&feedExtractor{ctx: ctx}); feed.CanExtract(url) {
+		ctx.logger.Debug("Using extractor", "name", feedExtractorName, "url", url)
+		return feedExtractorName, feed, nil
+	}
+	if generic := (&genericExtractor{ctx: ctx}); generic.CanExtract(url) {
+		ctx.logger.Debug("Using extractor", "name", genericExtractorName, "url", url)
+		return genericExtractorName, generic, nil
+	}
+	return "", nil, ErrNoExtractorFound
+}
+
+// ExtractURL finds the extractor of url and extracts its medias, publishing an
+// ExtractStartedEvent and an ExtractFinishedEvent to the subscribers of ctx.
+// Only the playlist entries selected by the options are returned, see
+// PlaylistExtractor. It returns the name of the extractor and the extractor,
+// e.g. for SetSource and SetRefresher. It first waits for Option.StartAt.
+func ExtractURL(ctx *Context, url string) (string, Extractor, []Media, error) {
+	name, extractor, err := FindNamedExtractor(ctx, url)
+	if err != nil {
+		return "", nil, nil, fmt.Errorf("failed to find extractor for URL %s: %w", url, err)
+	}
+	if err := ctx.WaitStart(); err != nil {
+		return name, extractor, nil, err
+	}
+	ctx.publish(ExtractStartedEvent{URL: url, Extractor: name})
+	ctx.extraction.start(url, name)
+	started := time.Now()
+	medias, err := extractMedias(ctx, extractor, url)
+	ctx.publish(ExtractFinishedEvent{URL: url, Extractor: name, Medias: medias, Err: err, Duration: time.Since(started)})
+	if err != nil {
+		return name, extractor, nil, fmt.Errorf("failed to extract media from URL %s: %w", url, err)
+	}
+	return name, extractor, medias, nil
+}
+
+// extractProgressInterval is the shortest time between two ExtractProgressEvents.
+const extractProgressInterval = 100 * time.Millisecond
+
+// extraction counts the progress of the extraction run by ExtractURL.
+// Extractions running at once on a context share it.
+type extraction struct {
+	mu        sync.Mutex
+	url, name string
+	node      string
+	items     int
+	requests  int
+	published time.Time
+}
+
+// start resets the counters for the extraction of url by the extractor name.
+func (x *extraction) start(url, name string) {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	x.url, x.name, x.node = url, name, ""
+	x.items, x.requests = 0, 0
+	x.published = time.Time{}
+}
+
+// ReportExtractProgress lets an extractor tell the subscribers of the context
+// that it is working on node, e.g. the title of a chapter, and found items more
+// medias with requests more API requests, so that long extractions don't
+// appear frozen. An empty node keeps the previous one.
+func (c *Context) ReportExtractProgress(node string, items, requests int) {
+	x := &c.extraction
+	x.mu.Lock()
+	if node != "" {
+		x.node = node
+	}
+	x.items += items
+	x.requests += requests
+	now := time.Now()
+	if now.Sub(x.published) < extractProgressInterval || !c.hasSubscribers() {
+		x.mu.Unlock()
+		return
+	}
+	x.published = now
+	e := ExtractProgressEvent{URL: x.url, Extractor: x.name, Node: x.node, Items: x.items, Requests: x.requests}
+	x.mu.Unlock()
+	c.publish(e)
+}
+
+// NewExtractor returns the extractor registered as name.
+func NewExtractor(ctx *Context, name string) (Extractor, error) {
+	lock.RLock()
+	factory, ok := extractors[name]
+	lock.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown extractor %s", ErrNoExtractorFound, name)
+	}
+	return factory(ctx), nil
 }
 
 // ListExtractors returns the names of all registered extractors.
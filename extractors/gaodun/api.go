@@ -5,13 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/gregjones/httpcache"
-	"github.com/gregjones/httpcache/diskcache"
 )
 
 const (
@@ -54,16 +51,33 @@ type Api interface {
 	Headers() http.Header
 }
 
-// NewApi creates a new API client with proper authentication headers
-func NewApi(client *resty.Client) Api {
+// NewApi creates a new API client with proper authentication headers, token
+// being the Authentication header unless client already sets one.
+// Responses are cached in cache unless it is nil. At most concurrency
+// requests are sent at once, defaultConcurrency if 0, and 429 responses are
+// retried after a backoff slowing all of them down.
+func NewApi(client *resty.Client, cache httpcache.Cache, token string, concurrency int) Api {
 	if client == nil {
 		client = resty.New()
 	}
 
+	// Clones share the http.Client and headers, use separate ones so that the
+	// cache transport and API headers don't leak into the downloader's client
+	orig, base := client, client.GetClient()
+	client = resty.NewWithClient(&http.Client{
+		Transport: base.Transport,
+		Jar:       base.Jar,
+		Timeout:   base.Timeout,
+	})
+	client.Header = orig.Header.Clone()
+	client.RetryCount = orig.RetryCount
+	client.RetryWaitTime = orig.RetryWaitTime
+	client.RetryMaxWaitTime = orig.RetryMaxWaitTime
+	client.Debug = orig.Debug
+
 	client.SetBaseURL(endpoint)
 
 	if client.Header.Get("Authentication") == "" {
-		token := os.Getenv("GAODUN_AUTH_TOKEN")
 		client.SetHeader("Authentication", token)
 	}
 
@@ -78,10 +92,16 @@ func NewApi(client *resty.Client) Api {
 	client.SetHeader("Connection", "Keep-Alive")
 	client.SetHeader("Accept-Encoding", "gzip")
 
-	cachePath := filepath.Join(os.TempDir(), "gaodun_cache")
-	cache := diskcache.New(cachePath)
-	transport := httpcache.NewTransport(cache)
-	client.SetTransport(transport)
+	// Keep the proxy and TLS settings of the configured transport behind the
+	// throttle, cache hits don't count against its limit
+	throttled := newThrottle(base.Transport, concurrency, orig.RetryCount, orig.RetryWaitTime, orig.RetryMaxWaitTime)
+	if cache != nil {
+		transport := httpcache.NewTransport(cache)
+		transport.Transport = throttled
+		client.SetTransport(transport)
+	} else {
+		client.SetTransport(throttled)
+	}
 
 	client.OnAfterResponse(func(c *resty.Client, r *resty.Response) error {
 		if r.StatusCode() != http.StatusOK {
@@ -180,7 +200,9 @@ func (c *api) VideoResource(code, quality string, channel int) (*VideoResource,
 
 	var res apiResponse[VideoResource]
 
+	// Stream URLs are signed and expire, never serve them from the disk cache
 	_, err := c.client.R().
+		SetHeader("Cache-Control", "no-cache").
 		SetHeader("isLiveVodAuthenticate", "true").
 		SetResult(&res).
 		Get(url)
@@ -2,13 +2,14 @@ package gaodun
 
 import (
 	"fmt"
+	"os"
 	"testing"
 )
 
 func TestClient(t *testing.T) {
 	// t.Skip("Skipping test for now, as it requires network access")
 
-	api := NewApi(nil)
+	api := NewApi(nil, nil, os.Getenv("GAODUN_AUTH_TOKEN"), 0)
 	gStudyGradations, err := api.GStudy("33795")
 	if err != nil {
 		t.Fatalf("error: %v", err)
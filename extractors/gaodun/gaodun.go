@@ -1,6 +1,7 @@
 package gaodun
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"path/filepath"
@@ -16,22 +17,35 @@ import (
 
 func init() {
 	grab.Register("gaodun", func(ctx *grab.Context) grab.Extractor {
-		return &extractor{
-			ctx: ctx,
-			api: NewApi(ctx.Client().Clone()),
-		}
+		return &extractor{ctx: ctx}
 	})
 }
 
 // extractor implements grab.Extractor for Gaodun platform.
 type extractor struct {
-	ctx *grab.Context
-	api Api
+	ctx  *grab.Context
+	api  Api
+	sync *syncManifest // Manifest of the course with Option.Sync, nil otherwise
 }
 
 // Name returns the extractor's unique name.
 func (e *extractor) Name() string { return "gaodun" }
 
+// Login implements grab.Authenticator. Gaodun takes the token of a logged in
+// session as the Authentication header of API requests, a pasted header line
+// is reduced to its value. The account and password login of the Gaodun
+// app is not implemented, the token must be copied from the app or web site.
+func (e *extractor) Login(credential grab.Credential) (grab.Credential, error) {
+	token := strings.TrimSpace(credential.Token)
+	if name, value, ok := strings.Cut(token, ":"); ok && strings.EqualFold(strings.TrimSpace(name), "Authentication") {
+		token = strings.TrimSpace(value)
+	}
+	if token == "" {
+		return grab.Credential{}, errors.New("Gaodun account login is not implemented yet, pass the Authentication header of a logged in app or web session with --token")
+	}
+	return grab.Credential{Token: token}, nil
+}
+
 // CanExtract checks if the extractor supports the given URL.
 func (e *extractor) CanExtract(url string) bool {
 	patterns := []string{
@@ -59,11 +73,20 @@ func extractCourseID(url string) (string, error) {
 
 // Extract fetches all media resources for a Gaodun course URL.
 func (e *extractor) Extract(url string) ([]grab.Media, error) {
-	e.api = NewApi(e.ctx.Client())
+	// GAODUN_AUTH_TOKEN or the token saved with grab auth login gaodun
+	e.api = NewApi(e.ctx.Client(), e.ctx.HTTPCache(), e.ctx.Credential("gaodun").Token, e.ctx.Option().ExtractorConcurrency)
 	courseID, err := extractCourseID(url)
 	if err != nil {
 		return nil, fmt.Errorf("failed to extract course ID: %w", err)
 	}
+	e.sync = nil
+	if option := e.ctx.Option(); option.Sync {
+		// Lessons are recorded as their downloads complete, after Extract returns
+		if e.sync, err = openSyncManifest(e.ctx, filepath.Join(option.OutputPath, courseID, syncManifestName)); err != nil {
+			return nil, err
+		}
+		e.ctx.Subscribe(e.sync.handleEvent)
+	}
 	isGStudy, err := e.isGStudyCourse(courseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to determine course type: %w", err)
@@ -76,6 +99,7 @@ func (e *extractor) Extract(url string) ([]grab.Media, error) {
 
 // isGStudyCourse returns true if the course is a G-Study course.
 func (e *extractor) isGStudyCourse(courseID string) (bool, error) {
+	e.visit("course " + courseID)
 	gs, err := e.api.GStudy(courseID)
 	if err != nil || len(gs) == 0 {
 		return false, err
@@ -86,14 +110,16 @@ func (e *extractor) isGStudyCourse(courseID string) (bool, error) {
 
 // extractGStudyCourse fetches all media from a G-Study course.
 func (e *extractor) extractGStudyCourse(courseID string) ([]grab.Media, error) {
+	e.visit("course " + courseID)
 	gradations, err := e.api.GStudy(courseID)
 	if err != nil {
 		return nil, err
 	}
-	return processConcurrently(gradations, func(grad Gradation) ([]grab.Media, error) {
+	return processConcurrently(gradations, e.workers(), func(grad Gradation) ([]grab.Media, error) {
 		if grad.GSyllabus == nil {
 			return nil, nil
 		}
+		e.visit(grad.Name)
 		syllabus, err := e.api.GStudySyllabus(courseID, grad.SyllabusID.String())
 		if err != nil || syllabus == nil {
 			e.ctx.Logger().Error("failed to get G-Study syllabus",
@@ -131,11 +157,12 @@ func (e *extractor) extractGStudySyllabus(courseID, gradationName string, syllab
 
 // extractEpStudyCourse fetches all media from an Ep-Study course.
 func (e *extractor) extractEpStudyCourse(courseID string) ([]grab.Media, error) {
+	e.visit("course " + courseID)
 	gradations, err := e.api.EpStudy(courseID)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get EP-Study gradations: %w", err)
 	}
-	return processConcurrently(gradations, func(grad Gradation) ([]grab.Media, error) {
+	return processConcurrently(gradations, e.workers(), func(grad Gradation) ([]grab.Media, error) {
 		return e.processEpGradation(courseID, grad)
 	})
 }
@@ -144,12 +171,13 @@ func (e *extractor) extractEpStudyCourse(courseID string) ([]grab.Media, error)
 func (e *extractor) processEpGradation(courseID string, grad Gradation) ([]grab.Media, error) {
 	var allMedia []grab.Media
 	if len(grad.Children) > 0 {
-		childrenMedia, _ := processConcurrently(grad.Children, func(child Gradation) ([]grab.Media, error) {
+		childrenMedia, _ := processConcurrently(grad.Children, e.workers(), func(child Gradation) ([]grab.Media, error) {
 			return e.processEpGradation(courseID, child)
 		})
 		allMedia = append(allMedia, childrenMedia...)
 	}
 	if grad.SyllabusID.String() != "0" && grad.SyllabusID.String() != "" {
+		e.visit(grad.Name)
 		syllabusItems, err := e.api.EpStudySyllabus(courseID, grad.SyllabusID.String())
 		if err != nil {
 			return nil, fmt.Errorf("failed to get EP-Study syllabus for gradation %s (syllabus_id: %s): %w",
@@ -217,8 +245,30 @@ func (e *extractor) extractResources(resources []Resource, baseDir string) ([]gr
 	})
 }
 
-// processResource creates a Media object from a Resource, handling different types.
+// processResource creates a Media object from a Resource, nil for resources
+// downloaded by an earlier sync and unchanged since.
 func (e *extractor) processResource(resource Resource, baseDir string) (*grab.Media, error) {
+	if e.sync.synced(resource) {
+		e.ctx.Logger().Debug("skipping synced resource",
+			"resource_id", resource.ID, "title", resource.Title)
+		return nil, nil
+	}
+	media, err := e.extractResource(resource, baseDir)
+	if media != nil {
+		e.ctx.ReportExtractProgress("", 1, 0)
+	}
+	e.sync.track(media, resource)
+	return media, err
+}
+
+// visit reports the API request about node, e.g. a gradation or lesson, as
+// extraction progress.
+func (e *extractor) visit(node string) {
+	e.ctx.ReportExtractProgress(node, 0, 1)
+}
+
+// extractResource creates a Media object from a Resource, handling different types.
+func (e *extractor) extractResource(resource Resource, baseDir string) (*grab.Media, error) {
 	switch resource.Discriminator {
 	case "live_new":
 		if resource.LiveUrlPlayBackApp == "" {
@@ -232,6 +282,7 @@ func (e *extractor) processResource(resource Resource, baseDir string) (*grab.Me
 				"resource_id", resource.ID, "error", err)
 			return nil, nil
 		}
+		e.visit(resource.Title)
 		code, err := e.api.GLiveCheck(roomID, token)
 		if err != nil {
 			e.ctx.Logger().Error("failed to check GLive",
@@ -239,8 +290,10 @@ func (e *extractor) processResource(resource Resource, baseDir string) (*grab.Me
 			return nil, nil
 		}
 		resource.VideoID = code
+		e.visit(resource.Title)
 		return e.processVideoResource(resource, baseDir)
 	case "video":
+		e.visit(resource.Title)
 		return e.processVideoResource(resource, baseDir)
 	case "lecture_note":
 		return e.processNonVideoResource(resource, baseDir)
@@ -288,12 +341,44 @@ func (e *extractor) processVideoResource(resource Resource, baseDir string) (*gr
 	if len(streams) == 0 {
 		return nil, fmt.Errorf("no available video streams")
 	}
+	option := e.ctx.Option()
 	return &grab.Media{
-		Title:   resource.Title,
-		Streams: streams,
+		ID:       resource.VideoID,
+		Title:    resource.Title,
+		Duration: time.Duration(resource.Duration) * time.Second,
+		Streams:  option.SelectQualities(streams),
+		Extra: map[string]string{
+			"video_id": resource.VideoID,
+			"base_dir": baseDir,
+			"duration": strconv.Itoa(resource.Duration),
+		},
 	}, nil
 }
 
+// Refresh extracts a video media again to obtain freshly signed stream URLs.
+func (e *extractor) Refresh(media grab.Media) (grab.Media, error) {
+	videoID := media.Extra["video_id"]
+	if videoID == "" {
+		return media, fmt.Errorf("media %s is not a video resource", media.Title)
+	}
+	duration, _ := strconv.Atoi(media.Extra["duration"])
+	resource := Resource{
+		Title:    media.Title,
+		VideoID:  videoID,
+		Duration: duration,
+	}
+	fresh, err := e.processVideoResource(resource, media.Extra["base_dir"])
+	if err != nil {
+		return media, err
+	}
+	for _, key := range []string{extraSyncManifest, extraSyncKey, extraSyncFingerprint} {
+		if value, ok := media.Extra[key]; ok {
+			fresh.Extra[key] = value
+		}
+	}
+	return *fresh, nil
+}
+
 // processNonVideoResource creates a Media object for a non-video resource (e.g., PDF).
 func (e *extractor) processNonVideoResource(res Resource, baseDir string) (*grab.Media, error) {
 	if res.Path == "" {
@@ -331,6 +416,7 @@ func (e *extractor) processNonVideoResource(res Resource, baseDir string) (*grab
 		Header:  resourceHeaders(res.Path),
 	}}
 	return &grab.Media{
+		ID:      strconv.Itoa(res.ID),
 		Title:   res.Title,
 		Streams: streams,
 	}, nil
@@ -358,70 +444,71 @@ func (e *extractor) buildResourcePath(courseID, gradationName, syllabusName stri
 	return filepath.Join(parts...)
 }
 
-// processConcurrently runs fn for each item concurrently and aggregates results.
-// Only the first error is returned. Panics are recovered to avoid goroutine leaks.
-func processConcurrently[T any](items []T, fn func(T) ([]grab.Media, error)) ([]grab.Media, error) {
+// workers returns the number of items processConcurrently works on at once.
+// API requests are limited by the throttle of the api, this only bounds the
+// goroutines waiting for it.
+func (e *extractor) workers() int {
+	if n := e.ctx.Option().ExtractorConcurrency; n > 0 {
+		return n
+	}
+	return defaultConcurrency
+}
+
+// processConcurrently runs fn for each item with a pool of workers goroutines
+// and aggregates results in the order of items, the order of the course.
+// Only the first error is returned. Panics are recovered to avoid goroutine
+// leaks.
+func processConcurrently[T any](items []T, workers int, fn func(T) ([]grab.Media, error)) ([]grab.Media, error) {
 	var wg sync.WaitGroup
-	var mu sync.Mutex
 	var once sync.Once
-	var allMedia []grab.Media
 	var firstErr error
-	for _, item := range items {
-		wg.Add(1)
-		go func(item T) {
-			defer func() {
-				if r := recover(); r != nil {
-					once.Do(func() { firstErr = fmt.Errorf("panic: %v", r) })
-				}
-				wg.Done()
-			}()
-			media, err := fn(item)
-			if err != nil {
-				once.Do(func() { firstErr = err })
+	results := make([][]grab.Media, len(items))
+	queue := make(chan int)
+	process := func(i int) {
+		defer func() {
+			if r := recover(); r != nil {
+				once.Do(func() { firstErr = fmt.Errorf("panic: %v", r) })
 			}
-			if len(media) > 0 {
-				mu.Lock()
-				allMedia = append(allMedia, media...)
-				mu.Unlock()
+		}()
+		media, err := fn(items[i])
+		if err != nil {
+			once.Do(func() { firstErr = err })
+		}
+		results[i] = media
+	}
+	for range min(max(workers, 1), len(items)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				process(i)
 			}
-		}(item)
+		}()
+	}
+	for i := range items {
+		queue <- i
 	}
+	close(queue)
 	wg.Wait()
+	var allMedia []grab.Media
+	for _, media := range results {
+		allMedia = append(allMedia, media...)
+	}
 	return allMedia, firstErr
 }
 
 // processSyllabusConcurrently runs fn for each syllabus concurrently and aggregates results.
 func (e *extractor) processSyllabusConcurrently(items []Syllabus, fn func(Syllabus) ([]grab.Media, error)) ([]grab.Media, error) {
-	return processConcurrently(items, fn)
+	return processConcurrently(items, e.workers(), fn)
 }
 
 // processResourcesConcurrently runs fn for each resource concurrently and aggregates results.
 func (e *extractor) processResourcesConcurrently(items []Resource, fn func(Resource) (*grab.Media, error)) ([]grab.Media, error) {
-	var wg sync.WaitGroup
-	var mu sync.Mutex
-	var once sync.Once
-	var allMedia []grab.Media
-	var firstErr error
-	for _, item := range items {
-		wg.Add(1)
-		go func(item Resource) {
-			defer func() {
-				if r := recover(); r != nil {
-					once.Do(func() { firstErr = fmt.Errorf("panic: %v", r) })
-				}
-				wg.Done()
-			}()
-			media, err := fn(item)
-			if err != nil {
-				once.Do(func() { firstErr = err })
-			}
-			if media != nil {
-				mu.Lock()
-				allMedia = append(allMedia, *media)
-				mu.Unlock()
-			}
-		}(item)
-	}
-	wg.Wait()
-	return allMedia, firstErr
+	return processConcurrently(items, e.workers(), func(item Resource) ([]grab.Media, error) {
+		media, err := fn(item)
+		if media == nil {
+			return nil, err
+		}
+		return []grab.Media{*media}, err
+	})
 }
@@ -0,0 +1,36 @@
+package gaodun
+
+import (
+	"testing"
+
+	"github.com/hydrz/grab"
+)
+
+// TestLogin verifies pasted tokens are stored as the bare header value and
+// that account logins, not implemented, are refused rather than stored.
+func TestLogin(t *testing.T) {
+	tests := []struct {
+		credential grab.Credential
+		want       string // Stored token, "" if Login fails
+	}{
+		{grab.Credential{Token: "abc.def"}, "abc.def"},
+		{grab.Credential{Token: "  abc.def\n"}, "abc.def"},
+		{grab.Credential{Token: "Authentication: abc.def"}, "abc.def"},
+		{grab.Credential{Token: "authentication:abc.def"}, "abc.def"},
+		{grab.Credential{Username: "user", Password: "secret"}, ""},
+		{grab.Credential{Token: " "}, ""},
+	}
+	e := &extractor{}
+	for _, tt := range tests {
+		got, err := e.Login(tt.credential)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Login(%+v) = %+v, want an error", tt.credential, got)
+			}
+			continue
+		}
+		if err != nil || got != (grab.Credential{Token: tt.want}) {
+			t.Errorf("Login(%+v) = %+v, %v, want token %q", tt.credential, got, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package gaodun
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/hydrz/grab"
+)
+
+// syncManifestName is the manifest of a course in its directory, see Option.Sync.
+const syncManifestName = ".grab-sync.json"
+
+// Extra keys tying a media to its entry in a sync manifest.
+const (
+	extraSyncManifest    = "sync_manifest"
+	extraSyncKey         = "sync_key"
+	extraSyncFingerprint = "sync_fingerprint"
+)
+
+// syncManifest records the resources of a course downloaded by earlier runs
+// with Option.Sync, so that later runs only extract new or updated lessons.
+type syncManifest struct {
+	ctx  *grab.Context
+	path string
+
+	mu      sync.Mutex
+	entries map[string]syncEntry // By resource key, see syncKey
+}
+
+type syncEntry struct {
+	Fingerprint  string    `json:"fingerprint"`
+	Title        string    `json:"title"`
+	DownloadedAt time.Time `json:"downloaded_at"`
+}
+
+// openSyncManifest reads the manifest at path. A missing file is empty.
+func openSyncManifest(ctx *grab.Context, path string) (*syncManifest, error) {
+	m := &syncManifest{ctx: ctx, path: path, entries: make(map[string]syncEntry)}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read sync manifest: %w", err)
+	}
+	if err := json.Unmarshal(data, &m.entries); err != nil {
+		return nil, fmt.Errorf("invalid sync manifest %s: %w", path, err)
+	}
+	if m.entries == nil {
+		m.entries = make(map[string]syncEntry)
+	}
+	return m, nil
+}
+
+// syncKey identifies resource within its course.
+func syncKey(resource Resource) string {
+	return resource.Discriminator + ":" + strconv.Itoa(resource.ID)
+}
+
+// syncFingerprint changes when resource is updated: renamed, re-recorded or
+// replaced by another file.
+func syncFingerprint(resource Resource) string {
+	h := sha256.New()
+	for _, field := range []string{resource.Title, strconv.Itoa(resource.Duration), resource.VideoID, resource.Filesize.String(), resource.Extension} {
+		h.Write([]byte(field))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// synced reports whether resource was downloaded before and is unchanged
+// since. A nil manifest has nothing.
+func (m *syncManifest) synced(resource Resource) bool {
+	if m == nil {
+		return false
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[syncKey(resource)]
+	return ok && entry.Fingerprint == syncFingerprint(resource)
+}
+
+// track tags media, extracted from resource, to be recorded once downloaded.
+func (m *syncManifest) track(media *grab.Media, resource Resource) {
+	if m == nil || media == nil {
+		return
+	}
+	if media.Extra == nil {
+		media.Extra = make(map[string]string)
+	}
+	media.Extra[extraSyncManifest] = m.path
+	media.Extra[extraSyncKey] = syncKey(resource)
+	media.Extra[extraSyncFingerprint] = syncFingerprint(resource)
+}
+
+// handleEvent records the medias of the manifest whose stream was downloaded.
+func (m *syncManifest) handleEvent(e grab.Event) {
+	done, ok := e.(grab.StreamCompletedEvent)
+	if !ok || done.Media.Extra[extraSyncManifest] != m.path {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[done.Media.Extra[extraSyncKey]] = syncEntry{
+		Fingerprint:  done.Media.Extra[extraSyncFingerprint],
+		Title:        done.Media.Title,
+		DownloadedAt: time.Now(),
+	}
+	if err := m.save(); err != nil {
+		// Not fatal, the lesson is extracted again next time
+		m.ctx.Logger().Warn("failed to update sync manifest", "path", m.path, "error", err)
+	}
+}
+
+// save writes the manifest, replacing the file atomically.
+func (m *syncManifest) save() error {
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
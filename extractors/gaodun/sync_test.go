@@ -0,0 +1,57 @@
+package gaodun
+
+import (
+	"context"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/hydrz/grab"
+)
+
+// TestSyncManifest verifies downloaded resources are recorded and skipped by
+// the next sync until they change.
+func TestSyncManifest(t *testing.T) {
+	ctx := grab.NewContext(context.Background(), grab.Option{})
+	path := filepath.Join(t.TempDir(), "33795", syncManifestName)
+	lesson := Resource{ID: 1, Title: "Lesson 1", Discriminator: "video", VideoID: "v1", Duration: 600}
+	notes := Resource{ID: 2, Title: "Notes", Discriminator: "lecture_note", Filesize: json.Number("1024")}
+
+	m, err := openSyncManifest(ctx, path)
+	if err != nil {
+		t.Fatalf("openSyncManifest() error: %v", err)
+	}
+	var medias []grab.Media
+	for _, resource := range []Resource{lesson, notes} {
+		if m.synced(resource) {
+			t.Fatalf("resource %d synced before any download", resource.ID)
+		}
+		media := &grab.Media{Title: resource.Title}
+		m.track(media, resource)
+		medias = append(medias, *media)
+	}
+	// Only the lesson completes
+	m.handleEvent(grab.StreamCompletedEvent{Media: medias[0]})
+
+	m, err = openSyncManifest(ctx, path)
+	if err != nil {
+		t.Fatalf("openSyncManifest() again error: %v", err)
+	}
+	if !m.synced(lesson) {
+		t.Errorf("downloaded lesson not synced")
+	}
+	if m.synced(notes) {
+		t.Errorf("notes synced without being downloaded")
+	}
+	updated := lesson
+	updated.VideoID = "v2"
+	if m.synced(updated) {
+		t.Errorf("re-recorded lesson still synced")
+	}
+
+	other := grab.Media{Extra: map[string]string{extraSyncManifest: "elsewhere", extraSyncKey: syncKey(notes), extraSyncFingerprint: syncFingerprint(notes)}}
+	m.handleEvent(grab.StreamCompletedEvent{Media: other})
+	if m.synced(notes) {
+		t.Errorf("media of another manifest recorded")
+	}
+}
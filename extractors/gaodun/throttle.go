@@ -0,0 +1,139 @@
+package gaodun
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	// defaultConcurrency is the number of API requests in flight at once when
+	// Option.ExtractorConcurrency is 0.
+	defaultConcurrency = 8
+
+	// Backoff on 429 responses, unless the retry options of the client ask for more
+	minThrottleRetries = 5
+	throttleDelay      = time.Second
+	throttleMaxDelay   = 30 * time.Second
+)
+
+// throttle is a transport running at most a number of API requests at once.
+// A 429 Too Many Requests response pauses all of them, for the Retry-After
+// the API sends or a delay doubling with each 429 in a row, before the
+// request is sent again.
+type throttle struct {
+	transport http.RoundTripper
+	slots     chan struct{}
+	retries   int
+	delay     time.Duration // Delay after the first 429
+	maxDelay  time.Duration
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// newThrottle returns a throttle sending concurrency requests at once through
+// transport, http.DefaultTransport if nil.
+func newThrottle(transport http.RoundTripper, concurrency, retries int, delay, maxDelay time.Duration) *throttle {
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	if concurrency <= 0 {
+		concurrency = defaultConcurrency
+	}
+	if delay <= 0 {
+		delay = throttleDelay
+	}
+	if maxDelay < delay {
+		maxDelay = max(throttleMaxDelay, delay)
+	}
+	return &throttle{
+		transport: transport,
+		slots:     make(chan struct{}, concurrency),
+		retries:   max(retries, minThrottleRetries),
+		delay:     delay,
+		maxDelay:  maxDelay,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *throttle) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	select {
+	case t.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	defer func() { <-t.slots }()
+
+	for failed := 0; ; failed++ {
+		if err := t.wait(req); err != nil {
+			return nil, err
+		}
+		resp, err := t.transport.RoundTrip(req)
+		if err != nil || resp.StatusCode != http.StatusTooManyRequests || failed >= t.retries {
+			return resp, err
+		}
+		// API calls are GETs, anything with a body can't be sent again
+		if req.Body != nil && req.GetBody == nil {
+			return resp, nil
+		}
+		t.pause(t.backoff(failed, resp.Header))
+		io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+		resp.Body.Close()
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(ctx)
+			req.Body = body
+		}
+	}
+}
+
+// wait blocks while the API asked to slow down.
+func (t *throttle) wait(req *http.Request) error {
+	t.mu.Lock()
+	delay := time.Until(t.pausedUntil)
+	t.mu.Unlock()
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-req.Context().Done():
+		return req.Context().Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pause holds all requests back for delay, unless they already are for longer.
+func (t *throttle) pause(delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if until := time.Now().Add(delay); until.After(t.pausedUntil) {
+		t.pausedUntil = until
+	}
+}
+
+// backoff returns the delay before sending a request again after failed
+// earlier 429 responses and the last one, with header.
+func (t *throttle) backoff(failed int, header http.Header) time.Duration {
+	delay := t.delay
+	for i := 0; i < failed && delay < t.maxDelay; i++ {
+		delay *= 2
+	}
+	if value := header.Get("Retry-After"); value != "" {
+		if seconds, err := strconv.Atoi(value); err == nil {
+			delay = max(delay, time.Duration(seconds)*time.Second)
+		} else if date, err := http.ParseTime(value); err == nil {
+			delay = max(delay, time.Until(date))
+		}
+	}
+	return min(delay, t.maxDelay)
+}
@@ -0,0 +1,79 @@
+package gaodun
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// TestThrottle verifies the throttle bounds requests in flight and retries
+// 429 responses after a backoff.
+func TestThrottle(t *testing.T) {
+	var inFlight, peak, throttled atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		time.Sleep(20 * time.Millisecond)
+		if throttled.Add(1) <= 3 {
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("ok"))
+	}))
+	defer srv.Close()
+
+	client := &http.Client{Transport: newThrottle(nil, 2, 0, 10*time.Millisecond, 40*time.Millisecond)}
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := client.Get(srv.URL)
+			if err != nil {
+				t.Errorf("Get() error: %v", err)
+				return
+			}
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusOK {
+				t.Errorf("Get() status %d, want 200", resp.StatusCode)
+			}
+		}()
+	}
+	wg.Wait()
+	if got := peak.Load(); got > 2 {
+		t.Errorf("%d requests in flight, want at most 2", got)
+	}
+}
+
+func TestThrottleBackoff(t *testing.T) {
+	th := newThrottle(nil, 1, 0, time.Second, 30*time.Second)
+	tests := []struct {
+		failed     int
+		retryAfter string
+		want       time.Duration
+	}{
+		{0, "", time.Second},
+		{2, "", 4 * time.Second},
+		{10, "", 30 * time.Second},
+		{0, "7", 7 * time.Second},
+		{0, "120", 30 * time.Second},
+	}
+	for _, tt := range tests {
+		header := http.Header{}
+		if tt.retryAfter != "" {
+			header.Set("Retry-After", tt.retryAfter)
+		}
+		if got := th.backoff(tt.failed, header); got != tt.want {
+			t.Errorf("backoff(%d, %q) = %v, want %v", tt.failed, tt.retryAfter, got, tt.want)
+		}
+	}
+}
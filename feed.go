@@ -0,0 +1,335 @@
+package grab
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/html/charset"
+)
+
+// feedExtractorName is the name FindNamedExtractor returns for RSS and Atom
+// feeds, e.g. of podcasts.
+const feedExtractorName = "feed"
+
+// feedExtractor turns the enclosures of the items of an RSS or Atom feed into
+// medias, newest first as feeds list them, so the playlist options select
+// episodes. Feeds at URLs without a telling name are handed over by the
+// generic extractor from their Content-Type.
+type feedExtractor struct {
+	ctx *Context
+}
+
+// CanExtract matches http(s) URLs named like feeds, e.g. ending with .rss or
+// /feed.
+func (e *feedExtractor) CanExtract(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return false
+	}
+	name := strings.ToLower(path.Base(u.Path))
+	switch path.Ext(name) {
+	case ".rss", ".atom":
+		return true
+	}
+	switch strings.TrimSuffix(name, path.Ext(name)) {
+	case "feed", "rss", "atom", "podcast":
+		return true
+	}
+	return false
+}
+
+// isFeedType reports whether contentType is the media type of RSS or Atom
+// feeds, or of XML documents that may be one.
+func isFeedType(contentType string) bool {
+	switch contentType {
+	case "application/rss+xml", "application/atom+xml", "application/xml", "text/xml":
+		return true
+	}
+	return false
+}
+
+// Extract downloads the feed at rawURL and returns a media for each item with
+// an enclosure, items without one, e.g. blog posts, being skipped.
+func (e *feedExtractor) Extract(rawURL string) ([]Media, error) {
+	req := e.ctx.client.R().SetContext(e.ctx.Context())
+	req.Header = genericHeader(e.ctx, rawURL)
+	req.SetHeader("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	resp, err := req.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch feed %s: %w", rawURL, err)
+	}
+	if resp.StatusCode() != http.StatusOK {
+		return nil, fmt.Errorf("failed to fetch feed %s: HTTP error: %s", rawURL, resp.Status())
+	}
+	medias, err := e.parse(resp.Body())
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse feed %s: %w", rawURL, err)
+	}
+	if len(medias) == 0 {
+		return nil, fmt.Errorf("feed %s has no item with an enclosure", rawURL)
+	}
+	return medias, nil
+}
+
+// parse returns the medias of the RSS or Atom feed in data.
+func (e *feedExtractor) parse(data []byte) ([]Media, error) {
+	root, err := feedRoot(data)
+	if err != nil {
+		return nil, err
+	}
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	switch root {
+	case "rss":
+		var feed rssFeed
+		if err := decoder.Decode(&feed); err != nil {
+			return nil, err
+		}
+		return feed.medias(), nil
+	case "feed":
+		var feed atomFeed
+		if err := decoder.Decode(&feed); err != nil {
+			return nil, err
+		}
+		return feed.medias(), nil
+	}
+	return nil, fmt.Errorf("<%s> document is not an RSS or Atom feed", root)
+}
+
+// feedRoot returns the local name of the root element of the XML document in
+// data.
+func feedRoot(data []byte) (string, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.CharsetReader = charset.NewReaderLabel
+	decoder.Strict = false
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return "", fmt.Errorf("not an XML document: %w", err)
+		}
+		if start, ok := token.(xml.StartElement); ok {
+			return start.Name.Local, nil
+		}
+	}
+}
+
+// rssFeed is an RSS 2.0 feed with the itunes: elements of podcasts. Fields of
+// the itunes namespace come before the plain ones of the same name, xml
+// giving an element to the first field matching it.
+type rssFeed struct {
+	Channel struct {
+		Title        string        `xml:"title"`
+		ItunesAuthor string        `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+		ItunesImage  feedHref      `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+		Image        rssImage      `xml:"image"`
+		Language     string        `xml:"language"`
+		Items        []rssItem     `xml:"item"`
+		Categories   []rssCategory `xml:"category"`
+	} `xml:"channel"`
+}
+
+type rssImage struct {
+	URL string `xml:"url"`
+}
+
+type rssCategory struct {
+	Text string `xml:",chardata"`
+}
+
+type rssItem struct {
+	ItunesTitle    string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd title"`
+	Title          string         `xml:"title"`
+	GUID           string         `xml:"guid"`
+	PubDate        string         `xml:"pubDate"`
+	ItunesSummary  string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd summary"`
+	Description    string         `xml:"description"`
+	ItunesDuration string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd duration"`
+	ItunesSeason   string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd season"`
+	ItunesEpisode  string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd episode"`
+	ItunesImage    feedHref       `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd image"`
+	ItunesAuthor   string         `xml:"http://www.itunes.com/dtds/podcast-1.0.dtd author"`
+	Enclosures     []rssEnclosure `xml:"enclosure"`
+}
+
+type rssEnclosure struct {
+	URL    string `xml:"url,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+type feedHref struct {
+	Href string `xml:"href,attr"`
+}
+
+func (f *rssFeed) medias() []Media {
+	channel := f.Channel
+	artwork := cmp.Or(channel.ItunesImage.Href, channel.Image.URL)
+	var medias []Media
+	for _, item := range channel.Items {
+		var streams []Stream
+		for _, enclosure := range item.Enclosures {
+			if stream, ok := feedStream(enclosure.URL, enclosure.Type, enclosure.Length); ok {
+				streams = append(streams, stream)
+			}
+		}
+		if len(streams) == 0 {
+			continue
+		}
+		media := Media{
+			ID:          cmp.Or(strings.TrimSpace(item.GUID), streams[0].URL),
+			Title:       strings.TrimSpace(cmp.Or(item.ItunesTitle, item.Title)),
+			Thumbnail:   cmp.Or(item.ItunesImage.Href, artwork),
+			Description: strings.TrimSpace(cmp.Or(item.Description, item.ItunesSummary)),
+			Uploader:    strings.TrimSpace(cmp.Or(item.ItunesAuthor, channel.ItunesAuthor)),
+			UploadDate:  parseFeedDate(item.PubDate),
+			Series:      strings.TrimSpace(channel.Title),
+			Season:      strings.TrimSpace(item.ItunesSeason),
+			Language:    strings.TrimSpace(channel.Language),
+		}
+		media.Duration, _ = parseTimestamp(item.ItunesDuration)
+		media.Episode, _ = strconv.Atoi(strings.TrimSpace(item.ItunesEpisode))
+		for _, category := range channel.Categories {
+			if text := strings.TrimSpace(category.Text); text != "" {
+				media.Tags = append(media.Tags, text)
+			}
+		}
+		medias = append(medias, feedMedia(media, streams))
+	}
+	return medias
+}
+
+type atomFeed struct {
+	Title   string      `xml:"title"`
+	Icon    string      `xml:"icon"`
+	Logo    string      `xml:"logo"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID        string     `xml:"id"`
+	Title     string     `xml:"title"`
+	Published string     `xml:"published"`
+	Updated   string     `xml:"updated"`
+	Summary   string     `xml:"summary"`
+	Author    atomAuthor `xml:"author"`
+	Links     []atomLink `xml:"link"`
+}
+
+type atomLink struct {
+	Href   string `xml:"href,attr"`
+	Rel    string `xml:"rel,attr"`
+	Type   string `xml:"type,attr"`
+	Length string `xml:"length,attr"`
+}
+
+func (f *atomFeed) medias() []Media {
+	var medias []Media
+	for _, entry := range f.Entries {
+		var streams []Stream
+		for _, link := range entry.Links {
+			if link.Rel != "enclosure" {
+				continue
+			}
+			if stream, ok := feedStream(link.Href, link.Type, link.Length); ok {
+				streams = append(streams, stream)
+			}
+		}
+		if len(streams) == 0 {
+			continue
+		}
+		medias = append(medias, feedMedia(Media{
+			ID:          cmp.Or(strings.TrimSpace(entry.ID), streams[0].URL),
+			Title:       strings.TrimSpace(entry.Title),
+			Thumbnail:   cmp.Or(f.Logo, f.Icon),
+			Description: strings.TrimSpace(entry.Summary),
+			Uploader:    strings.TrimSpace(cmp.Or(entry.Author.Name, f.Author.Name)),
+			UploadDate:  parseFeedDate(cmp.Or(entry.Published, entry.Updated)),
+			Series:      strings.TrimSpace(f.Title),
+		}, streams))
+	}
+	return medias
+}
+
+// feedStream returns the stream of the enclosure at rawURL, false if it isn't
+// an http(s) URL.
+func feedStream(rawURL, contentType, length string) (Stream, bool) {
+	rawURL = strings.TrimSpace(rawURL)
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return Stream{}, false
+	}
+	contentType, _, _ = mime.ParseMediaType(contentType)
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(u.Path)), ".")
+	stream := Stream{
+		URL:    rawURL,
+		Type:   genericStreamType(contentType, ext),
+		Format: ext,
+	}
+	if stream.Type == StreamTypeM3u8 {
+		stream.Format = "ts"
+	} else if genericStreamType("", ext) == StreamTypeOther {
+		// Tracking redirects of podcast hosts often have no media extension
+		stream.Format = cmp.Or(genericFormats[contentType], ext)
+	}
+	if size, err := strconv.ParseInt(strings.TrimSpace(length), 10, 64); err == nil && size > 0 {
+		stream.Size = size
+	}
+	return stream, true
+}
+
+// feedMedia returns media with streams, named and given IDs and the headers
+// of their host.
+func feedMedia(media Media, streams []Stream) Media {
+	if media.Title == "" {
+		media.Title = path.Base(streams[0].URL)
+	}
+	for i := range streams {
+		streams[i].ID = feedExtractorName
+		if len(streams) > 1 {
+			streams[i].ID = fmt.Sprintf("%s-%d", feedExtractorName, i+1)
+		}
+		streams[i].Title = media.Title
+		streams[i].Duration = media.Duration
+	}
+	media.Streams = streams
+	return media
+}
+
+// feedDateLayouts are the date layouts seen in feeds, RSS dates being RFC 822
+// ones often written loosely.
+var feedDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	"Mon, 2 Jan 2006 15:04:05 -0700",
+	"Mon, 2 Jan 2006 15:04:05 MST",
+	"2 Jan 2006 15:04:05 -0700",
+	"Mon, 02 Jan 2006 15:04 -0700",
+	time.RFC3339,
+	time.DateOnly,
+}
+
+// parseFeedDate parses the date of a feed item, the zero time if it can't.
+func parseFeedDate(s string) time.Time {
+	s = strings.TrimSpace(s)
+	for _, layout := range feedDateLayouts {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
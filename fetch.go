@@ -0,0 +1,119 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// Result is the outcome of Fetch for one media.
+type Result struct {
+	Media      Media
+	Files      []string         // Files of the downloaded streams, after format conversion; empty with ExtractOnly
+	Streams    []DownloadResult // Outcome of each stream download; empty with ExtractOnly
+	Failures   []Failure        // Streams skipped after failing, only with IgnoreErrors
+	Downgrades []Downgrade      // Streams downloaded in a lower quality, only with QualityFallback
+}
+
+// FetchOption configures Fetch.
+type FetchOption func(*fetchConfig)
+
+type fetchConfig struct {
+	option      Option
+	subscribers []func(Event)
+	logger      *slog.Logger
+}
+
+// WithOption combines option into the options of Fetch, which start from DefaultOptions.
+func WithOption(option Option) FetchOption {
+	return func(c *fetchConfig) {
+		c.option.Combine(option)
+	}
+}
+
+// WithProgress reports the progress of every stream download to callback.
+func WithProgress(callback ProgressCallback) FetchOption {
+	return WithEvents(ProgressEvents(callback))
+}
+
+// WithEvents subscribes fn to the events of the extraction and downloads, see Context.Subscribe.
+func WithEvents(fn func(Event)) FetchOption {
+	return func(c *fetchConfig) {
+		c.subscribers = append(c.subscribers, fn)
+	}
+}
+
+// WithLogger logs to logger instead of stdout, see Context.SetLogger.
+func WithLogger(logger *slog.Logger) FetchOption {
+	return func(c *fetchConfig) {
+		c.logger = logger
+	}
+}
+
+// Fetch extracts the medias of url with the matching extractor and downloads
+// their streams passing the quality, type and playlist filters of the options,
+// converting and tagging them as requested, as the grab command does:
+//
+//	results, err := grab.Fetch(ctx, "https://example.com/video/123",
+//		grab.WithOption(grab.Option{OutputPath: "./videos", Quality: "720p"}))
+//
+// With ExtractOnly nothing is downloaded and the results only hold the medias.
+// On error, the results of the medias handled before are returned with it.
+func Fetch(ctx context.Context, url string, opts ...FetchOption) (results []Result, err error) {
+	config := fetchConfig{option: *DefaultOptions}
+	for _, opt := range opts {
+		opt(&config)
+	}
+	c := NewContext(ctx, config.option)
+	if config.logger != nil {
+		c.SetLogger(config.logger)
+	}
+	for _, fn := range config.subscribers {
+		c.Subscribe(fn)
+	}
+	defer func() {
+		if closeErr := c.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+
+	name, extractor, medias, err := ExtractURL(c, url)
+	if err != nil {
+		return nil, err
+	}
+
+	results = make([]Result, 0, len(medias))
+	if config.option.ExtractOnly {
+		for _, media := range medias {
+			results = append(results, Result{Media: media})
+		}
+		return results, nil
+	}
+
+	d := NewDownloader(c)
+	d.SetSource(url, name)
+	if refresher, ok := extractor.(Refresher); ok {
+		d.SetRefresher(refresher)
+	}
+	for _, media := range medias {
+		failures, downgrades := len(d.Failures()), len(d.Downgrades())
+		streams, err := d.Download([]Media{media})
+
+		result := Result{
+			Media:      media,
+			Streams:    streams,
+			Failures:   d.Failures()[failures:],
+			Downgrades: d.Downgrades()[downgrades:],
+		}
+		for _, s := range streams {
+			if s.Path != "" {
+				result.Files = append(result.Files, s.Path)
+			}
+		}
+		results = append(results, result)
+		if err != nil {
+			return results, fmt.Errorf("failed to download media for URL %s: %w", url, err)
+		}
+	}
+	return results, nil
+}
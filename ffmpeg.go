@@ -1,32 +1,172 @@
 package grab
 
 import (
+	"bufio"
+	"bytes"
+	"context"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
-// convertFormat uses ffmpeg to convert input file to the specified format.
+// convertFormat uses the ffmpeg at ffmpegPath to convert input file to the
+// specified format, calling onProgress, if not nil, as ffmpeg reports its
+// progress. Without ffmpegArgs, the streams are copied into the new container
+// when it can hold them, and re-encoded with the defaults of ffmpeg otherwise.
 // Returns the output file path or error.
-func convertFormat(inputPath, outputFormat string) (string, error) {
-	ffmpegBin := "ffmpeg"
-	if runtime.GOOS == "windows" {
-		ffmpegBin = "ffmpeg.exe"
+func convertFormat(ctx context.Context, ffmpegPath string, ffmpegArgs []string, inputPath, outputFormat string, onProgress func(current, total time.Duration)) (string, error) {
+	outputPath := convertedPath(inputPath, outputFormat)
+
+	if len(ffmpegArgs) == 0 {
+		if _, err := runFFmpeg(ctx, ffmpegPath, []string{"-y", "-i", inputPath, "-c", "copy", outputPath}, onProgress); err == nil {
+			return outputPath, nil
+		} else if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
 	}
-	ffmpegPath, err := exec.LookPath(ffmpegBin)
+
+	inputArgs, outputArgs := splitFFmpegArgs(ffmpegArgs)
+	args := append([]string{"-y"}, inputArgs...)
+	args = append(args, "-i", inputPath)
+	args = append(append(args, outputArgs...), outputPath)
+	output, err := runFFmpeg(ctx, ffmpegPath, args, onProgress)
 	if err != nil {
-		return "", ErrFFmpegNotFound
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+	}
+	return outputPath, nil
+}
+
+// splitFFmpegArgs splits Option.FFmpegArgs at "-i" into the options of the
+// input and those of the output, all of them if there is no "-i".
+func splitFFmpegArgs(args []string) (input, output []string) {
+	if i := slices.Index(args, "-i"); i >= 0 {
+		return args[:i], args[i+1:]
 	}
+	return nil, args
+}
 
-	ext := "." + strings.ToLower(outputFormat)
-	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + ext
+// ffmpegDuration matches the duration of an input in the log of ffmpeg.
+var ffmpegDuration = regexp.MustCompile(`Duration: (\d+):(\d{2}):(\d{2}(?:\.\d+)?)`)
 
-	cmd := exec.Command(ffmpegPath, "-y", "-i", inputPath, outputPath)
-	output, err := cmd.CombinedOutput()
+// runFFmpeg runs ffmpeg with args and returns its log. Unless onProgress is
+// nil, ffmpeg writes its progress to stdout with -progress, and onProgress is
+// called with the position reached in the output and the duration of the
+// first input, read from the log, 0 until known.
+func runFFmpeg(ctx context.Context, ffmpegPath string, args []string, onProgress func(current, total time.Duration)) ([]byte, error) {
+	if onProgress == nil {
+		return exec.CommandContext(ctx, ffmpegPath, args...).CombinedOutput()
+	}
+	args = append([]string{"-nostats", "-progress", "pipe:1"}, args...)
+	cmd := exec.CommandContext(ctx, ffmpegPath, args...)
+	log := &syncBuffer{}
+	cmd.Stderr = log
+	stdout, err := cmd.StdoutPipe()
 	if err != nil {
-		return "", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output))
+		return nil, err
 	}
-	return outputPath, nil
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	var total time.Duration
+	readFFmpegProgress(stdout, func(current time.Duration) {
+		if total == 0 {
+			total = parseFFmpegDuration(log.Bytes())
+		}
+		onProgress(current, total)
+	})
+	err = cmd.Wait()
+	return log.Bytes(), err
+}
+
+// readFFmpegProgress reads the key=value blocks of ffmpeg -progress from r
+// until it is closed, calling onProgress with the position of each block.
+func readFFmpegProgress(r io.Reader, onProgress func(current time.Duration)) {
+	scanner := bufio.NewScanner(r)
+	current := time.Duration(-1)
+	for scanner.Scan() {
+		key, value, _ := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		switch key {
+		case "out_time_us", "out_time_ms": // Both are in microseconds
+			if us, err := strconv.ParseInt(value, 10, 64); err == nil && us >= 0 {
+				current = time.Duration(us) * time.Microsecond
+			}
+		case "progress": // Ends a block
+			if current >= 0 {
+				onProgress(current)
+			}
+		}
+	}
+	io.Copy(io.Discard, r) // Never block ffmpeg on a full pipe
+}
+
+// parseFFmpegDuration returns the duration of the first input in the log of
+// ffmpeg, 0 if it is not there yet or unknown, e.g. "Duration: N/A".
+func parseFFmpegDuration(log []byte) time.Duration {
+	m := ffmpegDuration.FindSubmatch(log)
+	if m == nil {
+		return 0
+	}
+	hours, _ := strconv.Atoi(string(m[1]))
+	minutes, _ := strconv.Atoi(string(m[2]))
+	seconds, _ := strconv.ParseFloat(string(m[3]), 64)
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute + time.Duration(seconds*float64(time.Second))
+}
+
+// syncBuffer is a bytes.Buffer safe to write while it is read.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the bytes written so far.
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return bytes.Clone(b.buf.Bytes())
+}
+
+// findFFmpeg returns the path of the ffmpeg executable at location, the
+// executable itself or the directory holding it, or in PATH if location is
+// empty, see Option.FFmpegPath.
+func findFFmpeg(location string) (string, error) {
+	ffmpegBin := "ffmpeg"
+	if runtime.GOOS == "windows" {
+		ffmpegBin = "ffmpeg.exe"
+	}
+	if location == "" {
+		ffmpegPath, err := exec.LookPath(ffmpegBin)
+		if err != nil {
+			return "", fmt.Errorf("%w in PATH", ErrFFmpegNotFound)
+		}
+		return ffmpegPath, nil
+	}
+	if info, err := os.Stat(location); err == nil && info.IsDir() {
+		location = filepath.Join(location, ffmpegBin)
+	}
+	ffmpegPath, err := exec.LookPath(location)
+	if err != nil {
+		return "", fmt.Errorf("%w at %s", ErrFFmpegNotFound, location)
+	}
+	return ffmpegPath, nil
+}
+
+// convertedPath returns the path convertFormat writes inputPath converted to outputFormat to.
+func convertedPath(inputPath, outputFormat string) string {
+	return strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + "." + strings.ToLower(outputFormat)
 }
@@ -20,6 +20,30 @@ func (q qualityFilter) Filter(stream Stream) bool {
 	return q == "" || strings.EqualFold(stream.Quality, string(q))
 }
 
+// variantFilter keeps M3U8 streams only, whose variant is picked by quality when downloading.
+type variantFilter string
+
+func (v variantFilter) Filter(stream Stream) bool {
+	return stream.Type == StreamTypeM3u8
+}
+
+// explicitQualityFilter returns the filter of a quality given by the user.
+// Streams of exactly that quality win; without any, M3U8 streams are kept so
+// that the quality selects a variant of their master playlist.
+func explicitQualityFilter(streams []Stream, quality string) Filter {
+	hasM3u8 := false
+	for _, s := range streams {
+		if strings.EqualFold(s.Quality, quality) {
+			return qualityFilter(quality)
+		}
+		hasM3u8 = hasM3u8 || s.Type == StreamTypeM3u8
+	}
+	if hasM3u8 {
+		return variantFilter(quality)
+	}
+	return qualityFilter(quality)
+}
+
 // VideoOnlyFilter filters only video or m3u8 streams.
 type videoOnlyFilter struct{}
 
@@ -42,6 +66,9 @@ func (f *noSubtitleFilter) Filter(stream Stream) bool {
 }
 
 // PlaylistFilter filters playlist streams by index range.
+//
+// Deprecated: extractors don't number streams this way, playlists are sliced
+// by ExtractURL instead, see PlaylistExtractor.
 type PlaylistFilter struct {
 	Start int
 	End   int
@@ -80,26 +107,7 @@ func (o *Option) filtersForStreams(streams []Stream) []Filter {
 	}
 
 	if quality == "best" || quality == "worst" {
-		// Find all unique qualities and sort
-		qualities := make(map[string]int)
-		order := []string{}
-		for i, s := range streams {
-			if _, ok := qualities[s.Quality]; !ok {
-				qualities[s.Quality] = i
-				order = append(order, s.Quality)
-			}
-		}
-
-		// Sort qualities by a custom rule, e.g., resolution or bitrate
-		sort.Slice(order, func(i, j int) bool {
-			// Try to parse as int, fallback to string compare
-			qi, erri := strconv.Atoi(order[i])
-			qj, errj := strconv.Atoi(order[j])
-			if erri == nil && errj == nil {
-				return qi > qj // higher is better
-			}
-			return order[i] > order[j]
-		})
+		order := sortedQualities(streams)
 		var target string
 		if quality == "best" {
 			target = order[0]
@@ -108,7 +116,7 @@ func (o *Option) filtersForStreams(streams []Stream) []Filter {
 		}
 		filters = append(filters, qualityFilter(target))
 	} else if quality != "" {
-		filters = append(filters, qualityFilter(quality))
+		filters = append(filters, explicitQualityFilter(streams, quality))
 	}
 	if o.VideoOnly {
 		filters = append(filters, &videoOnlyFilter{})
@@ -116,14 +124,80 @@ func (o *Option) filtersForStreams(streams []Stream) []Filter {
 	if o.AudioOnly {
 		filters = append(filters, &audioOnlyFilter{})
 	}
-	if !o.Subtitle {
+	if !o.Subtitle && !o.EmbedSubs {
 		filters = append(filters, &noSubtitleFilter{})
 	}
-	if o.PlaylistStart > 0 || o.PlaylistEnd > 0 {
-		filters = append(filters, &PlaylistFilter{
-			Start: o.PlaylistStart,
-			End:   o.PlaylistEnd,
-		})
-	}
 	return filters
 }
+
+// sortedQualities returns the distinct qualities of streams, best first.
+func sortedQualities(streams []Stream) []string {
+	seen := make(map[string]bool)
+	order := []string{}
+	for _, s := range streams {
+		if !seen[s.Quality] {
+			seen[s.Quality] = true
+			order = append(order, s.Quality)
+		}
+	}
+
+	// Sort qualities by a custom rule, e.g., resolution or bitrate
+	sort.Slice(order, func(i, j int) bool {
+		// Try to parse as int, fallback to string compare
+		qi, erri := strconv.Atoi(order[i])
+		qj, errj := strconv.Atoi(order[j])
+		if erri == nil && errj == nil {
+			return qi > qj // higher is better
+		}
+		return order[i] > order[j]
+	})
+	return order
+}
+
+// SelectQualities returns the streams of the quality Quality selects, for
+// extractors that would otherwise list every quality of a media. Streams
+// without a quality, such as subtitles, are kept. All streams are returned
+// with AllFormats or QualityFallback, which need the other qualities, and
+// when none has the requested quality, leaving the choice to the downloader.
+func (o *Option) SelectQualities(streams []Stream) []Stream {
+	if o.AllFormats || o.QualityFallback {
+		return streams
+	}
+	var rated []Stream
+	for _, s := range streams {
+		if s.Quality != "" {
+			rated = append(rated, s)
+		}
+	}
+	if len(rated) == 0 {
+		return streams
+	}
+	target := o.Quality
+	switch order := sortedQualities(rated); target {
+	case "", "best":
+		target = order[0]
+	case "worst":
+		target = order[len(order)-1]
+	}
+	var selected []Stream
+	for _, s := range streams {
+		if s.Quality == "" || strings.EqualFold(s.Quality, target) {
+			selected = append(selected, s)
+		}
+	}
+	if len(selected) == len(streams)-len(rated) {
+		return streams
+	}
+	return selected
+}
+
+// lowerQualities returns the qualities of streams below quality, best first.
+func lowerQualities(streams []Stream, quality string) []string {
+	order := sortedQualities(streams)
+	for i, q := range order {
+		if q == quality {
+			return order[i+1:]
+		}
+	}
+	return nil
+}
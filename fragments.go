@@ -0,0 +1,114 @@
+package grab
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	fragmentsSuffix   = ".fragments"
+	fragmentsPlaylist = "index.m3u8"
+	fragmentsManifest = "fragments.json"
+)
+
+// fragment maps a kept segment file to its source and its place in the merged output.
+type fragment struct {
+	Index         int     `json:"index"`
+	File          string  `json:"file"`
+	URI           string  `json:"uri"`
+	Duration      float64 `json:"duration"`
+	Discontinuity bool    `json:"discontinuity,omitempty"`
+	Offset        int64   `json:"offset"` // Offset of the segment in the merged output
+	Size          int64   `json:"size"`
+}
+
+// fragmentsDir returns the directory kept segments of outputPath are written to.
+func fragmentsDir(outputPath string) string {
+	return outputPath + fragmentsSuffix
+}
+
+// saveFragments writes each segment of path, the merged and decrypted output
+// of Read, to its own file in dir, along with a local playlist to remux them
+// and a manifest mapping them to their URIs and offsets in the output.
+func (r *m3U8Reader) saveFragments(path, dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create fragments directory: %w", err)
+	}
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	fragments := make([]fragment, 0, len(r.segments))
+	var playlist strings.Builder
+	targetDuration := 0
+	for i, segment := range r.segments {
+		f := fragment{
+			Index:         i,
+			File:          fmt.Sprintf("%05d%s", i, segmentExtension(segment.URI)),
+			URI:           segment.URI,
+			Duration:      segment.Duration,
+			Discontinuity: segment.Discontinuity,
+			Offset:        segment.offset,
+			Size:          segment.size,
+		}
+		if err := writeSection(filepath.Join(dir, f.File), src, f.Offset, f.Size); err != nil {
+			return fmt.Errorf("failed to write fragment %d: %w", i, err)
+		}
+		fragments = append(fragments, f)
+
+		if f.Discontinuity {
+			playlist.WriteString("#EXT-X-DISCONTINUITY\n")
+		}
+		fmt.Fprintf(&playlist, "#EXTINF:%.3f,\n%s\n", f.Duration, f.File)
+		targetDuration = max(targetDuration, int(f.Duration+0.999))
+	}
+
+	header := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n", targetDuration)
+	content := header + playlist.String() + "#EXT-X-ENDLIST\n"
+	if err := os.WriteFile(filepath.Join(dir, fragmentsPlaylist), []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write fragments playlist: %w", err)
+	}
+
+	manifest, err := json.MarshalIndent(fragments, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, fragmentsManifest), manifest, 0644); err != nil {
+		return fmt.Errorf("failed to write fragments manifest: %w", err)
+	}
+	return nil
+}
+
+// writeSection copies size bytes of src starting at offset to a new file at path.
+func writeSection(path string, src io.ReaderAt, offset, size int64) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, io.NewSectionReader(src, offset, size)); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// segmentExtension returns the file extension of a segment URI, ".ts" if it has none.
+func segmentExtension(uri string) string {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return ".ts"
+	}
+	ext := path.Ext(u.Path)
+	if ext == "" || len(ext) > 5 {
+		return ".ts"
+	}
+	return ext
+}
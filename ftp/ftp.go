@@ -0,0 +1,295 @@
+// Package ftp downloads files from FTP and FTPS servers, from an offset so
+// that interrupted downloads resume.
+//
+// Only what downloading needs is implemented: login, binary mode, SIZE, the
+// EPSV and PASV passive modes, REST and RETR. ftps URLs use implicit TLS,
+// like curl, the control and data connections being encrypted from the start.
+package ftp
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Default ports of the ftp and ftps schemes.
+const (
+	DefaultPort    = "21"
+	DefaultTLSPort = "990"
+)
+
+// ErrResumeUnsupported is returned by Open for a non-zero offset when the
+// server refuses REST.
+var ErrResumeUnsupported = errors.New("server does not support resuming downloads")
+
+// conn is a logged in control connection.
+type conn struct {
+	raw       net.Conn
+	text      *textproto.Conn
+	tlsConfig *tls.Config // nil for plain FTP
+	dialer    net.Dialer
+}
+
+// Open logs in to the server of u, anonymously unless u has a user, and
+// returns the content of the file at the path of u from offset, with the size
+// of the whole file, -1 if the server doesn't tell. The path is relative to
+// the login directory, a leading "//" makes it absolute. tlsConfig configures
+// the TLS of ftps URLs, nil using the defaults. Closing the reader ends the
+// session; it fails if the transfer didn't complete.
+func Open(ctx context.Context, u *url.URL, offset int64, tlsConfig *tls.Config) (io.ReadCloser, int64, error) {
+	c, err := dial(ctx, u, tlsConfig)
+	if err != nil {
+		return nil, -1, err
+	}
+	// Unblock the exchanges below when ctx is done
+	stop := context.AfterFunc(ctx, func() { c.raw.Close() })
+	r, size, err := c.retrieve(ctx, u, offset)
+	if !stop() || err != nil {
+		if r != nil {
+			r.data.Close()
+		}
+		c.raw.Close()
+		if ctx.Err() != nil {
+			return nil, -1, context.Cause(ctx)
+		}
+		return nil, -1, err
+	}
+	r.stop = context.AfterFunc(ctx, func() {
+		r.data.Close()
+		c.raw.Close()
+	})
+	return r, size, nil
+}
+
+// dial connects to the server of u and logs in.
+func dial(ctx context.Context, u *url.URL, tlsConfig *tls.Config) (*conn, error) {
+	c := &conn{}
+	port := DefaultPort
+	if u.Scheme == "ftps" {
+		port = DefaultTLSPort
+		c.tlsConfig = &tls.Config{}
+		if tlsConfig != nil {
+			c.tlsConfig = tlsConfig.Clone()
+		}
+		if c.tlsConfig.ServerName == "" {
+			c.tlsConfig.ServerName = u.Hostname()
+		}
+		// Servers often require data connections to resume the control session
+		if c.tlsConfig.ClientSessionCache == nil {
+			c.tlsConfig.ClientSessionCache = tls.NewLRUClientSessionCache(1)
+		}
+	} else if u.Scheme != "ftp" {
+		return nil, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	if u.Port() != "" {
+		port = u.Port()
+	}
+
+	raw, err := c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(u.Hostname(), port))
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	if c.tlsConfig != nil {
+		tlsConn := tls.Client(raw, c.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			raw.Close()
+			return nil, fmt.Errorf("TLS handshake failed: %w", err)
+		}
+		raw = tlsConn
+	}
+	c.raw = raw
+	c.text = textproto.NewConn(raw)
+
+	stop := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stop()
+	if err := c.login(u.User); err != nil {
+		raw.Close()
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// cmd sends a command and reads its reply, which must have a code starting
+// with expect, see textproto.Conn.ReadResponse.
+func (c *conn) cmd(expect int, format string, args ...any) (int, string, error) {
+	if _, err := c.text.Cmd(format, args...); err != nil {
+		return 0, "", err
+	}
+	return c.text.ReadResponse(expect)
+}
+
+// login greets the server and logs in as user, anonymous if nil.
+func (c *conn) login(user *url.Userinfo) error {
+	if _, _, err := c.text.ReadResponse(220); err != nil {
+		return fmt.Errorf("unexpected greeting: %w", err)
+	}
+	name, password := "anonymous", "anonymous@"
+	if user != nil {
+		name = user.Username()
+		if p, ok := user.Password(); ok {
+			password = p
+		}
+	}
+	code, _, err := c.cmd(0, "USER %s", name)
+	if err == nil && code == 331 {
+		code, _, err = c.cmd(2, "PASS %s", password)
+	}
+	if err != nil || code/100 != 2 {
+		return fmt.Errorf("login failed: %w", replyError(code, err))
+	}
+	if c.tlsConfig != nil {
+		if _, _, err := c.cmd(2, "PBSZ 0"); err != nil {
+			return fmt.Errorf("PBSZ failed: %w", err)
+		}
+		if _, _, err := c.cmd(2, "PROT P"); err != nil {
+			return fmt.Errorf("PROT failed: %w", err)
+		}
+	}
+	if _, _, err := c.cmd(2, "TYPE I"); err != nil {
+		return fmt.Errorf("failed to switch to binary mode: %w", err)
+	}
+	return nil
+}
+
+// replyError returns err, or an error for an unexpected reply code if nil.
+func replyError(code int, err error) error {
+	if err != nil {
+		return err
+	}
+	return fmt.Errorf("unexpected reply %d", code)
+}
+
+// retrieve starts downloading the file of u from offset.
+func (c *conn) retrieve(ctx context.Context, u *url.URL, offset int64) (*reader, int64, error) {
+	path := filePath(u)
+	size := int64(-1)
+	if _, msg, err := c.cmd(213, "SIZE %s", path); err == nil {
+		if n, err := strconv.ParseInt(strings.TrimSpace(msg), 10, 64); err == nil {
+			size = n
+		}
+	}
+
+	data, err := c.passive(ctx)
+	if err != nil {
+		return nil, -1, err
+	}
+	if offset > 0 {
+		if _, _, err := c.cmd(350, "REST %d", offset); err != nil {
+			data.Close()
+			return nil, -1, fmt.Errorf("%w: %v", ErrResumeUnsupported, err)
+		}
+	}
+	if _, _, err := c.cmd(1, "RETR %s", path); err != nil {
+		data.Close()
+		return nil, -1, fmt.Errorf("failed to retrieve %s: %w", path, err)
+	}
+	if c.tlsConfig != nil {
+		tlsConn := tls.Client(data, c.tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			data.Close()
+			return nil, -1, fmt.Errorf("TLS handshake of the data connection failed: %w", err)
+		}
+		data = tlsConn
+	}
+	return &reader{conn: c, data: data}, size, nil
+}
+
+// filePath returns the path of the file of u on the server.
+func filePath(u *url.URL) string {
+	if strings.HasPrefix(u.Path, "//") {
+		return u.Path[1:]
+	}
+	return strings.TrimPrefix(u.Path, "/")
+}
+
+// passive opens a data connection with EPSV, or PASV if the server doesn't
+// know it. The address PASV replies with is ignored for that of the control
+// connection, it is often private behind NAT.
+func (c *conn) passive(ctx context.Context) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(c.raw.RemoteAddr().String())
+	var port int
+	if _, msg, err := c.cmd(229, "EPSV"); err == nil {
+		// Entering Extended Passive Mode (|||6446|)
+		start, end := strings.Index(msg, "(|||"), strings.LastIndex(msg, "|)")
+		if start < 0 || end < start+4 {
+			return nil, fmt.Errorf("invalid EPSV reply %q", msg)
+		}
+		if port, err = strconv.Atoi(msg[start+4 : end]); err != nil {
+			return nil, fmt.Errorf("invalid EPSV reply %q", msg)
+		}
+	} else {
+		_, msg, err := c.cmd(227, "PASV")
+		if err != nil {
+			return nil, fmt.Errorf("failed to enter passive mode: %w", err)
+		}
+		// Entering Passive Mode (h1,h2,h3,h4,p1,p2)
+		start, end := strings.Index(msg, "("), strings.LastIndex(msg, ")")
+		if start < 0 || end < start {
+			return nil, fmt.Errorf("invalid PASV reply %q", msg)
+		}
+		fields := strings.Split(msg[start+1:end], ",")
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid PASV reply %q", msg)
+		}
+		p1, err1 := strconv.Atoi(strings.TrimSpace(fields[4]))
+		p2, err2 := strconv.Atoi(strings.TrimSpace(fields[5]))
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid PASV reply %q", msg)
+		}
+		port = p1<<8 | p2
+	}
+	data, err := c.dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open data connection: %w", err)
+	}
+	return data, nil
+}
+
+// reader reads the data connection of a RETR.
+type reader struct {
+	conn *conn
+	data net.Conn
+	eof  bool
+	stop func() bool // Stops closing the connections when the context is done
+
+	once sync.Once
+	err  error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	n, err := r.data.Read(p)
+	if err == io.EOF {
+		r.eof = true
+	}
+	return n, err
+}
+
+// Close closes the data connection and ends the session, returning an error
+// if the whole file was read but the server reported a failed transfer.
+func (r *reader) Close() error {
+	r.once.Do(func() {
+		if r.stop != nil {
+			r.stop()
+		}
+		r.data.Close()
+		if r.eof {
+			if _, _, err := r.conn.text.ReadResponse(2); err != nil {
+				r.err = fmt.Errorf("transfer failed: %w", err)
+			}
+			r.conn.text.Cmd("QUIT")
+		}
+		r.conn.raw.Close()
+	})
+	return r.err
+}
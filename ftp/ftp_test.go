@@ -0,0 +1,200 @@
+package ftp
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// testServer is an FTP server serving files from memory to one client at a
+// time, just enough for Open.
+type testServer struct {
+	listener net.Listener
+	files    map[string]string
+	password string // Required password of user "user", anonymous if empty
+	noEPSV   bool
+	noREST   bool
+}
+
+func newTestServer(t *testing.T, files map[string]string) *testServer {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, files: files}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			s.serve(c)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url(path string) *url.URL {
+	u, _ := url.Parse("ftp://" + s.listener.Addr().String() + path)
+	return u
+}
+
+func (s *testServer) serve(c net.Conn) {
+	defer c.Close()
+	r := bufio.NewReader(c)
+	reply := func(format string, args ...any) { fmt.Fprintf(c, format+"\r\n", args...) }
+	reply("220 test server")
+	var data net.Listener
+	var offset int64
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		command, arg, _ := strings.Cut(strings.TrimSpace(line), " ")
+		switch command {
+		case "USER":
+			if s.password == "" && arg == "anonymous" {
+				reply("331 any password")
+			} else if arg == "user" {
+				reply("331 password please")
+			} else {
+				reply("530 unknown user")
+			}
+		case "PASS":
+			if s.password != "" && arg != s.password {
+				reply("530 wrong password")
+			} else {
+				reply("230 logged in")
+			}
+		case "TYPE":
+			reply("200 binary")
+		case "SIZE":
+			if content, ok := s.files[arg]; ok {
+				reply("213 %d", len(content))
+			} else {
+				reply("550 no such file")
+			}
+		case "EPSV", "PASV":
+			if command == "EPSV" && s.noEPSV {
+				reply("500 unknown command")
+				continue
+			}
+			data, _ = net.Listen("tcp", "127.0.0.1:0")
+			port := data.Addr().(*net.TCPAddr).Port
+			if command == "EPSV" {
+				reply("229 Entering Extended Passive Mode (|||%d|)", port)
+			} else {
+				// A private address the client must ignore
+				reply("227 Entering Passive Mode (10,0,0,1,%d,%d)", port>>8, port&0xff)
+			}
+		case "REST":
+			if s.noREST {
+				reply("502 not implemented")
+				continue
+			}
+			offset, _ = strconv.ParseInt(arg, 10, 64)
+			reply("350 restarting")
+		case "RETR":
+			content, ok := s.files[arg]
+			if !ok || data == nil {
+				reply("550 no such file")
+				continue
+			}
+			reply("150 sending")
+			dc, err := data.Accept()
+			data.Close()
+			if err != nil {
+				return
+			}
+			io.WriteString(dc, content[offset:])
+			dc.Close()
+			reply("226 done")
+			data, offset = nil, 0
+		case "QUIT":
+			reply("221 bye")
+			return
+		default:
+			reply("502 not implemented")
+		}
+	}
+}
+
+func readAll(t *testing.T, u *url.URL, offset int64) (string, int64) {
+	t.Helper()
+	body, size, err := Open(context.Background(), u, offset, nil)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return string(content), size
+}
+
+func TestOpen(t *testing.T) {
+	s := newTestServer(t, map[string]string{"pub/file.bin": "0123456789"})
+
+	content, size := readAll(t, s.url("/pub/file.bin"), 0)
+	if content != "0123456789" || size != 10 {
+		t.Errorf("got %q with size %d, want the whole file with size 10", content, size)
+	}
+
+	content, size = readAll(t, s.url("/pub/file.bin"), 4)
+	if content != "456789" || size != 10 {
+		t.Errorf("got %q with size %d from offset 4, want %q with size 10", content, size, "456789")
+	}
+
+	s.noEPSV = true
+	if content, _ := readAll(t, s.url("/pub/file.bin"), 0); content != "0123456789" {
+		t.Errorf("got %q over PASV, want the whole file", content)
+	}
+}
+
+func TestOpenLogin(t *testing.T) {
+	s := newTestServer(t, map[string]string{"file": "data"})
+	s.password = "secret"
+
+	u := s.url("/file")
+	u.User = url.UserPassword("user", "secret")
+	if content, _ := readAll(t, u, 0); content != "data" {
+		t.Errorf("got %q, want %q", content, "data")
+	}
+
+	u.User = url.UserPassword("user", "wrong")
+	if _, _, err := Open(context.Background(), u, 0, nil); err == nil || !strings.Contains(err.Error(), "login failed") {
+		t.Errorf("Open with a wrong password: got %v, want a login error", err)
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	s := newTestServer(t, map[string]string{"file": "data"})
+
+	if _, _, err := Open(context.Background(), s.url("/missing"), 0, nil); err == nil || !strings.Contains(err.Error(), "550") {
+		t.Errorf("Open of a missing file: got %v, want a 550 error", err)
+	}
+
+	s.noREST = true
+	if _, _, err := Open(context.Background(), s.url("/file"), 2, nil); !errors.Is(err, ErrResumeUnsupported) {
+		t.Errorf("Open from an offset without REST: got %v, want ErrResumeUnsupported", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Open(ctx, s.url("/file"), 0, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("Open with a canceled context: got %v, want context.Canceled", err)
+	}
+}
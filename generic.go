@@ -0,0 +1,212 @@
+package grab
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// genericExtractorName is the name FindNamedExtractor returns for direct media
+// URLs no registered extractor handles.
+const genericExtractorName = "generic"
+
+// genericExtractor downloads any http(s) URL pointing at a media file or an
+// M3U8 playlist, or the URL of a file with a Transport, e.g. ftp, as a single
+// stream. It is the fallback of FindNamedExtractor.
+type genericExtractor struct {
+	ctx *Context
+}
+
+func (e *genericExtractor) CanExtract(rawURL string) bool {
+	u, err := url.Parse(rawURL)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https" || transportFor(rawURL) != nil) && u.Host != ""
+}
+
+// Extract probes rawURL with a one byte range request and builds the stream
+// from its Content-Type, Content-Disposition and size. Web pages are refused.
+func (e *genericExtractor) Extract(rawURL string) ([]Media, error) {
+	if transportFor(rawURL) != nil {
+		return transportMedias(rawURL)
+	}
+	header := genericHeader(e.ctx, rawURL)
+	req := newMediaRequest(e.ctx.Context(), e.ctx.client, header)
+	req.SetHeader("Range", "bytes=0-0")
+	resp, err := req.Get(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe %s: %w", rawURL, err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != http.StatusOK && resp.StatusCode() != http.StatusPartialContent {
+		return nil, fmt.Errorf("failed to probe %s: HTTP error: %s", rawURL, resp.Status())
+	}
+
+	finalURL := resp.RawResponse.Request.URL
+	contentType, _, _ := mime.ParseMediaType(resp.Header().Get("Content-Type"))
+	filename := ""
+	if _, params, err := mime.ParseMediaType(resp.Header().Get("Content-Disposition")); err == nil {
+		filename = path.Base(params["filename"])
+	}
+	if filename == "" || filename == "." || filename == "/" {
+		filename = path.Base(finalURL.Path)
+	}
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+
+	stream := Stream{
+		ID:     genericExtractorName,
+		URL:    rawURL,
+		Header: header,
+		Type:   genericStreamType(contentType, ext),
+		Format: ext,
+	}
+	if stream.Type == StreamTypeOther {
+		// Servers often label playlists as text/plain or octet-stream
+		head := make([]byte, len("#EXTM3U"))
+		if n, _ := io.ReadFull(resp.RawBody(), head); bytes.Equal(head[:n], []byte("#EXTM3U")) {
+			stream.Type = StreamTypeM3u8
+		}
+	}
+	if contentType == "application/dash+xml" || ext == "mpd" {
+		if err := e.checkDASH(rawURL, header); err != nil {
+			return nil, err
+		}
+	}
+	if stream.Type == StreamTypeOther && isFeedType(contentType) {
+		return (&feedExtractor{ctx: e.ctx}).Extract(rawURL)
+	}
+	if stream.Type == StreamTypeOther && (contentType == "text/html" || contentType == "application/xhtml+xml") {
+		return nil, fmt.Errorf("%w: %s is a web page, not media", ErrNoExtractorFound, rawURL)
+	}
+	if stream.Type == StreamTypeM3u8 {
+		stream.Format = "ts"
+	} else {
+		if stream.Format == "" {
+			stream.Format = genericFormats[contentType]
+		}
+		if stream.Format == "" {
+			if exts, _ := mime.ExtensionsByType(contentType); len(exts) > 0 {
+				stream.Format = strings.TrimPrefix(exts[0], ".")
+			}
+		}
+		stream.Size = genericSize(resp.StatusCode(), resp.Header())
+	}
+
+	title := strings.TrimSuffix(filename, path.Ext(filename))
+	if title == "" {
+		title = finalURL.Host
+	}
+	stream.Title = title
+	return []Media{{ID: rawURL, Title: title, Streams: []Stream{stream}}}, nil
+}
+
+// checkDASH fails with ErrDRMProtected if the DASH manifest at rawURL
+// protects its content. Other manifests are downloaded like any file.
+func (e *genericExtractor) checkDASH(rawURL string, header http.Header) error {
+	resp, err := newMediaRequest(e.ctx.Context(), e.ctx.client, header).Get(rawURL)
+	if err != nil {
+		return fmt.Errorf("failed to download DASH manifest: %w", err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != http.StatusOK {
+		return httpStatusError(resp)
+	}
+	if err := dashDRM(io.LimitReader(resp.RawBody(), 16<<20)); errors.Is(err, ErrDRMProtected) {
+		return err
+	}
+	return nil
+}
+
+// transportMedias returns the media of the file at rawURL, downloaded with a
+// Transport, named and typed after its extension without connecting.
+func transportMedias(rawURL string) ([]Media, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	filename := path.Base(u.Path)
+	if filename == "." || filename == "/" {
+		return nil, fmt.Errorf("%w: %s has no file name", ErrInvalidURL, u.Redacted())
+	}
+	ext := strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+	title := strings.TrimSuffix(filename, path.Ext(filename))
+	stream := Stream{
+		ID:     genericExtractorName,
+		Title:  title,
+		URL:    rawURL,
+		Type:   genericStreamType("", ext),
+		Format: ext,
+	}
+	return []Media{{ID: rawURL, Title: title, Streams: []Stream{stream}}}, nil
+}
+
+// genericHeader returns the headers the registered site header rules, then
+// those of the options of ctx, set for the host of rawURL.
+func genericHeader(ctx *Context, rawURL string) http.Header {
+	header := SiteHeaders(rawURL)
+	for key, values := range matchSiteHeaders(ctx.option.siteHeaderRules(), utils.URLHost(rawURL)) {
+		header[key] = values
+	}
+	return header
+}
+
+// genericFormats maps media types to the usual extension of their files, where
+// mime.ExtensionsByType would pick a rare one first.
+var genericFormats = map[string]string{
+	"video/mp4":        "mp4",
+	"video/webm":       "webm",
+	"video/x-matroska": "mkv",
+	"video/quicktime":  "mov",
+	"video/mp2t":       "ts",
+	"audio/mpeg":       "mp3",
+	"audio/mp4":        "m4a",
+	"audio/aac":        "aac",
+	"audio/ogg":        "ogg",
+	"audio/flac":       "flac",
+	"image/jpeg":       "jpg",
+	"application/pdf":  "pdf",
+}
+
+// genericStreamType returns the stream type of a response with the given
+// media type and file extension.
+func genericStreamType(contentType, ext string) StreamType {
+	switch {
+	case contentType == "application/vnd.apple.mpegurl", contentType == "application/x-mpegurl",
+		contentType == "audio/mpegurl", contentType == "audio/x-mpegurl", ext == "m3u8":
+		return StreamTypeM3u8
+	case strings.HasPrefix(contentType, "video/"):
+		return StreamTypeVideo
+	case strings.HasPrefix(contentType, "audio/"):
+		return StreamTypeAudio
+	case strings.HasPrefix(contentType, "image/"):
+		return StreamTypeImage
+	case contentType == "application/pdf":
+		return StreamTypeDocument
+	}
+	switch ext {
+	case "mp4", "m4v", "mkv", "webm", "mov", "avi", "flv", "ts", "wmv":
+		return StreamTypeVideo
+	case "mp3", "m4a", "aac", "flac", "ogg", "opus", "wav":
+		return StreamTypeAudio
+	}
+	return StreamTypeOther
+}
+
+// genericSize returns the size of the file from the probe response, 0 if unknown.
+func genericSize(status int, header http.Header) int64 {
+	if status == http.StatusPartialContent {
+		if _, _, total, err := utils.ParseContentRange(header.Get("Content-Range")); err == nil && total > 0 {
+			return total
+		}
+		return 0
+	}
+	size, _ := strconv.ParseInt(header.Get("Content-Length"), 10, 64)
+	return max(0, size)
+}
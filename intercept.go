@@ -0,0 +1,127 @@
+package grab
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/go-resty/resty/v2"
+	"github.com/hydrz/grab/utils"
+)
+
+// Interceptor observes or modifies the HTTP requests sent through the client
+// of a Context, see Context.Intercept.
+type Interceptor struct {
+	// Hosts limits the interceptor to requests to hosts matching one of the
+	// patterns, see utils.MatchHost. It applies to every request if empty.
+	Hosts []string
+
+	// Request is called before req is sent, once per attempt, and may modify
+	// it, e.g. to sign it or add a token. An error aborts the request.
+	Request func(req *http.Request) error
+
+	// Response is called once the status and headers of resp are received,
+	// before its body is read. An error fails the request.
+	Response func(resp *http.Response) error
+}
+
+// matches reports whether i applies to req.
+func (i Interceptor) matches(req *http.Request) bool {
+	if len(i.Hosts) == 0 {
+		return true
+	}
+	for _, pattern := range i.Hosts {
+		if utils.MatchHost(pattern, req.URL.Hostname()) {
+			return true
+		}
+	}
+	return false
+}
+
+// interceptors holds the interceptors of a Context. The zero value has none.
+type interceptors struct {
+	mu      sync.RWMutex
+	entries []interceptorEntry // In registration order
+	next    int
+}
+
+type interceptorEntry struct {
+	id int
+	Interceptor
+}
+
+// Intercept registers i for the requests of the client of the context, those
+// of extractors and downloads alike, and returns a function unregistering it.
+// Interceptors run in the order they were registered, on the goroutine
+// sending the request, concurrently for parallel downloads.
+func (c *Context) Intercept(i Interceptor) (remove func()) {
+	c.Client() // Installs the interceptors on a lazily created client
+	s := &c.interceptors
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	id := s.next
+	s.next++
+	s.entries = append(s.entries, interceptorEntry{id: id, Interceptor: i})
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.entries = slices.DeleteFunc(s.entries, func(e interceptorEntry) bool { return e.id == id })
+	}
+}
+
+// matching returns the interceptors applying to req, in registration order.
+func (s *interceptors) matching(req *http.Request) []Interceptor {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var matched []Interceptor
+	for _, e := range s.entries {
+		if e.matches(req) {
+			matched = append(matched, e.Interceptor)
+		}
+	}
+	return matched
+}
+
+// install routes the requests of client through the interceptors.
+func (s *interceptors) install(client *resty.Client) {
+	client.SetTransport(&interceptingTransport{base: client.GetClient().Transport, interceptors: s})
+}
+
+// interceptingTransport runs the interceptors of a Context around the requests
+// sent by base.
+type interceptingTransport struct {
+	base         http.RoundTripper
+	interceptors *interceptors
+}
+
+func (t *interceptingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	matched := t.interceptors.matching(req)
+	if len(matched) == 0 {
+		return t.base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request it is given
+	req = req.Clone(req.Context())
+	for _, i := range matched {
+		if i.Request != nil {
+			if err := i.Request(req); err != nil {
+				if req.Body != nil {
+					req.Body.Close()
+				}
+				return nil, err
+			}
+		}
+	}
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	for _, i := range matched {
+		if i.Response != nil {
+			if err := i.Response(resp); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+	}
+	return resp, nil
+}
@@ -1,12 +1,23 @@
 package grab
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
 	"log/slog"
-	"os"
+	"strings"
 )
 
-// newLogger creates a logger for internal use.
-func newLogger(o Option) *slog.Logger {
+// Defaults of the log file rotation for the options left at zero.
+const (
+	defaultLogMaxSize  = 10 * 1024 * 1024 // 10 MB
+	defaultLogMaxFiles = 3
+)
+
+// newLogger creates a logger for internal use writing to w and, if not nil,
+// to the log file file in the format of LogFormat.
+func newLogger(o Option, w io.Writer, file io.Writer) *slog.Logger {
 	level := slog.LevelWarn
 	if o.Debug {
 		level = slog.LevelDebug
@@ -17,9 +28,83 @@ func newLogger(o Option) *slog.Logger {
 	if o.Silent {
 		level = slog.LevelError
 	}
-	handler := slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
+	handler := slog.Handler(slog.NewTextHandler(w, &slog.HandlerOptions{
 		Level:     level,
 		AddSource: level <= slog.LevelDebug,
-	})
+	}))
+	if file != nil {
+		// The file keeps the details the console leaves out
+		fileOptions := &slog.HandlerOptions{Level: slog.LevelInfo}
+		if o.Debug {
+			fileOptions.Level, fileOptions.AddSource = slog.LevelDebug, true
+		}
+		var fileHandler slog.Handler
+		if o.LogFormat == "json" {
+			fileHandler = slog.NewJSONHandler(file, fileOptions)
+		} else {
+			fileHandler = slog.NewTextHandler(file, fileOptions)
+		}
+		handler = teeHandler{handler, fileHandler}
+	}
 	return slog.New(handler)
 }
+
+// restyLogger passes the messages of the resty client of a Context, such as
+// warnings about its configuration, to the logger of the context.
+type restyLogger struct {
+	c *Context
+}
+
+func (l restyLogger) Errorf(format string, v ...any) {
+	l.c.Logger().Error(restyMessage(format, v))
+}
+
+func (l restyLogger) Warnf(format string, v ...any) {
+	l.c.Logger().Warn(restyMessage(format, v))
+}
+
+func (l restyLogger) Debugf(format string, v ...any) {
+	l.c.Logger().Debug(restyMessage(format, v))
+}
+
+func restyMessage(format string, v []any) string {
+	return strings.TrimSpace(fmt.Sprintf(format, v...))
+}
+
+// teeHandler passes records to each of its handlers enabled for their level.
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range t {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, h := range t {
+		handlers[i] = h.WithGroup(name)
+	}
+	return handlers
+}
@@ -2,38 +2,51 @@ package grab
 
 import (
 	"bytes"
+	"cmp"
+	"context"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"fmt"
+	"hash"
 	"io"
+	"log/slog"
 	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
+	"runtime"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-resty/resty/v2"
 	"github.com/grafov/m3u8"
+	"github.com/hydrz/grab/utils"
 )
 
 // segmentInfo holds information and cached data for a single segment.
 type segmentInfo struct {
-	URI      string
-	Duration float64
-	Key      *m3u8.Key
-	Headers  http.Header
-	Retries  int
-	data     []byte // Cached segment data
-}
-
-// segmentData is used for concurrent segment download coordination.
-type segmentData struct {
-	index int
-	data  []byte
-	err   error
+	URI           string
+	Duration      float64
+	Key           *m3u8.Key
+	Sequence      uint64 // Media sequence number, the IV of keys without one
+	Headers       http.Header
+	Retries       int
+	Discontinuity bool          // Counters and clocks restart with this segment (EXT-X-DISCONTINUITY)
+	offset        int64         // Offset of the segment in the output, set once read
+	size          int64         // Number of bytes of the segment in the output
+	data          []byte        // Prefetched segment data, decrypted
+	spill         string        // File of the prefetched segment, decrypted, when memory was full
+	reserved      int64         // Bytes of the memory budgets held by data
+	claimed       bool          // A prefetch of the segment started, ready is closed when it ends
+	ready         chan struct{} // Closed once the prefetch stored data or spill, or failed
+	taken         bool          // Read moved past this segment, data arriving later is dropped
 }
 
 // m3U8Reader implements concurrent segment downloading with memory pooling.
@@ -41,50 +54,81 @@ type m3U8Reader struct {
 	segments      []*segmentInfo
 	currentIdx    int
 	currentReader io.ReadCloser
-	tempDir       string   // Temporary directory for segment files
-	cleanup       []string // Files to cleanup
+	tempDir       string       // Temporary directory for segment files, removed by Close
+	files         atomic.Int64 // Number of segment files created in tempDir, naming the next one
 	mu            sync.Mutex
 	client        *resty.Client
-	maxRetries    int
-	retryDelay    time.Duration
-
-	// Optimization fields
-	bufferPool   sync.Pool
-	segmentChan  chan *segmentData
-	errorChan    chan error
-	workers      int
-	prefetchSize int
-	closed       bool         // Track if reader is closed
-	closeMu      sync.RWMutex // Protect closed flag
+	retry         RetryPolicy // Retries of segments and keys
+	ctx           context.Context
+	cancel        context.CancelFunc
+	deadline      time.Duration   // Maximum duration of a single segment attempt, 0 means none
+	memory        *utils.Budget   // Budget for prefetched segment data, nil means unlimited
+	buffer        int64           // Bytes of prefetched segments kept in memory, further ones spill to files
+	buffered      int64           // Bytes of prefetched segments held in memory
+	segmentSize   int64           // Estimated segment size, reserved from memory before fetching
+	size          int64           // Estimated size of the segments, smaller than the stream when clipped to a section
+	dataMu        sync.Mutex      // Protects buffered, and the prefetch fields of segments
+	written       int64           // Bytes returned by Read so far
+	ts            *tsChecker      // Continuity of the stream returned by Read
+	transfer      *transfer       // Receives per-segment progress, optional
+	limits        []*utils.Bucket // Global and per-stream rate limits of segment fetches
+	statePath     string          // File recording the segments returned by Read for resume, "" if none
+	state         *segmentState   // Segments returned by Read so far
+	segmentHash   hash.Hash       // Checksum of the current segment as returned by Read
+
+	// Keys by URI, downloaded once for all the segments they encrypt
+	keys        map[string]*keyEntry
+	keysMu      sync.Mutex // Protects keys
+	keyOverride []byte     // Key of all encrypted segments instead of theirs, nil if none (--hls-key)
+	ivOverride  []byte     // IV of all encrypted segments instead of theirs, nil if none (--hls-iv)
+
+	// Segments the server doesn't have that may be left out, and those left out
+	allowMissing int   // (--hls-allow-missing-segments)
+	missing      []int // Indexes of the segments left out, set by Read
+	logger       *slog.Logger
+
+	renditions []rendition // Downloaded along with the segments once they are, see downloadRenditions
+
+	// Prefetching: workers download the segments after Read in parallel,
+	// holding one of the slots of the window per segment until Read takes it
+	workers int
+	window  chan struct{}
+	next    int          // Next segment for the workers, protected by dataMu
+	started bool         // Workers were started by the first Read
+	closed  bool         // Track if reader is closed
+	closeMu sync.RWMutex // Protect closed flag
 }
 
 // processM3U8 handles M3U8 streams with zero-copy optimization and encryption support.
 // Returns a ReadCloser that streams segments on-demand without loading everything into memory.
-func (d *Downloader) processM3U8(stream Stream) (io.ReadCloser, error) {
+// If tempPath is set, the reader records its progress next to it and skips the
+// segments an interrupted download already wrote there. Canceling ctx, e.g. by
+// pausing the download, stops the playlist fetch and the reader.
+func (d *Downloader) processM3U8(ctx context.Context, stream Stream, tempPath string) (io.ReadCloser, error) {
 	if stream.Type != StreamTypeM3u8 {
 		return nil, nil // Not an M3U8 stream
 	}
 
-	playlist, listType, err := d.parsePlaylist(stream)
+	playlist, listType, err := d.parsePlaylist(ctx, stream)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse playlist: %w", err)
 	}
 
 	switch listType {
 	case m3u8.MEDIA:
-		return d.processMediaPlaylist(playlist.(*m3u8.MediaPlaylist), stream)
+		return d.processMediaPlaylist(ctx, playlist.(*m3u8.MediaPlaylist), stream, tempPath)
 	case m3u8.MASTER:
-		return d.processMasterPlaylist(playlist.(*m3u8.MasterPlaylist), stream)
+		return d.processMasterPlaylist(ctx, playlist.(*m3u8.MasterPlaylist), stream, tempPath)
 	default:
 		return nil, fmt.Errorf("unsupported playlist type: %d", listType)
 	}
 }
 
 // parsePlaylist fetches and parses an M3U8 playlist from the given URL.
-func (d *Downloader) parsePlaylist(stream Stream) (m3u8.Playlist, m3u8.ListType, error) {
+func (d *Downloader) parsePlaylist(ctx context.Context, stream Stream) (m3u8.Playlist, m3u8.ListType, error) {
 	playlistURL := stream.URL
 	req := d.ctx.client.R().
-		SetContext(d.ctx.ctx).
+		SetContext(ctx).
 		SetDoNotParseResponse(true)
 	req.Header = stream.Header.Clone()
 
@@ -95,7 +139,7 @@ func (d *Downloader) parsePlaylist(stream Stream) (m3u8.Playlist, m3u8.ListType,
 	defer resp.RawBody().Close()
 
 	if resp.StatusCode() != http.StatusOK {
-		return nil, 0, fmt.Errorf("HTTP error: %s, URL: %s", resp.Status(), playlistURL)
+		return nil, 0, withRetryAfter(fmt.Errorf("HTTP error: %s, URL: %s", resp.Status(), playlistURL), resp.StatusCode(), resp.Header())
 	}
 
 	playlist, listType, err := m3u8.DecodeFrom(resp.RawBody(), true)
@@ -107,21 +151,26 @@ func (d *Downloader) parsePlaylist(stream Stream) (m3u8.Playlist, m3u8.ListType,
 }
 
 // processMediaPlaylist creates an optimized reader for media playlist segments.
-func (d *Downloader) processMediaPlaylist(playlist *m3u8.MediaPlaylist, stream Stream) (io.ReadCloser, error) {
+func (d *Downloader) processMediaPlaylist(ctx context.Context, playlist *m3u8.MediaPlaylist, stream Stream, tempPath string) (io.ReadCloser, error) {
 	baseURL, err := url.Parse(stream.URL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
 
 	segments := make([]*segmentInfo, 0, len(playlist.Segments))
-	var currentKey *m3u8.Key
+	// Keys apply to the segments after them until the next EXT-X-KEY rotates
+	// them, a key of the header to all segments
+	currentKey := resolveKey(baseURL, playlist.Key)
 
-	for _, segment := range playlist.Segments {
+	for i, segment := range playlist.Segments {
 		if segment == nil {
 			continue
 		}
 		if segment.Key != nil {
-			currentKey = segment.Key
+			currentKey = resolveKey(baseURL, segment.Key)
+		}
+		if err := hlsKeyDRM(currentKey); err != nil {
+			return nil, err
 		}
 		segmentURL, err := baseURL.Parse(segment.URI)
 		if err != nil {
@@ -129,10 +178,12 @@ func (d *Downloader) processMediaPlaylist(playlist *m3u8.MediaPlaylist, stream S
 			continue
 		}
 		segments = append(segments, &segmentInfo{
-			URI:      segmentURL.String(),
-			Duration: segment.Duration,
-			Key:      currentKey,
-			Headers:  stream.Header,
+			URI:           segmentURL.String(),
+			Duration:      segment.Duration,
+			Key:           currentKey,
+			Sequence:      playlist.SeqNo + uint64(i),
+			Headers:       stream.Header,
+			Discontinuity: segment.Discontinuity,
 		})
 	}
 
@@ -140,6 +191,50 @@ func (d *Downloader) processMediaPlaylist(playlist *m3u8.MediaPlaylist, stream S
 		return nil, fmt.Errorf("no valid segments found in playlist")
 	}
 
+	// Segment sizes are unknown up front, estimate them from the stream size
+	var segmentSize int64
+	if stream.Size > 0 {
+		segmentSize = stream.Size / int64(len(segments))
+	}
+
+	if d.ctx.option.HLSSkipAds {
+		var skipped int
+		if segments, skipped = skipAdSegments(segments); skipped > 0 {
+			stream.Size = segmentSize * int64(len(segments))
+			d.ctx.logger.Info("Skipping ad segments", "stream", stream.ID, "segments", skipped)
+		}
+	}
+
+	section, clip, err := d.section()
+	if err != nil {
+		return nil, err
+	}
+	if clip {
+		if segments = clipSegments(segments, section); len(segments) == 0 {
+			return nil, fmt.Errorf("no segment of the playlist in section %s", d.ctx.option.DownloadSections)
+		}
+		stream.Size = segmentSize * int64(len(segments))
+		d.ctx.logger.Info("Downloading the segments of the section", "stream", stream.ID, "section", d.ctx.option.DownloadSections, "segments", len(segments))
+	}
+
+	if tempPath != "" {
+		if err := d.checkSpace(tempPath, estimateSize(stream, segments), 0); err != nil {
+			return nil, err
+		}
+	}
+
+	var keyOverride, ivOverride []byte
+	if d.ctx.option.HLSKey != "" {
+		if keyOverride, err = ParseHLSKey(d.ctx.option.HLSKey); err != nil {
+			return nil, fmt.Errorf("invalid HLS key: %w", err)
+		}
+	}
+	if d.ctx.option.HLSIV != "" {
+		if ivOverride, err = ParseHLSKey(d.ctx.option.HLSIV); err != nil {
+			return nil, fmt.Errorf("invalid HLS IV: %w", err)
+		}
+	}
+
 	tempDir, err := os.MkdirTemp("", "grab_m3u8_*")
 	if err != nil {
 		return nil, fmt.Errorf("failed to create temp directory: %w", err)
@@ -149,157 +244,574 @@ func (d *Downloader) processMediaPlaylist(playlist *m3u8.MediaPlaylist, stream S
 	if workers <= 0 {
 		workers = min(4, len(segments))
 	}
-	prefetchSize := min(workers*2, 10)
+	window := max(min(workers*2, 10), workers)
+
+	// Closing the reader stops prefetches still running or waiting for memory
+	readerCtx, cancel := context.WithCancel(ctx)
 
 	reader := &m3U8Reader{
-		segments:     segments,
-		tempDir:      tempDir,
-		cleanup:      make([]string, 0),
-		client:       d.ctx.client,
-		maxRetries:   max(d.ctx.option.RetryCount, 3),
-		retryDelay:   time.Second,
-		workers:      workers,
-		prefetchSize: prefetchSize,
-		segmentChan:  make(chan *segmentData, prefetchSize),
-		errorChan:    make(chan error, 1),
-		bufferPool: sync.Pool{
-			New: func() interface{} {
-				return make([]byte, 0, 1024*1024) // 1MB initial capacity
-			},
-		},
-	}
-
-	reader.startWorkers()
+		segments:    segments,
+		tempDir:     tempDir,
+		client:      d.ctx.client,
+		retry:       segmentRetryPolicy(d.ctx.RetryPolicy()),
+		ctx:         readerCtx,
+		cancel:      cancel,
+		deadline:    d.ctx.option.chunkDeadline(deadlineSegmentSize(stream, segments, segmentSize)),
+		memory:      d.ctx.memory,
+		buffer:      cmp.Or(max(d.ctx.option.HLSBufferSize, 0), defaultHLSBufferSize),
+		limits:      []*utils.Bucket{d.ctx.rateLimiter, utils.NewBucket(d.ctx.option.RateLimitPerStream)},
+		segmentSize: segmentSize,
+		size:        stream.Size,
+		ts:          newTSChecker(),
+		workers:     workers,
+		window:      make(chan struct{}, window),
+		keyOverride: keyOverride,
+		ivOverride:  ivOverride,
+		logger:      d.ctx.logger,
+
+		allowMissing: d.ctx.option.HLSAllowMissingSegments,
+	}
+
+	if tempPath != "" {
+		d.resumeSegments(reader, stream, tempPath)
+	}
 	return reader, nil
 }
 
-// processMasterPlaylist selects the best quality stream from master playlist.
-func (d *Downloader) processMasterPlaylist(playlist *m3u8.MasterPlaylist, stream Stream) (io.ReadCloser, error) {
-	if len(playlist.Variants) == 0 {
-		return nil, fmt.Errorf("no variants found in master playlist")
+// resolveKey returns a copy of key with its URI resolved against the
+// playlist URL base, nil if key is nil.
+func resolveKey(base *url.URL, key *m3u8.Key) *m3u8.Key {
+	if key == nil {
+		return nil
 	}
+	resolved := *key
+	if key.URI != "" {
+		if u, err := base.Parse(key.URI); err == nil {
+			resolved.URI = u.String()
+		}
+	}
+	return &resolved
+}
 
-	var selectedVariant *m3u8.Variant
-	maxBandwidth := uint32(0)
-	for _, variant := range playlist.Variants {
-		if variant != nil && variant.Bandwidth > maxBandwidth {
-			maxBandwidth = variant.Bandwidth
-			selectedVariant = variant
+// estimateSize returns the size of stream, estimated from its bitrate and the
+// duration of segments if unknown, 0 if neither is known.
+func estimateSize(stream Stream, segments []*segmentInfo) int64 {
+	if stream.Size > 0 || stream.Bitrate <= 0 {
+		return stream.Size
+	}
+	var seconds float64
+	for _, segment := range segments {
+		seconds += segment.Duration
+	}
+	return int64(seconds * float64(stream.Bitrate) / 8)
+}
+
+// deadlineSegmentSize returns the size a segment attempt gets time for with
+// Option.MinSpeed: that of the longest segment at the bitrate of stream, else
+// the share of segmentSize, else deadlineSegmentFloor. Most HLS streams tell
+// neither their size nor a bitrate, and a floor keeps their segments bounded.
+func deadlineSegmentSize(stream Stream, segments []*segmentInfo, segmentSize int64) int64 {
+	if stream.Bitrate > 0 {
+		var longest float64
+		for _, segment := range segments {
+			longest = max(longest, segment.Duration)
+		}
+		if size := int64(longest * float64(stream.Bitrate) / 8); size > 0 {
+			return size
 		}
 	}
-	if selectedVariant == nil {
-		return nil, fmt.Errorf("no suitable variant found")
+	if segmentSize > 0 {
+		return segmentSize
+	}
+	return deadlineSegmentFloor
+}
+
+// resumeSegments makes reader record its progress in the segment state of
+// tempPath and start after the segments the state holds, if they are still
+// the first ones of the playlist and intact in tempPath.
+func (d *Downloader) resumeSegments(r *m3U8Reader, stream Stream, tempPath string) {
+	r.statePath = tempPath + stateSuffix
+	r.state = &segmentState{Playlist: withoutQuery(stream.URL)}
+
+	state, err := loadSegmentState(r.statePath)
+	if err != nil || state.Playlist != r.state.Playlist {
+		return
+	}
+	count, offset := state.verified(tempPath, r.segments)
+	if count == 0 {
+		return
+	}
+	for i, entry := range state.Segments[:count] {
+		segment := r.segments[i]
+		segment.offset, segment.size = r.written, entry.Size
+		segment.taken = true
+		r.written += entry.Size
+	}
+	r.state.Segments = state.Segments[:count]
+	r.currentIdx = count
+	d.ctx.logger.Info("Resuming M3U8 download", "stream", stream.ID, "segments", count, "total", len(r.segments), "offset", offset)
+}
+
+// checkpoint records the segment of the given index, fully returned by Read,
+// in the segment state.
+func (r *m3U8Reader) checkpoint(index int) {
+	if r.state == nil {
+		return
+	}
+	r.state.Segments = append(r.state.Segments, segmentEntry{
+		URI:    withoutQuery(r.segments[index].URI),
+		Size:   r.segments[index].size,
+		SHA256: hex.EncodeToString(r.segmentHash.Sum(nil)),
+	})
+	// Losing the state only costs a restart from the first segment
+	r.state.save(r.statePath)
+}
+
+// processMasterPlaylist downloads the variant of a master playlist matching
+// Option.Quality, with the renditions going with it, see variantRenditions.
+func (d *Downloader) processMasterPlaylist(ctx context.Context, playlist *m3u8.MasterPlaylist, stream Stream, tempPath string) (io.ReadCloser, error) {
+	variant, err := selectVariant(playlist, d.ctx.option.Quality)
+	if err != nil {
+		return nil, err
 	}
 
 	baseURL, err := url.Parse(stream.URL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid base URL: %w", err)
 	}
-	variantURL, err := baseURL.Parse(selectedVariant.URI)
+	variantURL, err := baseURL.Parse(variant.URI)
 	if err != nil {
 		return nil, fmt.Errorf("invalid variant URI: %w", err)
 	}
 
 	variantStream := Stream{
-		ID:      stream.ID + "_variant",
-		Title:   stream.Title,
-		Type:    StreamTypeM3u8,
-		URL:     variantURL.String(),
-		Format:  stream.Format,
-		Quality: selectedVariant.Resolution,
-		Header:  stream.Header,
+		ID:     stream.ID + "_variant",
+		Title:  stream.Title,
+		Type:   StreamTypeM3u8,
+		URL:    variantURL.String(),
+		Format: stream.Format,
+		Header: stream.Header,
+	}
+	reader, err := d.processM3U8(ctx, applyVariant(variantStream, variant), tempPath)
+	if r, ok := reader.(*m3U8Reader); ok {
+		r.renditions = variantRenditions(baseURL, stream, variant, d.ctx.option.Subtitle)
+	}
+	return reader, err
+}
+
+// selectVariant returns the variant of playlist matching quality:
+//   - "" or "best": the highest bandwidth
+//   - "worst": the lowest bandwidth
+//   - a resolution such as "1280x720" or a height such as "720p" or "720":
+//     that resolution, else the best variant below it
+//   - a bandwidth cap such as "800k" or "3M": the best variant within it
+//
+// The lowest variant is used when nothing is below a height or cap, the best
+// one for qualities it doesn't understand. I-frame only variants are ignored.
+func selectVariant(playlist *m3u8.MasterPlaylist, quality string) (*m3u8.Variant, error) {
+	variants := sortedVariants(playlist)
+	if len(variants) == 0 {
+		return nil, fmt.Errorf("no variants found in master playlist")
+	}
+	best, worst := variants[0], variants[len(variants)-1]
+
+	q := strings.ToLower(strings.TrimSpace(quality))
+	switch q {
+	case "", "best":
+		return best, nil
+	case "worst":
+		return worst, nil
+	}
+	if width, height, ok := parseResolution(q); ok {
+		for _, v := range variants {
+			if w, h, _ := parseResolution(v.Resolution); h == height && (width == 0 || w == width) {
+				return v, nil
+			}
+		}
+		for _, v := range variants {
+			if _, h, ok := parseResolution(v.Resolution); ok && h <= height {
+				return v, nil
+			}
+		}
+		return worst, nil
+	}
+	if limit, ok := parseBandwidth(q); ok {
+		for _, v := range variants {
+			if int64(v.Bandwidth) <= limit {
+				return v, nil
+			}
+		}
+		return worst, nil
 	}
-	return d.processM3U8(variantStream)
+	return best, nil
 }
 
-// startWorkers launches background goroutines to download segments concurrently.
+// sortedVariants returns the variants of playlist except I-frame only ones, highest bandwidth first.
+func sortedVariants(playlist *m3u8.MasterPlaylist) []*m3u8.Variant {
+	variants := make([]*m3u8.Variant, 0, len(playlist.Variants))
+	for _, v := range playlist.Variants {
+		if v != nil && !v.Iframe {
+			variants = append(variants, v)
+		}
+	}
+	sort.SliceStable(variants, func(i, j int) bool {
+		return variants[i].Bandwidth > variants[j].Bandwidth
+	})
+	return variants
+}
+
+// parseResolution parses "1280x720", "720p" or "720" into width and height,
+// width being 0 when only the height is given.
+func parseResolution(s string) (width, height int, ok bool) {
+	s = strings.ToLower(s)
+	if w, h, found := strings.Cut(s, "x"); found {
+		width, errW := strconv.Atoi(w)
+		height, errH := strconv.Atoi(h)
+		return width, height, errW == nil && errH == nil && height > 0
+	}
+	height, err := strconv.Atoi(strings.TrimSuffix(s, "p"))
+	return 0, height, err == nil && height > 0
+}
+
+// parseBandwidth parses a bandwidth such as "800k", "800kbps" or "3M" into bits per second.
+func parseBandwidth(s string) (int64, bool) {
+	s = strings.TrimSuffix(strings.TrimSuffix(strings.ToLower(s), "bps"), "b")
+	unit := int64(1)
+	switch {
+	case strings.HasSuffix(s, "k"):
+		unit = 1000
+	case strings.HasSuffix(s, "m"):
+		unit = 1000 * 1000
+	default:
+		return 0, false
+	}
+	n, err := strconv.ParseFloat(s[:len(s)-1], 64)
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return int64(n * float64(unit)), true
+}
+
+// applyVariant fills the metadata of stream from the EXT-X-STREAM-INF attributes of variant.
+func applyVariant(stream Stream, variant *m3u8.Variant) Stream {
+	if variant.Resolution != "" {
+		stream.Quality = variant.Resolution
+	}
+	if variant.Codecs != "" {
+		stream.Codec = variant.Codecs
+	}
+	if variant.FrameRate > 0 {
+		stream.FPS = variant.FrameRate
+	}
+	if variant.Bandwidth > 0 {
+		stream.Bitrate = int64(variant.Bandwidth)
+	}
+	for _, alt := range variant.Alternatives {
+		if alt != nil && alt.Type == "AUDIO" && alt.Default && alt.Language != "" && stream.Language == "" {
+			stream.Language = alt.Language
+		}
+	}
+	return stream
+}
+
+// Probe fetches the playlist of an M3U8 stream and fills in the codec, frame rate,
+// bitrate and quality of the variant that would be downloaded. Streams of other
+// types and metadata missing from the playlist are returned unchanged.
+func (d *Downloader) Probe(stream Stream) (Stream, error) {
+	if stream.Type != StreamTypeM3u8 {
+		return stream, nil
+	}
+	playlist, listType, err := d.parsePlaylist(d.ctx.Context(), stream)
+	if err != nil {
+		return stream, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	if listType != m3u8.MASTER {
+		return stream, nil
+	}
+	variant, err := selectVariant(playlist.(*m3u8.MasterPlaylist), d.ctx.option.Quality)
+	if err != nil {
+		return stream, err
+	}
+	return applyVariant(stream, variant), nil
+}
+
+// Variants returns the streams an M3U8 stream offers: one per variant of its
+// master playlist, highest bandwidth first, each downloadable on its own so
+// that a quality can be chosen. Other streams are returned as they are.
+func (d *Downloader) Variants(stream Stream) ([]Stream, error) {
+	if stream.Type != StreamTypeM3u8 {
+		return []Stream{stream}, nil
+	}
+	playlist, listType, err := d.parsePlaylist(d.ctx.Context(), stream)
+	if err != nil {
+		return []Stream{stream}, fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	if listType != m3u8.MASTER {
+		return []Stream{stream}, nil
+	}
+	baseURL, err := url.Parse(stream.URL)
+	if err != nil {
+		return []Stream{stream}, fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	variants := sortedVariants(playlist.(*m3u8.MasterPlaylist))
+	if len(variants) == 0 {
+		return []Stream{stream}, fmt.Errorf("no variants found in master playlist")
+	}
+	streams := make([]Stream, 0, len(variants))
+	for i, variant := range variants {
+		variantURL, err := baseURL.Parse(variant.URI)
+		if err != nil {
+			d.ctx.logger.Warn("Invalid variant URI", "uri", variant.URI, "error", err)
+			continue
+		}
+		s := stream
+		s.ID = fmt.Sprintf("%s_%d", stream.ID, i)
+		s.URL = variantURL.String()
+		s.Size = 0 // The size of the master playlist stream is that of one of its variants
+		streams = append(streams, applyVariant(s, variant))
+	}
+	return streams, nil
+}
+
+// startWorkers launches the workers downloading the segments after those
+// already written. Read calls it first, once the transfer is set.
 func (r *m3U8Reader) startWorkers() {
-	for i := 0; i < r.workers; i++ {
+	r.dataMu.Lock()
+	r.next = r.currentIdx
+	r.dataMu.Unlock()
+	for range r.workers {
 		go r.downloadWorker()
 	}
-	go r.prefetchCoordinator()
 }
 
-// prefetchCoordinator manages which segments to download next.
-// It only handles initial prefetching and then closes the channel.
-func (r *m3U8Reader) prefetchCoordinator() {
-	defer close(r.segmentChan)
-
-	// Only prefetch initial segments, let triggerPrefetch handle the rest
-	for i := 0; i < len(r.segments) && i < r.prefetchSize; i++ {
+// downloadWorker prefetches the next segment whenever the window has room,
+// so that segments download in parallel while Read writes them in order. A
+// failed prefetch is left to Read, which downloads the segment again.
+func (r *m3U8Reader) downloadWorker() {
+	for {
 		select {
-		case r.segmentChan <- &segmentData{index: i}:
-		case <-r.errorChan:
+		case r.window <- struct{}{}:
+		case <-r.ctx.Done():
+			return
+		}
+		// Don't start new segments while the transfer is paused
+		if err := r.transfer.wait(r.ctx); err != nil {
 			return
 		}
+		segment := r.nextSegment()
+		if segment == nil {
+			return
+		}
+		r.prefetchSegment(segment)
 	}
 }
 
-// downloadWorker downloads segments concurrently.
-func (r *m3U8Reader) downloadWorker() {
-	for segData := range r.segmentChan {
-		if segData.index >= len(r.segments) {
-			continue
+// nextSegment claims the next segment Read didn't reach yet, nil when all are.
+func (r *m3U8Reader) nextSegment() *segmentInfo {
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+	for ; r.next < len(r.segments); r.next++ {
+		segment := r.segments[r.next]
+		if !segment.claimed && !segment.taken {
+			segment.claimed = true
+			segment.ready = make(chan struct{})
+			r.next++
+			return segment
 		}
-		segment := r.segments[segData.index]
-		data, err := r.downloadSegmentToMemory(segment)
-		if err != nil {
-			segData.err = err
-			select {
-			case r.errorChan <- err:
-			default:
-			}
-			continue
+	}
+	return nil
+}
+
+// defaultSegmentSize is reserved from the memory budget for segments of unknown size.
+const defaultSegmentSize = 1024 * 1024
+
+// deadlineSegmentFloor is the size segment attempts get time for with
+// Option.MinSpeed when neither the stream size nor its bitrate is known, that
+// of about 10 seconds of 6 Mbit/s video.
+const deadlineSegmentFloor = 8 << 20
+
+// defaultHLSBufferSize is the memory prefetched segments of a stream may hold
+// when Option.HLSBufferSize is 0.
+const defaultHLSBufferSize = 64 << 20
+
+// reserve takes n bytes from the buffer of the reader and the memory budget of
+// the context, and reports whether it could without waiting. A segment larger
+// than the buffer fits when nothing else is buffered.
+func (r *m3U8Reader) reserve(n int64) bool {
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+	if r.buffered > 0 && r.buffered+n > r.buffer {
+		return false
+	}
+	if !r.memory.TryAcquire(n) {
+		return false
+	}
+	r.buffered += n
+	return true
+}
+
+// release returns n bytes taken with reserve.
+func (r *m3U8Reader) release(n int64) {
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+	r.buffered = max(0, r.buffered-n)
+	r.memory.Release(n)
+}
+
+// prefetchSegment downloads a segment claimed by nextSegment for Read, into memory if its
+// expected size fits the buffer and memory budget, otherwise into a file, so
+// that prefetching never waits for Read to free memory. A segment turning out
+// larger than expected is kept even if its excess doesn't fit.
+func (r *m3U8Reader) prefetchSegment(segment *segmentInfo) error {
+	defer close(segment.ready)
+	reserved := r.segmentSize
+	if reserved <= 0 {
+		reserved = defaultSegmentSize
+	}
+	if !r.reserve(reserved) {
+		return r.spillSegment(segment)
+	}
+	data, err := r.downloadSegmentToMemory(segment)
+	if err != nil {
+		r.release(reserved)
+		return err
+	}
+	if size := int64(len(data)); size > reserved {
+		if r.reserve(size - reserved) {
+			reserved = size
 		}
-		segData.data = data
-		r.segments[segData.index].data = data
+	} else {
+		r.release(reserved - size)
+		reserved = size
+	}
+	r.storeSegment(segment, data, reserved)
+	return nil
+}
+
+// spillSegment downloads segment into a file of the temporary directory,
+// which Read removes once it read it.
+func (r *m3U8Reader) spillSegment(segment *segmentInfo) error {
+	path, err := r.saveSegment(segment)
+	if err != nil {
+		return err
+	}
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+	if segment.taken {
+		os.Remove(path)
+		return nil
+	}
+	segment.spill = path
+	return nil
+}
+
+// segmentFile returns the path of a new segment file in the temporary directory.
+func (r *m3U8Reader) segmentFile() string {
+	return filepath.Join(r.tempDir, fmt.Sprintf("segment_%d.ts", r.files.Add(1)))
+}
+
+// storeSegment keeps prefetched data for Read. Data arriving after Read moved
+// past the segment is dropped along with its memory reservation.
+func (r *m3U8Reader) storeSegment(segment *segmentInfo, data []byte, reserved int64) {
+	r.dataMu.Lock()
+	if !segment.taken {
+		segment.data = data
+		segment.reserved = reserved
+		r.dataMu.Unlock()
+		return
 	}
+	r.dataMu.Unlock()
+	r.release(reserved)
+}
+
+// takeSegment marks a segment as read and returns its prefetched data or the
+// file holding it, waiting for a prefetch in progress, along with the memory
+// reservation the caller must release when done. Both are empty if the
+// segment wasn't prefetched or its prefetch failed. Taking a prefetched
+// segment frees its slot of the window for the workers.
+func (r *m3U8Reader) takeSegment(segment *segmentInfo) ([]byte, string, int64, error) {
+	r.dataMu.Lock()
+	if !segment.claimed {
+		segment.taken = true
+		r.dataMu.Unlock()
+		return nil, "", 0, nil
+	}
+	ready := segment.ready
+	r.dataMu.Unlock()
+
+	select {
+	case <-ready:
+	case <-r.ctx.Done():
+		return nil, "", 0, r.ctx.Err()
+	}
+	<-r.window
+	r.dataMu.Lock()
+	defer r.dataMu.Unlock()
+	segment.taken = true
+	data, spill, reserved := segment.data, segment.spill, segment.reserved
+	segment.data, segment.spill, segment.reserved = nil, "", 0
+	return data, spill, reserved, nil
 }
 
 // downloadSegmentToMemory downloads a segment directly to memory with optimizations.
 func (r *m3U8Reader) downloadSegmentToMemory(segment *segmentInfo) ([]byte, error) {
-	var lastErr error
-	for attempt := 0; attempt < r.maxRetries; attempt++ {
-		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * r.retryDelay)
-		}
-		data, err := r.fetchSegmentData(segment)
-		if err == nil {
-			return data, nil
+	var data []byte
+	attempts, err := r.withRetry(func() (err error) {
+		if data, err = r.fetchSegmentData(segment); err != nil {
+			segment.Retries++
 		}
-		lastErr = err
-		segment.Retries++
-		if isNonRetryableError(err) {
-			break
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download segment after %d attempts: %w", attempts, err)
+	}
+	return data, nil
+}
+
+// segmentRetryPolicy returns the retry policy of segments and keys: cheap to
+// retry, they get at least three attempts.
+func segmentRetryPolicy(p RetryPolicy) RetryPolicy {
+	p.Attempts = max(p.Attempts, 3)
+	return p
+}
+
+// withRetry calls fn until it succeeds, fails with a non-retryable error or
+// the retry policy gives up, and returns the number of attempts.
+func (r *m3U8Reader) withRetry(fn func() error) (int, error) {
+	start := time.Now()
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || isNonRetryableError(err) || attempt >= r.retry.Attempts ||
+			!r.retry.wait(r.ctx, start, r.retry.Delay(attempt, err)) {
+			return attempt, err
 		}
 	}
-	return nil, fmt.Errorf("failed to download segment after %d attempts: %w", r.maxRetries, lastErr)
 }
 
 // fetchSegmentData downloads segment data directly to memory.
 func (r *m3U8Reader) fetchSegmentData(segment *segmentInfo) ([]byte, error) {
-	req := r.client.R().
-		SetDoNotParseResponse(true)
-	if segment.Headers != nil {
-		req.Header = segment.Headers.Clone()
-	}
+	ctx, cancel := withChunkDeadline(r.ctx, r.deadline)
+	defer cancel()
+
+	req := newMediaRequest(ctx, r.client, segment.Headers)
 
 	resp, err := req.Get(segment.URI)
 	if err != nil {
-		return nil, fmt.Errorf("request failed: %w", err)
+		return nil, fmt.Errorf("request failed: %w", chunkDeadlineError(ctx, err))
 	}
 	defer resp.RawBody().Close()
 	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error: %s", resp.Status())
+		return nil, httpStatusError(resp)
 	}
 
-	data, err := io.ReadAll(resp.RawBody())
+	data, err := io.ReadAll(utils.NewSharedRateLimiter(resp.RawBody(), r.limits...).WithContext(ctx))
 	if err != nil {
-		return nil, fmt.Errorf("failed to read segment data: %w", err)
+		return nil, fmt.Errorf("failed to read segment data: %w", chunkDeadlineError(ctx, err))
 	}
 
 	if segment.Key != nil && segment.Key.Method == "AES-128" {
-		decrypted, err := r.decryptSegmentData(data, segment.Key)
+		decrypted, err := r.decryptSegmentData(data, segment)
 		if err != nil {
 			return nil, fmt.Errorf("failed to decrypt segment: %w", err)
 		}
@@ -309,273 +821,380 @@ func (r *m3U8Reader) fetchSegmentData(segment *segmentInfo) ([]byte, error) {
 }
 
 // decryptSegmentData decrypts segment data in memory.
-func (r *m3U8Reader) decryptSegmentData(data []byte, key *m3u8.Key) ([]byte, error) {
-	keyData, err := r.downloadKeyWithRetry(key.URI)
+func (r *m3U8Reader) decryptSegmentData(data []byte, segment *segmentInfo) ([]byte, error) {
+	decryptor, err := r.newDecrypter(segment)
 	if err != nil {
-		return nil, fmt.Errorf("failed to download encryption key: %w", err)
+		return nil, err
+	}
+	if len(data)%aes.BlockSize != 0 {
+		return nil, fmt.Errorf("data length not aligned to block size")
+	}
+	decrypted := make([]byte, len(data))
+	decoders <- struct{}{}
+	decryptor.CryptBlocks(decrypted, data)
+	<-decoders
+	return removePKCS7Padding(decrypted), nil
+}
+
+// newDecrypter creates an AES-128-CBC decrypter for the key of segment. Each
+// segment gets its own, so nothing carries over a discontinuity.
+func (r *m3U8Reader) newDecrypter(segment *segmentInfo) (cipher.BlockMode, error) {
+	key := segment.Key
+	keyData := r.keyOverride
+	if keyData == nil {
+		var err error
+		if keyData, err = r.segmentKey(key.URI); err != nil {
+			return nil, fmt.Errorf("failed to download encryption key: %w", err)
+		}
 	}
 	block, err := aes.NewCipher(keyData)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
 	}
-	iv := make([]byte, aes.BlockSize)
-	if key.IV != "" {
-		ivStr := strings.TrimPrefix(key.IV, "0x")
-		if len(ivStr) != 32 {
-			return nil, fmt.Errorf("invalid IV length: %d", len(ivStr))
-		}
-		for i := 0; i < 16; i++ {
-			b, err := strconv.ParseUint(ivStr[i*2:(i+1)*2], 16, 8)
-			if err != nil {
-				return nil, fmt.Errorf("invalid IV format: %w", err)
-			}
-			iv[i] = byte(b)
+	iv := r.ivOverride
+	switch {
+	case iv != nil:
+	case key.IV != "":
+		if iv, err = ParseHLSKey(key.IV); err != nil {
+			return nil, fmt.Errorf("invalid IV: %w", err)
 		}
+	default:
+		// Without an IV attribute, the IV is the media sequence number of the segment
+		iv = make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], segment.Sequence)
 	}
-	decryptor := cipher.NewCBCDecrypter(block, iv)
-	if len(data)%aes.BlockSize != 0 {
-		return nil, fmt.Errorf("data length not aligned to block size")
+	return cipher.NewCBCDecrypter(block, iv), nil
+}
+
+// ParseHLSKey parses an AES-128 key or IV of HLS written as 32 hexadecimal
+// digits, optionally prefixed with 0x like the IV attribute of EXT-X-KEY.
+func ParseHLSKey(s string) ([]byte, error) {
+	digits := strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+	if len(digits) != 2*aes.BlockSize {
+		return nil, fmt.Errorf("%q is not 32 hexadecimal digits", s)
 	}
-	decrypted := make([]byte, len(data))
-	decryptor.CryptBlocks(decrypted, data)
-	return removePKCS7Padding(decrypted), nil
+	b, err := hex.DecodeString(digits)
+	if err != nil {
+		return nil, fmt.Errorf("%q is not 32 hexadecimal digits", s)
+	}
+	return b, nil
 }
 
 // Read implements io.Reader with zero-copy segment streaming.
 func (r *m3U8Reader) Read(p []byte) (n int, err error) {
 	r.mu.Lock()
 	defer r.mu.Unlock()
+	if !r.started {
+		r.started = true
+		r.startWorkers()
+	}
 	for {
 		if r.currentReader != nil {
 			n, err = r.currentReader.Read(p)
-			if err == nil || (err != io.EOF && n > 0) {
+			if n > 0 {
+				r.ts.Write(p[:n])
+				if r.segmentHash != nil {
+					r.segmentHash.Write(p[:n])
+				}
+				r.written += int64(n)
+				r.segments[r.currentIdx-1].size += int64(n)
+				r.transfer.partAdd(r.currentIdx-1, int64(n))
+				if err == io.EOF {
+					// The next call moves on to the following segment
+					err = nil
+				}
+			}
+			if err != io.EOF {
 				return n, err
 			}
 			r.currentReader.Close()
 			r.currentReader = nil
+			r.transfer.partComplete(r.currentIdx-1, r.segments[r.currentIdx-1].Retries)
+			r.checkpoint(r.currentIdx - 1)
 		}
 		if r.currentIdx >= len(r.segments) {
+			r.ts.finish()
 			return 0, io.EOF
 		}
 		segment := r.segments[r.currentIdx]
+		segment.offset = r.written
+		if r.state != nil {
+			r.segmentHash = sha256.New()
+		}
+		r.ts.startSegment(r.currentIdx, segment.Discontinuity)
 		r.currentIdx++
+		data, spill, reserved, err := r.takeSegment(segment)
+		if err != nil {
+			return 0, err
+		}
 		var reader io.ReadCloser
-		if segment.data != nil {
-			reader = io.NopCloser(bytes.NewReader(segment.data))
-		} else {
-			reader, err = r.openSegmentWithRetry(segment)
-			if err != nil {
+		switch {
+		case data != nil:
+			reader = &budgetReader{Reader: bytes.NewReader(data), release: r.release, reserved: reserved}
+		case spill != "":
+			if reader, err = r.openSegmentFile(spill); err != nil {
 				return 0, fmt.Errorf("failed to open segment %d: %w", r.currentIdx-1, err)
 			}
-		}
-		r.currentReader = reader
-		r.triggerPrefetch()
-	}
-}
-
-// triggerPrefetch requests downloading of upcoming segments.
-// Uses a separate mechanism to avoid sending to closed channels.
-func (r *m3U8Reader) triggerPrefetch() {
-	start := r.currentIdx
-	end := min(start+r.prefetchSize, len(r.segments))
-
-	go func() {
-		for i := start; i < end; i++ {
-			if r.segments[i].data == nil {
-				// Check if reader is closed before proceeding
-				r.closeMu.RLock()
-				if r.closed {
-					r.closeMu.RUnlock()
-					return
+		default:
+			if reader, err = r.openSegmentWithRetry(segment); err != nil {
+				if !r.skipMissing(r.currentIdx-1, err) {
+					return 0, fmt.Errorf("failed to open segment %d: %w", r.currentIdx-1, err)
 				}
-				r.closeMu.RUnlock()
-
-				// Start downloading this segment directly instead of using channel
-				go r.downloadSegmentAsync(i)
+				reader = io.NopCloser(bytes.NewReader(nil))
 			}
 		}
-	}()
+		r.currentReader = reader
+	}
 }
 
-// downloadSegmentAsync downloads a single segment asynchronously.
-// This replaces the channel-based approach for on-demand prefetching.
-func (r *m3U8Reader) downloadSegmentAsync(index int) {
-	// Double-check bounds and closure status
-	if index >= len(r.segments) {
-		return
+// skipMissing reports whether segment index, which failed with err, is left
+// out of the output: the server doesn't have it (404 or 410) and fewer than
+// allowMissing segments are missing so far. The next segment is marked as a
+// discontinuity, its timestamps don't follow those before the gap.
+func (r *m3U8Reader) skipMissing(index int, err error) bool {
+	status := errorStatus(err)
+	if status != http.StatusNotFound && status != http.StatusGone || len(r.missing) >= r.allowMissing {
+		return false
 	}
-
-	r.closeMu.RLock()
-	if r.closed {
-		r.closeMu.RUnlock()
-		return
+	r.missing = append(r.missing, index)
+	var at float64
+	for _, segment := range r.segments[:index] {
+		at += segment.Duration
 	}
-	r.closeMu.RUnlock()
-
 	segment := r.segments[index]
-
-	// Check if already downloaded (race condition protection)
-	if segment.data != nil {
-		return
+	r.logger.Warn("HLS segment missing, leaving a gap", "segment", index,
+		"at", time.Duration(at*float64(time.Second)).Round(time.Second), "duration", segment.Duration, "uri", segment.URI)
+	if index+1 < len(r.segments) {
+		r.segments[index+1].Discontinuity = true
 	}
-
-	data, err := r.downloadSegmentToMemory(segment)
-	if err != nil {
-		// Log error but don't fail the entire download
-		select {
-		case r.errorChan <- err:
-		default:
-		}
-		return
-	}
-
-	// Store the downloaded data
-	r.segments[index].data = data
+	return true
 }
 
 // openSegmentWithRetry opens a segment with retry logic.
 func (r *m3U8Reader) openSegmentWithRetry(segment *segmentInfo) (io.ReadCloser, error) {
-	var lastErr error
-	for attempt := 0; attempt < r.maxRetries; attempt++ {
-		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * r.retryDelay)
-		}
-		reader, err := r.openSegment(segment)
-		if err == nil {
-			return reader, nil
-		}
-		lastErr = err
-		segment.Retries++
-		if isNonRetryableError(err) {
-			break
+	var reader io.ReadCloser
+	attempts, err := r.withRetry(func() (err error) {
+		if reader, err = r.openSegment(segment); err != nil {
+			segment.Retries++
 		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open segment after %d attempts: %w", attempts, err)
 	}
-	return nil, fmt.Errorf("failed to open segment after %d attempts: %w", r.maxRetries, lastErr)
+	return reader, nil
 }
 
-// openSegment opens and optionally decrypts a segment with zero-copy approach.
+// openSegment downloads a segment into a file and opens it like openSegmentFile.
 func (r *m3U8Reader) openSegment(segment *segmentInfo) (io.ReadCloser, error) {
-	tempFile := filepath.Join(r.tempDir, fmt.Sprintf("segment_%d.ts", len(r.cleanup)))
-	r.cleanup = append(r.cleanup, tempFile)
-	if err := r.downloadSegmentWithRetry(segment.URI, tempFile, segment.Headers); err != nil {
-		return nil, fmt.Errorf("failed to download segment: %w", err)
+	path, err := r.saveSegment(segment)
+	if err != nil {
+		return nil, err
+	}
+	return r.openSegmentFile(path)
+}
+
+// saveSegment downloads segment into a new file of the temporary directory,
+// decrypted, and returns its path.
+func (r *m3U8Reader) saveSegment(segment *segmentInfo) (string, error) {
+	path := r.segmentFile()
+	err := r.downloadSegmentWithRetry(segment.URI, path, segment.Headers)
+	if err == nil && segment.Key != nil && segment.Key.Method == "AES-128" {
+		if err = r.decryptSegmentFile(path, segment); err != nil {
+			err = fmt.Errorf("failed to decrypt segment: %w", err)
+		}
 	}
-	file, err := os.Open(tempFile)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open segment file: %w", err)
+		os.Remove(path)
+		return "", err
 	}
-	if segment.Key != nil && segment.Key.Method == "AES-128" {
-		return r.createDecryptedReader(file, segment.Key)
+	return path, nil
+}
+
+// openSegmentFile opens the segment file at path, written by saveSegment. The
+// file is removed when the reader is closed.
+func (r *m3U8Reader) openSegmentFile(path string) (io.ReadCloser, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("failed to open segment file: %w", err)
 	}
-	return file, nil
+	return &removingReader{ReadCloser: file, path: path}, nil
 }
 
 // downloadSegmentWithRetry downloads a segment with retry logic.
 func (r *m3U8Reader) downloadSegmentWithRetry(segmentURL, outputPath string, headers http.Header) error {
-	var lastErr error
-	for attempt := 0; attempt < r.maxRetries; attempt++ {
-		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * r.retryDelay)
-		}
-		err := r.downloadSegment(segmentURL, outputPath, headers)
-		if err == nil {
-			return nil
-		}
-		lastErr = err
-		if isNonRetryableError(err) {
-			break
-		}
+	attempts, err := r.withRetry(func() error {
+		return r.downloadSegment(segmentURL, outputPath, headers)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download segment after %d attempts: %w", attempts, err)
 	}
-	return fmt.Errorf("failed to download segment after %d attempts: %w", r.maxRetries, lastErr)
+	return nil
 }
 
 // downloadSegment downloads a segment to local file with zero-copy optimization.
 func (r *m3U8Reader) downloadSegment(segmentURL, outputPath string, headers http.Header) error {
-	req := r.client.R().
-		SetDoNotParseResponse(true)
-	if headers != nil {
-		req.Header = headers.Clone()
-	}
+	ctx, cancel := withChunkDeadline(r.ctx, r.deadline)
+	defer cancel()
+
+	req := newMediaRequest(ctx, r.client, headers)
 
 	resp, err := req.Get(segmentURL)
 	if err != nil {
-		return fmt.Errorf("request failed: %w", err)
+		return fmt.Errorf("request failed: %w", chunkDeadlineError(ctx, err))
 	}
 	defer resp.RawBody().Close()
 	if resp.StatusCode() != http.StatusOK {
-		return fmt.Errorf("HTTP error: %s", resp.Status())
+		return httpStatusError(resp)
 	}
 	file, err := os.Create(outputPath)
 	if err != nil {
 		return fmt.Errorf("failed to create file: %w", err)
 	}
 	defer file.Close()
-	_, err = io.Copy(file, resp.RawBody())
+	_, err = io.Copy(file, utils.NewSharedRateLimiter(resp.RawBody(), r.limits...).WithContext(ctx))
 	if err != nil {
-		return fmt.Errorf("failed to write segment: %w", err)
+		return fmt.Errorf("failed to write segment: %w", chunkDeadlineError(ctx, err))
 	}
 	return nil
 }
 
-// createDecryptedReader creates a reader that decrypts AES-128 encrypted segments.
-func (r *m3U8Reader) createDecryptedReader(file *os.File, key *m3u8.Key) (io.ReadCloser, error) {
-	keyData, err := r.downloadKeyWithRetry(key.URI)
+// decoders bounds the CPU-bound decryption of segments, by all readers, to
+// the number of CPUs.
+var decoders = make(chan struct{}, runtime.NumCPU())
+
+// decryptSegmentFile decrypts the AES-128 encrypted segment file at path in
+// place, so that prefetched segments spilled to files are decrypted by the
+// workers like those kept in memory.
+func (r *m3U8Reader) decryptSegmentFile(path string, segment *segmentInfo) (err error) {
+	decryptor, err := r.newDecrypter(segment)
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to download encryption key: %w", err)
+		return err
 	}
-	block, err := aes.NewCipher(keyData)
+	file, err := os.OpenFile(path, os.O_RDWR, 0)
 	if err != nil {
-		file.Close()
-		return nil, fmt.Errorf("failed to create AES cipher: %w", err)
+		return err
 	}
-	iv := make([]byte, aes.BlockSize)
-	if key.IV != "" {
-		ivStr := strings.TrimPrefix(key.IV, "0x")
-		if len(ivStr) != 32 {
-			file.Close()
-			return nil, fmt.Errorf("invalid IV length: %d", len(ivStr))
-		}
-		for i := 0; i < 16; i++ {
-			b, err := strconv.ParseUint(ivStr[i*2:(i+1)*2], 16, 8)
-			if err != nil {
-				file.Close()
-				return nil, fmt.Errorf("invalid IV format: %w", err)
-			}
-			iv[i] = byte(b)
+	defer func() {
+		if closeErr := file.Close(); err == nil {
+			err = closeErr
 		}
+	}()
+	// Decrypted bytes are written behind those read, and the padding removed
+	w := io.NewOffsetWriter(file, 0)
+	if err := r.decryptStream(w, file, decryptor); err != nil {
+		return err
 	}
-	decryptor := cipher.NewCBCDecrypter(block, iv)
-	return &decryptedReader{
-		file:      file,
-		decryptor: decryptor,
-		buffer:    make([]byte, aes.BlockSize),
-	}, nil
+	size, _ := w.Seek(0, io.SeekCurrent)
+	return file.Truncate(size)
 }
 
-// downloadKeyWithRetry downloads the encryption key with retry logic.
-func (r *m3U8Reader) downloadKeyWithRetry(keyURL string) ([]byte, error) {
-	var lastErr error
-	for attempt := 0; attempt < r.maxRetries; attempt++ {
-		if attempt > 0 {
-			time.Sleep(time.Duration(attempt) * r.retryDelay)
+// decryptBatchSize is the number of bytes decrypted at once by decryptStream.
+const decryptBatchSize = 256 * 1024
+
+// decryptStream decrypts src into dst in batches, holding back the last block
+// until the end of src to remove its PKCS7 padding.
+func (r *m3U8Reader) decryptStream(dst io.Writer, src io.Reader, decryptor cipher.BlockMode) error {
+	if err := r.memory.Acquire(r.ctx, decryptBatchSize); err != nil {
+		return err
+	}
+	defer r.memory.Release(decryptBatchSize)
+	buf := make([]byte, decryptBatchSize)
+	var last [aes.BlockSize]byte
+	hasLast := false
+	for {
+		n, err := io.ReadFull(src, buf)
+		if n%aes.BlockSize != 0 {
+			return fmt.Errorf("data length not aligned to block size")
 		}
-		keyData, err := r.downloadKey(keyURL)
-		if err == nil {
-			return keyData, nil
+		if n > 0 {
+			decoders <- struct{}{}
+			decryptor.CryptBlocks(buf[:n], buf[:n])
+			<-decoders
+
+			if hasLast {
+				if _, werr := dst.Write(last[:]); werr != nil {
+					return werr
+				}
+			}
+			if _, werr := dst.Write(buf[:n-aes.BlockSize]); werr != nil {
+				return werr
+			}
+			copy(last[:], buf[n-aes.BlockSize:n])
+			hasLast = true
 		}
-		lastErr = err
-		if isNonRetryableError(err) {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
 			break
 		}
+		if err != nil {
+			return err
+		}
+	}
+	if hasLast {
+		if _, err := dst.Write(removePKCS7Padding(last[:])); err != nil {
+			return err
+		}
 	}
-	return nil, fmt.Errorf("failed to download key after %d attempts: %w", r.maxRetries, lastErr)
+	return nil
+}
+
+// keyEntry is a key of the key cache of a reader, ready once downloaded.
+type keyEntry struct {
+	ready chan struct{}
+	data  []byte
+	err   error
+}
+
+// segmentKey returns the key at keyURL, downloading it once for all the
+// segments encrypted with it. Concurrent callers wait for the same download,
+// and share its error; the next caller downloads a failed key again.
+func (r *m3U8Reader) segmentKey(keyURL string) ([]byte, error) {
+	r.keysMu.Lock()
+	if r.keys == nil {
+		r.keys = make(map[string]*keyEntry)
+	}
+	entry, ok := r.keys[keyURL]
+	if !ok {
+		entry = &keyEntry{ready: make(chan struct{})}
+		r.keys[keyURL] = entry
+	}
+	r.keysMu.Unlock()
+
+	if ok {
+		select {
+		case <-entry.ready:
+			return entry.data, entry.err
+		case <-r.ctx.Done():
+			return nil, r.ctx.Err()
+		}
+	}
+	entry.data, entry.err = r.downloadKeyWithRetry(keyURL)
+	if entry.err != nil {
+		r.keysMu.Lock()
+		delete(r.keys, keyURL)
+		r.keysMu.Unlock()
+	}
+	close(entry.ready)
+	return entry.data, entry.err
+}
+
+// downloadKeyWithRetry downloads the encryption key with retry logic.
+func (r *m3U8Reader) downloadKeyWithRetry(keyURL string) ([]byte, error) {
+	var keyData []byte
+	attempts, err := r.withRetry(func() (err error) {
+		keyData, err = r.downloadKey(keyURL)
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download key after %d attempts: %w", attempts, err)
+	}
+	return keyData, nil
 }
 
 // downloadKey downloads the encryption key for AES decryption.
 func (r *m3U8Reader) downloadKey(keyURL string) ([]byte, error) {
-	req := r.client.R().
-		SetDoNotParseResponse(true)
+	req := newMediaRequest(r.ctx, r.client, nil)
 
 	resp, err := req.Get(keyURL)
 	if err != nil {
@@ -583,7 +1202,7 @@ func (r *m3U8Reader) downloadKey(keyURL string) ([]byte, error) {
 	}
 	defer resp.RawBody().Close()
 	if resp.StatusCode() != http.StatusOK {
-		return nil, fmt.Errorf("HTTP error downloading key: %s", resp.Status())
+		return nil, withRetryAfter(fmt.Errorf("HTTP error downloading key: %s", resp.Status()), resp.StatusCode(), resp.Header())
 	}
 	keyData, err := io.ReadAll(resp.RawBody())
 	if err != nil {
@@ -595,6 +1214,69 @@ func (r *m3U8Reader) downloadKey(keyURL string) ([]byte, error) {
 	return keyData, nil
 }
 
+// repairSegments downloads the given segments again and rewrites path, the
+// complete output of Read, with their fresh data in place of the damaged bytes.
+// It returns the checker of the rewritten stream to tell what is still damaged.
+func (r *m3U8Reader) repairSegments(path string, indexes []int) (*tsChecker, error) {
+	refetch := make(map[int]bool, len(indexes))
+	for _, index := range indexes {
+		refetch[index] = true
+	}
+
+	src, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer src.Close()
+	repairPath := path + ".repair"
+	dst, err := os.Create(repairPath)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(repairPath)
+	defer dst.Close()
+
+	checker := newTSChecker()
+	w := io.MultiWriter(dst, checker)
+	sizes := make([]int64, len(r.segments))
+	for i, segment := range r.segments {
+		checker.startSegment(i, segment.Discontinuity)
+		if !refetch[i] {
+			n, err := io.Copy(w, io.NewSectionReader(src, segment.offset, segment.size))
+			if err != nil {
+				return nil, fmt.Errorf("failed to copy segment %d: %w", i, err)
+			}
+			sizes[i] = n
+			continue
+		}
+		data, err := r.downloadSegmentToMemory(segment)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download segment %d again: %w", i, err)
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, fmt.Errorf("failed to write segment %d: %w", i, err)
+		}
+		sizes[i] = int64(len(data))
+	}
+	checker.finish()
+
+	if err := dst.Close(); err != nil {
+		return nil, err
+	}
+	src.Close()
+	if err := os.Rename(repairPath, path); err != nil {
+		return nil, fmt.Errorf("failed to replace repaired file: %w", err)
+	}
+
+	// Keep the segment layout in line with the rewritten file
+	var offset int64
+	for i, segment := range r.segments {
+		segment.offset, segment.size = offset, sizes[i]
+		offset += sizes[i]
+	}
+	return checker, nil
+}
+
 // Close cleans up resources and temporary files.
 // Enhanced to properly signal shutdown to all goroutines.
 func (r *m3U8Reader) Close() error {
@@ -605,10 +1287,22 @@ func (r *m3U8Reader) Close() error {
 	}
 	r.closed = true
 	r.closeMu.Unlock()
+	r.cancel()
 
 	r.mu.Lock()
 	defer r.mu.Unlock()
 
+	// Return the memory of prefetched segments that were never read, their
+	// files go with the temporary directory
+	r.dataMu.Lock()
+	for _, segment := range r.segments {
+		r.memory.Release(segment.reserved)
+		r.buffered -= segment.reserved
+		segment.data, segment.spill, segment.reserved = nil, "", 0
+		segment.taken = true
+	}
+	r.dataMu.Unlock()
+
 	var lastErr error
 	if r.currentReader != nil {
 		if err := r.currentReader.Close(); err != nil {
@@ -617,18 +1311,7 @@ func (r *m3U8Reader) Close() error {
 		r.currentReader = nil
 	}
 
-	// Signal shutdown to all workers and prefetch goroutines
-	select {
-	case r.errorChan <- fmt.Errorf("reader closed"):
-	default:
-	}
-
 	// Clean up temporary files
-	for _, file := range r.cleanup {
-		if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
-			lastErr = err
-		}
-	}
 	if r.tempDir != "" {
 		if err := os.RemoveAll(r.tempDir); err != nil && !os.IsNotExist(err) {
 			lastErr = err
@@ -637,52 +1320,31 @@ func (r *m3U8Reader) Close() error {
 	return lastErr
 }
 
-// decryptedReader wraps a file reader with AES-CBC decryption.
-type decryptedReader struct {
-	file      *os.File
-	decryptor cipher.BlockMode
-	buffer    []byte
-	remainder []byte
+// budgetReader reads a prefetched segment and releases its memory reservation on Close.
+type budgetReader struct {
+	*bytes.Reader
+	release  func(int64)
+	reserved int64
 }
 
-// Read decrypts data on-the-fly using AES-CBC.
-func (dr *decryptedReader) Read(p []byte) (n int, err error) {
-	if len(dr.remainder) > 0 {
-		n = copy(p, dr.remainder)
-		dr.remainder = dr.remainder[n:]
-		if len(dr.remainder) == 0 {
-			dr.remainder = nil
-		}
-		return n, nil
-	}
-	blockBuf := make([]byte, ((len(p)/aes.BlockSize)+1)*aes.BlockSize)
-	readBytes, err := dr.file.Read(blockBuf)
-	if err != nil && err != io.EOF {
-		return 0, err
-	}
-	if readBytes == 0 {
-		return 0, io.EOF
-	}
-	completeBlocks := (readBytes / aes.BlockSize) * aes.BlockSize
-	if completeBlocks > 0 {
-		decrypted := make([]byte, completeBlocks)
-		for i := 0; i < completeBlocks; i += aes.BlockSize {
-			dr.decryptor.CryptBlocks(decrypted[i:i+aes.BlockSize], blockBuf[i:i+aes.BlockSize])
-		}
-		if err == io.EOF && completeBlocks == readBytes {
-			decrypted = removePKCS7Padding(decrypted)
-		}
-		n = copy(p, decrypted)
-		if n < len(decrypted) {
-			dr.remainder = decrypted[n:]
-		}
-	}
-	return n, err
+// Close releases the memory reservation of the segment.
+func (br *budgetReader) Close() error {
+	br.release(br.reserved)
+	br.reserved = 0
+	return nil
+}
+
+// removingReader reads a segment file and removes it on Close, so that files
+// of segments already read don't pile up during long downloads.
+type removingReader struct {
+	io.ReadCloser
+	path string
 }
 
-// Close closes the underlying file.
-func (dr *decryptedReader) Close() error {
-	return dr.file.Close()
+func (rr *removingReader) Close() error {
+	err := rr.ReadCloser.Close()
+	os.Remove(rr.path)
+	return err
 }
 
 // removePKCS7Padding removes PKCS7 padding from decrypted data.
@@ -0,0 +1,199 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/grafov/m3u8"
+)
+
+// TestParseResolution verifies heights and resolutions are told apart from bandwidths.
+func TestParseResolution(t *testing.T) {
+	tests := []struct {
+		s             string
+		width, height int
+		ok            bool
+	}{
+		{"1280x720", 1280, 720, true},
+		{"720p", 0, 720, true},
+		{"720", 0, 720, true},
+		{"1920X1080", 1920, 1080, true},
+		{"800k", 0, 0, false},
+		{"1280x", 0, 0, false},
+		{"0", 0, 0, false},
+		{"best", 0, 0, false},
+	}
+	for _, tt := range tests {
+		width, height, ok := parseResolution(tt.s)
+		if ok != tt.ok || ok && (width != tt.width || height != tt.height) {
+			t.Errorf("parseResolution(%q) = %d, %d, %v, want %d, %d, %v", tt.s, width, height, ok, tt.width, tt.height, tt.ok)
+		}
+	}
+}
+
+// TestParseBandwidth verifies bandwidth caps need a unit.
+func TestParseBandwidth(t *testing.T) {
+	tests := []struct {
+		s    string
+		want int64
+		ok   bool
+	}{
+		{"800k", 800000, true},
+		{"800kbps", 800000, true},
+		{"800Kb", 800000, true},
+		{"3M", 3000000, true},
+		{"1.5mbps", 1500000, true},
+		{"720", 0, false},
+		{"720p", 0, false},
+		{"0k", 0, false},
+		{"k", 0, false},
+	}
+	for _, tt := range tests {
+		got, ok := parseBandwidth(tt.s)
+		if ok != tt.ok || got != tt.want {
+			t.Errorf("parseBandwidth(%q) = %d, %v, want %d, %v", tt.s, got, ok, tt.want, tt.ok)
+		}
+	}
+}
+
+// TestSelectVariant verifies --quality picks the variant it names, the best
+// one below a height or cap, and the lowest when nothing is below.
+func TestSelectVariant(t *testing.T) {
+	variant := func(uri string, bandwidth uint32, resolution string, iframe bool) *m3u8.Variant {
+		return &m3u8.Variant{URI: uri, VariantParams: m3u8.VariantParams{Bandwidth: bandwidth, Resolution: resolution, Iframe: iframe}}
+	}
+	playlist := &m3u8.MasterPlaylist{Variants: []*m3u8.Variant{
+		variant("480.m3u8", 1200000, "854x480", false),
+		variant("1080.m3u8", 5000000, "1920x1080", false),
+		variant("iframe.m3u8", 100000, "640x360", true),
+		variant("360.m3u8", 600000, "640x360", false),
+		variant("720.m3u8", 2500000, "1280x720", false),
+	}}
+	tests := []struct {
+		quality string
+		want    string
+	}{
+		{"", "1080.m3u8"},
+		{"best", "1080.m3u8"},
+		{"worst", "360.m3u8"},
+		{"720", "720.m3u8"},
+		{"720p", "720.m3u8"},
+		{"1280x720", "720.m3u8"},
+		{"960x720", "720.m3u8"},
+		{"600p", "480.m3u8"},
+		{"240p", "360.m3u8"},
+		{"800k", "360.m3u8"},
+		{"3M", "720.m3u8"},
+		{"100k", "360.m3u8"},
+		{"unknown", "1080.m3u8"},
+	}
+	for _, tt := range tests {
+		got, err := selectVariant(playlist, tt.quality)
+		if err != nil {
+			t.Errorf("selectVariant(%q) failed: %v", tt.quality, err)
+			continue
+		}
+		if got.URI != tt.want {
+			t.Errorf("selectVariant(%q) = %s, want %s", tt.quality, got.URI, tt.want)
+		}
+	}
+
+	if _, err := selectVariant(&m3u8.MasterPlaylist{Variants: []*m3u8.Variant{variant("iframe.m3u8", 100000, "", true)}}, ""); err == nil {
+		t.Error("selectVariant of I-frame variants only succeeded, want an error")
+	}
+}
+
+// TestDownloadEncryptedM3U8 verifies AES-128 segments are decrypted whether
+// prefetched into memory or spilled to files, or fetched by Read itself.
+func TestDownloadEncryptedM3U8(t *testing.T) {
+	key := bytes.Repeat([]byte{0x2a}, aes.BlockSize)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var plain, playlist bytes.Buffer
+	encrypted := make(map[string][]byte)
+	playlist.WriteString("#EXTM3U\n#EXT-X-TARGETDURATION:2\n#EXT-X-MEDIA-SEQUENCE:7\n#EXT-X-KEY:METHOD=AES-128,URI=\"key.bin\"\n")
+	for i := range 6 {
+		segment := bytes.Repeat([]byte{byte(i)}, 300000+i*1000)
+		plain.Write(segment)
+		padding := aes.BlockSize - len(segment)%aes.BlockSize
+		data := append(segment, bytes.Repeat([]byte{byte(padding)}, padding)...)
+		iv := make([]byte, aes.BlockSize)
+		binary.BigEndian.PutUint64(iv[8:], uint64(7+i))
+		cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+		name := fmt.Sprintf("%d.ts", i)
+		encrypted["/"+name] = data
+		fmt.Fprintf(&playlist, "#EXTINF:2,\n%s\n", name)
+	}
+	playlist.WriteString("#EXT-X-ENDLIST\n")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/index.m3u8":
+			w.Write(playlist.Bytes())
+		case "/key.bin":
+			w.Write(key)
+		default:
+			w.Write(encrypted[r.URL.Path])
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		buffer  int64
+		threads int
+	}{
+		{"in memory", 0, 4},
+		{"spilled", 1, 4},
+		{"single worker", 1, 1},
+	}
+	for _, tt := range tests {
+		c := NewContext(context.Background(), Option{OutputPath: t.TempDir(), NoCache: true, Silent: true, RetryCount: 1, Threads: tt.threads, HLSBufferSize: tt.buffer})
+		stream := Stream{ID: "hls", Title: "encrypted", Type: StreamTypeM3u8, URL: server.URL + "/index.m3u8", Format: "ts", Header: http.Header{}}
+		results, err := NewDownloader(c).Download([]Media{{Title: "encrypted", Streams: []Stream{stream}}})
+		c.Close()
+		if err != nil {
+			t.Errorf("%s: Download failed: %v", tt.name, err)
+			continue
+		}
+		if got, err := os.ReadFile(results[0].Path); err != nil || !bytes.Equal(got, plain.Bytes()) {
+			t.Errorf("%s: downloaded %d bytes (%v), want the %d decrypted bytes", tt.name, len(got), err, plain.Len())
+		}
+	}
+}
+
+// TestProcessM3U8Canceled verifies canceling a download, as pausing it does,
+// aborts the fetch of a playlist that is slow to come.
+func TestProcessM3U8Canceled(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	c := NewContext(context.Background(), Option{OutputPath: t.TempDir(), NoCache: true, Silent: true, RetryCount: 1})
+	defer c.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	stream := Stream{ID: "hls", Type: StreamTypeM3u8, URL: server.URL + "/index.m3u8", Header: http.Header{}}
+	if _, err := NewDownloader(c).processM3U8(ctx, stream, ""); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("processM3U8 with a canceled context = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("processM3U8 returned after %v, want right after the cancellation", elapsed)
+	}
+}
@@ -0,0 +1,101 @@
+package grab
+
+import (
+	"sync"
+	"time"
+)
+
+// mirrorChunksPerThread is how many chunks per thread a stream with mirrors
+// is split into. Smaller chunks are handed out as connections free up, so
+// faster mirrors end up serving a larger share of the file.
+const mirrorChunksPerThread = 4
+
+// mirror is one URL serving the bytes of a stream.
+type mirror struct {
+	url    string
+	active int     // Chunk requests in flight
+	speed  float64 // Bytes per second of a single connection, 0 until measured
+	failed bool    // Dropped for the rest of the attempt
+}
+
+// mirrorSet spreads the chunk requests of a stream over its URL and mirrors,
+// preferring the mirrors that deliver the most bytes per connection.
+type mirrorSet struct {
+	mu      sync.Mutex
+	mirrors []*mirror
+}
+
+// newMirrorSet returns the mirrors of stream, its URL first.
+func newMirrorSet(stream Stream) *mirrorSet {
+	s := &mirrorSet{mirrors: []*mirror{{url: stream.URL}}}
+	seen := map[string]bool{stream.URL: true}
+	for _, url := range stream.Mirrors {
+		if url != "" && !seen[url] {
+			seen[url] = true
+			s.mirrors = append(s.mirrors, &mirror{url: url})
+		}
+	}
+	return s
+}
+
+// multi reports whether there is more than one URL to download from.
+func (s *mirrorSet) multi() bool {
+	return len(s.mirrors) > 1
+}
+
+// acquire returns the mirror the next chunk request should go to, nil if
+// every mirror failed. Unmeasured mirrors are tried first, then the one with
+// the best expected speed per connection once this request is added.
+func (s *mirrorSet) acquire() *mirror {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var best *mirror
+	var bestScore float64
+	for _, m := range s.mirrors {
+		if m.failed {
+			continue
+		}
+		score := m.speed / float64(m.active+1)
+		if m.speed == 0 {
+			if m.active == 0 {
+				best = m
+				break
+			}
+			continue
+		}
+		if best == nil || score > bestScore {
+			best, bestScore = m, score
+		}
+	}
+	if best == nil {
+		// Only busy unmeasured mirrors are left, share them evenly
+		for _, m := range s.mirrors {
+			if !m.failed && (best == nil || m.active < best.active) {
+				best = m
+			}
+		}
+	}
+	if best != nil {
+		best.active++
+	}
+	return best
+}
+
+// release records that a request to m received n bytes in elapsed and ended
+// with err. Mirrors returning errors are not used again in this attempt.
+func (s *mirrorSet) release(m *mirror, n int64, elapsed time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	m.active--
+	if n > 0 && elapsed > 0 {
+		sample := float64(n) / elapsed.Seconds()
+		if m.speed == 0 {
+			m.speed = sample
+		} else {
+			m.speed = 0.7*m.speed + 0.3*sample
+		}
+	}
+	if err != nil {
+		m.failed = true
+	}
+}
@@ -0,0 +1,192 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// muxPartExtra marks, in Stream.Extra, a stream downloaded to be merged into
+// the output of its group instead of becoming an output of its own.
+const muxPartExtra = "mux_part"
+
+// muxGroup is a video stream and the audio stream merged with it.
+type muxGroup struct {
+	output Stream // The merged file, named like the video stream
+	video  Stream
+	audio  Stream
+}
+
+// isMuxPart reports whether stream is the video or audio part of a muxGroup.
+func isMuxPart(stream Stream) bool {
+	return stream.Extra[muxPartExtra] != ""
+}
+
+// planMux pairs every selected video stream having a Group with the audio
+// stream of the same group of highest bitrate, selected or not, so that they
+// are merged into a single file. Both are then downloaded as parts named
+// title.f<ID>.<format>. Groups whose merged file already exists are dropped
+// from selected. Other streams are left alone.
+func (d *Downloader) planMux(media Media, selected []Stream) ([]Stream, []muxGroup) {
+	if d.ctx.option.VideoOnly || d.ctx.option.AudioOnly {
+		return selected, nil
+	}
+
+	var groups []muxGroup
+	paired := make(map[string]bool) // IDs of the streams of groups
+	for _, video := range selected {
+		if video.Group == "" || (video.Type != StreamTypeVideo && video.Type != StreamTypeM3u8) {
+			continue
+		}
+		audio, ok := groupAudio(media.Streams, video.Group)
+		if !ok || paired[video.ID] {
+			continue
+		}
+		paired[video.ID], paired[audio.ID] = true, true
+
+		output := video
+		output.Format = muxFormat(video.Format, audio.Format)
+		outputPath := d.getOutputPath(output)
+		if d.skipExisting() && d.findDownloaded(output, outputPath) != "" {
+			d.ctx.logger.Debug("Merged file already exists, skipping", "path", outputPath)
+			continue
+		}
+		if d.noOverwrite() {
+			if path := d.existingOutput(output, outputPath); path != "" {
+				d.ctx.logger.Warn("File exists, not overwriting", "path", path)
+				continue
+			}
+		}
+		groups = append(groups, muxGroup{
+			output: output,
+			video:  d.muxPart(output, d.withSiteHeaders(video)),
+			audio:  d.muxPart(output, d.withSiteHeaders(audio)),
+		})
+	}
+	if len(paired) == 0 {
+		return selected, nil
+	}
+
+	streams := make([]Stream, 0, len(selected)+len(groups))
+	for _, stream := range selected {
+		if !paired[stream.ID] {
+			streams = append(streams, stream)
+		}
+	}
+	parts := make(map[string]bool) // Paths of the parts, a shared audio is downloaded once
+	for _, g := range groups {
+		for _, part := range []Stream{g.video, g.audio} {
+			if partPath := d.getOutputPath(part); !parts[partPath] {
+				parts[partPath] = true
+				streams = append(streams, part)
+			}
+		}
+	}
+	return streams, groups
+}
+
+// groupAudio returns the audio stream of group with the highest bitrate.
+func groupAudio(streams []Stream, group string) (Stream, bool) {
+	var best Stream
+	found := false
+	for _, s := range streams {
+		if s.Group == group && s.Type == StreamTypeAudio && (!found || s.Bitrate > best.Bitrate) {
+			best, found = s, true
+		}
+	}
+	return best, found
+}
+
+// muxPart returns stream as a part of the merged file output, saved next to it.
+func (d *Downloader) muxPart(output, stream Stream) Stream {
+	name := d.getOutputFilename(output)
+	name = fmt.Sprintf("%s.f%s.%s", strings.TrimSuffix(name, path.Ext(name)), d.sanitizeFilename(stream.ID), stream.Format)
+	stream.SaveAs = name
+	if output.SaveAs != "" {
+		stream.SaveAs = path.Join(path.Dir(saveAsPath(output)), name)
+	}
+	stream.Extra = maps.Clone(stream.Extra)
+	if stream.Extra == nil {
+		stream.Extra = make(map[string]string)
+	}
+	stream.Extra[muxPartExtra] = "true"
+	return stream
+}
+
+// muxFormat returns the container holding video and audio of the given formats without re-encoding.
+func muxFormat(videoFormat, audioFormat string) string {
+	mp4 := map[string]bool{"mp4": true, "m4v": true, "m4a": true, "mov": true, "aac": true}
+	switch {
+	case mp4[videoFormat] && mp4[audioFormat]:
+		return "mp4"
+	case videoFormat == "webm" && (audioFormat == "webm" || audioFormat == "opus" || audioFormat == "ogg"):
+		return "webm"
+	default:
+		return "mkv"
+	}
+}
+
+// muxGroups merges the groups of media whose parts were all downloaded, then
+// removes the parts of those merged. Errors are handled like those of streams.
+func (d *Downloader) muxGroups(ctx context.Context, media Media, groups []muxGroup, failed map[string]bool) error {
+	var parts []string
+	defer func() {
+		for _, part := range parts {
+			os.Remove(part)
+		}
+	}()
+	for _, g := range groups {
+		if failed[g.video.ID] || failed[g.audio.ID] {
+			for _, part := range []Stream{g.video, g.audio} {
+				if !failed[part.ID] {
+					d.addResult(d.streamResult(media, part, nil))
+				}
+			}
+			continue
+		}
+		t := &transfer{} // Collects the output of the merged file, not listed by Snapshot
+		err := d.mux(withTransfer(ctx, t), g)
+		d.addResult(d.muxResult(media, g, t.take(), err))
+		if err != nil {
+			d.ctx.logger.Error("Failed to merge streams", "video", g.video.ID, "audio", g.audio.ID, "error", err)
+			if d.ctx.option.IgnoreErrors && ctx.Err() == nil {
+				d.addFailure(Failure{Media: media.Title, StreamID: g.video.ID, URL: g.video.URL, Err: err})
+				continue
+			}
+			return fmt.Errorf("failed to merge stream %s: %w", g.video.ID, err)
+		}
+		parts = append(parts, d.getOutputPath(g.video), d.getOutputPath(g.audio))
+	}
+	return nil
+}
+
+// mux merges the downloaded parts of g into its output with ffmpeg and
+// post-processes the merged file like any other download.
+func (d *Downloader) mux(ctx context.Context, g muxGroup) error {
+	ffmpegPath, err := findFFmpeg(d.ctx.option.FFmpegPath)
+	if err != nil {
+		return err
+	}
+	videoPath, audioPath := d.getOutputPath(g.video), d.getOutputPath(g.audio)
+	outputPath := d.getOutputPath(g.output)
+
+	ext := filepath.Ext(outputPath)
+	muxingPath := strings.TrimSuffix(outputPath, ext) + ".muxing" + ext
+	cmd := exec.CommandContext(ctx, ffmpegPath, "-y", "-i", videoPath, "-i", audioPath,
+		"-map", "0:v:0", "-map", "1:a:0", "-c", "copy", muxingPath)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.Remove(muxingPath)
+		return fmt.Errorf("ffmpeg failed to merge %s and %s: %v, output: %s", videoPath, audioPath, err, string(output))
+	}
+	if err := os.Rename(muxingPath, outputPath); err != nil {
+		return fmt.Errorf("failed to rename merged file: %w", err)
+	}
+
+	d.ctx.logger.Info("Merged video and audio", "output", outputPath)
+	return d.finishOutput(ctx, g.output, outputPath)
+}
@@ -3,6 +3,7 @@ package grab
 import (
 	"net/http"
 	"runtime"
+	"sort"
 	"time"
 
 	"github.com/hydrz/grab/utils"
@@ -17,51 +18,140 @@ const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/
 type Option struct {
 	// Output options
 	OutputPath string // Output directory for downloaded files (--output-dir, -o)
-	OutputName string // Output filename (--output-filename, -O)
+	OutputName string // Output filename, or a template such as "{series}/{episode} - {title}" with the fields of the media (--output-filename, -O)
 
 	// Quality and format
-	Quality string // Preferred video quality, e.g. "best", "worst", "720p" (--quality, -q)
-	Format  string // Output format, e.g. "mp4", "mkv", "mp3" (--format, -f)
+	Quality         string   // Preferred video quality, e.g. "best", "worst", "720p" (--quality, -q)
+	Format          string   // Output format, e.g. "mp4", "mkv", "mp3" (--format, -f)
+	FFmpegPath      string   // Path of the ffmpeg executable or of its directory, looked up in PATH if empty (--ffmpeg-path)
+	FFmpegArgs      []string // Arguments of format conversions, e.g. codec and CRF, those before a "-i" applying to the input, e.g. hwaccel; streams are copied when possible if empty (--ffmpeg-args)
+	RemuxVideo      string   // Container MPEG-TS HLS downloads are remuxed to without re-encoding, that of their extension if empty, RemuxNone to keep them (--remux-video)
+	QualityFallback bool     // Download the next lower quality when a stream keeps failing (--quality-fallback)
+	AllFormats      bool     // Extractors list every quality, not only the one Quality selects (--list-formats, -F)
+	Sync            bool     // Extractors supporting it skip the lessons of a course downloaded by earlier syncs (--sync)
 
 	// Network options
 	Headers    http.Header   // Custom HTTP headers (--header, -H)
 	UserAgent  string        // Custom user agent (--user-agent, -u)
 	Proxy      string        // HTTP proxy URL (--proxy, -x)
+	ProxyUser  string        // Username for proxy basic auth (--proxy-user)
+	ProxyPass  string        // Password for proxy basic auth (--proxy-pass)
+	NoProxyEnv bool          // Ignore HTTP_PROXY, HTTPS_PROXY and NO_PROXY when Proxy is empty (--no-proxy-env)
 	RetryCount int           // Number of retry attempts (--retry, -r)
 	Timeout    time.Duration // Request timeout (--timeout, -t)
 
-	// Rate limit (bytes per second), 0 means unlimited
-	RateLimit int64 // Download speed limit (--rate-limit)
+	// Backoff between retries, doubling from RetryDelay up to RetryMaxDelay; a Retry-After of 429 and 503 responses is honored
+	RetryDelay    time.Duration // Delay before the first retry, 1s if 0 (--retry-delay)
+	RetryMaxDelay time.Duration // Longest delay between two attempts, 30s if 0 (--retry-max-delay)
+	RetryMaxTime  time.Duration // Stop retrying a download or segment after this long, 0 means no limit (--retry-max-time)
+
+	// Default headers by host pattern (e.g. "example.com"), added to streams lacking them (--site-header)
+	SiteHeaders map[string]http.Header
+
+	// HTTP cache of extractor API calls
+	CacheDir     string        // Cache directory, DefaultCacheDir() if empty (--cache-dir)
+	CacheMaxSize int64         // Maximum cache size in bytes, 0 means unlimited (--cache-max-size)
+	CacheTTL     time.Duration // Maximum age of cached responses, 0 means forever (--cache-ttl)
+	NoCache      bool          // Disable the HTTP cache (--no-cache)
+
+	// Geo bypass, extractors may also consult it to pick regional API endpoints
+	GeoBypassCountry string // ISO 3166-1 alpha-2 country code to fake the origin of requests (--geo-bypass-country)
+
+	// Rate limits (bytes per second), 0 means unlimited
+	RateLimit          int64  // Total download speed limit across all streams (--limit-rate)
+	RateLimitPerStream int64  // Download speed limit of a single stream (--limit-rate-per-stream)
+	RateSchedule       string // Periods of the day overriding RateLimit, e.g. "09:00-18:00=1M,22:00-06:00=0", see utils.ParseRateSchedule (--rate-schedule)
+
+	// Deadlines for a single HLS segment or HTTP chunk attempt
+	ChunkTimeout time.Duration // Maximum duration per segment/chunk attempt, 0 derives it from MinSpeed (--chunk-timeout)
+	MinSpeed     int64         // Minimum acceptable speed in bytes per second for a segment/chunk (--min-speed)
 
 	// Advanced authentication
-	AuthType   string // Authentication type: "", "basic", "bearer", "header" (--auth-type)
-	AuthUser   string // Username for basic auth (--auth-user)
-	AuthPass   string // Password for basic auth (--auth-pass)
-	AuthToken  string // Token for bearer auth (--auth-token)
-	AuthHeader string // Custom header for auth, e.g. "X-API-Key: ..." (--auth-header)
-	Cookie     string // Cookie file path for authentication (--cookies, -c)
+	AuthType    string // Authentication type: "", "basic", "bearer", "header" (--auth-type)
+	AuthUser    string // Username for basic auth (--auth-user)
+	AuthPass    string // Password for basic auth (--auth-pass)
+	AuthToken   string // Token for bearer auth (--auth-token)
+	AuthHeader  string // Custom header for auth, e.g. "X-API-Key: ..." (--auth-header)
+	Cookie      string // Cookie file path for authentication (--cookies, -c)
+	SaveCookies string // Netscape cookie file the cookies are written to when the Context is closed (--save-cookies)
+	Netrc       bool   // Send the login of the request host in the .netrc file as basic auth (--netrc)
+	NetrcFile   string // .netrc file read with Netrc, $NETRC or ~/.netrc if empty (--netrc-file)
 
 	// Download options
-	Threads        int   // Number of concurrent download threads (--threads, -n)
-	ChunkSize      int64 // Download chunk size in bytes
-	NoSkipExisting bool  // Do not skip existing files (--no-skip, -S)
+	Threads             int       // Number of concurrent download threads (--threads, -n)
+	ChunkSize           int64     // Minimum chunk size in bytes, smaller files use fewer threads, also the smallest range split off a slow chunk (--chunk-size)
+	NoSkipExisting      bool      // Do not skip existing files (--no-skip, -S)
+	NoCheckSpace        bool      // Do not check the free disk space before downloading (--no-check-space)
+	NoOverwrite         bool      // Never replace an existing file, its stream is skipped instead (--no-overwrite, -w)
+	ForceOverwrite      bool      // Download again and replace existing files, even complete ones; wins over NoOverwrite and AutoNumber (--force-overwrite)
+	AutoNumber          bool      // Save as "title (1).mp4" rather than replacing a file of the same name that isn't an earlier download of the stream (--auto-number)
+	RestrictFilenames   bool      // Transliterate or replace non-ASCII characters, spaces and shell metacharacters in file and directory names (--restrict-filenames)
+	FilenameReplacement string    // Replacement of the characters removed by RestrictFilenames, "_" if empty (--filename-replacement)
+	LongPaths           bool      // Use \\?\ prefixed paths on Windows when exceeding MAX_PATH (--long-paths)
+	Checksum            bool      // Record a SHA-256 .sha256 sidecar for each finished file (--checksum)
+	ExpectedHash        string    // Digest the single downloaded file must match, "sha256:<hex>" or "md5:<hex>" (--expect-hash)
+	Update              bool      // Re-download existing files whose remote copy changed (--update)
+	MaxMemory           int64     // Maximum bytes held in download buffers across all streams, 0 means unlimited (--max-memory)
+	StartAt             time.Time // Wait until this time before extracting, e.g. to download at night (--start-at)
+	MaxDownloads        int       // Stop once this many medias were downloaded with the Context, skipped ones not counted, 0 means no limit (--max-downloads)
+	MinFilesize         int64     // Skip streams known to be smaller than this many bytes, subtitles excepted (--min-filesize)
+	MaxFilesize         int64     // Skip streams known to be larger than this many bytes, 0 means no limit (--max-filesize)
+	KeepFragments       bool      // Keep HLS segments and their manifest in <output>.fragments next to the merged file (--keep-fragments)
+	EmbedMetadata       bool      // Write title, description, chapters, source URL, extractor, date and grab version into tags and extended attributes (--embed-metadata)
+	EmbedSubs           bool      // Download subtitles and embed them into their video instead of keeping them as files (--embed-subs)
+	DownloadSections    string    // Only download this section of videos, e.g. "00:10:00-00:25:00": the HLS segments intersecting it, or a cut by ffmpeg of other streams (--download-sections)
+	SplitChapters       bool      // Also cut video and audio files into one file per chapter of their media, without re-encoding (--split-chapters)
+	ArchiveOutput       string    // Write finished files into this .zip, .tar, .tar.gz or .tgz instead of the output directory (--archive-output)
+	// File recording downloaded medias as "extractor id" lines, medias listed in it are skipped (--download-archive)
+	DownloadArchive string
+	// Download plain HTTP files with this program instead, aria2c, curl, wget or the path of one; extraction, HLS and post-processing stay in grab (--external-downloader)
+	ExternalDownloader string
+	// Argument template of ExternalDownloader, with {url}, {output}, {dir}, {file} and {headers}; the defaults of aria2c, curl and wget if empty (--external-downloader-args)
+	ExternalDownloaderArgs []string
+	// Bytes of prefetched HLS segments each stream keeps in memory until they are written, further ones wait in temporary files; 0 means 64 MiB (--hls-buffer-size)
+	HLSBufferSize int64
+	// Drop the runs of HLS segments between discontinuities whose paths look like ads (--hls-skip-ads)
+	HLSSkipAds bool
+	// Key of encrypted HLS segments as 32 hex digits, used instead of downloading theirs, e.g. when it needs an app login (--hls-key)
+	HLSKey string
+	// IV of encrypted HLS segments as 32 hex digits, instead of that of the playlist or the media sequence number (--hls-iv)
+	HLSIV string
+	// Leave out up to this many HLS segments the server answers 404 or 410 for, instead of failing the download (--hls-allow-missing-segments)
+	HLSAllowMissingSegments int
 
 	// Behavior options
 	ExtractOnly   bool // Only extract media info, do not download (--info, -i)
-	Playlist      bool // Download all videos in playlist (--playlist, -p)
-	PlaylistStart int  // Playlist start index (--playlist-start)
-	PlaylistEnd   int  // Playlist end index (--playlist-end)
+	Playlist      bool // Extract the whole playlist of a URL pointing to one of its entries (--playlist, -p)
+	PlaylistStart int  // Index, from 1, of the first playlist entry extracted, 0 for the first (--playlist-start)
+	PlaylistEnd   int  // Index of the last playlist entry extracted, 0 for the last (--playlist-end)
+	// Indexes and ranges of the playlist entries extracted, e.g. "1,3,7-12,-5", instead of PlaylistStart and PlaylistEnd (--playlist-items)
+	PlaylistItems string
+
+	// Concurrent API requests of an extractor walking a course or playlist, its own default if 0 (--extractor-concurrency)
+	ExtractorConcurrency int
 
 	// Content options
-	Subtitle     bool // Download subtitles (--subtitle)
-	VideoOnly    bool // Download video only, no audio (--video-only)
-	AudioOnly    bool // Download audio only (--audio-only)
-	IgnoreErrors bool // Continue on errors (--ignore-errors)
+	Subtitle       bool   // Download subtitles (--subtitle)
+	SubtitleFormat string // Convert subtitles to "srt", "vtt" or "ttml" (--sub-format)
+	VideoOnly      bool   // Download video only, no audio (--video-only)
+	AudioOnly      bool   // Download audio only (--audio-only)
+	IgnoreErrors   bool   // Continue on errors (--ignore-errors)
+
+	// Remote monitoring
+	WebhookURL      string        // URL receiving job events as JSON POSTs (--webhook)
+	WebhookSecret   string        // Secret for the HMAC-SHA256 X-Grab-Signature header (--webhook-secret)
+	WebhookInterval time.Duration // Interval between progress snapshots (--webhook-interval)
 
 	// Error handling and logging
 	Debug   bool // Enable debug logging (--debug, -d)
 	Verbose bool // Enable verbose output (--verbose, -v)
 	Silent  bool // Suppress all output except errors (--silent)
+
+	// Log file
+	LogFile     string // Also write logs to this file, at least at info level (--log-file)
+	LogFormat   string // Format of the log file, "text" or "json" (--log-format)
+	LogMaxSize  int64  // Size in bytes past which the log file is rotated, 10 MB if 0 (--log-max-size)
+	LogMaxFiles int    // Rotated log files kept, 3 if 0 (--log-max-files)
 }
 
 func (o *Option) Combine(other Option) {
@@ -77,32 +167,166 @@ func (o *Option) Combine(other Option) {
 	if other.Format != "" {
 		o.Format = other.Format
 	}
+	if other.FFmpegPath != "" {
+		o.FFmpegPath = other.FFmpegPath
+	}
+	if len(other.FFmpegArgs) > 0 {
+		o.FFmpegArgs = other.FFmpegArgs
+	}
+	if other.RemuxVideo != "" {
+		o.RemuxVideo = other.RemuxVideo
+	}
+	if other.ExpectedHash != "" {
+		o.ExpectedHash = other.ExpectedHash
+	}
+	if other.SubtitleFormat != "" {
+		o.SubtitleFormat = other.SubtitleFormat
+	}
 	if other.Cookie != "" {
 		o.Cookie = other.Cookie
 	}
+	if other.SaveCookies != "" {
+		o.SaveCookies = other.SaveCookies
+	}
+	o.Netrc = o.Netrc || other.Netrc
+	if other.NetrcFile != "" {
+		o.NetrcFile = other.NetrcFile
+	}
 	if len(other.Headers) > 0 {
 		o.Headers = utils.MergeHeader(o.Headers, other.Headers)
 	}
+	for pattern, header := range other.SiteHeaders {
+		if o.SiteHeaders == nil {
+			o.SiteHeaders = make(map[string]http.Header)
+		}
+		o.SiteHeaders[pattern] = utils.MergeHeader(o.SiteHeaders[pattern], header)
+	}
 	if other.UserAgent != "" {
 		o.UserAgent = other.UserAgent
 	}
 	if other.Proxy != "" {
 		o.Proxy = other.Proxy
 	}
+	if other.ProxyUser != "" {
+		o.ProxyUser = other.ProxyUser
+		o.ProxyPass = other.ProxyPass
+	}
+	o.NoProxyEnv = o.NoProxyEnv || other.NoProxyEnv
 	if other.RetryCount > 0 {
 		o.RetryCount = other.RetryCount
 	}
+	if other.RetryDelay > 0 {
+		o.RetryDelay = other.RetryDelay
+	}
+	if other.RetryMaxDelay > 0 {
+		o.RetryMaxDelay = other.RetryMaxDelay
+	}
+	if other.RetryMaxTime > 0 {
+		o.RetryMaxTime = other.RetryMaxTime
+	}
 	if other.Timeout > 0 {
 		o.Timeout = other.Timeout
 	}
+	if other.CacheDir != "" {
+		o.CacheDir = other.CacheDir
+	}
+	if other.CacheMaxSize > 0 {
+		o.CacheMaxSize = other.CacheMaxSize
+	}
+	if other.CacheTTL > 0 {
+		o.CacheTTL = other.CacheTTL
+	}
+	o.NoCache = o.NoCache || other.NoCache
+	if other.GeoBypassCountry != "" {
+		o.GeoBypassCountry = other.GeoBypassCountry
+	}
+	if other.RateLimit > 0 {
+		o.RateLimit = other.RateLimit
+	}
+	if other.RateLimitPerStream > 0 {
+		o.RateLimitPerStream = other.RateLimitPerStream
+	}
+	if other.RateSchedule != "" {
+		o.RateSchedule = other.RateSchedule
+	}
+	if other.ChunkTimeout > 0 {
+		o.ChunkTimeout = other.ChunkTimeout
+	}
+	if other.MinSpeed > 0 {
+		o.MinSpeed = other.MinSpeed
+	}
 	if other.Threads > 0 {
 		o.Threads = other.Threads
 	}
+	if !other.StartAt.IsZero() {
+		o.StartAt = other.StartAt
+	}
+	if other.MaxDownloads > 0 {
+		o.MaxDownloads = other.MaxDownloads
+	}
+	if other.MinFilesize > 0 {
+		o.MinFilesize = other.MinFilesize
+	}
+	if other.MaxFilesize > 0 {
+		o.MaxFilesize = other.MaxFilesize
+	}
+	if other.ExtractorConcurrency > 0 {
+		o.ExtractorConcurrency = other.ExtractorConcurrency
+	}
 	if other.ChunkSize > 0 {
 		o.ChunkSize = other.ChunkSize
 	}
+	if other.MaxMemory > 0 {
+		o.MaxMemory = other.MaxMemory
+	}
 
 	o.NoSkipExisting = other.NoSkipExisting
+	o.NoCheckSpace = o.NoCheckSpace || other.NoCheckSpace
+	o.NoOverwrite = o.NoOverwrite || other.NoOverwrite
+	o.ForceOverwrite = o.ForceOverwrite || other.ForceOverwrite
+	o.AutoNumber = o.AutoNumber || other.AutoNumber
+	o.RestrictFilenames = o.RestrictFilenames || other.RestrictFilenames
+	if other.FilenameReplacement != "" {
+		o.FilenameReplacement = other.FilenameReplacement
+	}
+	o.LongPaths = o.LongPaths || other.LongPaths
+	o.Checksum = o.Checksum || other.Checksum
+	o.Update = o.Update || other.Update
+	o.QualityFallback = o.QualityFallback || other.QualityFallback
+	o.AllFormats = o.AllFormats || other.AllFormats
+	o.Sync = o.Sync || other.Sync
+	o.KeepFragments = o.KeepFragments || other.KeepFragments
+	o.EmbedMetadata = o.EmbedMetadata || other.EmbedMetadata
+	o.EmbedSubs = o.EmbedSubs || other.EmbedSubs
+	o.SplitChapters = o.SplitChapters || other.SplitChapters
+	if other.ExternalDownloader != "" {
+		o.ExternalDownloader = other.ExternalDownloader
+	}
+	if len(other.ExternalDownloaderArgs) > 0 {
+		o.ExternalDownloaderArgs = other.ExternalDownloaderArgs
+	}
+	if other.HLSBufferSize > 0 {
+		o.HLSBufferSize = other.HLSBufferSize
+	}
+	o.HLSSkipAds = o.HLSSkipAds || other.HLSSkipAds
+	if other.HLSKey != "" {
+		o.HLSKey = other.HLSKey
+	}
+	if other.HLSIV != "" {
+		o.HLSIV = other.HLSIV
+	}
+	if other.HLSAllowMissingSegments > 0 {
+		o.HLSAllowMissingSegments = other.HLSAllowMissingSegments
+	}
+	if other.DownloadSections != "" {
+		o.DownloadSections = other.DownloadSections
+	}
+	if other.ArchiveOutput != "" {
+		o.ArchiveOutput = other.ArchiveOutput
+	}
+	if other.DownloadArchive != "" {
+		o.DownloadArchive = other.DownloadArchive
+	}
 	o.ExtractOnly = other.ExtractOnly
 
 	o.Playlist = o.Playlist || other.Playlist
@@ -112,6 +336,19 @@ func (o *Option) Combine(other Option) {
 	if other.PlaylistEnd > 0 {
 		o.PlaylistEnd = other.PlaylistEnd
 	}
+	if other.PlaylistItems != "" {
+		o.PlaylistItems = other.PlaylistItems
+	}
+
+	if other.WebhookURL != "" {
+		o.WebhookURL = other.WebhookURL
+	}
+	if other.WebhookSecret != "" {
+		o.WebhookSecret = other.WebhookSecret
+	}
+	if other.WebhookInterval > 0 {
+		o.WebhookInterval = other.WebhookInterval
+	}
 
 	o.Subtitle = o.Subtitle || other.Subtitle
 	o.VideoOnly = o.VideoOnly || other.VideoOnly
@@ -120,13 +357,57 @@ func (o *Option) Combine(other Option) {
 	o.Debug = o.Debug || other.Debug
 	o.Verbose = o.Verbose || other.Verbose
 	o.Silent = o.Silent || other.Silent
+	if other.LogFile != "" {
+		o.LogFile = other.LogFile
+	}
+	if other.LogFormat != "" {
+		o.LogFormat = other.LogFormat
+	}
+	if other.LogMaxSize > 0 {
+		o.LogMaxSize = other.LogMaxSize
+	}
+	if other.LogMaxFiles > 0 {
+		o.LogMaxFiles = other.LogMaxFiles
+	}
+}
+
+// siteHeaderRules returns SiteHeaders as rules, shorter and thus more general
+// patterns first so that headers of more specific patterns win.
+func (o *Option) siteHeaderRules() []siteHeaderRule {
+	rules := make([]siteHeaderRule, 0, len(o.SiteHeaders))
+	for pattern, header := range o.SiteHeaders {
+		rules = append(rules, siteHeaderRule{pattern: pattern, header: header})
+	}
+	sort.Slice(rules, func(i, j int) bool {
+		if len(rules[i].pattern) != len(rules[j].pattern) {
+			return len(rules[i].pattern) < len(rules[j].pattern)
+		}
+		return rules[i].pattern < rules[j].pattern
+	})
+	return rules
+}
+
+// chunkDeadline returns the maximum duration allowed for a single attempt at
+// downloading size bytes. An explicit ChunkTimeout wins; otherwise the deadline
+// is derived from MinSpeed plus the request Timeout as grace for connection setup.
+// Zero means no deadline.
+func (o *Option) chunkDeadline(size int64) time.Duration {
+	if o.ChunkTimeout > 0 {
+		return o.ChunkTimeout
+	}
+	if o.MinSpeed <= 0 || size <= 0 {
+		return 0
+	}
+	return time.Duration(size/o.MinSpeed)*time.Second + max(o.Timeout, 30*time.Second)
 }
 
 var DefaultOptions = &Option{
-	OutputPath: "./downloads",
-	RetryCount: 5,
-	Timeout:    30 * time.Second,
-	Threads:    max(4, runtime.NumCPU()), // Use at least 4 threads or number of CPU cores
-	ChunkSize:  1024 * 1024,              // 1 MB
-	UserAgent:  defaultUserAgent,
+	OutputPath:   "./downloads",
+	RetryCount:   5,
+	Timeout:      30 * time.Second,
+	Threads:      max(4, runtime.NumCPU()), // Use at least 4 threads or number of CPU cores
+	ChunkSize:    1024 * 1024,              // 1 MB
+	CacheMaxSize: 100 * 1024 * 1024,        // 100 MB
+	CacheTTL:     24 * time.Hour,
+	UserAgent:    defaultUserAgent,
 }
@@ -0,0 +1,99 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// maxAutoNumber bounds the numbers tried by AutoNumber before giving up and
+// replacing the file.
+const maxAutoNumber = 10000
+
+// skipExisting reports whether files already downloaded are kept rather than
+// downloaded again.
+func (d *Downloader) skipExisting() bool {
+	return !d.ctx.option.NoSkipExisting && !d.ctx.option.ForceOverwrite
+}
+
+// noOverwrite reports whether existing files are never replaced.
+func (d *Downloader) noOverwrite() bool {
+	return d.ctx.option.NoOverwrite && !d.ctx.option.ForceOverwrite
+}
+
+// existingOutput returns the file that downloading stream to outputPath would
+// replace: outputPath itself or the file it is converted to, "" if none.
+func (d *Downloader) existingOutput(stream Stream, outputPath string) string {
+	if format := d.outputFormat(stream); format != "" {
+		if path := convertedPath(outputPath, format); fileExists(path) {
+			return path
+		}
+	}
+	if fileExists(outputPath) {
+		return outputPath
+	}
+	return ""
+}
+
+// fileExists reports whether a file or directory is at path.
+func fileExists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// ownsOutput reports whether the files at outputPath can be taken for an
+// earlier or interrupted download of stream, rather than another file that
+// happens to have the same name: a partial download is resumed, a finished
+// one must be recorded for stream in the state database or match its size.
+func (d *Downloader) ownsOutput(stream Stream, outputPath string) bool {
+	path := d.existingOutput(stream, outputPath)
+	if path == "" || hasPartialFiles(outputPath) {
+		return true
+	}
+	if entry := d.state.completed(path); entry != nil {
+		return entry.StreamID == stream.ID
+	}
+	fi, err := os.Stat(path)
+	return err == nil && stream.Size > 0 && stream.Type != StreamTypeM3u8 && fi.Size() == stream.Size
+}
+
+// numberedFilename returns name in dir, or "name (N).ext" with the lowest N
+// that neither collides with an existing file not owned by stream nor with a
+// file of another stream of this Downloader. The choice is kept for the
+// stream, so all its steps agree on it.
+func (d *Downloader) numberedFilename(stream Stream, dir, name string) string {
+	d.namesMu.Lock()
+	defer d.namesMu.Unlock()
+	key := filepath.Join(dir, name) + "\x00" + stream.ID
+	if chosen, ok := d.names[key]; ok {
+		return chosen
+	}
+	if d.names == nil {
+		d.names = make(map[string]string)
+		d.namesTaken = make(map[string]string)
+	}
+
+	free := func(path string) bool {
+		if owner, taken := d.namesTaken[path]; taken {
+			return owner == key
+		}
+		return d.ownsOutput(stream, path)
+	}
+	chosen := name
+	ext := filepath.Ext(name)
+	base := strings.TrimSuffix(name, ext)
+	for n := 1; !free(filepath.Join(dir, chosen)); n++ {
+		if n > maxAutoNumber {
+			chosen = name
+			break
+		}
+		chosen = fmt.Sprintf("%s (%d)%s", base, n, ext)
+	}
+	if chosen != name {
+		d.ctx.logger.Info("File exists, saving under another name", "file", name, "name", chosen)
+	}
+	d.names[key] = chosen
+	d.namesTaken[filepath.Join(dir, chosen)] = key
+	return chosen
+}
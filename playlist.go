@@ -0,0 +1,213 @@
+package grab
+
+import (
+	"fmt"
+	"iter"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Playlist is a list of medias extracted lazily, e.g. page by page from the
+// API of a site, returned by a PlaylistExtractor.
+type Playlist struct {
+	ID    string // Identifier of the playlist on its site (optional)
+	Title string // Title of the playlist (optional)
+	Count int    // Number of entries, 0 if unknown
+
+	// Current is the index, from 1, of the entry the extracted URL points to,
+	// e.g. a video opened from a playlist, or 0 if it points to the playlist.
+	// ExtractURL only keeps that entry unless Option.Playlist is set.
+	Current int
+
+	// Entries yields the medias in order, then stops at the first error, which
+	// it yields with a zero Media. Consumers stop iterating after the last
+	// entry they need, so pages past it are never requested.
+	Entries iter.Seq2[Media, error]
+}
+
+// PlaylistExtractor is implemented by extractors of URLs listing many medias,
+// e.g. channels or courses. ExtractURL calls ExtractPlaylist rather than
+// Extract and keeps the entries selected by the playlist options.
+type PlaylistExtractor interface {
+	ExtractPlaylist(url string) (*Playlist, error)
+}
+
+// NewPlaylist returns a playlist of medias already extracted, e.g. by Extract.
+func NewPlaylist(medias []Media) *Playlist {
+	return &Playlist{
+		Count: len(medias),
+		Entries: func(yield func(Media, error) bool) {
+			for _, media := range medias {
+				if !yield(media, nil) {
+					return
+				}
+			}
+		},
+	}
+}
+
+// Slice returns the entries from start to end, indexes from 1 included, and
+// stops iterating after end. A start of 0 is the first entry, an end of 0 the
+// last one. On error, the entries read before are returned with it.
+func (p *Playlist) Slice(start, end int) ([]Media, error) {
+	if start > 0 && end > 0 && start > end {
+		return nil, fmt.Errorf("playlist start %d is after its end %d", start, end)
+	}
+	if p.Entries == nil {
+		return nil, nil
+	}
+	var medias []Media
+	index := 0
+	for media, err := range p.Entries {
+		if err != nil {
+			return medias, err
+		}
+		index++
+		if index < start {
+			continue
+		}
+		medias = append(medias, media)
+		if end > 0 && index >= end {
+			break
+		}
+	}
+	return medias, nil
+}
+
+// PlaylistItems selects playlist entries by index, see ParsePlaylistItems.
+type PlaylistItems []playlistRange
+
+// playlistRange is a range of entry indexes, both included. Negative indexes
+// count from the end, -1 being the last entry.
+type playlistRange struct {
+	start, end int
+}
+
+var playlistRangePattern = regexp.MustCompile(`^(-?\d+)(?:-(-?\d+))?$`)
+
+// ParsePlaylistItems parses a comma separated list of entry indexes and
+// ranges, from 1, e.g. "1,3,7-12,-5". Negative indexes count from the end,
+// -1 being the last entry, so "-3--1" is the last three.
+func ParsePlaylistItems(spec string) (PlaylistItems, error) {
+	var items PlaylistItems
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		m := playlistRangePattern.FindStringSubmatch(part)
+		if m == nil {
+			return nil, fmt.Errorf("invalid playlist item %q, use an index such as 3 or -1, or a range such as 7-12", part)
+		}
+		r := playlistRange{}
+		r.start, _ = strconv.Atoi(m[1])
+		r.end = r.start
+		if m[2] != "" {
+			r.end, _ = strconv.Atoi(m[2])
+		}
+		if r.start == 0 || r.end == 0 {
+			return nil, fmt.Errorf("invalid playlist item %q, indexes start at 1", part)
+		}
+		if (r.start > 0) == (r.end > 0) && r.start > r.end {
+			return nil, fmt.Errorf("invalid playlist item %q, the range ends before it starts", part)
+		}
+		items = append(items, r)
+	}
+	if len(items) == 0 {
+		return nil, fmt.Errorf("no playlist item in %q", spec)
+	}
+	return items, nil
+}
+
+// Contains reports whether items select the entry at index, from 1, of a
+// playlist of count entries.
+func (items PlaylistItems) Contains(index, count int) bool {
+	resolve := func(i int) int {
+		if i < 0 {
+			return count + 1 + i
+		}
+		return i
+	}
+	for _, r := range items {
+		if start, end := resolve(r.start), resolve(r.end); index >= start && index <= end {
+			return true
+		}
+	}
+	return false
+}
+
+// last returns the highest index items select, false if one counts from the
+// end, which needs the whole playlist.
+func (items PlaylistItems) last() (int, bool) {
+	last := 0
+	for _, r := range items {
+		if r.start < 0 || r.end < 0 {
+			return 0, false
+		}
+		last = max(last, r.end)
+	}
+	return last, true
+}
+
+// Select returns the entries items select, in the order of the playlist and
+// each once. Iteration stops after the last one, unless an index counts from
+// the end. On error, the entries read before are returned with it.
+func (p *Playlist) Select(items PlaylistItems) ([]Media, error) {
+	end, _ := items.last() // 0 reads the whole playlist
+	entries, err := p.Slice(0, end)
+	var medias []Media
+	for i, media := range entries {
+		if items.Contains(i+1, len(entries)) {
+			medias = append(medias, media)
+		}
+	}
+	return medias, err
+}
+
+// extractMedias extracts the medias of url with extractor, keeping the
+// playlist entries selected by the options of ctx: the entry the URL points
+// to unless Playlist is set, otherwise those of PlaylistItems or from
+// PlaylistStart to PlaylistEnd. The medias of extractors returning them all
+// at once are a playlist too.
+func extractMedias(ctx *Context, extractor Extractor, url string) ([]Media, error) {
+	var playlist *Playlist
+	if pe, ok := extractor.(PlaylistExtractor); ok {
+		var err error
+		if playlist, err = pe.ExtractPlaylist(url); err != nil {
+			return nil, err
+		}
+	} else {
+		medias, err := extractor.Extract(url)
+		if err != nil {
+			return nil, err
+		}
+		if ctx.option.PlaylistStart <= 0 && ctx.option.PlaylistEnd <= 0 && ctx.option.PlaylistItems == "" {
+			return medias, nil
+		}
+		playlist = NewPlaylist(medias)
+	}
+
+	var medias []Media
+	var err error
+	switch start, end := ctx.option.PlaylistStart, ctx.option.PlaylistEnd; {
+	case playlist.Current > 0 && !ctx.option.Playlist:
+		ctx.logger.Info("Extracting a single entry of the playlist, use --playlist for all", "title", playlist.Title, "index", playlist.Current)
+		medias, err = playlist.Slice(playlist.Current, playlist.Current)
+	case ctx.option.PlaylistItems != "":
+		items, parseErr := ParsePlaylistItems(ctx.option.PlaylistItems)
+		if parseErr != nil {
+			return nil, parseErr
+		}
+		medias, err = playlist.Select(items)
+	default:
+		if playlist.Count > 0 && start > playlist.Count {
+			ctx.logger.Warn("Playlist start is past its last entry", "start", start, "count", playlist.Count)
+		}
+		medias, err = playlist.Slice(start, end)
+	}
+	if err != nil {
+		return medias, fmt.Errorf("failed to extract playlist entries: %w", err)
+	}
+	return medias, nil
+}
@@ -0,0 +1,77 @@
+package grab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// profilesFileName is the profiles file inside the user config directory.
+const profilesFileName = "grab/profiles.json"
+
+// Profile is a named set of credentials and settings, e.g. one per account
+// of a site, selected with --profile. Empty fields leave the options alone.
+type Profile struct {
+	OutputPath string `json:"output_dir,omitempty"`
+	Cookie     string `json:"cookies,omitempty"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	Proxy      string `json:"proxy,omitempty"`
+	ProxyUser  string `json:"proxy_user,omitempty"`
+	ProxyPass  string `json:"proxy_pass,omitempty"`
+	AuthType   string `json:"auth_type,omitempty"`
+	AuthUser   string `json:"auth_user,omitempty"`
+	AuthPass   string `json:"auth_pass,omitempty"`
+	AuthToken  string `json:"auth_token,omitempty"`
+	AuthHeader string `json:"auth_header,omitempty"`
+
+	Headers     map[string]string            `json:"headers,omitempty"`      // Headers of every request
+	SiteHeaders map[string]map[string]string `json:"site_headers,omitempty"` // Headers by host pattern, as --site-header
+	Env         map[string]string            `json:"env,omitempty"`          // Environment read by extractors, e.g. GAODUN_AUTH_TOKEN
+}
+
+// DefaultProfilesPath returns the profiles file: $XDG_CONFIG_HOME/grab/profiles.json
+// on Linux, with the platform equivalents elsewhere.
+func DefaultProfilesPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, filepath.FromSlash(profilesFileName))
+}
+
+// LoadProfiles reads the profiles file at path, a JSON object of profiles by name.
+// A missing file holds no profiles.
+func LoadProfiles(path string) (map[string]Profile, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]Profile{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var profiles map[string]Profile
+	if err := json.Unmarshal(data, &profiles); err != nil {
+		return nil, fmt.Errorf("invalid profiles file %s: %w", path, err)
+	}
+	return profiles, nil
+}
+
+// LoadProfile returns the profile name of the profiles file at path.
+func LoadProfile(path, name string) (Profile, error) {
+	profiles, err := LoadProfiles(path)
+	if err != nil {
+		return Profile{}, err
+	}
+	profile, ok := profiles[name]
+	if !ok {
+		names := make([]string, 0, len(profiles))
+		for n := range profiles {
+			names = append(names, n)
+		}
+		sort.Strings(names)
+		return Profile{}, fmt.Errorf("unknown profile %q in %s (available: %v)", name, path, names)
+	}
+	return profile, nil
+}
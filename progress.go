@@ -6,15 +6,16 @@ import (
 	"time"
 )
 
-// ProgressCallback defines the callback function for progress updates
-type ProgressCallback func(current, total int64, description string)
+// ProgressCallback defines the callback function for progress updates. id
+// tells apart downloads with the same description, see ProgressEvent.ID.
+type ProgressCallback func(id string, current, total int64, description string)
 
 // progress tracks progress in concurrent environments with callback support.
 type progress struct {
 	Total       int64
 	Current     atomic.Int64
 	Description string
-	callback    ProgressCallback
+	callback    func(current, total int64)
 	lastUpdate  atomic.Int64
 }
 
@@ -29,7 +30,7 @@ func newProgress(total int64, description string) *progress {
 }
 
 // SetCallback sets the progress callback function
-func (p *progress) SetCallback(callback ProgressCallback) {
+func (p *progress) SetCallback(callback func(current, total int64)) {
 	p.callback = callback
 }
 
@@ -46,17 +47,23 @@ func (p *progress) Add(num int64) {
 		lastUpdate := p.lastUpdate.Load()
 		if now-lastUpdate > 100 { // Update at most every 100ms
 			if p.lastUpdate.CompareAndSwap(lastUpdate, now) {
-				p.callback(current, p.Total, p.Description)
+				p.callback(current, p.Total)
 			}
 		}
 	}
 }
 
-// Finish marks the progress bar as finished.
+// Finish marks the progress bar as finished. Current is raised to Total, but
+// never lowered: it stays the bytes received when Total is unknown, e.g. for
+// M3U8 streams, or was too low.
 func (p *progress) Finish() {
-	p.Current.Store(p.Total)
+	current := p.Current.Load()
+	for current < p.Total && !p.Current.CompareAndSwap(current, p.Total) {
+		current = p.Current.Load()
+	}
+	current = max(current, p.Total)
 	if p.callback != nil {
-		p.callback(p.Total, p.Total, p.Description)
+		p.callback(current, p.Total)
 	}
 }
 
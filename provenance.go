@@ -0,0 +1,38 @@
+package grab
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/hydrz/grab/version"
+)
+
+// provenance records where a downloaded file came from.
+type provenance struct {
+	SourceURL string    // URL given to grab, the stream URL if unknown
+	Extractor string    // Name of the extractor that found the stream
+	Date      time.Time // Time the download finished
+}
+
+// comment returns the provenance as a single human readable line.
+func (p provenance) comment() string {
+	comment := fmt.Sprintf("Downloaded from %s on %s by grab %s", p.SourceURL, p.Date.Format(time.RFC3339), version.Version)
+	if p.Extractor != "" {
+		comment += fmt.Sprintf(" (extractor %s)", p.Extractor)
+	}
+	return comment
+}
+
+// xattrs returns the provenance as extended attributes, the origin URL under
+// the freedesktop.org name understood by file managers.
+func (p provenance) xattrs() map[string]string {
+	attrs := map[string]string{
+		"xdg.origin.url": p.SourceURL,
+		"grab.date":      p.Date.Format(time.RFC3339),
+		"grab.version":   version.Version,
+	}
+	if p.Extractor != "" {
+		attrs["grab.extractor"] = p.Extractor
+	}
+	return attrs
+}
@@ -0,0 +1,336 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// JobStatus is the state of a queued download job.
+type JobStatus string
+
+const (
+	JobQueued   JobStatus = "queued"
+	JobRunning  JobStatus = "running"
+	JobPaused   JobStatus = "paused"
+	JobDone     JobStatus = "done"
+	JobFailed   JobStatus = "failed"
+	JobCanceled JobStatus = "canceled"
+)
+
+// ErrJobNotFound is returned for operations on unknown job IDs.
+var ErrJobNotFound = errors.New("job not found")
+
+// Job is a snapshot of a queued media download.
+type Job struct {
+	ID       string
+	Media    Media
+	Priority int // Higher priorities run first
+	Status   JobStatus
+	Err      error // Set when Status is JobFailed
+}
+
+// queueJob is the internal, mutable state of a job.
+type queueJob struct {
+	Job
+	seq        int                // Insertion order, breaks priority ties
+	cancel     context.CancelFunc // Cancels the running download
+	downloader *Downloader        // Downloader of the last run, nil if never run
+
+	sourceURL, extractorName string    // Source of the media, see Downloader.SetSource
+	refresher                Refresher // Renews expired stream URLs, may be nil
+}
+
+// Queue downloads media jobs with a fixed number of workers, highest priority
+// first. Jobs can be paused, resumed and canceled at runtime. Pausing a running
+// job stops it while keeping its partial files, so resuming continues where it stopped.
+type Queue struct {
+	ctx     *Context
+	workers int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	jobs   map[string]*queueJob
+	seq    int
+	closed bool
+}
+
+// NewQueue creates a queue that runs up to workers jobs concurrently.
+func NewQueue(ctx *Context, workers int) *Queue {
+	q := &Queue{
+		ctx:     ctx,
+		workers: max(1, workers),
+		jobs:    make(map[string]*queueJob),
+	}
+	q.cond = sync.NewCond(&q.mu)
+	return q
+}
+
+// Add queues a media download with the given priority and returns the job ID.
+func (q *Queue) Add(media Media, priority int) string {
+	return q.add(&queueJob{Job: Job{Media: media, Priority: priority}})
+}
+
+// AddFrom is Add for media extracted from url by the named extractor, which
+// also renews expired stream URLs of the job if it is a Refresher.
+func (q *Queue) AddFrom(media Media, priority int, url, extractorName string, extractor Extractor) string {
+	j := &queueJob{Job: Job{Media: media, Priority: priority}, sourceURL: url, extractorName: extractorName}
+	j.refresher, _ = extractor.(Refresher)
+	return q.add(j)
+}
+
+// add queues j and returns its new ID.
+func (q *Queue) add(j *queueJob) string {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.seq++
+	j.ID, j.Status, j.seq = strconv.Itoa(q.seq), JobQueued, q.seq
+	q.jobs[j.ID] = j
+	q.cond.Broadcast()
+	return j.ID
+}
+
+// Jobs returns a snapshot of all jobs in queue order.
+func (q *Queue) Jobs() []Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	sorted := q.sortedLocked()
+	jobs := make([]Job, len(sorted))
+	for i, j := range sorted {
+		jobs[i] = j.Job
+	}
+	return jobs
+}
+
+// Job returns a snapshot of the job id.
+func (q *Queue) Job(id string) (Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	return j.Job, nil
+}
+
+// Snapshot returns the detailed progress of the streams of a job, see
+// Downloader.Snapshot. It is empty for jobs that never ran.
+func (q *Queue) Snapshot(id string) ([]TransferSnapshot, error) {
+	q.mu.Lock()
+	j, ok := q.jobs[id]
+	var downloader *Downloader
+	if ok {
+		downloader = j.downloader
+	}
+	q.mu.Unlock()
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if downloader == nil {
+		return nil, nil
+	}
+	return downloader.Snapshot(), nil
+}
+
+// PauseStream pauses a single stream of a running job without stopping the
+// job, see Downloader.Pause.
+func (q *Queue) PauseStream(id, streamID string) error {
+	downloader, err := q.runningDownloader(id)
+	if err != nil {
+		return err
+	}
+	return downloader.Pause(streamID)
+}
+
+// ResumeStream resumes a stream paused with PauseStream.
+func (q *Queue) ResumeStream(id, streamID string) error {
+	downloader, err := q.runningDownloader(id)
+	if err != nil {
+		return err
+	}
+	return downloader.Resume(streamID)
+}
+
+// runningDownloader returns the Downloader of the running job id.
+func (q *Queue) runningDownloader(id string) (*Downloader, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if j.Status != JobRunning || j.downloader == nil {
+		return nil, fmt.Errorf("job %s is %s", id, j.Status)
+	}
+	return j.downloader, nil
+}
+
+// SetPriority changes the priority of a job that hasn't finished yet.
+func (q *Queue) SetPriority(id string, priority int) error {
+	return q.update(id, func(j *queueJob) error {
+		j.Priority = priority
+		return nil
+	})
+}
+
+// Pause stops a queued or running job until Resume is called.
+func (q *Queue) Pause(id string) error {
+	return q.update(id, func(j *queueJob) error {
+		switch j.Status {
+		case JobQueued:
+			j.Status = JobPaused
+		case JobRunning:
+			j.Status = JobPaused
+			j.cancel()
+		case JobPaused:
+		default:
+			return fmt.Errorf("job %s is %s", id, j.Status)
+		}
+		return nil
+	})
+}
+
+// Resume queues a paused job again.
+func (q *Queue) Resume(id string) error {
+	return q.update(id, func(j *queueJob) error {
+		if j.Status != JobPaused {
+			return fmt.Errorf("job %s is %s", id, j.Status)
+		}
+		j.Status = JobQueued
+		return nil
+	})
+}
+
+// Cancel stops a job for good. Partial files are kept.
+func (q *Queue) Cancel(id string) error {
+	return q.update(id, func(j *queueJob) error {
+		switch j.Status {
+		case JobQueued, JobPaused:
+			j.Status = JobCanceled
+		case JobRunning:
+			j.Status = JobCanceled
+			j.cancel()
+		default:
+			return fmt.Errorf("job %s is %s", id, j.Status)
+		}
+		return nil
+	})
+}
+
+// Close makes Run return once no job is queued or running anymore.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.closed = true
+	q.cond.Broadcast()
+}
+
+// Run processes jobs until ctx is done, or until the queue is closed and has no
+// queued or running jobs left. Paused jobs don't keep a closed queue running.
+func (q *Queue) Run(ctx context.Context) error {
+	stop := context.AfterFunc(ctx, func() {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		q.cond.Broadcast()
+	})
+	defer stop()
+
+	var wg sync.WaitGroup
+	for i := 0; i < q.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				job, jobCtx := q.next(ctx)
+				if job == nil {
+					return
+				}
+				downloader := NewDownloader(q.ctx)
+				q.mu.Lock()
+				job.downloader = downloader
+				downloader.SetSource(job.sourceURL, job.extractorName)
+				if job.refresher != nil {
+					downloader.SetRefresher(job.refresher)
+				}
+				q.mu.Unlock()
+				_, err := downloader.download(jobCtx, []Media{job.Media})
+				q.finish(job, err)
+			}
+		}()
+	}
+	wg.Wait()
+	return ctx.Err()
+}
+
+// next blocks until a job can run and marks it running. It returns nil when
+// the queue is done or ctx is canceled.
+func (q *Queue) next(ctx context.Context) (*queueJob, context.Context) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for {
+		if ctx.Err() != nil {
+			return nil, nil
+		}
+		running := false
+		for _, j := range q.sortedLocked() {
+			if j.Status == JobQueued {
+				jobCtx, cancel := context.WithCancel(ctx)
+				j.Status, j.cancel = JobRunning, cancel
+				return j, jobCtx
+			}
+			running = running || j.Status == JobRunning
+		}
+		if q.closed && !running {
+			return nil, nil
+		}
+		q.cond.Wait()
+	}
+}
+
+// finish records the outcome of a job run.
+func (q *Queue) finish(j *queueJob, err error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j.cancel()
+	// Paused and canceled jobs were stopped on purpose, their status is already set
+	if j.Status == JobRunning {
+		if err != nil {
+			j.Status, j.Err = JobFailed, err
+		} else {
+			j.Status = JobDone
+		}
+	}
+	q.cond.Broadcast()
+}
+
+func (q *Queue) update(id string, fn func(*queueJob) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	j, ok := q.jobs[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	if err := fn(j); err != nil {
+		return err
+	}
+	q.cond.Broadcast()
+	return nil
+}
+
+// sortedLocked returns the jobs by descending priority, then insertion order.
+func (q *Queue) sortedLocked() []*queueJob {
+	jobs := make([]*queueJob, 0, len(q.jobs))
+	for _, j := range q.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(a, b int) bool {
+		if jobs[a].Priority != jobs[b].Priority {
+			return jobs[a].Priority > jobs[b].Priority
+		}
+		return jobs[a].seq < jobs[b].seq
+	})
+	return jobs
+}
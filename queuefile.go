@@ -0,0 +1,116 @@
+package grab
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// queueFileName is the persisted queue inside the user config directory.
+const queueFileName = "grab/queue.json"
+
+// QueueEntry is a URL waiting in the persisted queue of `grab queue`.
+type QueueEntry struct {
+	ID       string    `json:"id"`
+	URL      string    `json:"url"`
+	Priority int       `json:"priority,omitempty"` // Higher priorities run first
+	Added    time.Time `json:"added"`
+	Error    string    `json:"error,omitempty"` // Error of the last failed run, the entry is retried
+}
+
+// DefaultQueuePath returns the queue file: $XDG_CONFIG_HOME/grab/queue.json
+// on Linux, with the platform equivalents elsewhere.
+func DefaultQueuePath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = "."
+	}
+	return filepath.Join(dir, filepath.FromSlash(queueFileName))
+}
+
+// LoadQueueFile reads the entries of the queue file at path, highest priority
+// first, then in the order they were added. A missing file holds no entries.
+func LoadQueueFile(path string) ([]QueueEntry, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var entries []QueueEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return nil, fmt.Errorf("invalid queue file %s: %w", path, err)
+	}
+	sortQueueEntries(entries)
+	return entries, nil
+}
+
+// UpdateQueueFile replaces the entries of the queue file at path with those
+// returned by fn, called with the current entries. Processes updating the same
+// file concurrently wait for each other, so entries added by `grab queue add`
+// during a run are kept.
+func UpdateQueueFile(path string, fn func([]QueueEntry) ([]QueueEntry, error)) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	lock, err := lockQueueFile(path)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	entries, err := LoadQueueFile(path)
+	if err != nil {
+		return err
+	}
+	entries, err = fn(entries)
+	if err != nil {
+		return err
+	}
+	sortQueueEntries(entries)
+	data, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data)
+}
+
+// NextQueueID returns an ID not used by entries.
+func NextQueueID(entries []QueueEntry) string {
+	next := 1
+	for _, entry := range entries {
+		if id, err := strconv.Atoi(entry.ID); err == nil && id >= next {
+			next = id + 1
+		}
+	}
+	return strconv.Itoa(next)
+}
+
+// lockQueueFile waits up to a few seconds for the lock of the queue file at path.
+func lockQueueFile(path string) (*utils.FileLock, error) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		lock, err := utils.TryLock(path + ".lock")
+		if !errors.Is(err, utils.ErrLocked) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// sortQueueEntries sorts entries by descending priority, then by time added.
+func sortQueueEntries(entries []QueueEntry) {
+	sort.SliceStable(entries, func(a, b int) bool {
+		if entries[a].Priority != entries[b].Priority {
+			return entries[a].Priority > entries[b].Priority
+		}
+		return entries[a].Added.Before(entries[b].Added)
+	})
+}
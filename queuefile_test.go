@@ -0,0 +1,110 @@
+package grab
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestNextQueueID verifies IDs are never reused while their entries are queued.
+func TestNextQueueID(t *testing.T) {
+	tests := []struct {
+		ids  []string
+		want string
+	}{
+		{nil, "1"},
+		{[]string{"1", "2"}, "3"},
+		{[]string{"7", "3"}, "8"},
+		{[]string{"x", "2"}, "3"},
+	}
+	for _, tt := range tests {
+		var entries []QueueEntry
+		for _, id := range tt.ids {
+			entries = append(entries, QueueEntry{ID: id})
+		}
+		if got := NextQueueID(entries); got != tt.want {
+			t.Errorf("NextQueueID(%v) = %s, want %s", tt.ids, got, tt.want)
+		}
+	}
+}
+
+// TestLoadQueueFile verifies entries are read highest priority first, then in
+// the order they were added.
+func TestLoadQueueFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queue.json")
+	if entries, err := LoadQueueFile(path); err != nil || entries != nil {
+		t.Errorf("LoadQueueFile of a missing file = %v, %v, want no entries", entries, err)
+	}
+
+	added := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	err := os.WriteFile(path, fmt.Appendf(nil, `[
+		{"id":"1","url":"a","added":%q},
+		{"id":"2","url":"b","priority":5,"added":%q},
+		{"id":"3","url":"c","priority":-1,"added":%q},
+		{"id":"4","url":"d","priority":5,"added":%q}
+	]`, added.Format(time.RFC3339), added.Add(2*time.Minute).Format(time.RFC3339),
+		added.Format(time.RFC3339), added.Add(time.Minute).Format(time.RFC3339)), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, err := LoadQueueFile(path)
+	if err != nil {
+		t.Fatalf("LoadQueueFile failed: %v", err)
+	}
+	var ids []string
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+	}
+	if want := []string{"4", "2", "1", "3"}; !slices.Equal(ids, want) {
+		t.Errorf("LoadQueueFile = %v, want %v", ids, want)
+	}
+
+	os.WriteFile(path, []byte(`{"id":"1"}`), 0644)
+	if _, err := LoadQueueFile(path); err == nil {
+		t.Error("LoadQueueFile of an invalid file succeeded, want an error")
+	}
+}
+
+// TestUpdateQueueFile verifies concurrent updates, as of grab queue add during
+// a run, don't lose each other's entries, and that failed updates change nothing.
+func TestUpdateQueueFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "grab", "queue.json")
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := UpdateQueueFile(path, func(entries []QueueEntry) ([]QueueEntry, error) {
+				return append(entries, QueueEntry{ID: NextQueueID(entries), URL: fmt.Sprint(i), Priority: i % 3}), nil
+			})
+			if err != nil {
+				t.Errorf("UpdateQueueFile failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	entries, err := LoadQueueFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ids := make(map[string]bool)
+	for _, entry := range entries {
+		ids[entry.ID] = true
+	}
+	if len(entries) != 20 || len(ids) != 20 {
+		t.Errorf("got %d entries with %d IDs, want 20 of each", len(entries), len(ids))
+	}
+
+	err = UpdateQueueFile(path, func(entries []QueueEntry) ([]QueueEntry, error) {
+		return nil, errors.New("failed")
+	})
+	if after, _ := LoadQueueFile(path); err == nil || len(after) != 20 {
+		t.Errorf("failed update = %v, left %d entries, want an error and 20 entries", err, len(after))
+	}
+}
@@ -0,0 +1,136 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// RemuxNone is the RemuxVideo value keeping HLS downloads as the MPEG-TS
+// they were downloaded as.
+const RemuxNone = "none"
+
+// isMPEGTS reports whether the file at path starts with MPEG-TS packets.
+func isMPEGTS(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	head := make([]byte, 3*tsPacketSize)
+	n, _ := io.ReadFull(f, head)
+	if n < tsPacketSize {
+		return false
+	}
+	for i := 0; i < n; i += tsPacketSize {
+		if head[i] != tsSyncByte {
+			return false
+		}
+	}
+	return true
+}
+
+// remuxFormat returns the container RemuxVideo asks the HLS download of
+// stream to be remuxed to instead of the one of its output extension, "" if
+// none.
+func (d *Downloader) remuxFormat(stream Stream) string {
+	format := strings.ToLower(d.ctx.option.RemuxVideo)
+	if stream.Type != StreamTypeM3u8 || format == "" || format == RemuxNone || d.outputFormat(stream) != "" {
+		return ""
+	}
+	return format
+}
+
+// remux rewrites the MPEG-TS HLS download of stream at outputPath into the
+// container its name promises, e.g. MP4, without re-encoding: concatenated TS
+// segments named .mp4 are rejected by some players. It returns the path of
+// the remuxed file, outputPath itself unless RemuxVideo changes the
+// extension. Files that aren't MPEG-TS, e.g. fMP4 segments, are left alone,
+// and so is the file if ffmpeg is missing or fails.
+func (d *Downloader) remux(ctx context.Context, stream Stream, outputPath string) string {
+	if stream.Type != StreamTypeM3u8 || isMuxPart(stream) || strings.EqualFold(d.ctx.option.RemuxVideo, RemuxNone) || d.outputFormat(stream) != "" {
+		return outputPath
+	}
+	target := outputPath
+	if format := d.remuxFormat(stream); format != "" {
+		target = convertedPath(outputPath, format)
+	}
+	ext := strings.ToLower(filepath.Ext(target))
+	if ext == ".ts" || ext == "" || !isMPEGTS(outputPath) {
+		return outputPath
+	}
+	ffmpegPath, err := findFFmpeg(d.ctx.option.FFmpegPath)
+	if err != nil {
+		d.ctx.logger.Warn("Keeping HLS download as MPEG-TS, install ffmpeg to remux it", "file", outputPath)
+		return outputPath
+	}
+
+	t := transferFrom(ctx)
+	t.setPhase(PhaseConverting)
+	offsets := t.discontinuityOffsets()
+	d.ctx.logger.Info("Remuxing HLS download", "file", outputPath, "to", ext[1:], "discontinuities", len(offsets))
+	tmpPath := strings.TrimSuffix(target, ext) + ".remux" + ext
+	args := []string{"-y", "-i", outputPath}
+	if len(offsets) > 0 {
+		// Timestamps restart at discontinuities, the concat demuxer makes
+		// each run continue where the previous one ended
+		listPath := strings.TrimSuffix(target, ext) + ".remux.ffconcat"
+		if err := writeConcatList(listPath, outputPath, offsets); err != nil {
+			d.ctx.logger.Warn("Failed to split HLS download at its discontinuities", "file", outputPath, "error", err)
+		} else {
+			defer os.Remove(listPath)
+			args = []string{"-y", "-f", "concat", "-safe", "0", "-protocol_whitelist", "file,subfile", "-i", listPath}
+		}
+	}
+	args = append(args, "-map", "0", "-c", "copy")
+	switch ext {
+	case ".mp4", ".m4v", ".m4a", ".mov":
+		// ADTS AAC of TS needs its headers moved into the MP4 sample description
+		args = append(args, "-bsf:a", "aac_adtstoasc", "-movflags", "+faststart")
+	}
+	args = append(args, tmpPath)
+	output, err := runFFmpeg(ctx, ffmpegPath, args, d.convertProgress(ctx, stream))
+	if err != nil {
+		os.Remove(tmpPath)
+		d.ctx.logger.Warn("Failed to remux HLS download, keeping it as MPEG-TS", "file", outputPath,
+			"error", fmt.Errorf("ffmpeg failed: %v, output: %s", err, string(output)))
+		return outputPath
+	}
+	if err := os.Rename(tmpPath, target); err != nil {
+		os.Remove(tmpPath)
+		d.ctx.logger.Warn("Failed to replace HLS download with its remux", "file", outputPath, "error", err)
+		return outputPath
+	}
+	if target != outputPath {
+		os.Remove(outputPath)
+		t.setConverted()
+	}
+	return target
+}
+
+// writeConcatList writes the ffconcat list at listPath reading the file at
+// path as consecutive parts split at offsets, with the subfile protocol.
+func writeConcatList(listPath, path string, offsets []int64) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var list strings.Builder
+	list.WriteString("ffconcat version 1.0\n")
+	quoted := strings.ReplaceAll(path, "'", `'\''`)
+	var start int64
+	for _, end := range append(offsets[:len(offsets):len(offsets)], fi.Size()) {
+		if end > start {
+			fmt.Fprintf(&list, "file 'subfile,,start,%d,end,%d,,:%s'\n", start, end, quoted)
+		}
+		start = end
+	}
+	return os.WriteFile(listPath, []byte(list.String()), 0644)
+}
@@ -0,0 +1,250 @@
+package grab
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/grafov/m3u8"
+	"github.com/hydrz/grab/subtitle"
+)
+
+// rendition is an alternative audio or subtitles of a master playlist
+// (EXT-X-MEDIA) going with the variant being downloaded, in a media playlist
+// of its own.
+type rendition struct {
+	Stream         // Media playlist of the rendition, with its language
+	subtitles bool // Subtitles, audio otherwise
+}
+
+// variantRenditions returns the renditions to download along with variant:
+// the audio of its AUDIO group, the default one if the group has several, and
+// if subtitles is set all the subtitles of its SUBTITLES group. Renditions
+// without URI are part of the variant itself.
+func variantRenditions(base *url.URL, stream Stream, variant *m3u8.Variant, subtitles bool) []rendition {
+	var audio *m3u8.Alternative
+	var renditions []rendition
+	seen := make(map[string]bool)
+	for _, alt := range variant.Alternatives {
+		if alt == nil || alt.URI == "" {
+			continue
+		}
+		switch {
+		case alt.Type == "AUDIO" && alt.GroupId == variant.Audio:
+			if audio == nil || alt.Default && !audio.Default {
+				audio = alt
+			}
+		case alt.Type == "SUBTITLES" && subtitles && alt.GroupId == variant.Subtitles && !seen[alt.URI]:
+			seen[alt.URI] = true
+			if r, ok := newRendition(base, stream, alt); ok {
+				renditions = append(renditions, r)
+			}
+		}
+	}
+	if audio != nil {
+		if r, ok := newRendition(base, stream, audio); ok {
+			renditions = append([]rendition{r}, renditions...)
+		}
+	}
+	return renditions
+}
+
+// newRendition returns the rendition of alt, an alternative of the master
+// playlist of stream at base.
+func newRendition(base *url.URL, stream Stream, alt *m3u8.Alternative) (rendition, bool) {
+	u, err := base.Parse(alt.URI)
+	if err != nil {
+		return rendition{}, false
+	}
+	kind := strings.ToLower(alt.Type)
+	return rendition{
+		Stream: Stream{
+			ID:       fmt.Sprintf("%s_%s_%s", stream.ID, kind, cmp.Or(alt.Language, alt.Name)),
+			Title:    cmp.Or(alt.Name, stream.Title),
+			Type:     StreamTypeM3u8,
+			URL:      u.String(),
+			Header:   stream.Header,
+			Language: alt.Language,
+		},
+		subtitles: alt.Type == "SUBTITLES",
+	}, true
+}
+
+// downloadRenditions downloads the renditions of the HLS download of stream
+// at tempPath: audio is merged into it, subtitles are saved next to its output
+// like those of other sites. With EmbedSubs they are held for stream, and
+// embed muxes them into the output once remuxed or converted to a container
+// that holds subtitles, MPEG-TS can't. It reports whether it merged audio,
+// which moves the bytes of the video.
+func (d *Downloader) downloadRenditions(ctx context.Context, stream Stream, renditions []rendition, tempPath string) (bool, error) {
+	outputPath := strings.TrimSuffix(tempPath, downloadingSuffix)
+	base := strings.TrimSuffix(outputPath, filepath.Ext(outputPath))
+	merged := false
+	for _, r := range renditions {
+		if r.subtitles {
+			format := cmp.Or(subtitle.Normalize(d.ctx.option.SubtitleFormat), "vtt")
+			name := base
+			if r.Language != "" {
+				name += "." + d.sanitizeFilename(r.Language)
+			}
+			path := name + "." + format
+			if err := d.downloadSubtitleRendition(ctx, r.Stream, path, format); err != nil {
+				d.ctx.logger.Warn("Failed to download HLS subtitles", "stream", stream.ID, "language", r.Language, "error", err)
+				continue
+			}
+			if e := embeddingFrom(ctx); e != nil && d.ctx.option.EmbedSubs {
+				sub := r.Stream
+				sub.Type, sub.Format = StreamTypeSubtitle, format
+				e.holdFor(stream.ID, heldSubtitle{stream: sub, path: path})
+			}
+			continue
+		}
+		ok, err := d.mergeAudioRendition(ctx, r.Stream, tempPath, base)
+		if err != nil {
+			return merged, fmt.Errorf("failed to download audio rendition %s: %w", r.ID, err)
+		}
+		merged = merged || ok
+	}
+	return merged, nil
+}
+
+// mergeAudioRendition downloads the audio rendition audio and merges it into
+// the video at tempPath with ffmpeg, in place of any audio of the video. The
+// audio is kept in its own file next to the output named after base if
+// ffmpeg is missing, and mergeAudioRendition then reports false.
+func (d *Downloader) mergeAudioRendition(ctx context.Context, audio Stream, tempPath, base string) (bool, error) {
+	data, err := d.processM3U8(ctx, audio, "")
+	if err != nil {
+		return false, fmt.Errorf("failed to process M3U8 stream: %w", err)
+	}
+	defer data.Close()
+	ext := ".ts"
+	if reader, ok := data.(*m3U8Reader); ok {
+		if u, err := url.Parse(reader.segments[0].URI); err == nil && path.Ext(u.Path) != "" {
+			ext = path.Ext(u.Path)
+		}
+	}
+
+	d.ctx.logger.Info("Downloading HLS audio rendition", "stream", audio.ID, "language", audio.Language)
+	audioPath := tempPath + ".audio" + ext
+	defer os.Remove(audioPath)
+	file, err := os.Create(audioPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to create audio file: %w", err)
+	}
+	_, err = d.copyWithContext(ctx, file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to write audio file: %w", err)
+	}
+
+	ffmpegPath, err := findFFmpeg(d.ctx.option.FFmpegPath)
+	if err != nil {
+		kept := base + ".audio" + ext
+		if err := os.Rename(audioPath, kept); err != nil {
+			return false, fmt.Errorf("failed to keep audio file: %w", err)
+		}
+		d.ctx.logger.Warn("Keeping HLS audio rendition as a separate file, install ffmpeg to merge it", "file", kept)
+		return false, nil
+	}
+
+	format := "mpegts"
+	if !isMPEGTS(tempPath) {
+		format = "mp4" // fMP4 segments
+	}
+	mergingPath := tempPath + ".merging"
+	args := []string{"-y", "-i", tempPath, "-i", audioPath, "-map", "0:v", "-map", "1:a", "-c", "copy"}
+	if audio.Language != "" {
+		args = append(args, "-metadata:s:a:0", "language="+audio.Language)
+	}
+	args = append(args, "-f", format, mergingPath)
+	transferFrom(ctx).setPhase(PhaseConverting)
+	if output, err := runFFmpeg(ctx, ffmpegPath, args, nil); err != nil {
+		os.Remove(mergingPath)
+		return false, fmt.Errorf("ffmpeg failed to merge audio: %v, output: %s", err, string(output))
+	}
+	if err := os.Rename(mergingPath, tempPath); err != nil {
+		os.Remove(mergingPath)
+		return false, fmt.Errorf("failed to replace video with merged file: %w", err)
+	}
+	return true, nil
+}
+
+// downloadSubtitleRendition downloads the segments of the subtitle rendition
+// sub and writes their cues to path in format. Cues repeated by consecutive
+// segments, which they span, are written once.
+func (d *Downloader) downloadSubtitleRendition(ctx context.Context, sub Stream, path, format string) error {
+	playlist, listType, err := d.parsePlaylist(ctx, sub)
+	if err != nil {
+		return fmt.Errorf("failed to parse playlist: %w", err)
+	}
+	media, ok := playlist.(*m3u8.MediaPlaylist)
+	if listType != m3u8.MEDIA || !ok {
+		return fmt.Errorf("subtitle rendition is not a media playlist")
+	}
+	base, err := url.Parse(sub.URL)
+	if err != nil {
+		return fmt.Errorf("invalid base URL: %w", err)
+	}
+
+	var cues []subtitle.Cue
+	seen := make(map[subtitle.Cue]bool)
+	for _, segment := range media.Segments {
+		if segment == nil {
+			continue
+		}
+		u, err := base.Parse(segment.URI)
+		if err != nil {
+			return fmt.Errorf("invalid segment URI: %w", err)
+		}
+		data, err := d.fetchSubtitleSegment(ctx, u.String(), sub.Header)
+		if err != nil {
+			return err
+		}
+		segmentCues, err := subtitle.Parse(data)
+		if err != nil {
+			return fmt.Errorf("failed to parse subtitle segment: %w", err)
+		}
+		for _, cue := range segmentCues {
+			if !seen[cue] {
+				seen[cue] = true
+				cues = append(cues, cue)
+			}
+		}
+	}
+	data, err := subtitle.Write(cues, format)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write subtitles: %w", err)
+	}
+	d.ctx.logger.Info("Saved HLS subtitles", "file", path, "language", sub.Language)
+	return nil
+}
+
+// fetchSubtitleSegment downloads the subtitle segment at segmentURL.
+func (d *Downloader) fetchSubtitleSegment(ctx context.Context, segmentURL string, header http.Header) ([]byte, error) {
+	resp, err := newMediaRequest(ctx, d.ctx.client, header).Get(segmentURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download subtitle segment: %w", err)
+	}
+	defer resp.RawBody().Close()
+	if resp.StatusCode() != http.StatusOK {
+		return nil, httpStatusError(resp)
+	}
+	data, err := io.ReadAll(resp.RawBody())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read subtitle segment: %w", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,91 @@
+package grab
+
+import "time"
+
+// DownloadResult is the outcome of a stream download, as returned by
+// Downloader.Download. Video and audio streams merged into one file are a
+// single result named after the video stream.
+type DownloadResult struct {
+	Media        string        // Title of the media
+	StreamID     string        // ID of the stream, empty for a media skipped by the download archive
+	URL          string        // URL of the stream
+	Path         string        // Final file, after conversion, merging and archiving; empty on error
+	Bytes        int64         // Bytes received, 0 if skipped
+	Duration     time.Duration // Time spent, retries and post-processing included
+	AverageSpeed float64       // Bytes per second over Duration
+	Skipped      bool          // Already downloaded or not overwritten, see Option.NoSkipExisting, Option.NoOverwrite and Option.DownloadArchive
+	Converted    bool          // Converted to another format, see Option.Format
+	Err          error         // Why the stream failed, nil on success
+}
+
+// Results returns the outcome of every stream downloaded by d so far, in order.
+func (d *Downloader) Results() []DownloadResult {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	return append([]DownloadResult(nil), d.results...)
+}
+
+func (d *Downloader) addResult(r DownloadResult) {
+	d.failuresMu.Lock()
+	defer d.failuresMu.Unlock()
+	d.results = append(d.results, r)
+}
+
+// streamResult returns the outcome of the download of stream that ended with err.
+func (d *Downloader) streamResult(media Media, stream Stream, err error) DownloadResult {
+	var snapshot TransferSnapshot
+	if t := d.transferOf(stream); t != nil {
+		snapshot = t.take()
+	}
+	r := DownloadResult{
+		Media:     media.Title,
+		StreamID:  stream.ID,
+		URL:       stream.URL,
+		Path:      snapshot.Output,
+		Bytes:     snapshot.Current,
+		Skipped:   snapshot.Skipped,
+		Converted: snapshot.Converted,
+		Err:       err,
+	}
+	if !snapshot.FinishedAt.IsZero() {
+		r.Duration = snapshot.FinishedAt.Sub(snapshot.StartedAt)
+	}
+	return r.finish()
+}
+
+// muxResult returns the outcome of the merge of g, with merged the state of
+// the merge and err its error.
+func (d *Downloader) muxResult(media Media, g muxGroup, merged TransferSnapshot, err error) DownloadResult {
+	video, audio := d.streamResult(media, g.video, nil), d.streamResult(media, g.audio, nil)
+	started := time.Now()
+	for _, part := range []Stream{g.video, g.audio} {
+		if t := d.transferOf(part); t != nil {
+			if s := t.take().StartedAt; s.Before(started) {
+				started = s
+			}
+		}
+	}
+	r := DownloadResult{
+		Media:     media.Title,
+		StreamID:  g.video.ID,
+		URL:       g.video.URL,
+		Path:      merged.Output,
+		Bytes:     video.Bytes + audio.Bytes,
+		Duration:  time.Since(started),
+		Skipped:   video.Skipped && audio.Skipped,
+		Converted: merged.Converted,
+		Err:       err,
+	}
+	return r.finish()
+}
+
+// finish fills the fields derived from the others.
+func (r DownloadResult) finish() DownloadResult {
+	if r.Err != nil {
+		r.Path = ""
+	}
+	if r.Duration > 0 {
+		r.AverageSpeed = float64(r.Bytes) / r.Duration.Seconds()
+	}
+	return r
+}
@@ -0,0 +1,71 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestProgressFinish verifies finishing raises the bytes received to the total
+// but never lowers them, as for streams of unknown size.
+func TestProgressFinish(t *testing.T) {
+	tests := []struct {
+		total, received, want int64
+	}{
+		{100, 100, 100},
+		{100, 40, 100},
+		{0, 300, 300},
+		{100, 150, 150},
+		{0, 0, 0},
+	}
+	for _, tt := range tests {
+		p := newProgress(tt.total, "test")
+		var reported int64
+		p.SetCallback(func(current, total int64) { reported = current })
+		p.Add(tt.received)
+		p.Finish()
+		if got := p.Current.Load(); got != tt.want || reported != tt.want {
+			t.Errorf("Finish with %d of %d bytes = %d, reported %d, want %d", tt.received, tt.total, got, reported, tt.want)
+		}
+	}
+}
+
+// TestDownloadResultUnknownSize verifies the result of an M3U8 download,
+// whose size isn't known beforehand, counts the bytes of its segments.
+func TestDownloadResultUnknownSize(t *testing.T) {
+	segment := strings.Repeat("\x47", 188*10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/index.m3u8" {
+			fmt.Fprint(w, "#EXTM3U\n#EXT-X-TARGETDURATION:2\n")
+			for i := range 3 {
+				fmt.Fprintf(w, "#EXTINF:2,\n%d.ts\n", i)
+			}
+			fmt.Fprint(w, "#EXT-X-ENDLIST\n")
+			return
+		}
+		fmt.Fprint(w, segment)
+	}))
+	defer server.Close()
+
+	dir := t.TempDir()
+	c := NewContext(context.Background(), Option{OutputPath: dir, NoCache: true, Silent: true, RetryCount: 1})
+	defer c.Close()
+	media := Media{Title: "live", Streams: []Stream{{
+		ID: "hls", Type: StreamTypeM3u8, URL: server.URL + "/index.m3u8", Format: "ts", Header: http.Header{},
+	}}}
+	results, err := NewDownloader(c).Download([]Media{media})
+	if err != nil {
+		t.Fatalf("Download failed: %v", err)
+	}
+	want := int64(3 * len(segment))
+	if len(results) != 1 || results[0].Bytes != want {
+		t.Fatalf("Download results = %+v, want one of %d bytes", results, want)
+	}
+	if fi, err := os.Stat(results[0].Path); err != nil || fi.Size() != want {
+		t.Errorf("downloaded file %s: %v, want %d bytes", results[0].Path, err, want)
+	}
+}
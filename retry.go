@@ -0,0 +1,199 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// Defaults of the retry policy for the options left at zero.
+const (
+	defaultRetryDelay    = time.Second
+	defaultRetryMaxDelay = 30 * time.Second
+	defaultRetryJitter   = 0.2
+)
+
+// RetryPolicy decides whether and when a failed request, segment or download
+// is tried again. It is shared by the HTTP client, stream downloads and HLS
+// segments of a Context, see Context.SetRetryPolicy.
+type RetryPolicy struct {
+	Attempts   int           // Attempts in total, at least one
+	BaseDelay  time.Duration // Delay before the second attempt, doubled for each next one
+	MaxDelay   time.Duration // Longest delay between two attempts, Retry-After included
+	MaxElapsed time.Duration // Give up once retrying would go past this time since the first attempt, 0 means never
+	Jitter     float64       // Fraction of each delay randomly added or removed, from 0 to 1
+}
+
+// retryPolicyOf returns the retry policy configured by the options.
+func retryPolicyOf(o Option) RetryPolicy {
+	p := RetryPolicy{
+		Attempts:   max(o.RetryCount, 1),
+		BaseDelay:  o.RetryDelay,
+		MaxDelay:   o.RetryMaxDelay,
+		MaxElapsed: o.RetryMaxTime,
+		Jitter:     defaultRetryJitter,
+	}
+	if p.BaseDelay <= 0 {
+		p.BaseDelay = defaultRetryDelay
+	}
+	if p.MaxDelay <= 0 {
+		p.MaxDelay = max(defaultRetryMaxDelay, p.BaseDelay)
+	}
+	return p
+}
+
+// Delay returns how long to wait before the next attempt once failed attempts
+// failed, the last one with err. A Retry-After sent with err is honored up to
+// MaxDelay.
+func (p RetryPolicy) Delay(failed int, err error) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < failed && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if p.Jitter > 0 {
+		delay += time.Duration(float64(delay) * p.Jitter * (2*rand.Float64() - 1))
+	}
+	var after *retryAfterError
+	if errors.As(err, &after) {
+		delay = max(delay, after.after)
+	}
+	if p.MaxDelay > 0 {
+		delay = min(delay, p.MaxDelay)
+	}
+	return max(delay, 0)
+}
+
+// wait sleeps delay before another attempt of something first tried at
+// start. It returns false without waiting if the attempt would start past
+// MaxElapsed, and false once ctx is done.
+func (p RetryPolicy) wait(ctx context.Context, start time.Time, delay time.Duration) bool {
+	if p.MaxElapsed > 0 && time.Since(start)+delay > p.MaxElapsed {
+		return false
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+// configure applies p to the retries of client.
+func (p RetryPolicy) configure(client *resty.Client) {
+	client.SetRetryCount(p.Attempts - 1)
+	client.SetRetryWaitTime(p.BaseDelay)
+	client.SetRetryMaxWaitTime(p.MaxDelay)
+	client.RetryConditions = nil
+	client.AddRetryCondition(func(r *resty.Response, _ error) bool {
+		return !p.elapsed(r.Request.Context()) && retryableResponse(r)
+	})
+	client.SetRetryAfter(func(_ *resty.Client, resp *resty.Response) (time.Duration, error) {
+		if resp == nil {
+			return 0, nil
+		}
+		return retryAfter(resp.StatusCode(), resp.Header()), nil
+	})
+}
+
+// retryStartKey is the context key of the time of the first attempt of what
+// requests with the context retry.
+type retryStartKey struct{}
+
+// withRetryStart returns ctx recording start as the first attempt of what its
+// requests retry, unless it records one already.
+func withRetryStart(ctx context.Context, start time.Time) context.Context {
+	if _, ok := ctx.Value(retryStartKey{}).(time.Time); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, retryStartKey{}, start)
+}
+
+// elapsed reports whether MaxElapsed passed since the first attempt recorded
+// in ctx, see withRetryStart.
+func (p RetryPolicy) elapsed(ctx context.Context) bool {
+	start, ok := ctx.Value(retryStartKey{}).(time.Time)
+	return ok && p.MaxElapsed > 0 && time.Since(start) >= p.MaxElapsed
+}
+
+// RetryPolicy returns the retry policy of the context.
+func (c *Context) RetryPolicy() RetryPolicy {
+	if c.retry.Attempts == 0 {
+		return retryPolicyOf(c.option)
+	}
+	return c.retry
+}
+
+// SetRetryPolicy replaces the retry policy of the context, built from
+// RetryCount, RetryDelay, RetryMaxDelay and RetryMaxTime by default.
+func (c *Context) SetRetryPolicy(p RetryPolicy) {
+	p.Attempts = max(p.Attempts, 1)
+	c.retry = p
+	p.configure(c.Client())
+}
+
+// retryAfterError is an error of a response asking to wait before retrying.
+type retryAfterError struct {
+	err   error
+	after time.Duration
+}
+
+func (e *retryAfterError) Error() string { return e.err.Error() }
+func (e *retryAfterError) Unwrap() error { return e.err }
+
+// withRetryAfter returns err along with the Retry-After of a 429 or 503
+// response with status and header, if it has one.
+func withRetryAfter(err error, status int, header http.Header) error {
+	if after := retryAfter(status, header); after > 0 {
+		return &retryAfterError{err: err, after: after}
+	}
+	return err
+}
+
+// httpStatusError returns the error of a response with an unexpected status,
+// carrying its Retry-After.
+func httpStatusError(resp *resty.Response) error {
+	return withRetryAfter(&statusError{status: resp.StatusCode(), text: resp.Status()}, resp.StatusCode(), resp.Header())
+}
+
+// statusError is the error of a response with an unexpected status.
+type statusError struct {
+	status int
+	text   string // Status line, e.g. "404 Not Found"
+}
+
+func (e *statusError) Error() string { return "HTTP error: " + e.text }
+
+// errorStatus returns the status of the response err is about, 0 if none.
+func errorStatus(err error) int {
+	var se *statusError
+	if errors.As(err, &se) {
+		return se.status
+	}
+	return 0
+}
+
+// retryAfter returns the delay a 429 or 503 response asks for in its
+// Retry-After header, in seconds or as a date, 0 if none.
+func retryAfter(status int, header http.Header) time.Duration {
+	if status != http.StatusTooManyRequests && status != http.StatusServiceUnavailable {
+		return 0
+	}
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,174 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// TimeRange is a section of a media, from Start to End, an End of 0 being
+// the end of the media.
+type TimeRange struct {
+	Start time.Duration
+	End   time.Duration
+}
+
+// ParseTimeRange parses a section such as "00:10:00-00:25:00", the format of
+// Option.DownloadSections. Times are [[HH:]MM:]SS[.fraction]; an empty or
+// "inf" end is the end of the media, and a leading "*" is allowed.
+func ParseTimeRange(spec string) (TimeRange, error) {
+	start, end, ok := strings.Cut(strings.TrimPrefix(strings.TrimSpace(spec), "*"), "-")
+	if !ok {
+		return TimeRange{}, fmt.Errorf("invalid section %q, use start-end such as 00:10:00-00:25:00", spec)
+	}
+	var r TimeRange
+	var err error
+	if r.Start, err = parseTimestamp(start); err != nil {
+		return TimeRange{}, fmt.Errorf("invalid section %q: %w", spec, err)
+	}
+	if end = strings.TrimSpace(end); end != "" && end != "inf" {
+		if r.End, err = parseTimestamp(end); err != nil {
+			return TimeRange{}, fmt.Errorf("invalid section %q: %w", spec, err)
+		}
+		if r.End <= r.Start {
+			return TimeRange{}, fmt.Errorf("invalid section %q, it ends before it starts", spec)
+		}
+	}
+	return r, nil
+}
+
+// parseTimestamp parses [[HH:]MM:]SS[.fraction], an empty timestamp being 0.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+	parts := strings.Split(s, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	var seconds float64
+	for i, part := range parts {
+		value, err := strconv.ParseFloat(part, 64)
+		if err != nil || value < 0 || (i < len(parts)-1 && strings.Contains(part, ".")) {
+			return 0, fmt.Errorf("invalid time %q", s)
+		}
+		seconds = seconds*60 + value
+	}
+	return time.Duration(seconds * float64(time.Second)), nil
+}
+
+// section returns the section DownloadSections selects, false if none.
+func (d *Downloader) section() (TimeRange, bool, error) {
+	if d.ctx.option.DownloadSections == "" {
+		return TimeRange{}, false, nil
+	}
+	r, err := ParseTimeRange(d.ctx.option.DownloadSections)
+	return r, err == nil, err
+}
+
+// clipSegments returns the segments intersecting r, timed by their durations.
+func clipSegments(segments []*segmentInfo, r TimeRange) []*segmentInfo {
+	var clipped []*segmentInfo
+	var start float64
+	for _, segment := range segments {
+		from, to := start, start+segment.Duration
+		start = to
+		if r.End > 0 && from >= r.End.Seconds() {
+			break
+		}
+		if to > r.Start.Seconds() {
+			clipped = append(clipped, segment)
+		}
+	}
+	return clipped
+}
+
+// clipsWithFFmpeg reports whether stream is cut to a section by ffmpeg
+// rather than downloaded whole: video and audio files over HTTP, which ffmpeg
+// reads with range requests from the start of the section.
+func (d *Downloader) clipsWithFFmpeg(stream Stream) bool {
+	if d.ctx.option.DownloadSections == "" || (stream.Type != StreamTypeVideo && stream.Type != StreamTypeAudio) {
+		return false
+	}
+	return strings.HasPrefix(stream.URL, "http://") || strings.HasPrefix(stream.URL, "https://")
+}
+
+// downloadClip writes the section of stream DownloadSections selects to
+// tempPath with ffmpeg, copying the streams so the section starts at the
+// keyframe before its start.
+func (d *Downloader) downloadClip(ctx context.Context, stream Stream, tempPath string) error {
+	r, _, err := d.section()
+	if err != nil {
+		return err
+	}
+	ffmpegPath, err := findFFmpeg(d.ctx.option.FFmpegPath)
+	if err != nil {
+		return fmt.Errorf("cutting a section of %s needs ffmpeg: %w", stream.ID, err)
+	}
+
+	args := []string{"-y"}
+	if headers := ffmpegHeaders(d.ctx.client.Header, stream.Header); headers != "" {
+		args = append(args, "-headers", headers)
+	}
+	if r.Start > 0 {
+		args = append(args, "-ss", ffmpegTime(r.Start))
+	}
+	if r.End > 0 {
+		args = append(args, "-t", ffmpegTime(r.End-r.Start))
+	}
+	// ffmpeg picks the container by the extension, which .part hides
+	ext := filepath.Ext(strings.TrimSuffix(tempPath, downloadingSuffix))
+	clipPath := tempPath + ext
+	args = append(args, "-i", stream.URL, "-map", "0", "-c", "copy", "-avoid_negative_ts", "make_zero", clipPath)
+
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+	d.ctx.logger.Info("Downloading section", "stream", stream.ID, "start", utils.FormatDuration(r.Start), "end", utils.FormatDuration(r.End))
+	onProgress := d.convertProgress(ctx, stream)
+	if onProgress != nil && r.End > 0 {
+		report := onProgress
+		onProgress = func(current, _ time.Duration) { report(current, r.End-r.Start) }
+	}
+	output, err := runFFmpeg(ctx, ffmpegPath, args, onProgress)
+	if err != nil {
+		os.Remove(clipPath)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("ffmpeg failed to download section: %v, output: %s", err, string(output))
+	}
+	return os.Rename(clipPath, tempPath)
+}
+
+// ffmpegHeaders returns the headers of the client overridden by those of the
+// stream in the format of the -headers option of ffmpeg, without those about
+// the transfer ffmpeg handles itself.
+func ffmpegHeaders(client, stream http.Header) string {
+	headers := client.Clone()
+	if headers == nil {
+		headers = make(http.Header)
+	}
+	for name, values := range stream {
+		headers[name] = values
+	}
+	headers.Del("Accept-Encoding")
+	headers.Del("Connection")
+	headers.Del("Range")
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(headers)) {
+		for _, value := range headers[name] {
+			fmt.Fprintf(&b, "%s: %s\r\n", name, value)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,475 @@
+// Package sftp downloads files from SFTP servers, from an offset so that
+// interrupted downloads resume.
+//
+// Only what downloading needs is implemented on top of golang.org/x/crypto/ssh:
+// version 3 of the protocol, OPEN, FSTAT, READ and CLOSE. Like curl, URL paths
+// are absolute, a leading "/~/" making them relative to the home directory.
+package sftp
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// DefaultPort is the port of the sftp scheme.
+const DefaultPort = "22"
+
+// Packet types and status codes of the protocol, see
+// draft-ietf-secsh-filexfer-02.
+const (
+	fxpInit    = 1
+	fxpVersion = 2
+	fxpOpen    = 3
+	fxpClose   = 4
+	fxpRead    = 5
+	fxpFstat   = 8
+	fxpStatus  = 101
+	fxpHandle  = 102
+	fxpData    = 103
+	fxpAttrs   = 105
+
+	fxOK  = 0
+	fxEOF = 1
+
+	fxfRead       = 0x1 // Open flag
+	attrSize      = 0x1 // Attribute flag
+	protoVersion  = 3
+	maxPacketSize = 256 << 10
+)
+
+const (
+	// readSize is the length read by each READ request, which servers answer
+	// with 32 KiB at least.
+	readSize = 32 << 10
+	// maxPending is the number of READ requests sent ahead of the data read.
+	maxPending = 16
+)
+
+// Config is how Open logs in and checks the server.
+type Config struct {
+	// Auth are the methods tried after the password of the URL, if any.
+	// DefaultAuth() if nil.
+	Auth []ssh.AuthMethod
+	// HostKeyCallback checks the key of the server, against ~/.ssh/known_hosts
+	// if nil. Unknown servers are refused.
+	HostKeyCallback ssh.HostKeyCallback
+}
+
+// DefaultAuth returns the keys of the running ssh-agent, if any, and the
+// unencrypted keys of ~/.ssh with their usual names.
+func DefaultAuth() []ssh.AuthMethod {
+	var methods []ssh.AuthMethod
+	if socket := os.Getenv("SSH_AUTH_SOCK"); socket != "" {
+		if conn, err := net.Dial("unix", socket); err == nil {
+			methods = append(methods, ssh.PublicKeysCallback(agent.NewClient(conn).Signers))
+		}
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return methods
+	}
+	var signers []ssh.Signer
+	for _, name := range []string{"id_ed25519", "id_ecdsa", "id_rsa"} {
+		data, err := os.ReadFile(filepath.Join(home, ".ssh", name))
+		if err != nil {
+			continue
+		}
+		if signer, err := ssh.ParsePrivateKey(data); err == nil {
+			signers = append(signers, signer)
+		}
+	}
+	if len(signers) > 0 {
+		methods = append(methods, ssh.PublicKeys(signers...))
+	}
+	return methods
+}
+
+// defaultHostKeyCallback checks host keys against ~/.ssh/known_hosts.
+func defaultHostKeyCallback() (ssh.HostKeyCallback, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to find known hosts: %w", err)
+	}
+	callback, err := knownhosts.New(filepath.Join(home, ".ssh", "known_hosts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read known hosts, connect once with ssh to add the server: %w", err)
+	}
+	return callback, nil
+}
+
+// Open logs in to the server of u as the user of u, the current user if it
+// has none, and returns the content of the file at the path of u from offset
+// with the size of the whole file, -1 if the server doesn't tell. config nil
+// uses the defaults. Closing the reader ends the session.
+func Open(ctx context.Context, u *url.URL, offset int64, config *Config) (io.ReadCloser, int64, error) {
+	if u.Scheme != "sftp" {
+		return nil, -1, fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+	client, err := dial(ctx, u, config)
+	if err != nil {
+		return nil, -1, err
+	}
+	// Unblock the exchanges below when ctx is done
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	r, size, err := start(client, filePath(u), offset)
+	if !stop() || err != nil {
+		client.Close()
+		if ctx.Err() != nil {
+			return nil, -1, context.Cause(ctx)
+		}
+		return nil, -1, err
+	}
+	r.stop = context.AfterFunc(ctx, func() { client.Close() })
+	return r, size, nil
+}
+
+// dial connects to the server of u and logs in.
+func dial(ctx context.Context, u *url.URL, config *Config) (*ssh.Client, error) {
+	if config == nil {
+		config = &Config{}
+	}
+	hostKeyCallback := config.HostKeyCallback
+	if hostKeyCallback == nil {
+		var err error
+		if hostKeyCallback, err = defaultHostKeyCallback(); err != nil {
+			return nil, err
+		}
+	}
+	auth := config.Auth
+	if auth == nil {
+		auth = DefaultAuth()
+	}
+	name := u.User.Username()
+	if password, ok := u.User.Password(); ok {
+		answer := func(name, instruction string, questions []string, echos []bool) ([]string, error) {
+			answers := make([]string, len(questions))
+			for i := range answers {
+				answers[i] = password
+			}
+			return answers, nil
+		}
+		auth = append([]ssh.AuthMethod{ssh.Password(password), ssh.KeyboardInteractive(answer)}, auth...)
+	}
+	if name == "" {
+		if current, err := user.Current(); err == nil {
+			name = current.Username
+		}
+	}
+
+	port := DefaultPort
+	if u.Port() != "" {
+		port = u.Port()
+	}
+	addr := net.JoinHostPort(u.Hostname(), port)
+	var dialer net.Dialer
+	raw, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect: %w", err)
+	}
+	stop := context.AfterFunc(ctx, func() { raw.Close() })
+	defer stop()
+	conn, chans, reqs, err := ssh.NewClientConn(raw, addr, &ssh.ClientConfig{
+		User:            name,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+	})
+	if err != nil {
+		raw.Close()
+		if ctx.Err() != nil {
+			return nil, context.Cause(ctx)
+		}
+		return nil, fmt.Errorf("SSH login failed: %w", err)
+	}
+	return ssh.NewClient(conn, chans, reqs), nil
+}
+
+// filePath returns the path of the file of u on the server.
+func filePath(u *url.URL) string {
+	if rest, ok := strings.CutPrefix(u.Path, "/~/"); ok {
+		return rest
+	}
+	return u.Path
+}
+
+// session is the SFTP subsystem of an SSH session.
+type session struct {
+	in     io.WriteCloser
+	out    io.Reader
+	nextID uint32
+}
+
+// start opens the SFTP session and the file at path, returning a reader of
+// the file from offset and its size.
+func start(client *ssh.Client, path string, offset int64) (*reader, int64, error) {
+	sshSession, err := client.NewSession()
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	in, err := sshSession.StdinPipe()
+	if err != nil {
+		return nil, -1, err
+	}
+	out, err := sshSession.StdoutPipe()
+	if err != nil {
+		return nil, -1, err
+	}
+	if err := sshSession.RequestSubsystem("sftp"); err != nil {
+		return nil, -1, fmt.Errorf("server has no SFTP subsystem: %w", err)
+	}
+	s := &session{in: in, out: out}
+
+	if err := s.send(fxpInit, binary.BigEndian.AppendUint32(nil, protoVersion)); err != nil {
+		return nil, -1, err
+	}
+	typ, _, err := s.receive()
+	if err != nil {
+		return nil, -1, err
+	}
+	if typ != fxpVersion {
+		return nil, -1, fmt.Errorf("unexpected SFTP packet %d instead of the version", typ)
+	}
+
+	payload := appendString(nil, path)
+	payload = binary.BigEndian.AppendUint32(payload, fxfRead)
+	payload = binary.BigEndian.AppendUint32(payload, 0) // No attributes
+	typ, data, err := s.request(fxpOpen, payload)
+	if err != nil {
+		return nil, -1, fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	handle, _, ok := readString(data)
+	if typ != fxpHandle || !ok {
+		return nil, -1, fmt.Errorf("failed to open %s: %w", path, statusError(typ, data))
+	}
+
+	size := int64(-1)
+	if typ, data, err := s.request(fxpFstat, appendString(nil, handle)); err == nil && typ == fxpAttrs && len(data) >= 12 {
+		if flags := binary.BigEndian.Uint32(data); flags&attrSize != 0 {
+			size = int64(binary.BigEndian.Uint64(data[4:]))
+		}
+	}
+	return &reader{session: s, client: client, handle: handle, offset: offset}, size, nil
+}
+
+// send writes a packet of type typ with the id of the next request followed
+// by payload, or payload alone for INIT, and returns the id.
+func (s *session) send(typ byte, payload []byte) error {
+	packet := binary.BigEndian.AppendUint32(nil, uint32(1+len(payload)))
+	packet = append(packet, typ)
+	packet = append(packet, payload...)
+	_, err := s.in.Write(packet)
+	return err
+}
+
+// sendRequest sends a request of type typ and returns its id.
+func (s *session) sendRequest(typ byte, payload []byte) (uint32, error) {
+	s.nextID++
+	id := s.nextID
+	return id, s.send(typ, append(binary.BigEndian.AppendUint32(nil, id), payload...))
+}
+
+// receive reads a packet, returning its type and the rest.
+func (s *session) receive() (byte, []byte, error) {
+	var header [5]byte
+	if _, err := io.ReadFull(s.out, header[:]); err != nil {
+		return 0, nil, fmt.Errorf("failed to read SFTP packet: %w", err)
+	}
+	length := binary.BigEndian.Uint32(header[:4])
+	if length < 1 || length > maxPacketSize {
+		return 0, nil, fmt.Errorf("invalid SFTP packet length %d", length)
+	}
+	data := make([]byte, length-1)
+	if _, err := io.ReadFull(s.out, data); err != nil {
+		return 0, nil, fmt.Errorf("failed to read SFTP packet: %w", err)
+	}
+	return header[4], data, nil
+}
+
+// receiveResponse reads a response, returning its type, id and the rest.
+func (s *session) receiveResponse() (byte, uint32, []byte, error) {
+	typ, data, err := s.receive()
+	if err != nil {
+		return 0, 0, nil, err
+	}
+	if len(data) < 4 {
+		return 0, 0, nil, fmt.Errorf("invalid SFTP response %d", typ)
+	}
+	return typ, binary.BigEndian.Uint32(data), data[4:], nil
+}
+
+// request sends a request and reads its response, the only one pending.
+func (s *session) request(typ byte, payload []byte) (byte, []byte, error) {
+	id, err := s.sendRequest(typ, payload)
+	if err != nil {
+		return 0, nil, err
+	}
+	respType, respID, data, err := s.receiveResponse()
+	if err != nil {
+		return 0, nil, err
+	}
+	if respID != id {
+		return 0, nil, fmt.Errorf("SFTP response to request %d instead of %d", respID, id)
+	}
+	return respType, data, nil
+}
+
+// statusError returns the error of a STATUS response, or of another response
+// than expected.
+func statusError(typ byte, data []byte) error {
+	if typ != fxpStatus || len(data) < 4 {
+		return fmt.Errorf("unexpected SFTP response %d", typ)
+	}
+	code := binary.BigEndian.Uint32(data)
+	if msg, _, ok := readString(data[4:]); ok && msg != "" {
+		return fmt.Errorf("%s (status %d)", msg, code)
+	}
+	return fmt.Errorf("status %d", code)
+}
+
+// appendString appends s with its length, as strings of the protocol.
+func appendString(b []byte, s string) []byte {
+	b = binary.BigEndian.AppendUint32(b, uint32(len(s)))
+	return append(b, s...)
+}
+
+// readString reads a string of the protocol from b, returning the rest.
+func readString(b []byte) (string, []byte, bool) {
+	if len(b) < 4 {
+		return "", nil, false
+	}
+	n := binary.BigEndian.Uint32(b)
+	if uint64(len(b)-4) < uint64(n) {
+		return "", nil, false
+	}
+	return string(b[4 : 4+n]), b[4+n:], true
+}
+
+// pendingRead is a READ request waiting for its data.
+type pendingRead struct {
+	id     uint32
+	offset int64
+}
+
+// reader reads a file with up to maxPending READ requests ahead.
+type reader struct {
+	session *session
+	client  *ssh.Client
+	handle  string
+	offset  int64 // Of the next READ request
+	pending []pendingRead
+	early   map[uint32][]byte // Responses received before those of earlier requests, with their type first
+	buf     []byte
+	err     error
+	stop    func() bool // Stops closing the client when the context is done
+
+	once     sync.Once
+	closeErr error
+}
+
+func (r *reader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.buf, r.err = r.next()
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+// next returns the data of the first pending READ, io.EOF at the end of the file.
+func (r *reader) next() ([]byte, error) {
+	for len(r.pending) < maxPending {
+		payload := appendString(nil, r.handle)
+		payload = binary.BigEndian.AppendUint64(payload, uint64(r.offset))
+		payload = binary.BigEndian.AppendUint32(payload, readSize)
+		id, err := r.session.sendRequest(fxpRead, payload)
+		if err != nil {
+			return nil, err
+		}
+		r.pending = append(r.pending, pendingRead{id: id, offset: r.offset})
+		r.offset += readSize
+	}
+
+	first := r.pending[0]
+	r.pending = r.pending[1:]
+	typ, data, err := r.response(first.id)
+	if err != nil {
+		return nil, err
+	}
+	if typ == fxpStatus && len(data) >= 4 && binary.BigEndian.Uint32(data) == fxEOF {
+		return nil, io.EOF
+	}
+	chunk, _, ok := readString(data)
+	if typ != fxpData || !ok {
+		return nil, fmt.Errorf("failed to read: %w", statusError(typ, data))
+	}
+	if len(chunk) < readSize {
+		// Short read: the requests sent ahead skipped the rest of the chunk,
+		// drop their data and request again from its end
+		for _, p := range r.pending {
+			if _, _, err := r.response(p.id); err != nil {
+				return nil, err
+			}
+		}
+		r.pending = r.pending[:0]
+		r.offset = first.offset + int64(len(chunk))
+	}
+	return []byte(chunk), nil
+}
+
+// response returns the response to the READ request id, keeping those that
+// arrive before it for later.
+func (r *reader) response(id uint32) (byte, []byte, error) {
+	if data, ok := r.early[id]; ok {
+		delete(r.early, id)
+		return data[0], data[1:], nil
+	}
+	for {
+		typ, respID, data, err := r.session.receiveResponse()
+		if err != nil {
+			return 0, nil, err
+		}
+		if respID == id {
+			return typ, data, nil
+		}
+		if r.early == nil {
+			r.early = make(map[uint32][]byte)
+		}
+		r.early[respID] = append([]byte{typ}, data...)
+	}
+}
+
+// Close ends the session.
+func (r *reader) Close() error {
+	r.once.Do(func() {
+		if r.stop != nil {
+			r.stop()
+		}
+		if r.err == nil {
+			r.err = errors.New("reader closed")
+		}
+		// Closing the handle is best effort, ending the session closes it too
+		r.session.sendRequest(fxpClose, appendString(nil, r.handle))
+		r.session.in.Close()
+		r.closeErr = r.client.Close()
+		if errors.Is(r.closeErr, net.ErrClosed) {
+			r.closeErr = nil
+		}
+	})
+	return r.closeErr
+}
@@ -0,0 +1,257 @@
+package sftp
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// testServer is an SSH server with an SFTP subsystem serving files from
+// memory, just enough for Open.
+type testServer struct {
+	listener net.Listener
+	hostKey  ssh.Signer
+	files    map[string]string
+	maxRead  int // Longest data of READ responses, all that is asked if 0
+}
+
+func newTestServer(t *testing.T, files map[string]string) *testServer {
+	t.Helper()
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostKey, err := ssh.NewSignerFromKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &testServer{listener: listener, hostKey: hostKey, files: files}
+	t.Cleanup(func() { listener.Close() })
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(conn ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			if conn.User() == "user" && string(password) == "secret" {
+				return nil, nil
+			}
+			return nil, errors.New("wrong password")
+		},
+	}
+	config.AddHostKey(hostKey)
+	go func() {
+		for {
+			c, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(c, config)
+		}
+	}()
+	return s
+}
+
+func (s *testServer) url(path string) *url.URL {
+	u, _ := url.Parse("sftp://user:secret@" + s.listener.Addr().String() + path)
+	return u
+}
+
+// config trusts the key of the server and only logs in with the password.
+func (s *testServer) config() *Config {
+	return &Config{Auth: []ssh.AuthMethod{}, HostKeyCallback: ssh.FixedHostKey(s.hostKey.PublicKey())}
+}
+
+func (s *testServer) serve(c net.Conn, config *ssh.ServerConfig) {
+	defer c.Close()
+	_, chans, reqs, err := ssh.NewServerConn(c, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "sessions only")
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			return
+		}
+		go func() {
+			for req := range requests {
+				ok := req.Type == "subsystem" && len(req.Payload) > 4 && string(req.Payload[4:]) == "sftp"
+				req.Reply(ok, nil)
+				if ok {
+					go func() {
+						defer channel.Close()
+						s.serveSFTP(channel)
+					}()
+				}
+			}
+		}()
+	}
+}
+
+func (s *testServer) serveSFTP(channel ssh.Channel) {
+	reply := func(typ byte, id uint32, payload []byte) {
+		packet := binary.BigEndian.AppendUint32(nil, uint32(5+len(payload)))
+		packet = append(packet, typ)
+		packet = binary.BigEndian.AppendUint32(packet, id)
+		channel.Write(append(packet, payload...))
+	}
+	status := func(id uint32, code uint32, msg string) {
+		payload := binary.BigEndian.AppendUint32(nil, code)
+		payload = appendString(payload, msg)
+		reply(fxpStatus, id, appendString(payload, ""))
+	}
+	for {
+		var header [5]byte
+		if _, err := io.ReadFull(channel, header[:]); err != nil {
+			return
+		}
+		data := make([]byte, binary.BigEndian.Uint32(header[:4])-1)
+		if _, err := io.ReadFull(channel, data); err != nil {
+			return
+		}
+		if header[4] == fxpInit {
+			reply(fxpVersion, protoVersion, nil)
+			continue
+		}
+		id := binary.BigEndian.Uint32(data)
+		handle, rest, _ := readString(data[4:])
+		content, found := s.files[handle]
+		switch header[4] {
+		case fxpOpen:
+			if found {
+				reply(fxpHandle, id, appendString(nil, handle))
+			} else {
+				status(id, 2, "no such file")
+			}
+		case fxpFstat:
+			payload := binary.BigEndian.AppendUint32(nil, attrSize)
+			reply(fxpAttrs, id, binary.BigEndian.AppendUint64(payload, uint64(len(content))))
+		case fxpRead:
+			offset := binary.BigEndian.Uint64(rest)
+			length := int(binary.BigEndian.Uint32(rest[8:]))
+			if s.maxRead > 0 {
+				length = min(length, s.maxRead)
+			}
+			if offset >= uint64(len(content)) {
+				status(id, fxEOF, "")
+				continue
+			}
+			reply(fxpData, id, appendString(nil, content[offset:min(int(offset)+length, len(content))]))
+		case fxpClose:
+			status(id, fxOK, "")
+		default:
+			status(id, 8, "unsupported")
+		}
+	}
+}
+
+func readAll(t *testing.T, u *url.URL, offset int64, config *Config) (string, int64) {
+	t.Helper()
+	body, size, err := Open(context.Background(), u, offset, config)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	content, err := io.ReadAll(body)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if err := body.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	return string(content), size
+}
+
+func TestOpen(t *testing.T) {
+	var b strings.Builder
+	for i := 0; b.Len() < (maxPending+3)*readSize; i++ {
+		fmt.Fprintf(&b, "%d,", i)
+	}
+	large := b.String()
+	s := newTestServer(t, map[string]string{"/pub/file.bin": "0123456789", "big": large})
+
+	content, size := readAll(t, s.url("/pub/file.bin"), 0, s.config())
+	if content != "0123456789" || size != 10 {
+		t.Errorf("got %q with size %d, want the whole file with size 10", content, size)
+	}
+
+	content, size = readAll(t, s.url("/pub/file.bin"), 4, s.config())
+	if content != "456789" || size != 10 {
+		t.Errorf("got %q with size %d from offset 4, want %q with size 10", content, size, "456789")
+	}
+
+	if content, size := readAll(t, s.url("/~/big"), 0, s.config()); content != large || size != int64(len(large)) {
+		t.Errorf("got %d bytes with size %d, want the %d bytes of the file", len(content), size, len(large))
+	}
+
+	s.maxRead = 1000
+	if content, _ := readAll(t, s.url("/~/big"), 5, s.config()); content != large[5:] {
+		t.Errorf("got %d bytes with short reads, want the %d bytes of the file from offset 5", len(content), len(large)-5)
+	}
+}
+
+func TestOpenHostKey(t *testing.T) {
+	s := newTestServer(t, map[string]string{"/file": "data"})
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("SSH_AUTH_SOCK", "")
+
+	if _, _, err := Open(context.Background(), s.url("/file"), 0, nil); err == nil || !strings.Contains(err.Error(), "known hosts") {
+		t.Errorf("Open without known hosts: got %v, want a known hosts error", err)
+	}
+
+	other := newTestServer(t, nil).hostKey
+	config := &Config{Auth: []ssh.AuthMethod{}, HostKeyCallback: ssh.FixedHostKey(other.PublicKey())}
+	if _, _, err := Open(context.Background(), s.url("/file"), 0, config); err == nil {
+		t.Error("Open with another host key succeeded, want an error")
+	}
+
+	line := knownhosts.Line([]string{knownhosts.Normalize(s.listener.Addr().String())}, s.hostKey.PublicKey())
+	if err := os.MkdirAll(filepath.Join(home, ".ssh"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".ssh", "known_hosts"), []byte(line+"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if content, _ := readAll(t, s.url("/file"), 0, nil); content != "data" {
+		t.Errorf("got %q with the server in known hosts, want %q", content, "data")
+	}
+}
+
+func TestOpenErrors(t *testing.T) {
+	s := newTestServer(t, map[string]string{"/file": "data"})
+
+	u := s.url("/file")
+	u.User = url.UserPassword("user", "wrong")
+	if _, _, err := Open(context.Background(), u, 0, s.config()); err == nil || !strings.Contains(err.Error(), "login failed") {
+		t.Errorf("Open with a wrong password: got %v, want a login error", err)
+	}
+
+	if _, _, err := Open(context.Background(), s.url("/missing"), 0, s.config()); err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("Open of a missing file: got %v, want a no such file error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, _, err := Open(ctx, s.url("/file"), 0, s.config()); !errors.Is(err, context.Canceled) {
+		t.Errorf("Open with a canceled context: got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,71 @@
+package grab
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// siteHeaderRule holds the default headers of the hosts matching pattern.
+type siteHeaderRule struct {
+	pattern string
+	header  http.Header
+}
+
+var (
+	siteHeaderRules []siteHeaderRule
+	siteHeadersLock sync.RWMutex
+)
+
+// RegisterSiteHeaders registers default headers, typically Referer or Origin,
+// for streams whose host matches pattern (see utils.MatchHost). The downloader
+// adds them to every request of a matching stream that doesn't set them itself.
+// Rules registered later take precedence.
+func RegisterSiteHeaders(pattern string, header http.Header) {
+	siteHeadersLock.Lock()
+	defer siteHeadersLock.Unlock()
+	siteHeaderRules = append(siteHeaderRules, siteHeaderRule{pattern: pattern, header: header.Clone()})
+}
+
+// SiteHeaders returns the default headers registered for the host of rawURL.
+func SiteHeaders(rawURL string) http.Header {
+	siteHeadersLock.RLock()
+	defer siteHeadersLock.RUnlock()
+	return matchSiteHeaders(siteHeaderRules, utils.URLHost(rawURL))
+}
+
+// matchSiteHeaders merges the headers of all rules matching host, later rules winning.
+func matchSiteHeaders(rules []siteHeaderRule, host string) http.Header {
+	header := make(http.Header)
+	for _, rule := range rules {
+		if utils.MatchHost(rule.pattern, host) {
+			for key, values := range rule.header {
+				header[key] = append([]string(nil), values...)
+			}
+		}
+	}
+	return header
+}
+
+// withSiteHeaders returns stream with the default headers of its site added,
+// the registered ones first and then those of Option.SiteHeaders. Headers set
+// by the extractor are kept.
+func (d *Downloader) withSiteHeaders(stream Stream) Stream {
+	defaults := SiteHeaders(stream.URL)
+	for key, values := range matchSiteHeaders(d.ctx.option.siteHeaderRules(), utils.URLHost(stream.URL)) {
+		defaults[key] = values
+	}
+
+	header := stream.Header.Clone()
+	if header == nil {
+		header = make(http.Header)
+	}
+	for key, values := range defaults {
+		if _, ok := header[key]; !ok {
+			header[key] = values
+		}
+	}
+	stream.Header = header
+	return stream
+}
@@ -0,0 +1,470 @@
+package grab
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// TransferPhase is the step a stream download is in.
+type TransferPhase string
+
+const (
+	PhaseProbing     TransferPhase = "probing"     // Asking the server for size and range support
+	PhaseDownloading TransferPhase = "downloading" // Fetching chunks or segments
+	PhaseMerging     TransferPhase = "merging"     // Joining chunks into the output
+	PhaseVerifying   TransferPhase = "verifying"   // Checking and repairing merged segments
+	PhaseConverting  TransferPhase = "converting"  // Converting the format with ffmpeg
+	PhaseDone        TransferPhase = "done"
+	PhaseFailed      TransferPhase = "failed"
+)
+
+// PartSnapshot is the progress of one chunk or HLS segment of a transfer.
+type PartSnapshot struct {
+	Index    int   `json:"index"`
+	Size     int64 `json:"size"` // Expected size in bytes, 0 if unknown
+	Done     int64 `json:"done"` // Bytes received
+	Retries  int   `json:"retries"`
+	Complete bool  `json:"complete"`
+}
+
+// TransferSnapshot is the state of a stream download at one point in time.
+type TransferSnapshot struct {
+	ID           string         `json:"id"` // Unique among the downloads of the Context, unlike StreamID
+	StreamID     string         `json:"stream_id"`
+	Title        string         `json:"title"`
+	URL          string         `json:"url"`
+	Output       string         `json:"output,omitempty"` // Path of the finished file, after conversion
+	Phase        TransferPhase  `json:"phase"`
+	Total        int64          `json:"total"` // Total size in bytes, 0 if unknown
+	Current      int64          `json:"current"`
+	Speed        float64        `json:"speed"`         // Bytes per second since the previous snapshot
+	AverageSpeed float64        `json:"average_speed"` // Bytes per second since the start
+	Attempts     int            `json:"attempts"`      // Attempts of the whole stream, more than 1 after retries
+	Paused       bool           `json:"paused,omitempty"`
+	Skipped      bool           `json:"skipped,omitempty"`   // Already downloaded, nothing was fetched
+	Converted    bool           `json:"converted,omitempty"` // Converted to another format after the download
+	Parts        []PartSnapshot `json:"parts,omitempty"`
+	StartedAt    time.Time      `json:"started_at"`
+	FinishedAt   time.Time      `json:"finished_at,omitzero"`
+	Error        string         `json:"error,omitempty"`
+}
+
+// transfer tracks the download of a single stream for Snapshot. Its methods
+// are no-ops on a nil transfer, so code paths without tracking need no checks.
+type transfer struct {
+	mu        sync.Mutex
+	snapshot  TransferSnapshot
+	progress  *progress
+	lastBytes int64
+	lastTime  time.Time
+	resumed   chan struct{}           // Closed by resume, nil unless paused
+	cancel    context.CancelCauseFunc // Cancels the current download of the stream, nil if none
+
+	// Offsets in the HLS download where timestamps restart, remuxed separately
+	discontinuities []int64
+}
+
+type transferKey struct{}
+
+// withTransfer returns ctx carrying t for the download steps below it.
+func withTransfer(ctx context.Context, t *transfer) context.Context {
+	return context.WithValue(ctx, transferKey{}, t)
+}
+
+// transferFrom returns the transfer carried by ctx, nil if none.
+func transferFrom(ctx context.Context) *transfer {
+	t, _ := ctx.Value(transferKey{}).(*transfer)
+	return t
+}
+
+// startTransfer registers the download of stream, returning the existing
+// transfer if the same output is downloaded again.
+func (d *Downloader) startTransfer(stream Stream) *transfer {
+	d.transfersMu.Lock()
+	defer d.transfersMu.Unlock()
+	key := d.getOutputPath(stream)
+	if t, ok := d.transfers[key]; ok {
+		return t
+	}
+	now := time.Now()
+	t := &transfer{
+		snapshot: TransferSnapshot{
+			ID:        strconv.FormatInt(d.ctx.transferIDs.Add(1), 10),
+			StreamID:  stream.ID,
+			Title:     stream.Title,
+			URL:       stream.URL,
+			Phase:     PhaseProbing,
+			StartedAt: now,
+		},
+		lastTime: now,
+	}
+	if d.transfers == nil {
+		d.transfers = make(map[string]*transfer)
+	}
+	d.transfers[key] = t
+	return t
+}
+
+// transferOf returns the transfer of stream, nil if it wasn't downloaded.
+func (d *Downloader) transferOf(stream Stream) *transfer {
+	d.transfersMu.Lock()
+	defer d.transfersMu.Unlock()
+	return d.transfers[d.getOutputPath(stream)]
+}
+
+// outputOf returns the finished file of stream, "" if none.
+func (d *Downloader) outputOf(stream Stream) string {
+	t := d.transferOf(stream)
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot.Output
+}
+
+// Pause stops reading the in-flight downloads of the stream with the given ID
+// until Resume is called. Connections stay open and received chunks and
+// segments are kept, so the transfer continues where it stopped; if the server
+// drops a connection meanwhile, the chunk is requested again from its offset.
+func (d *Downloader) Pause(streamID string) error {
+	return d.updateTransfers(streamID, (*transfer).pause)
+}
+
+// Resume continues the downloads of the stream paused with Pause.
+func (d *Downloader) Resume(streamID string) error {
+	return d.updateTransfers(streamID, (*transfer).resume)
+}
+
+// Cancel stops the download of the stream with the given ID for good. The
+// stream fails with ErrStreamCanceled while the other streams continue;
+// partial files are kept, so downloading it again later resumes it.
+func (d *Downloader) Cancel(streamID string) error {
+	return d.updateTransfers(streamID, (*transfer).stop)
+}
+
+// updateTransfers applies fn to the unfinished transfers of streamID.
+func (d *Downloader) updateTransfers(streamID string, fn func(*transfer)) error {
+	d.transfersMu.Lock()
+	defer d.transfersMu.Unlock()
+	found := false
+	for _, t := range d.transfers {
+		if t.snapshot.StreamID == streamID && !t.finished() {
+			fn(t)
+			found = true
+		}
+	}
+	if !found {
+		return fmt.Errorf("%w: %s", ErrStreamNotFound, streamID)
+	}
+	return nil
+}
+
+// Snapshot returns the state of every stream download of this Downloader,
+// finished ones included, in the order they started. The result is safe to
+// serialize and keep, e.g. to render a per-file progress grid.
+func (d *Downloader) Snapshot() []TransferSnapshot {
+	d.transfersMu.Lock()
+	transfers := make([]*transfer, 0, len(d.transfers))
+	for _, t := range d.transfers {
+		transfers = append(transfers, t)
+	}
+	d.transfersMu.Unlock()
+
+	snapshots := make([]TransferSnapshot, 0, len(transfers))
+	for _, t := range transfers {
+		snapshots = append(snapshots, t.take())
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return snapshots[i].StartedAt.Before(snapshots[j].StartedAt)
+	})
+	return snapshots
+}
+
+// take copies the state of t and updates the current speed.
+func (t *transfer) take() TransferSnapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	if p := t.progress; p != nil {
+		t.snapshot.Total = p.Total
+		t.snapshot.Current = p.Current.Load()
+	}
+	if elapsed := now.Sub(t.lastTime).Seconds(); elapsed >= 0.5 {
+		t.snapshot.Speed = float64(t.snapshot.Current-t.lastBytes) / elapsed
+		t.lastBytes, t.lastTime = t.snapshot.Current, now
+	}
+	end := now
+	if !t.snapshot.FinishedAt.IsZero() {
+		end = t.snapshot.FinishedAt
+	}
+	if elapsed := end.Sub(t.snapshot.StartedAt).Seconds(); elapsed > 0 {
+		t.snapshot.AverageSpeed = float64(t.snapshot.Current) / elapsed
+	}
+	if t.snapshot.Phase == PhaseDone || t.snapshot.Phase == PhaseFailed {
+		t.snapshot.Speed = 0
+	}
+
+	snapshot := t.snapshot
+	snapshot.Paused = t.resumed != nil
+	snapshot.Parts = append([]PartSnapshot(nil), t.snapshot.Parts...)
+	return snapshot
+}
+
+// id returns the ID of the download, "" for a nil transfer.
+func (t *transfer) id() string {
+	if t == nil {
+		return ""
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot.ID
+}
+
+// attempt records the start of a new attempt at the download.
+func (t *transfer) attempt() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Attempts++
+	t.snapshot.Phase = PhaseProbing
+	t.snapshot.Error = ""
+	t.snapshot.Skipped = false
+	t.snapshot.Converted = false
+	t.snapshot.FinishedAt = time.Time{}
+}
+
+func (t *transfer) finished() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snapshot.Phase == PhaseDone || t.snapshot.Phase == PhaseFailed
+}
+
+func (t *transfer) pause() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resumed == nil {
+		t.resumed = make(chan struct{})
+	}
+}
+
+func (t *transfer) resume() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resumed != nil {
+		close(t.resumed)
+		t.resumed = nil
+	}
+}
+
+// setCancel makes cancel stop the current download of t.
+func (t *transfer) setCancel(cancel context.CancelCauseFunc) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.cancel = cancel
+}
+
+func (t *transfer) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.cancel != nil {
+		t.cancel(ErrStreamCanceled)
+	}
+}
+
+// wait blocks while t is paused.
+func (t *transfer) wait(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	resumed := t.resumed
+	t.mu.Unlock()
+	if resumed == nil {
+		return nil
+	}
+	select {
+	case <-resumed:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (t *transfer) setPhase(phase TransferPhase) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Phase = phase
+}
+
+// setOutput records the path of the finished file.
+func (t *transfer) setOutput(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Output = path
+}
+
+// skip records that the file at path was already downloaded.
+func (t *transfer) skip(path string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Output = path
+	t.snapshot.Skipped = true
+}
+
+// setConverted records that the file was converted to another format.
+func (t *transfer) setConverted() {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Converted = true
+}
+
+// setProgress makes p the source of the byte counts of t.
+func (t *transfer) setProgress(p *progress) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.progress = p
+	t.lastBytes = 0
+}
+
+// setParts replaces the parts of t by parts of the given expected sizes.
+func (t *transfer) setParts(sizes []int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.snapshot.Parts = make([]PartSnapshot, len(sizes))
+	for i, size := range sizes {
+		t.snapshot.Parts[i] = PartSnapshot{Index: i, Size: size}
+	}
+}
+
+// setDiscontinuities records the offsets of the discontinuities of the HLS
+// download, see m3U8Reader.discontinuities.
+func (t *transfer) setDiscontinuities(offsets []int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.discontinuities = offsets
+}
+
+// discontinuityOffsets returns the offsets recorded by setDiscontinuities.
+func (t *transfer) discontinuityOffsets() []int64 {
+	if t == nil {
+		return nil
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.discontinuities
+}
+
+// splitPart moves size bytes at the end of part index to a new part.
+func (t *transfer) splitPart(index int, size int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < len(t.snapshot.Parts) {
+		t.snapshot.Parts[index].Size -= size
+		t.snapshot.Parts = append(t.snapshot.Parts, PartSnapshot{Index: len(t.snapshot.Parts), Size: size})
+	}
+}
+
+// partAdd records n more bytes received for part index.
+func (t *transfer) partAdd(index int, n int64) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < len(t.snapshot.Parts) {
+		part := &t.snapshot.Parts[index]
+		part.Done += n
+		part.Complete = part.Size > 0 && part.Done >= part.Size
+	}
+}
+
+// partComplete marks part index as fully received after retries attempts.
+func (t *transfer) partComplete(index, retries int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if index < len(t.snapshot.Parts) {
+		t.snapshot.Parts[index].Complete = true
+		t.snapshot.Parts[index].Retries = retries
+	}
+}
+
+// partReader counts the bytes read from r towards part index.
+func (t *transfer) partReader(index int, r io.ReadCloser) io.ReadCloser {
+	if t == nil {
+		return r
+	}
+	return &partReader{ReadCloser: r, t: t, index: index}
+}
+
+// finish records the outcome of the download.
+func (t *transfer) finish(err error) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.resumed != nil {
+		close(t.resumed)
+		t.resumed = nil
+	}
+	t.snapshot.FinishedAt = time.Now()
+	if err != nil {
+		t.snapshot.Phase = PhaseFailed
+		t.snapshot.Error = err.Error()
+		return
+	}
+	t.snapshot.Phase = PhaseDone
+	for i := range t.snapshot.Parts {
+		t.snapshot.Parts[i].Complete = true
+	}
+}
+
+type partReader struct {
+	io.ReadCloser
+	t     *transfer
+	index int
+}
+
+func (r *partReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.t.partAdd(r.index, int64(n))
+	return n, err
+}
@@ -0,0 +1,57 @@
+package grab
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// spaceMargin is the free space left on top of a download, for metadata,
+// state files and the rest of the system.
+const spaceMargin = 16 * 1024 * 1024
+
+// checkSpace fails with ErrNoSpace if the file system holding tempPath has
+// less than size bytes free for the download, minus what is already in its
+// partial files, plus extra bytes needed meanwhile. It passes when the free
+// space can't be told or NoCheckSpace is set.
+func (d *Downloader) checkSpace(tempPath string, size, extra int64) error {
+	if d.ctx.option.NoCheckSpace || size <= 0 {
+		return nil
+	}
+	dir := filepath.Dir(tempPath)
+	free, err := utils.FreeSpace(dir)
+	if err != nil {
+		d.ctx.logger.Debug("Failed to check free disk space", "dir", dir, "error", err)
+		return nil
+	}
+	need := max(0, size-partialSize(tempPath)) + extra
+	if free < need+spaceMargin {
+		return fmt.Errorf("%w: %s needs %s, %s available in %s", ErrNoSpace,
+			strings.TrimSuffix(filepath.Base(tempPath), downloadingSuffix), utils.FormatBytes(need), utils.FormatBytes(free), dir)
+	}
+	return nil
+}
+
+// partialSize returns the bytes already written to tempPath and its chunk files.
+func partialSize(tempPath string) int64 {
+	var size int64
+	if fi, err := os.Stat(tempPath); err == nil {
+		size += fi.Size()
+	}
+	entries, err := os.ReadDir(filepath.Dir(tempPath))
+	if err != nil {
+		return size
+	}
+	prefix := filepath.Base(tempPath) + ".part"
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), prefix) && partFilePattern.MatchString(entry.Name()) {
+			if fi, err := entry.Info(); err == nil {
+				size += fi.Size()
+			}
+		}
+	}
+	return size
+}
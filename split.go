@@ -0,0 +1,173 @@
+package grab
+
+import (
+	"errors"
+	"io"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minSplitSize is the smallest range split off a running chunk, whatever the ChunkSize.
+const minSplitSize = 256 * 1024
+
+// errThrottled is returned for chunk requests the server refused with 429 or
+// 503, a sign it wants fewer connections.
+var errThrottled = errors.New("server throttles connections")
+
+// liveChunk is a chunk of a multi-threaded download. Its end moves down when
+// the rest of its range is split off to a connection that finished early.
+type liveChunk struct {
+	idx   int
+	start int64
+
+	mu       sync.Mutex
+	end      int64     // Inclusive, lowered by splits
+	pos      int64     // Offset of the next byte to receive
+	reserved int64     // End of the read in progress, no split may start before it
+	active   bool      // A request is in flight
+	began    time.Time // Start of the current request
+	received int64     // Bytes of the current request
+}
+
+// bounds returns the current range of c.
+func (c *liveChunk) bounds() (start, end int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.start, c.end
+}
+
+// reader returns r, the body of a request for c starting at from, cut at the
+// end of c even when it moves down during the request.
+func (c *liveChunk) reader(r io.Reader, from int64) io.Reader {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pos, c.reserved = from, from
+	c.active, c.began, c.received = true, time.Now(), 0
+	return &liveChunkReader{c: c, r: r}
+}
+
+// idle marks the request of c as finished.
+func (c *liveChunk) idle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.active = false
+}
+
+// timeLeft estimates how long the request of c needs to finish from its
+// throughput so far, infinite if nothing was received yet.
+func (c *liveChunk) timeLeft() float64 {
+	elapsed := time.Since(c.began).Seconds()
+	if c.received == 0 || elapsed <= 0 {
+		return math.Inf(1)
+	}
+	return float64(c.end-c.pos+1) / (float64(c.received) / elapsed)
+}
+
+type liveChunkReader struct {
+	c *liveChunk
+	r io.Reader
+}
+
+func (r *liveChunkReader) Read(p []byte) (int, error) {
+	c := r.c
+	c.mu.Lock()
+	left := c.end - c.pos + 1
+	if left <= 0 {
+		c.mu.Unlock()
+		return 0, io.EOF
+	}
+	if int64(len(p)) > left {
+		p = p[:left]
+	}
+	c.reserved = c.pos + int64(len(p))
+	c.mu.Unlock()
+
+	n, err := r.r.Read(p)
+
+	c.mu.Lock()
+	c.pos += int64(n)
+	c.received += int64(n)
+	c.reserved = c.pos
+	c.mu.Unlock()
+	return n, err
+}
+
+// chunkSplitter rebalances the chunks of a multi-threaded download: when a
+// connection finishes its chunk, it takes over the second half of what is left
+// of the chunk expected to finish last, so slow connections don't hold up the
+// end of the download. It also lowers the number of connections when the
+// server throttles them.
+type chunkSplitter struct {
+	minSize int64 // Smallest range split off
+
+	mu     sync.Mutex
+	chunks []*liveChunk
+
+	waiting atomic.Int32 // Chunks waiting for a connection
+	limit   atomic.Int32 // Connections allowed
+}
+
+// newChunkSplitter returns a splitter for a download using up to connections.
+func newChunkSplitter(connections int, minSize int64) *chunkSplitter {
+	s := &chunkSplitter{minSize: max(minSize, minSplitSize)}
+	s.limit.Store(int32(connections))
+	return s
+}
+
+// add registers the chunk idx covering start to end.
+func (s *chunkSplitter) add(idx int, start, end int64) *liveChunk {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	c := &liveChunk{idx: idx, start: start, end: end}
+	s.chunks = append(s.chunks, c)
+	return c
+}
+
+// split shortens the running chunk expected to finish last and returns the
+// range it gave up, false if no chunk is worth splitting or other chunks are
+// still waiting for a connection.
+func (s *chunkSplitter) split() (victim *liveChunk, start, end int64, ok bool) {
+	if s.waiting.Load() > 0 {
+		return nil, 0, 0, false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var slowest float64
+	for _, c := range s.chunks {
+		c.mu.Lock()
+		if c.active && c.end-c.reserved+1 >= 2*s.minSize {
+			if left := c.timeLeft(); victim == nil || left > slowest {
+				victim, slowest = c, left
+			}
+		}
+		c.mu.Unlock()
+	}
+	if victim == nil {
+		return nil, 0, 0, false
+	}
+	victim.mu.Lock()
+	defer victim.mu.Unlock()
+	start = victim.reserved + (victim.end-victim.reserved+1)/2
+	end = victim.end
+	if end-start+1 < s.minSize || start <= victim.reserved {
+		return nil, 0, 0, false
+	}
+	victim.end = start - 1
+	return victim, start, end, true
+}
+
+// throttle lowers the number of connections by one, returning false if only
+// one is left.
+func (s *chunkSplitter) throttle() bool {
+	for {
+		limit := s.limit.Load()
+		if limit <= 1 {
+			return false
+		}
+		if s.limit.CompareAndSwap(limit, limit-1) {
+			return true
+		}
+	}
+}
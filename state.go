@@ -0,0 +1,504 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// chunkCheckpointSize is how many bytes of a chunk are written between two
+// checkpoints of its checksum in the chunk state.
+const chunkCheckpointSize = 8 * 1024 * 1024
+
+// chunkState records the chunk layout of a multi-threaded download next to its
+// .part file, so an interrupted download resumes with the same ranges even if
+// the thread count changed in between. Ranges split off running chunks are
+// appended, so they are not in file order. Sums holds a checksum of the verified
+// prefix of each chunk in the .part file: bytes beyond it, or chunks not
+// matching it, are never trusted after a crash or a flaky run, nor are chunks
+// of another remote copy, told apart by its ETag or Last-Modified date.
+type chunkState struct {
+	Total  int64      `json:"total"`
+	Ranges [][2]int64 `json:"ranges"` // Inclusive byte ranges of the chunks, in any order
+	Sums   []chunkSum `json:"sums"`   // Verified prefix of each chunk
+
+	remoteValidators // Remote copy the chunks are downloaded from
+
+	mu sync.Mutex // Serializes checkpoints of concurrent chunks
+}
+
+// chunkSum is the SHA-256 of the first Size bytes of a chunk.
+type chunkSum struct {
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// newChunkState splits total bytes into at most threads contiguous ranges of
+// at least minChunkSize bytes, so small files aren't split into tiny requests.
+func newChunkState(total int64, threads int, minChunkSize int64) *chunkState {
+	if minChunkSize > 0 && int64(threads) > total/minChunkSize {
+		threads = int(total / minChunkSize)
+	}
+	if int64(threads) > total {
+		threads = int(total)
+	}
+	threads = max(1, threads)
+	chunkSize := total / int64(threads)
+	state := &chunkState{
+		Total:  total,
+		Ranges: make([][2]int64, threads),
+		Sums:   make([]chunkSum, threads),
+	}
+	for i := range state.Ranges {
+		start := int64(i) * chunkSize
+		end := start + chunkSize - 1
+		if i == threads-1 {
+			end = total - 1
+		}
+		state.Ranges[i] = [2]int64{start, end}
+	}
+	return state
+}
+
+// loadChunkState reads a chunk state file, rejecting layouts that don't cover Total.
+func loadChunkState(path string) (*chunkState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state chunkState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	var next int64
+	for _, i := range state.order() {
+		if state.Ranges[i][0] != next || state.Ranges[i][1] < next {
+			return nil, fmt.Errorf("invalid chunk state in %s", path)
+		}
+		next = state.Ranges[i][1] + 1
+	}
+	if len(state.Ranges) == 0 || next != state.Total {
+		return nil, fmt.Errorf("invalid chunk state in %s", path)
+	}
+	if len(state.Sums) != len(state.Ranges) {
+		// Written without checksums, nothing on disk can be trusted
+		state.Sums = make([]chunkSum, len(state.Ranges))
+	}
+	return &state, nil
+}
+
+// save writes the chunk state to path, syncing it to disk before returning.
+func (s *chunkState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data)
+}
+
+// writeFileSync writes data to the file at path, syncing it to disk before returning.
+func writeFileSync(path string, data []byte) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// order returns the indexes of the chunks in file order.
+func (s *chunkState) order() []int {
+	order := make([]int, len(s.Ranges))
+	for i := range order {
+		order[i] = i
+	}
+	sort.Slice(order, func(a, b int) bool { return s.Ranges[order[a]][0] < s.Ranges[order[b]][0] })
+	return order
+}
+
+// split ends chunk idx before start, adds a chunk from start to its former
+// end and saves the state to path. It returns the index of the new chunk.
+func (s *chunkState) split(path string, idx int, start int64) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	end := s.Ranges[idx][1]
+	s.Ranges[idx][1] = start - 1
+	s.Ranges = append(s.Ranges, [2]int64{start, end})
+	s.Sums = append(s.Sums, chunkSum{})
+	return len(s.Ranges) - 1, s.save(path)
+}
+
+// checkpoint records the checksum of chunk idx and saves the state to path.
+func (s *chunkState) checkpoint(path string, idx int, sum chunkSum) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.Sums[idx] = sum
+	return s.save(path)
+}
+
+// verified returns the bytes of all chunks matching their recorded checksum
+// when last checkpointed.
+func (s *chunkState) verified() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var size int64
+	for _, sum := range s.Sums {
+		size += sum.Size
+	}
+	return size
+}
+
+// verifyChunk checks chunk idx in f, the .part file, against its recorded
+// checksum. It returns the number of bytes that can be reused and a hash
+// holding their state, so that the checksum keeps rolling over the bytes
+// written next. Unverified bytes are simply written over.
+func (s *chunkState) verifyChunk(f *os.File, idx int) (int64, hash.Hash) {
+	h := sha256.New()
+	s.mu.Lock()
+	sum, start := s.Sums[idx], s.Ranges[idx][0]
+	s.mu.Unlock()
+	if sum.Size == 0 {
+		return 0, h
+	}
+
+	_, err := io.Copy(h, io.NewSectionReader(f, start, sum.Size))
+	if err != nil || hex.EncodeToString(h.Sum(nil)) != sum.SHA256 {
+		return 0, sha256.New()
+	}
+	return sum.Size, h
+}
+
+// chunkWriter writes a chunk at its place in the .part file, rolling its
+// checksum forward and checkpointing it every chunkCheckpointSize bytes.
+type chunkWriter struct {
+	f          *os.File
+	start      int64 // Offset of the chunk in the file
+	h          hash.Hash
+	size       int64 // Bytes of the chunk written, all of them hashed
+	unsaved    int64 // Bytes written since the last checkpoint
+	checkpoint func(chunkSum) error
+}
+
+// Write writes p to the file and the hash.
+func (w *chunkWriter) Write(p []byte) (int, error) {
+	n, err := w.f.WriteAt(p, w.start+w.size)
+	w.h.Write(p[:n])
+	w.size += int64(n)
+	w.unsaved += int64(n)
+	if err == nil && w.unsaved >= chunkCheckpointSize {
+		err = w.flush()
+	}
+	return n, err
+}
+
+// flush syncs the file and records its checksum, making the bytes written so far reusable.
+func (w *chunkWriter) flush() error {
+	if err := w.f.Sync(); err != nil {
+		return err
+	}
+	w.unsaved = 0
+	return w.checkpoint(chunkSum{Size: w.size, SHA256: hex.EncodeToString(w.h.Sum(nil))})
+}
+
+// segmentState records the segments of an M3U8 download written to its .part
+// file, in order, so an interrupted download continues after the last of them
+// instead of starting over. Segments are matched by URI without the query, as
+// signed playlists change it between runs, and trusted only if their bytes in
+// the .part file still match the recorded checksum.
+type segmentState struct {
+	Playlist string         `json:"playlist"` // Media playlist URL without query
+	Segments []segmentEntry `json:"segments"`
+}
+
+// segmentEntry is a segment written to the .part file right after the previous one.
+type segmentEntry struct {
+	URI    string `json:"uri"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// loadSegmentState reads a segment state file.
+func loadSegmentState(path string) (*segmentState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state segmentState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	return &state, nil
+}
+
+// save writes the segment state to path, syncing it to disk before returning.
+func (s *segmentState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data)
+}
+
+// verified returns how many segments of the state are, with the URIs of
+// segments, the beginning of the file at path, and their total size.
+func (s *segmentState) verified(path string, segments []*segmentInfo) (int, int64) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0
+	}
+	defer f.Close()
+
+	var offset int64
+	for i, entry := range s.Segments {
+		if i >= len(segments) || entry.URI != withoutQuery(segments[i].URI) {
+			return i, offset
+		}
+		h := sha256.New()
+		if n, err := io.Copy(h, io.NewSectionReader(f, offset, entry.Size)); err != nil || n != entry.Size ||
+			hex.EncodeToString(h.Sum(nil)) != entry.SHA256 {
+			return i, offset
+		}
+		offset += entry.Size
+	}
+	return len(s.Segments), offset
+}
+
+// withoutQuery returns rawURL without its query and fragment.
+func withoutQuery(rawURL string) string {
+	base, _, _ := strings.Cut(rawURL, "?")
+	base, _, _ = strings.Cut(base, "#")
+	return base
+}
+
+// stateDBName is the file name of the state database kept in the output directory.
+const stateDBName = ".grab-state.json"
+
+// quickHashSize is the number of bytes hashed at each end of a file by quickHash.
+const quickHashSize = 64 * 1024
+
+// resumeState records the remote copy a single-connection download is saved
+// from next to its .part file, so an interrupted download is only resumed
+// against the same content.
+type resumeState struct {
+	Total int64 `json:"total"`
+	remoteValidators
+}
+
+// loadResumeState reads the resume state at path. The chunk state of a
+// multi-threaded download, whose .part file is sparse, is refused.
+func loadResumeState(path string) (*resumeState, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var state struct {
+		resumeState
+		Ranges json.RawMessage `json:"ranges"`
+	}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, err
+	}
+	if state.Ranges != nil {
+		return nil, fmt.Errorf("%s is the state of a multi-threaded download", path)
+	}
+	return &state.resumeState, nil
+}
+
+// save writes the resume state to path, syncing it to disk before returning.
+func (s *resumeState) save(path string) error {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return writeFileSync(path, data)
+}
+
+// completedEntry records a finished download in the state database.
+type completedEntry struct {
+	StreamID    string    `json:"stream_id,omitempty"`
+	Size        int64     `json:"size"`
+	QuickHash   string    `json:"quick_hash"`
+	CompletedAt time.Time `json:"completed_at"`
+	remoteValidators
+}
+
+// remoteValidators are the HTTP cache validators of a downloaded resource.
+type remoteValidators struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+}
+
+// matches reports whether v and other identify the same remote copy. Missing
+// validators match only if both are missing.
+func (v remoteValidators) matches(other remoteValidators) bool {
+	if v.ETag != "" || other.ETag != "" {
+		return v.ETag == other.ETag
+	}
+	return v.LastModified == other.LastModified
+}
+
+// ifRange returns the If-Range header value for a request resuming the copy
+// identified by v: its strong ETag or else its Last-Modified date.
+func (v remoteValidators) ifRange() string {
+	if v.ETag != "" && !strings.HasPrefix(v.ETag, "W/") {
+		return v.ETag
+	}
+	return v.LastModified
+}
+
+// stateDB is a small JSON database of completed downloads, keyed by the file
+// path relative to the output directory. Every update re-reads the file under
+// a cross-process lock so concurrent grab instances don't lose each other's entries.
+type stateDB struct {
+	dir       string
+	mu        sync.Mutex
+	Completed map[string]*completedEntry `json:"completed"`
+}
+
+// openStateDB loads the state database of the given output directory.
+// A missing or unreadable database yields an empty one.
+func openStateDB(dir string) *stateDB {
+	db := &stateDB{dir: dir}
+	db.reload()
+	return db
+}
+
+func (db *stateDB) path() string {
+	return filepath.Join(db.dir, stateDBName)
+}
+
+// key returns the database key for path.
+func (db *stateDB) key(path string) string {
+	if rel, err := filepath.Rel(db.dir, path); err == nil {
+		path = rel
+	}
+	return filepath.ToSlash(path)
+}
+
+func (db *stateDB) reload() {
+	db.Completed = make(map[string]*completedEntry)
+	if data, err := os.ReadFile(db.path()); err == nil {
+		json.Unmarshal(data, db)
+	}
+}
+
+// completed returns the entry recorded for path, or nil.
+func (db *stateDB) completed(path string) *completedEntry {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	return db.Completed[db.key(path)]
+}
+
+// markCompleted records path as fully downloaded, along with the validators of the remote copy.
+func (db *stateDB) markCompleted(path string, stream Stream, validators remoteValidators) error {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	hash, err := quickHash(path)
+	if err != nil {
+		return err
+	}
+	return db.update(func() {
+		db.Completed[db.key(path)] = &completedEntry{
+			StreamID:    stream.ID,
+			Size:        fi.Size(),
+			QuickHash:   hash,
+			CompletedAt: time.Now(),
+
+			remoteValidators: validators,
+		}
+	})
+}
+
+// update applies fn to a freshly loaded database and writes it back, holding
+// the database lock so other processes' changes are merged rather than lost.
+func (db *stateDB) update(fn func()) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	if err := os.MkdirAll(db.dir, 0755); err != nil {
+		return err
+	}
+	lock, err := lockWithRetry(db.path()+lockSuffix, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock()
+
+	db.reload()
+	fn()
+	data, err := json.MarshalIndent(db, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := db.path() + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, db.path())
+}
+
+// lockWithRetry acquires the lock at path, waiting up to timeout for other processes.
+func lockWithRetry(path string, timeout time.Duration) (*utils.FileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := utils.TryLock(path)
+		if !errors.Is(err, utils.ErrLocked) || time.Now().After(deadline) {
+			return lock, err
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// quickHash fingerprints a file by its size and the SHA-256 of its first and
+// last quickHashSize bytes, cheap enough to run on every skip check.
+func quickHash(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	h := sha256.New()
+	fmt.Fprintf(h, "%d:", fi.Size())
+	if _, err := io.CopyN(h, f, quickHashSize); err != nil && err != io.EOF {
+		return "", err
+	}
+	if fi.Size() > 2*quickHashSize {
+		if _, err := f.Seek(-quickHashSize, io.SeekEnd); err != nil {
+			return "", err
+		}
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	} else if fi.Size() > quickHashSize {
+		if _, err := io.Copy(h, f); err != nil {
+			return "", err
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,197 @@
+package grab
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+)
+
+// TestNewChunkState verifies files are split in contiguous ranges of at least
+// the minimum chunk size.
+func TestNewChunkState(t *testing.T) {
+	tests := []struct {
+		total        int64
+		threads      int
+		minChunkSize int64
+		want         [][2]int64
+	}{
+		{100, 4, 0, [][2]int64{{0, 24}, {25, 49}, {50, 74}, {75, 99}}},
+		{10, 3, 0, [][2]int64{{0, 2}, {3, 5}, {6, 9}}},
+		{100, 8, 40, [][2]int64{{0, 49}, {50, 99}}},
+		{100, 4, 1000, [][2]int64{{0, 99}}},
+		{2, 4, 0, [][2]int64{{0, 0}, {1, 1}}},
+	}
+	for _, tt := range tests {
+		s := newChunkState(tt.total, tt.threads, tt.minChunkSize)
+		if !slices.Equal(s.Ranges, tt.want) || len(s.Sums) != len(tt.want) {
+			t.Errorf("newChunkState(%d, %d, %d) = %v, want %v", tt.total, tt.threads, tt.minChunkSize, s.Ranges, tt.want)
+		}
+	}
+}
+
+// TestLoadChunkState verifies layouts that don't cover the file are refused,
+// and that layouts without checksums trust nothing.
+func TestLoadChunkState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state string
+		ok    bool
+		sums  int
+	}{
+		{"in order", `{"total":10,"ranges":[[0,4],[5,9]],"sums":[{"size":5,"sha256":"x"},{"size":0}]}`, true, 2},
+		{"split", `{"total":10,"ranges":[[0,2],[5,9],[3,4]],"sums":[{},{},{}]}`, true, 3},
+		{"no checksums", `{"total":10,"ranges":[[0,4],[5,9]]}`, true, 2},
+		{"gap", `{"total":10,"ranges":[[0,3],[5,9]],"sums":[{},{}]}`, false, 0},
+		{"overlap", `{"total":10,"ranges":[[0,5],[5,9]],"sums":[{},{}]}`, false, 0},
+		{"short", `{"total":10,"ranges":[[0,4],[5,8]],"sums":[{},{}]}`, false, 0},
+		{"empty range", `{"total":10,"ranges":[[0,9],[10,8]],"sums":[{},{}]}`, false, 0},
+		{"no ranges", `{"total":0,"ranges":[]}`, false, 0},
+		{"not JSON", `{"total":`, false, 0},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		path := filepath.Join(dir, "file.part.state")
+		if err := os.WriteFile(path, []byte(tt.state), 0644); err != nil {
+			t.Fatal(err)
+		}
+		s, err := loadChunkState(path)
+		if (err == nil) != tt.ok {
+			t.Errorf("loadChunkState(%s) error = %v, want ok %v", tt.name, err, tt.ok)
+			continue
+		}
+		if err == nil && len(s.Sums) != tt.sums {
+			t.Errorf("loadChunkState(%s) has %d sums, want %d", tt.name, len(s.Sums), tt.sums)
+		}
+	}
+	path := filepath.Join(dir, "no-checksums.part.state")
+	if err := os.WriteFile(path, []byte(`{"total":10,"ranges":[[0,4],[5,9]]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := loadChunkState(path); err != nil || s.verified() != 0 {
+		t.Errorf("loadChunkState(no checksums) = %v, %v, want nothing verified", s, err)
+	}
+}
+
+// TestChunkStateResume verifies chunks split and checkpointed by a run are
+// reused by the next only as far as their bytes still match.
+func TestChunkStateResume(t *testing.T) {
+	dir := t.TempDir()
+	statePath := filepath.Join(dir, "file.part.state")
+	part, err := os.Create(filepath.Join(dir, "file.part"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer part.Close()
+
+	content := []byte("0123456789abcdefghij")
+	s := newChunkState(int64(len(content)), 2, 0)
+	idx, err := s.split(statePath, 1, 15)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, n := range []int64{4, 3, 5} { // Prefixes of the chunks written before the interrupt
+		start := s.Ranges[i][0]
+		part.WriteAt(content[start:start+n], start)
+		sum := sha256.Sum256(content[start : start+n])
+		if err := s.checkpoint(statePath, i, chunkSum{Size: n, SHA256: hex.EncodeToString(sum[:])}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	part.WriteAt([]byte("X"), s.Ranges[1][0]+1) // Chunk 1 corrupted after its checkpoint
+
+	loaded, err := loadChunkState(statePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := [][2]int64{{0, 9}, {10, 14}, {15, 19}}; idx != 2 || !slices.Equal(loaded.Ranges, want) {
+		t.Errorf("split chunk %d, loaded ranges %v, want chunk 2 and %v", idx, loaded.Ranges, want)
+	}
+	if got := loaded.verified(); got != 12 {
+		t.Errorf("verified() = %d, want 12", got)
+	}
+	for i, want := range []int64{4, 0, 5} {
+		if got, _ := loaded.verifyChunk(part, i); got != want {
+			t.Errorf("verifyChunk(%d) = %d, want %d", i, got, want)
+		}
+	}
+}
+
+// TestLoadResumeState verifies the state of a multi-threaded download is not
+// taken for that of a single-connection one.
+func TestLoadResumeState(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file.part.state")
+	state := &resumeState{Total: 10, remoteValidators: remoteValidators{ETag: `"abc"`}}
+	if err := state.save(path); err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err := loadResumeState(path); err != nil || *loaded != *state {
+		t.Errorf("loadResumeState = %v, %v, want %v", loaded, err, state)
+	}
+
+	if err := newChunkState(10, 2, 0).save(path); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadResumeState(path); err == nil {
+		t.Error("loadResumeState of a chunk state succeeded, want an error")
+	}
+}
+
+// TestStateDBUpdate verifies concurrent updates through several handles of
+// the same database, as of several grab instances, all make it to the file.
+func TestStateDBUpdate(t *testing.T) {
+	dir := t.TempDir()
+	dbs := []*stateDB{openStateDB(dir), openStateDB(dir), openStateDB(dir)}
+	var wg sync.WaitGroup
+	for i := range 30 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			db := dbs[i%len(dbs)]
+			key := fmt.Sprintf("file%d.mp4", i)
+			if err := db.update(func() { db.Completed[key] = &completedEntry{Size: int64(i)} }); err != nil {
+				t.Errorf("update(%s) failed: %v", key, err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	db := openStateDB(dir)
+	for i := range 30 {
+		if entry := db.completed(filepath.Join(dir, fmt.Sprintf("file%d.mp4", i))); entry == nil || entry.Size != int64(i) {
+			t.Errorf("entry of file%d.mp4 = %v, want size %d", i, entry, i)
+		}
+	}
+}
+
+// TestStateDBMarkCompleted verifies completed files are recorded by their path
+// relative to the output directory, with their fingerprint.
+func TestStateDBMarkCompleted(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "sub", "video.mp4")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("video"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	validators := remoteValidators{ETag: `"v1"`}
+	if err := openStateDB(dir).markCompleted(path, Stream{ID: "hd"}, validators); err != nil {
+		t.Fatalf("markCompleted failed: %v", err)
+	}
+
+	db := openStateDB(dir)
+	entry := db.Completed["sub/video.mp4"]
+	hash, _ := quickHash(path)
+	if entry == nil || entry.StreamID != "hd" || entry.Size != 5 || entry.QuickHash != hash || !entry.matches(validators) {
+		t.Errorf("entry of sub/video.mp4 = %+v, want stream hd, size 5, hash %s and ETag %s", entry, hash, validators.ETag)
+	}
+	if db.completed(filepath.Join(dir, "video.mp4")) != nil {
+		t.Error("completed(video.mp4) found the entry of sub/video.mp4")
+	}
+}
@@ -0,0 +1,85 @@
+package grab
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// StreamCheck is the result of a liveness probe of a stream.
+type StreamCheck struct {
+	Alive    bool   // The stream can be downloaded
+	Status   int    // HTTP status of the probe, 0 if the request failed
+	Size     int64  // Size reported by the server, 0 if unknown
+	FinalURL string `json:",omitempty"` // URL after redirects, empty if not redirected
+	Error    string `json:",omitempty"` // Why the stream is not alive
+}
+
+// CheckStream probes whether stream can still be downloaded without downloading
+// it: a single byte is requested, and for M3U8 streams the playlist is fetched
+// and must start with #EXTM3U.
+func (d *Downloader) CheckStream(ctx context.Context, stream Stream) StreamCheck {
+	req := newMediaRequest(ctx, d.ctx.client, stream.Header)
+	if stream.Type != StreamTypeM3u8 {
+		req.SetHeader("Range", "bytes=0-0")
+	}
+	resp, err := req.Get(stream.URL)
+	if err != nil {
+		return StreamCheck{Error: err.Error()}
+	}
+	defer resp.RawBody().Close()
+
+	check := StreamCheck{Status: resp.StatusCode()}
+	if final := resp.RawResponse.Request.URL.String(); final != stream.URL {
+		check.FinalURL = final
+	}
+	switch resp.StatusCode() {
+	case http.StatusPartialContent:
+		if _, _, total, err := utils.ParseContentRange(resp.Header().Get("Content-Range")); err == nil && total > 0 {
+			check.Size = total
+		}
+	case http.StatusOK:
+		if size, err := strconv.ParseInt(resp.Header().Get("Content-Length"), 10, 64); err == nil && stream.Type != StreamTypeM3u8 {
+			check.Size = size
+		}
+	default:
+		check.Error = "HTTP error: " + resp.Status()
+		return check
+	}
+
+	if stream.Type == StreamTypeM3u8 {
+		head := make([]byte, len("#EXTM3U"))
+		if _, err := io.ReadFull(resp.RawBody(), head); err != nil || !bytes.Equal(head, []byte("#EXTM3U")) {
+			check.Error = "response is not an M3U8 playlist"
+			return check
+		}
+	}
+	check.Alive = true
+	return check
+}
+
+// CheckStreams probes every stream of medias concurrently, using up to Threads
+// connections, and stores the results in their Check field.
+func (d *Downloader) CheckStreams(ctx context.Context, medias []Media) {
+	sem := make(chan struct{}, max(1, d.ctx.option.Threads))
+	var wg sync.WaitGroup
+	for i := range medias {
+		for j := range medias[i].Streams {
+			stream := &medias[i].Streams[j]
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				check := d.CheckStream(ctx, d.withSiteHeaders(*stream))
+				stream.Check = &check
+			}()
+		}
+	}
+	wg.Wait()
+}
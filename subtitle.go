@@ -0,0 +1,118 @@
+package grab
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/hydrz/grab/subtitle"
+)
+
+// defaultSubtitleFormat is used when neither the stream nor its URL tells the format.
+const defaultSubtitleFormat = "srt"
+
+// Subtitles returns the subtitle streams associated with the stream of the given ID.
+func (m *Media) Subtitles(streamID string) []Stream {
+	var subtitles []Stream
+	for _, stream := range m.Streams {
+		if stream.Type != StreamTypeSubtitle {
+			continue
+		}
+		if parent, ok := m.SubtitleParent(stream); ok && parent.ID == streamID {
+			subtitles = append(subtitles, stream)
+		}
+	}
+	return subtitles
+}
+
+// SubtitleParent returns the stream a subtitle belongs to. Subtitles without
+// ParentID belong to the first video stream of the media.
+func (m *Media) SubtitleParent(subtitle Stream) (Stream, bool) {
+	if subtitle.Type != StreamTypeSubtitle {
+		return Stream{}, false
+	}
+	if subtitle.ParentID != "" {
+		return findStream(m.Streams, subtitle.ParentID)
+	}
+	for _, stream := range m.Streams {
+		if stream.Type == StreamTypeVideo || stream.Type == StreamTypeM3u8 {
+			return stream, true
+		}
+	}
+	return Stream{}, false
+}
+
+// nameSubtitle names a subtitle after the stream it belongs to, e.g. video.zh-CN.srt,
+// so that players pick it up next to the video. Other streams are returned unchanged.
+func (d *Downloader) nameSubtitle(media Media, stream Stream) Stream {
+	parent, ok := media.SubtitleParent(stream)
+	if !ok {
+		return stream
+	}
+
+	videoName := d.getOutputFilename(parent)
+	name := strings.TrimSuffix(videoName, path.Ext(videoName))
+	if stream.Language != "" {
+		name += "." + d.sanitizeFilename(stream.Language)
+	}
+	name += "." + subtitleFormat(stream)
+
+	stream.SaveAs = name
+	if parent.SaveAs != "" {
+		stream.SaveAs = path.Join(path.Dir(saveAsPath(parent)), name)
+	}
+	return stream
+}
+
+// subtitleFormat returns the file extension of a subtitle stream without the dot.
+func subtitleFormat(stream Stream) string {
+	if stream.Format != "" {
+		return stream.Format
+	}
+	if u, err := url.Parse(stream.URL); err == nil {
+		if ext := strings.TrimPrefix(path.Ext(u.Path), "."); ext != "" && len(ext) <= 4 {
+			return strings.ToLower(ext)
+		}
+	}
+	return defaultSubtitleFormat
+}
+
+// outputFormat returns the format the file of stream is converted to once
+// downloaded: SubtitleFormat for subtitles, Format for the others. It returns
+// "" if the stream is already in that format or is a part of a merged file.
+func (d *Downloader) outputFormat(stream Stream) string {
+	if isMuxPart(stream) {
+		return ""
+	}
+	if stream.Type == StreamTypeSubtitle {
+		format := subtitle.Normalize(d.ctx.option.SubtitleFormat)
+		if format == subtitle.Normalize(subtitleFormat(stream)) {
+			return ""
+		}
+		return format
+	}
+	if d.ctx.option.Format == stream.Format {
+		return ""
+	}
+	return d.ctx.option.Format
+}
+
+// convertSubtitle converts the subtitle file at inputPath, whatever its format,
+// to outputFormat. Returns the output file path or error.
+func convertSubtitle(inputPath, outputFormat string) (string, error) {
+	data, err := os.ReadFile(inputPath)
+	if err != nil {
+		return "", err
+	}
+	converted, err := subtitle.Convert(data, outputFormat)
+	if err != nil {
+		return "", fmt.Errorf("failed to convert subtitle: %w", err)
+	}
+	outputPath := convertedPath(inputPath, outputFormat)
+	if err := os.WriteFile(outputPath, converted, 0644); err != nil {
+		return "", err
+	}
+	return outputPath, nil
+}
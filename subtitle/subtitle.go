@@ -0,0 +1,109 @@
+// Package subtitle converts subtitles between the SRT, WebVTT and TTML formats.
+package subtitle
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Formats supported by Parse and Write, named after their usual file extension.
+const (
+	SRT  = "srt"
+	VTT  = "vtt"
+	TTML = "ttml"
+)
+
+// ErrUnknownFormat is returned for formats other than SRT, WebVTT and TTML.
+var ErrUnknownFormat = errors.New("unknown subtitle format")
+
+// Cue is a piece of text shown between Start and End. Lines of Text are
+// separated by '\n'; <b>, <i> and <u> tags are the only markup kept.
+type Cue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+// Normalize returns the canonical name of a subtitle format or file extension,
+// e.g. "vtt" for "WebVTT" and "ttml" for "dfxp". Unknown formats are returned
+// lower cased.
+func Normalize(format string) string {
+	format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+	switch format {
+	case "webvtt":
+		return VTT
+	case "dfxp", "xml", "ttml2":
+		return TTML
+	}
+	return format
+}
+
+// Detect returns the format of data, sniffed from its first bytes, or "" if it
+// is not a subtitle.
+func Detect(data []byte) string {
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\uFEFF")))
+	switch {
+	case bytes.HasPrefix(data, []byte("WEBVTT")):
+		return VTT
+	case bytes.HasPrefix(data, []byte("<")) && bytes.Contains(data, []byte("<tt")):
+		return TTML
+	case bytes.Contains(firstBlock(data), []byte("-->")):
+		return SRT
+	}
+	return ""
+}
+
+// firstBlock returns data up to its first blank line.
+func firstBlock(data []byte) []byte {
+	data = bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n"))
+	if i := bytes.Index(data, []byte("\n\n")); i >= 0 {
+		return data[:i]
+	}
+	return data
+}
+
+// Parse reads the cues of data, whatever its format.
+func Parse(data []byte) ([]Cue, error) {
+	switch Detect(data) {
+	case SRT:
+		return parseSRT(data)
+	case VTT:
+		return parseVTT(data)
+	case TTML:
+		return parseTTML(data)
+	}
+	return nil, fmt.Errorf("%w: unrecognized subtitle content", ErrUnknownFormat)
+}
+
+// Write returns cues encoded in format.
+func Write(cues []Cue, format string) ([]byte, error) {
+	switch Normalize(format) {
+	case SRT:
+		return writeSRT(cues), nil
+	case VTT:
+		return writeVTT(cues), nil
+	case TTML:
+		return writeTTML(cues), nil
+	}
+	return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+}
+
+// Convert returns data, a subtitle of any supported format, encoded in format.
+// Data already in format is returned unchanged.
+func Convert(data []byte, format string) ([]byte, error) {
+	format = Normalize(format)
+	if format != SRT && format != VTT && format != TTML {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownFormat, format)
+	}
+	if Detect(data) == format {
+		return data, nil
+	}
+	cues, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+	return Write(cues, format)
+}
@@ -0,0 +1,117 @@
+package subtitle
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "1\r\n00:00:01,000 --> 00:00:02,500\r\nHello <i>world</i>\r\n\r\n2\r\n00:01:02,005 --> 00:01:04,000\r\n<font color=\"red\">Second</font>\r\nline & more\r\n"
+
+const testVTT = `WEBVTT
+Kind: captions
+
+NOTE a comment
+
+intro
+00:01.000 --> 00:02.500 align:start
+<v Bob>Hello <i>world</i></v>
+
+00:01:02.005 --> 00:01:04.000
+<c.red>Second</c>
+line &amp; more
+`
+
+const testTTML = `<?xml version="1.0" encoding="UTF-8"?>
+<tt xmlns="http://www.w3.org/ns/ttml" xmlns:ttp="http://www.w3.org/ns/ttml#parameter" ttp:tickRate="10000000">
+  <body><div>
+    <p begin="10000000t" end="25000000t">Hello <span tts:fontStyle="italic">world</span></p>
+    <p begin="00:01:02.005" dur="1995ms">
+      Second<br/>line &amp; more
+    </p>
+  </div></body>
+</tt>`
+
+var testCues = []Cue{
+	{Start: time.Second, End: 2500 * time.Millisecond, Text: "Hello <i>world</i>"},
+	{Start: 62005 * time.Millisecond, End: 64 * time.Second, Text: "Second\nline & more"},
+}
+
+// TestDetect verifies formats are sniffed from content.
+func TestDetect(t *testing.T) {
+	tests := map[string]string{
+		testSRT:                  SRT,
+		"\uFEFF" + testVTT:       VTT,
+		testTTML:                 TTML,
+		"<html><body></body>":    "",
+		"just some plain text\n": "",
+	}
+	for data, want := range tests {
+		if got := Detect([]byte(data)); got != want {
+			t.Errorf("Detect(%.20q) = %q, want %q", data, got, want)
+		}
+	}
+}
+
+// TestParse verifies the three formats are read into the same cues.
+func TestParse(t *testing.T) {
+	for name, data := range map[string]string{"srt": testSRT, "vtt": testVTT} {
+		cues, err := Parse([]byte(data))
+		if err != nil {
+			t.Fatalf("Parse(%s) error: %v", name, err)
+		}
+		if !reflect.DeepEqual(cues, testCues) {
+			t.Errorf("Parse(%s) = %#v, want %#v", name, cues, testCues)
+		}
+	}
+
+	cues, err := Parse([]byte(testTTML))
+	if err != nil {
+		t.Fatalf("Parse(ttml) error: %v", err)
+	}
+	want := append([]Cue{}, testCues...)
+	want[0].Text = "Hello world" // TTML styling is dropped
+	if !reflect.DeepEqual(cues, want) {
+		t.Errorf("Parse(ttml) = %#v, want %#v", cues, want)
+	}
+}
+
+// TestConvert verifies conversions round trip and unknown input is refused.
+func TestConvert(t *testing.T) {
+	srt, err := Convert([]byte(testVTT), "srt")
+	if err != nil {
+		t.Fatalf("Convert to srt error: %v", err)
+	}
+	want := "1\n00:00:01,000 --> 00:00:02,500\nHello <i>world</i>\n\n2\n00:01:02,005 --> 00:01:04,000\nSecond\nline & more\n\n"
+	if string(srt) != want {
+		t.Errorf("Convert to srt = %q, want %q", srt, want)
+	}
+
+	vtt, err := Convert(srt, "WebVTT")
+	if err != nil {
+		t.Fatalf("Convert to vtt error: %v", err)
+	}
+	if !strings.HasPrefix(string(vtt), "WEBVTT") || !strings.Contains(string(vtt), "line &amp; more") {
+		t.Errorf("Convert to vtt = %q", vtt)
+	}
+
+	ttml, err := Convert(vtt, "dfxp")
+	if err != nil {
+		t.Fatalf("Convert to ttml error: %v", err)
+	}
+	cues, err := Parse(ttml)
+	if err != nil {
+		t.Fatalf("Parse converted ttml error: %v", err)
+	}
+	if len(cues) != 2 || cues[1] != testCues[1] {
+		t.Errorf("TTML round trip = %#v", cues)
+	}
+
+	if _, err := Convert([]byte(testSRT), "ass"); err == nil {
+		t.Errorf("Convert to ass should fail")
+	}
+	if _, err := Convert([]byte("<html></html>"), "srt"); err == nil {
+		t.Errorf("Convert of a web page should fail")
+	}
+}
@@ -0,0 +1,138 @@
+package subtitle
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timingPattern matches the timing line of an SRT or WebVTT cue. Hours are
+// optional in WebVTT and SRT files in the wild use '.' as often as ','.
+var timingPattern = regexp.MustCompile(`^\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s*-->\s*((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+
+// blankLinePattern separates the blocks of SRT and WebVTT files.
+var blankLinePattern = regexp.MustCompile(`\n\s*\n`)
+
+// tagPattern matches the markup of a cue, kept only for <b>, <i> and <u>.
+var tagPattern = regexp.MustCompile(`</?([a-zA-Z0-9]+)[^>]*>`)
+
+// parseSRT reads SubRip cues: an optional counter, the timing line and the text.
+func parseSRT(data []byte) ([]Cue, error) {
+	return parseBlocks(data, func(text string) string { return cleanTags(text) })
+}
+
+// parseVTT reads WebVTT cues, skipping the header, NOTE, STYLE and REGION blocks.
+func parseVTT(data []byte) ([]Cue, error) {
+	return parseBlocks(data, func(text string) string { return html.UnescapeString(cleanTags(text)) })
+}
+
+// parseBlocks reads the blank line separated blocks of data having a timing
+// line as cues, their text transformed by clean.
+func parseBlocks(data []byte, clean func(string) string) ([]Cue, error) {
+	data = bytes.TrimPrefix(data, []byte("\uFEFF"))
+	text := strings.ReplaceAll(string(data), "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+
+	var cues []Cue
+	for _, block := range blankLinePattern.Split(text, -1) {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			m := timingPattern.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			start, err := parseTimestamp(m[1])
+			if err != nil {
+				return nil, err
+			}
+			end, err := parseTimestamp(m[2])
+			if err != nil {
+				return nil, err
+			}
+			cues = append(cues, Cue{Start: start, End: end, Text: clean(strings.Join(lines[i+1:], "\n"))})
+			break
+		}
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no cues found")
+	}
+	return cues, nil
+}
+
+// parseTimestamp parses [hh:]mm:ss.ttt, with ',' accepted as decimal separator.
+func parseTimestamp(s string) (time.Duration, error) {
+	s = strings.ReplaceAll(s, ",", ".")
+	var seconds float64
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		seconds = seconds*60 + n
+	}
+	d := time.Duration(seconds * float64(time.Second))
+	return d.Round(time.Millisecond), nil
+}
+
+// cleanTags removes the markup of text but <b>, <i> and <u>, e.g. WebVTT voice
+// and class spans or SRT font tags.
+func cleanTags(text string) string {
+	return tagPattern.ReplaceAllStringFunc(text, func(tag string) string {
+		switch name := strings.ToLower(tagPattern.FindStringSubmatch(tag)[1]); name {
+		case "b", "i", "u":
+			if strings.HasPrefix(tag, "</") {
+				return "</" + name + ">"
+			}
+			return "<" + name + ">"
+		}
+		return ""
+	})
+}
+
+// formatTimestamp formats d as hh:mm:ss followed by sep and milliseconds.
+func formatTimestamp(d time.Duration, sep string) string {
+	d = max(0, d.Round(time.Millisecond))
+	ms := d / time.Millisecond
+	return fmt.Sprintf("%02d:%02d:%02d%s%03d", ms/3600000, ms/60000%60, ms/1000%60, sep, ms%1000)
+}
+
+// writeSRT encodes cues as SubRip.
+func writeSRT(cues []Cue) []byte {
+	var b bytes.Buffer
+	for i, cue := range cues {
+		fmt.Fprintf(&b, "%d\n%s --> %s\n%s\n\n", i+1,
+			formatTimestamp(cue.Start, ","), formatTimestamp(cue.End, ","), cue.Text)
+	}
+	return b.Bytes()
+}
+
+// writeVTT encodes cues as WebVTT.
+func writeVTT(cues []Cue) []byte {
+	var b bytes.Buffer
+	b.WriteString("WEBVTT\n\n")
+	for _, cue := range cues {
+		fmt.Fprintf(&b, "%s --> %s\n%s\n\n",
+			formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), escapeText(cue.Text))
+	}
+	return b.Bytes()
+}
+
+// textEscaper escapes the characters WebVTT and TTML reserve for markup.
+var textEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escapeText escapes text for WebVTT and TTML, keeping its <b>, <i> and <u> tags.
+func escapeText(text string) string {
+	var b strings.Builder
+	last := 0
+	for _, loc := range tagPattern.FindAllStringIndex(text, -1) {
+		b.WriteString(textEscaper.Replace(text[last:loc[0]]))
+		b.WriteString(text[loc[0]:loc[1]])
+		last = loc[1]
+	}
+	b.WriteString(textEscaper.Replace(text[last:]))
+	return b.String()
+}
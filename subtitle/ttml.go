@@ -0,0 +1,182 @@
+package subtitle
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ttmlOffsetPattern matches TTML offset times such as "1.5s" or "15000000t".
+var ttmlOffsetPattern = regexp.MustCompile(`^(\d+(?:\.\d+)?)(h|m|s|ms|f|t)$`)
+
+// whitespacePattern matches the runs of whitespace TTML collapses.
+var whitespacePattern = regexp.MustCompile(`\s+`)
+
+// ttmlTiming holds the frame and tick rates declared on the tt element.
+type ttmlTiming struct {
+	frameRate float64
+	tickRate  float64
+}
+
+// parseTTML reads the p elements of a TTML (DFXP) document as cues. Styling is
+// dropped and br elements become line breaks.
+func parseTTML(data []byte) ([]Cue, error) {
+	decoder := xml.NewDecoder(bytes.NewReader(data))
+	decoder.Strict = false
+	decoder.Entity = xml.HTMLEntity
+
+	timing := ttmlTiming{frameRate: 30, tickRate: 1}
+	var cues []Cue
+	var cue *Cue
+	var text strings.Builder
+	for {
+		token, err := decoder.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("invalid TTML: %w", err)
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "tt":
+				timing = ttmlRates(t.Attr, timing)
+			case "p":
+				c, err := ttmlCue(t.Attr, timing)
+				if err != nil {
+					return nil, err
+				}
+				cue = c
+				text.Reset()
+			case "br":
+				if cue != nil {
+					text.WriteString("\n")
+				}
+			}
+		case xml.CharData:
+			if cue != nil {
+				// Whitespace collapses to one space, lines are trimmed at the end of p
+				text.WriteString(whitespacePattern.ReplaceAllString(string(t), " "))
+			}
+		case xml.EndElement:
+			if t.Name.Local == "p" && cue != nil {
+				lines := strings.Split(text.String(), "\n")
+				for i := range lines {
+					lines[i] = strings.Join(strings.Fields(lines[i]), " ")
+				}
+				cue.Text = strings.Join(lines, "\n")
+				cues = append(cues, *cue)
+				cue = nil
+			}
+		}
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no cues found")
+	}
+	return cues, nil
+}
+
+// ttmlRates returns timing updated with the rates declared by the attributes of a tt element.
+func ttmlRates(attrs []xml.Attr, timing ttmlTiming) ttmlTiming {
+	for _, attr := range attrs {
+		rate, err := strconv.ParseFloat(attr.Value, 64)
+		if err != nil || rate <= 0 {
+			continue
+		}
+		switch attr.Name.Local {
+		case "frameRate":
+			timing.frameRate = rate
+		case "tickRate":
+			timing.tickRate = rate
+		}
+	}
+	return timing
+}
+
+// ttmlCue returns the cue timed by the begin, end and dur attributes of a p element.
+func ttmlCue(attrs []xml.Attr, timing ttmlTiming) (*Cue, error) {
+	var cue Cue
+	var dur time.Duration
+	hasEnd := false
+	for _, attr := range attrs {
+		var err error
+		switch attr.Name.Local {
+		case "begin":
+			cue.Start, err = parseTTMLTime(attr.Value, timing)
+		case "end":
+			cue.End, err = parseTTMLTime(attr.Value, timing)
+			hasEnd = true
+		case "dur":
+			dur, err = parseTTMLTime(attr.Value, timing)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if !hasEnd {
+		cue.End = cue.Start + dur
+	}
+	return &cue, nil
+}
+
+// parseTTMLTime parses a TTML clock time (hh:mm:ss.fff or hh:mm:ss:ff) or
+// offset time (e.g. "1.5s", "150ms", "15000000t").
+func parseTTMLTime(s string, timing ttmlTiming) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if m := ttmlOffsetPattern.FindStringSubmatch(s); m != nil {
+		n, _ := strconv.ParseFloat(m[1], 64)
+		var seconds float64
+		switch m[2] {
+		case "h":
+			seconds = n * 3600
+		case "m":
+			seconds = n * 60
+		case "s":
+			seconds = n
+		case "ms":
+			seconds = n / 1000
+		case "f":
+			seconds = n / timing.frameRate
+		case "t":
+			seconds = n / timing.tickRate
+		}
+		return time.Duration(seconds * float64(time.Second)).Round(time.Millisecond), nil
+	}
+
+	parts := strings.Split(s, ":")
+	if len(parts) == 4 {
+		// Frames after the seconds
+		d, err := parseTimestamp(strings.Join(parts[:3], ":"))
+		if err != nil {
+			return 0, err
+		}
+		frames, err := strconv.ParseFloat(parts[3], 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid timestamp %q", s)
+		}
+		return d + time.Duration(frames/timing.frameRate*float64(time.Second)).Round(time.Millisecond), nil
+	}
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	return parseTimestamp(s)
+}
+
+// writeTTML encodes cues as a TTML document.
+func writeTTML(cues []Cue) []byte {
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<tt xmlns="http://www.w3.org/ns/ttml">` + "\n<body>\n<div>\n")
+	for _, cue := range cues {
+		text := textEscaper.Replace(tagPattern.ReplaceAllString(cue.Text, ""))
+		fmt.Fprintf(&b, "<p begin=\"%s\" end=\"%s\">%s</p>\n",
+			formatTimestamp(cue.Start, "."), formatTimestamp(cue.End, "."), strings.ReplaceAll(text, "\n", "<br/>"))
+	}
+	b.WriteString("</div>\n</body>\n</tt>\n")
+	return b.Bytes()
+}
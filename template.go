@@ -0,0 +1,110 @@
+package grab
+
+import (
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hydrz/grab/utils"
+)
+
+// outputTemplateField matches a {field} of an output template.
+var outputTemplateField = regexp.MustCompile(`\{([a-z_]+)\}`)
+
+// outputTemplateMissing replaces the fields a media has no value for.
+const outputTemplateMissing = "NA"
+
+// isOutputTemplate reports whether the OutputName name has {field}
+// placeholders, e.g. "{series}/{episode} - {title}".
+func isOutputTemplate(name string) bool {
+	return outputTemplateField.MatchString(name)
+}
+
+// outputTemplateValues returns the values of the fields of an output template
+// for stream of media, empty for those it doesn't have.
+func outputTemplateValues(media Media, stream Stream) map[string]string {
+	values := map[string]string{
+		"title":     media.Title,
+		"id":        media.ID,
+		"uploader":  media.Uploader,
+		"series":    media.Series,
+		"season":    media.Season,
+		"language":  media.Language,
+		"stream_id": stream.ID,
+		"quality":   stream.Quality,
+		"ext":       stream.Format,
+	}
+	if values["title"] == "" {
+		values["title"] = stream.Title
+	}
+	if values["ext"] == "" {
+		values["ext"] = "mp4"
+	}
+	if !media.UploadDate.IsZero() {
+		values["upload_date"] = media.UploadDate.Format(time.DateOnly)
+	}
+	if d := media.duration(); d > 0 {
+		values["duration"] = strconv.Itoa(int(d.Seconds()))
+	}
+	if media.Episode > 0 {
+		values["episode"] = strconv.Itoa(media.Episode)
+	}
+	return values
+}
+
+// expandOutputTemplate returns the path of stream of media named by tmpl,
+// each field sanitized with sanitize. The extension of the stream is added
+// unless tmpl ends with one, e.g. ".{ext}".
+func expandOutputTemplate(tmpl string, media Media, stream Stream, sanitize func(string) string) string {
+	values := outputTemplateValues(media, stream)
+	var parts []string
+	for _, part := range strings.Split(strings.ReplaceAll(tmpl, "\\", "/"), "/") {
+		part = outputTemplateField.ReplaceAllStringFunc(part, func(field string) string {
+			value, ok := values[field[1:len(field)-1]]
+			if !ok {
+				return field // Unknown fields are kept as typed
+			}
+			if value = sanitize(value); value == "" {
+				return outputTemplateMissing
+			}
+			return value
+		})
+		if part != "" && part != "." && part != ".." {
+			parts = append(parts, part)
+		}
+	}
+	name := path.Join(parts...)
+	if name == "" {
+		name = outputTemplateMissing
+	}
+	if ext := utils.FileExtension(path.Base(tmpl)); ext == "" || (strings.ContainsAny(ext, "{}") && ext != ".{ext}") {
+		name += "." + values["ext"]
+	}
+	return name
+}
+
+// applyOutputTemplate names the streams of media after the OutputName
+// template, if it is one. A template without directories replaces the file
+// name of the streams in the directory their extractor chose, one with
+// directories their whole path. Subtitles are named after their video later.
+func (d *Downloader) applyOutputTemplate(media Media) Media {
+	tmpl := d.ctx.option.OutputName
+	if !isOutputTemplate(tmpl) {
+		return media
+	}
+	streams := make([]Stream, len(media.Streams))
+	for i, stream := range media.Streams {
+		if stream.Type != StreamTypeSubtitle {
+			name := expandOutputTemplate(tmpl, media, stream, d.sanitizeFilename)
+			if !strings.ContainsAny(tmpl, `/\`) && stream.SaveAs != "" {
+				name = path.Join(path.Dir(saveAsPath(stream)), name)
+			}
+			stream.SaveAs = name
+		}
+		streams[i] = stream
+	}
+	media.Streams = streams
+	return media
+}
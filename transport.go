@@ -0,0 +1,147 @@
+package grab
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/hydrz/grab/ftp"
+	"github.com/hydrz/grab/sftp"
+	"github.com/hydrz/grab/utils"
+)
+
+// Transport downloads the streams of a URL scheme other than http(s), e.g.
+// ftp. Streams whose URL has the scheme of a registered transport are
+// downloaded with it over a single connection, resuming where an earlier
+// attempt stopped.
+type Transport interface {
+	// Open returns the content of the file at rawURL from offset, and the
+	// size of the whole file, -1 if unknown.
+	Open(ctx context.Context, rawURL string, offset int64) (io.ReadCloser, int64, error)
+}
+
+var (
+	transportsLock sync.RWMutex
+	transports     = map[string]Transport{
+		"ftp":  ftpTransport{},
+		"ftps": ftpTransport{},
+		"sftp": sftpTransport{},
+	}
+)
+
+// RegisterTransport registers the transport of a URL scheme, replacing the
+// built-in one of ftp, ftps or sftp.
+func RegisterTransport(scheme string, t Transport) {
+	transportsLock.Lock()
+	defer transportsLock.Unlock()
+	transports[strings.ToLower(scheme)] = t
+}
+
+// transportFor returns the transport of the scheme of rawURL, nil for http(s)
+// and unknown schemes.
+func transportFor(rawURL string) Transport {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil
+	}
+	transportsLock.RLock()
+	defer transportsLock.RUnlock()
+	return transports[strings.ToLower(u.Scheme)]
+}
+
+// downloadTransport downloads stream with transport into tempPath, keeping
+// the bytes a previous attempt left there.
+func (d *Downloader) downloadTransport(ctx context.Context, transport Transport, stream Stream, tempPath string) error {
+	hasher, err := newStreamHasher(stream)
+	if err != nil {
+		return err
+	}
+	var offset int64
+	if fi, err := os.Stat(tempPath); err == nil {
+		offset = fi.Size()
+	}
+
+	body, size, err := transport.Open(ctx, stream.URL, offset)
+	if errors.Is(err, ftp.ErrResumeUnsupported) {
+		d.ctx.logger.Debug("Server can't resume, restarting", "stream", stream.ID)
+		offset = 0
+		body, size, err = transport.Open(ctx, stream.URL, 0)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open remote file: %w", err)
+	}
+	defer body.Close()
+	totalSize := stream.Size
+	if size >= 0 {
+		totalSize = size
+	}
+	if err := d.checkSpace(tempPath, totalSize, offset); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(tempPath, os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to create output file: %w", err)
+	}
+	defer file.Close()
+	if err := file.Truncate(offset); err != nil {
+		return fmt.Errorf("failed to truncate output file: %w", err)
+	}
+	if offset > 0 {
+		if err := hashFile(hasher, tempPath); err != nil {
+			return err
+		}
+	}
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to seek output file: %w", err)
+	}
+
+	progress := d.newProgress(ctx, stream, totalSize)
+	progress.Add(offset)
+	t := transferFrom(ctx)
+	t.setPhase(PhaseDownloading)
+	t.setParts([]int64{max(totalSize, 0)})
+	t.partAdd(0, offset)
+
+	reader := progress.NewReader(body)
+	reader = t.partReader(0, d.limitReader(ctx, reader, utils.NewBucket(d.ctx.option.RateLimitPerStream)))
+	written, err := d.copyWithContext(ctx, hasher.writer(file), reader)
+	if err != nil {
+		return fmt.Errorf("failed to write to output file: %w", err)
+	}
+	if err := body.Close(); err != nil {
+		return err
+	}
+	if size >= 0 && offset+written != size {
+		return fmt.Errorf("incomplete download: got %d of %d bytes", offset+written, size)
+	}
+	return hasher.verify()
+}
+
+// ftpTransport downloads ftp and ftps URLs, see package ftp.
+type ftpTransport struct{}
+
+func (ftpTransport) Open(ctx context.Context, rawURL string, offset int64) (io.ReadCloser, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, -1, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	return ftp.Open(ctx, u, offset, nil)
+}
+
+// sftpTransport downloads sftp URLs, see package sftp. Keys, the ssh-agent
+// and known hosts are those of ~/.ssh.
+type sftpTransport struct{}
+
+func (sftpTransport) Open(ctx context.Context, rawURL string, offset int64) (io.ReadCloser, int64, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, -1, fmt.Errorf("%w: %v", ErrInvalidURL, err)
+	}
+	return sftp.Open(ctx, u, offset, nil)
+}
@@ -0,0 +1,161 @@
+package grab
+
+import (
+	"fmt"
+	"sort"
+)
+
+const (
+	tsPacketSize = 188
+	tsSyncByte   = 0x47
+	tsNullPID    = 0x1fff
+
+	pcrWrap   = int64(1) << 33 // PCR base is a 33-bit counter of a 90kHz clock
+	pcrMaxGap = 90000          // One second, ISO 13818-1 requires a PCR at least every 100ms
+)
+
+// tsPID holds the continuity state of a single PID.
+type tsPID struct {
+	cc      int   // Last continuity counter, -1 if no payload was seen yet
+	pcr     int64 // Last PCR base, -1 if none was seen yet
+	segment int   // Segment of the last packet
+}
+
+// tsChecker scans an MPEG-TS stream, fed segment by segment, for continuity
+// counter gaps and PCR jumps betraying dropped, duplicated or truncated segments.
+// Streams not starting with a sync byte, such as fMP4, are not checked.
+type tsChecker struct {
+	buf      []byte // Bytes of an incomplete packet
+	started  bool
+	disabled bool
+	segment  int
+	pids     map[int]*tsPID
+	issues   map[int]string // Segment index -> first issue found in it
+}
+
+func newTSChecker() *tsChecker {
+	return &tsChecker{
+		pids:   make(map[int]*tsPID),
+		issues: make(map[int]string),
+	}
+}
+
+// startSegment marks the beginning of segment index. After an EXT-X-DISCONTINUITY
+// counters and clocks restart, so previous state is forgotten.
+func (c *tsChecker) startSegment(index int, discontinuity bool) {
+	c.finish()
+	c.segment = index
+	if discontinuity {
+		c.pids = make(map[int]*tsPID)
+	}
+}
+
+// finish reports a trailing incomplete packet of the current segment.
+func (c *tsChecker) finish() {
+	if len(c.buf) > 0 && !c.disabled {
+		c.report(c.segment, fmt.Sprintf("segment ends with an incomplete packet of %d bytes", len(c.buf)))
+	}
+	c.buf = c.buf[:0]
+}
+
+// Write feeds the next bytes of the current segment. It never fails.
+func (c *tsChecker) Write(p []byte) (int, error) {
+	if c.disabled {
+		return len(p), nil
+	}
+	c.buf = append(c.buf, p...)
+	i := 0
+	for len(c.buf)-i >= tsPacketSize {
+		if c.buf[i] != tsSyncByte {
+			if !c.started {
+				c.disabled = true
+				c.buf = nil
+				return len(p), nil
+			}
+			c.report(c.segment, "lost packet sync")
+			i++
+			continue
+		}
+		c.started = true
+		c.packet(c.buf[i : i+tsPacketSize])
+		i += tsPacketSize
+	}
+	c.buf = append(c.buf[:0], c.buf[i:]...)
+	return len(p), nil
+}
+
+// packet checks the continuity counter and PCR of a single packet.
+func (c *tsChecker) packet(pkt []byte) {
+	pid := int(pkt[1]&0x1f)<<8 | int(pkt[2])
+	if pid == tsNullPID {
+		return
+	}
+	control := pkt[3] >> 4 & 0x3
+	hasPayload := control&0x1 != 0
+	cc := int(pkt[3] & 0xf)
+
+	discontinuity := false
+	pcr := int64(-1)
+	if control&0x2 != 0 && pkt[4] > 0 {
+		flags := pkt[5]
+		discontinuity = flags&0x80 != 0
+		if flags&0x10 != 0 && pkt[4] >= 7 {
+			pcr = int64(pkt[6])<<25 | int64(pkt[7])<<17 | int64(pkt[8])<<9 | int64(pkt[9])<<1 | int64(pkt[10])>>7
+		}
+	}
+
+	state, ok := c.pids[pid]
+	if !ok || discontinuity {
+		state = &tsPID{cc: -1, pcr: -1}
+		c.pids[pid] = state
+	}
+
+	if hasPayload {
+		// A single duplicate packet is allowed by ISO 13818-1
+		if state.cc >= 0 && cc != (state.cc+1)&0xf && cc != state.cc {
+			c.reportGap(state, fmt.Sprintf("continuity counter of PID %d jumps from %d to %d", pid, state.cc, cc))
+		}
+		state.cc = cc
+	}
+
+	if pcr >= 0 {
+		if state.pcr >= 0 {
+			delta := (pcr - state.pcr + pcrWrap) % pcrWrap
+			if delta > pcrMaxGap {
+				if delta > pcrWrap/2 {
+					c.reportGap(state, fmt.Sprintf("PCR of PID %d goes back by %.3fs", pid, float64(pcrWrap-delta)/90000))
+				} else {
+					c.reportGap(state, fmt.Sprintf("PCR of PID %d jumps by %.3fs", pid, float64(delta)/90000))
+				}
+			}
+		}
+		state.pcr = pcr
+	}
+	state.segment = c.segment
+}
+
+// reportGap reports an issue in the current segment and, when the previous
+// packet of the PID came from an earlier segment, in that segment too since
+// either side of the boundary may be at fault.
+func (c *tsChecker) reportGap(state *tsPID, issue string) {
+	c.report(c.segment, issue)
+	if state.segment != c.segment {
+		c.report(state.segment, issue)
+	}
+}
+
+func (c *tsChecker) report(segment int, issue string) {
+	if _, ok := c.issues[segment]; !ok {
+		c.issues[segment] = issue
+	}
+}
+
+// damaged returns the indexes of segments with issues in ascending order.
+func (c *tsChecker) damaged() []int {
+	indexes := make([]int, 0, len(c.issues))
+	for index := range c.issues {
+		indexes = append(indexes, index)
+	}
+	sort.Ints(indexes)
+	return indexes
+}
@@ -0,0 +1,86 @@
+package utils
+
+import (
+	"context"
+	"sync"
+)
+
+// Budget limits the total number of bytes held in memory by cooperating users,
+// e.g. prefetched segments and copy buffers of concurrent downloads. Acquire
+// blocks while the budget is exhausted, applying backpressure to producers.
+// A nil *Budget is valid and means unlimited.
+type Budget struct {
+	mu       sync.Mutex
+	limit    int64
+	used     int64
+	released chan struct{} // Closed and replaced whenever bytes are released
+}
+
+// NewBudget creates a budget of limit bytes, or returns nil if limit <= 0.
+func NewBudget(limit int64) *Budget {
+	if limit <= 0 {
+		return nil
+	}
+	return &Budget{limit: limit, released: make(chan struct{})}
+}
+
+// Acquire reserves n bytes, waiting until they are available or ctx is done.
+// Requests larger than the whole budget are granted once nothing else is reserved.
+func (b *Budget) Acquire(ctx context.Context, n int64) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit || b.used == 0 {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		released := b.released
+		b.mu.Unlock()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-released:
+		}
+	}
+}
+
+// TryAcquire reserves n bytes if they are available, like Acquire, and
+// reports whether it did, without waiting.
+func (b *Budget) TryAcquire(n int64) bool {
+	if b == nil || n <= 0 {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.used+n > b.limit && b.used != 0 {
+		return false
+	}
+	b.used += n
+	return true
+}
+
+// Release returns n bytes previously reserved with Acquire or TryAcquire.
+func (b *Budget) Release(n int64) {
+	if b == nil || n <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used = max(0, b.used-n)
+	close(b.released)
+	b.released = make(chan struct{})
+}
+
+// Used returns the number of bytes currently reserved.
+func (b *Budget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.used
+}
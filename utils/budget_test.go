@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestBudget verifies Acquire blocks until enough bytes are released.
+func TestBudget(t *testing.T) {
+	b := NewBudget(100)
+	ctx := context.Background()
+	if err := b.Acquire(ctx, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	acquired := make(chan struct{})
+	go func() {
+		b.Acquire(ctx, 60)
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("Acquire succeeded beyond the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	b.Release(60)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("Acquire not woken up by Release")
+	}
+	if got := b.Used(); got != 60 {
+		t.Errorf("Used() = %d, want 60", got)
+	}
+}
+
+// TestBudgetCancel verifies a waiting Acquire returns when its context is done.
+func TestBudgetCancel(t *testing.T) {
+	b := NewBudget(10)
+	b.Acquire(context.Background(), 10)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := b.Acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Acquire() = %v, want %v", err, context.DeadlineExceeded)
+	}
+}
+
+// TestBudgetOversized verifies requests larger than the budget are granted when it's free.
+func TestBudgetOversized(t *testing.T) {
+	b := NewBudget(10)
+	if err := b.Acquire(context.Background(), 50); err != nil {
+		t.Fatal(err)
+	}
+	b.Release(50)
+	if got := b.Used(); got != 0 {
+		t.Errorf("Used() = %d, want 0", got)
+	}
+}
+
+// TestBudgetNil verifies a nil budget never blocks.
+func TestBudgetNil(t *testing.T) {
+	var b *Budget
+	if err := b.Acquire(context.Background(), 1<<40); err != nil {
+		t.Fatal(err)
+	}
+	b.Release(1 << 40)
+	if NewBudget(0) != nil {
+		t.Error("NewBudget(0) should be unlimited")
+	}
+}
+
+// TestBudgetTryAcquire verifies TryAcquire fails instead of waiting when the
+// budget is exhausted.
+func TestBudgetTryAcquire(t *testing.T) {
+	b := NewBudget(100)
+	if !b.TryAcquire(150) {
+		t.Fatal("TryAcquire() of more than the budget failed with nothing reserved")
+	}
+	if b.TryAcquire(1) {
+		t.Fatal("TryAcquire() succeeded beyond the budget")
+	}
+	b.Release(150)
+	if !b.TryAcquire(60) || !b.TryAcquire(40) || b.TryAcquire(1) {
+		t.Error("TryAcquire() did not grant exactly the budget")
+	}
+	if got := b.Used(); got != 100 {
+		t.Errorf("Used() = %d, want 100", got)
+	}
+
+	var unlimited *Budget
+	if !unlimited.TryAcquire(1 << 40) {
+		t.Error("TryAcquire() on a nil budget failed")
+	}
+}
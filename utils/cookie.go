@@ -7,17 +7,49 @@ import (
 	"net/http/cookiejar"
 	"net/url"
 	"os"
+	"path"
+	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
+// httpOnlyPrefix marks HttpOnly cookies in Netscape cookie files.
+const httpOnlyPrefix = "#HttpOnly_"
+
+// CookieJar is an http.CookieJar that remembers the cookies it holds, so
+// cookies set by servers during a run, e.g. by a login redirect, can be saved
+// to a Netscape cookies.txt file with Save. It is safe for concurrent use.
+type CookieJar struct {
+	jar *cookiejar.Jar
+
+	mu      sync.Mutex
+	cookies map[cookieKey]savedCookie
+}
+
+type cookieKey struct {
+	domain, path, name string
+}
+
+type savedCookie struct {
+	value    string
+	hostOnly bool      // Sent to domain only, not to its subdomains
+	expires  time.Time // Zero for session cookies
+	secure   bool
+	httpOnly bool
+}
+
+// NewCookieJar creates an empty cookie jar.
+func NewCookieJar() *CookieJar {
+	jar, _ := cookiejar.New(nil) // Never fails without options
+	return &CookieJar{jar: jar, cookies: make(map[cookieKey]savedCookie)}
+}
+
 // CookieJarFromFile loads cookies from Mozilla cookies.sqlite or Netscape cookies.txt
-func CookieJarFromFile(filePath string) (*cookiejar.Jar, error) {
-	jar, err := cookiejar.New(nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create cookie jar: %w", err)
-	}
+func CookieJarFromFile(filePath string) (*CookieJar, error) {
+	jar := NewCookieJar()
 
 	// Check file extension to determine format
 	if strings.HasSuffix(strings.ToLower(filePath), ".txt") {
@@ -30,7 +62,7 @@ func CookieJarFromFile(filePath string) (*cookiejar.Jar, error) {
 }
 
 // loadNetscapeCookies loads cookies from Netscape format (cookies.txt)
-func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, error) {
+func loadNetscapeCookies(filePath string, jar *CookieJar) (*CookieJar, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, fmt.Errorf("failed to open cookie file: %w", err)
@@ -41,6 +73,10 @@ func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, e
 	for scanner.Scan() {
 		line := strings.TrimSpace(scanner.Text())
 
+		// HttpOnly cookies look like comments
+		httpOnly := strings.HasPrefix(line, httpOnlyPrefix)
+		line = strings.TrimPrefix(line, httpOnlyPrefix)
+
 		// Skip comments and empty lines
 		if line == "" || strings.HasPrefix(line, "#") {
 			continue
@@ -53,7 +89,7 @@ func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, e
 		}
 
 		domain := parts[0]
-		flag := parts[1] == "TRUE"
+		includeSubdomains := parts[1] == "TRUE"
 		path := parts[2]
 		secure := parts[3] == "TRUE"
 		expirationStr := parts[4]
@@ -68,15 +104,17 @@ func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, e
 			}
 		}
 
-		// Create cookie
+		// Create cookie, host-only cookies have no domain
 		cookie := &http.Cookie{
 			Name:     name,
 			Value:    value,
 			Path:     path,
-			Domain:   domain,
 			Expires:  expiration,
 			Secure:   secure,
-			HttpOnly: flag,
+			HttpOnly: httpOnly,
+		}
+		if includeSubdomains {
+			cookie.Domain = domain
 		}
 
 		// Create URL for this domain
@@ -84,7 +122,7 @@ func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, e
 		if secure {
 			scheme = "https"
 		}
-		u, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, domain, path))
+		u, err := url.Parse(fmt.Sprintf("%s://%s%s", scheme, strings.TrimPrefix(domain, "."), path))
 		if err != nil {
 			continue
 		}
@@ -99,3 +137,120 @@ func loadNetscapeCookies(filePath string, jar *cookiejar.Jar) (*cookiejar.Jar, e
 
 	return jar, nil
 }
+
+// SetCookies implements http.CookieJar.
+func (j *CookieJar) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	j.jar.SetCookies(u, cookies)
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	now := time.Now()
+	host := strings.ToLower(u.Hostname())
+	for _, c := range cookies {
+		key := cookieKey{domain: host, path: c.Path, name: c.Name}
+		hostOnly := true
+		if c.Domain != "" {
+			domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+			if host != domain && !strings.HasSuffix(host, "."+domain) {
+				continue // Rejected by the jar too
+			}
+			key.domain, hostOnly = domain, false
+		}
+		if key.path == "" || key.path[0] != '/' {
+			key.path = defaultCookiePath(u.Path)
+		}
+
+		expires := c.Expires
+		if c.MaxAge > 0 {
+			expires = now.Add(time.Duration(c.MaxAge) * time.Second)
+		}
+		if c.MaxAge < 0 || (!expires.IsZero() && !expires.After(now)) {
+			delete(j.cookies, key)
+			continue
+		}
+		j.cookies[key] = savedCookie{
+			value:    c.Value,
+			hostOnly: hostOnly,
+			expires:  expires,
+			secure:   c.Secure,
+			httpOnly: c.HttpOnly,
+		}
+	}
+}
+
+// Cookies implements http.CookieJar.
+func (j *CookieJar) Cookies(u *url.URL) []*http.Cookie {
+	return j.jar.Cookies(u)
+}
+
+// defaultCookiePath returns the path of a cookie set without one by a
+// response to a request for urlPath, as defined by RFC 6265.
+func defaultCookiePath(urlPath string) string {
+	if urlPath == "" || urlPath[0] != '/' {
+		return "/"
+	}
+	if dir := path.Dir(urlPath); dir != "." {
+		return dir
+	}
+	return "/"
+}
+
+// Save writes the unexpired cookies of the jar, session cookies included, to
+// filePath in Netscape cookies.txt format, replacing the file atomically.
+func (j *CookieJar) Save(filePath string) error {
+	j.mu.Lock()
+	now := time.Now()
+	keys := make([]cookieKey, 0, len(j.cookies))
+	for key, c := range j.cookies {
+		if c.expires.IsZero() || c.expires.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(a, b int) bool {
+		if keys[a].domain != keys[b].domain {
+			return keys[a].domain < keys[b].domain
+		}
+		if keys[a].path != keys[b].path {
+			return keys[a].path < keys[b].path
+		}
+		return keys[a].name < keys[b].name
+	})
+	var b strings.Builder
+	b.WriteString("# Netscape HTTP Cookie File\n# Written by grab, edit at your own risk\n\n")
+	for _, key := range keys {
+		c := j.cookies[key]
+		domain, includeSubdomains := key.domain, "FALSE"
+		if !c.hostOnly {
+			domain, includeSubdomains = "."+domain, "TRUE"
+		}
+		if c.httpOnly {
+			domain = httpOnlyPrefix + domain
+		}
+		var expires int64
+		if !c.expires.IsZero() {
+			expires = c.expires.Unix()
+		}
+		secure := "FALSE"
+		if c.secure {
+			secure = "TRUE"
+		}
+		fmt.Fprintf(&b, "%s\t%s\t%s\t%s\t%d\t%s\t%s\n", domain, includeSubdomains, key.path, secure, expires, key.name, c.value)
+	}
+	j.mu.Unlock()
+
+	if dir := filepath.Dir(filePath); dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return fmt.Errorf("failed to create cookie directory: %w", err)
+		}
+	}
+	// Cookies are credentials, keep them private
+	tmp := filePath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write cookie file: %w", err)
+	}
+	if err := os.Rename(tmp, filePath); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write cookie file: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestCookieJarSave verifies cookies loaded from a file and set by responses
+// are saved and loaded back, HttpOnly and host-only ones included, while
+// deleted and expired ones are dropped.
+func TestCookieJarSave(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "cookies.txt")
+	expires := time.Now().Add(time.Hour).Unix()
+	content := "# Netscape HTTP Cookie File\n" +
+		".example.com\tTRUE\t/\tFALSE\t" + strconv.FormatInt(expires, 10) + "\tpref\tdark\n" +
+		"#HttpOnly_example.com\tFALSE\t/\tTRUE\t0\told\tgone\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	jar, err := CookieJarFromFile(path)
+	if err != nil {
+		t.Fatalf("CookieJarFromFile() error: %v", err)
+	}
+
+	login, _ := url.Parse("https://www.example.com/account/login")
+	jar.SetCookies(login, []*http.Cookie{
+		{Name: "session", Value: "abc", HttpOnly: true, Secure: true},
+		{Name: "old", Value: "", Domain: "", Path: "/", MaxAge: -1},
+		{Name: "foreign", Value: "x", Domain: "other.com"},
+	})
+	old, _ := url.Parse("https://example.com/")
+	jar.SetCookies(old, []*http.Cookie{{Name: "old", Path: "/", MaxAge: -1}})
+
+	saved := filepath.Join(dir, "saved", "cookies.txt")
+	if err := jar.Save(saved); err != nil {
+		t.Fatalf("Save() error: %v", err)
+	}
+	data, _ := os.ReadFile(saved)
+	for _, want := range []string{
+		".example.com\tTRUE\t/\tFALSE\t" + strconv.FormatInt(expires, 10) + "\tpref\tdark\n",
+		"#HttpOnly_www.example.com\tFALSE\t/account\tTRUE\t0\tsession\tabc\n",
+	} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("saved cookies missing %q:\n%s", want, data)
+		}
+	}
+	for _, unwanted := range []string{"old", "foreign"} {
+		if strings.Contains(string(data), unwanted) {
+			t.Errorf("saved cookies contain %q:\n%s", unwanted, data)
+		}
+	}
+	if fi, err := os.Stat(saved); err == nil && fi.Mode().Perm() != 0600 {
+		t.Errorf("saved cookies mode = %v, want 0600", fi.Mode().Perm())
+	}
+
+	reloaded, err := CookieJarFromFile(saved)
+	if err != nil {
+		t.Fatalf("CookieJarFromFile(saved) error: %v", err)
+	}
+	account, _ := url.Parse("https://www.example.com/account/videos")
+	got := map[string]string{}
+	for _, c := range reloaded.Cookies(account) {
+		got[c.Name] = c.Value
+	}
+	if got["session"] != "abc" || got["pref"] != "dark" || len(got) != 2 {
+		t.Errorf("reloaded cookies = %v, want session and pref", got)
+	}
+	sub, _ := url.Parse("https://cdn.www.example.com/account/")
+	for _, c := range reloaded.Cookies(sub) {
+		if c.Name == "session" {
+			t.Errorf("host-only cookie sent to a subdomain")
+		}
+	}
+}
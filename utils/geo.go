@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"math/rand/v2"
+	"net/netip"
+	"strings"
+)
+
+// countryBlocks maps ISO 3166-1 alpha-2 country codes to a large public IPv4 block
+// allocated to that country. Only used to make geo-restricted services believe the
+// request originates from the given country.
+var countryBlocks = map[string]string{
+	"AU": "1.128.0.0/11",
+	"BR": "179.192.0.0/10",
+	"CA": "99.224.0.0/11",
+	"CN": "36.128.0.0/10",
+	"DE": "53.0.0.0/8",
+	"ES": "88.0.0.0/11",
+	"FR": "90.0.0.0/9",
+	"GB": "81.128.0.0/9",
+	"HK": "113.252.0.0/14",
+	"IN": "117.192.0.0/10",
+	"IT": "79.0.0.0/10",
+	"JP": "133.0.0.0/8",
+	"KR": "175.192.0.0/10",
+	"NL": "145.0.0.0/8",
+	"RU": "5.136.0.0/13",
+	"SG": "8.128.0.0/10",
+	"TW": "120.96.0.0/11",
+	"US": "6.0.0.0/8",
+}
+
+// GeoBypassIP returns a random IPv4 address inside a block allocated to the given
+// country code. The second return value is false if the country is unknown.
+func GeoBypassIP(country string) (string, bool) {
+	block, ok := countryBlocks[strings.ToUpper(strings.TrimSpace(country))]
+	if !ok {
+		return "", false
+	}
+	prefix := netip.MustParsePrefix(block)
+	addr := prefix.Addr().As4()
+	hostBits := 32 - prefix.Bits()
+	n := uint32(addr[0])<<24 | uint32(addr[1])<<16 | uint32(addr[2])<<8 | uint32(addr[3])
+	if hostBits > 0 {
+		n |= rand.Uint32N(uint32(1<<hostBits-1)) + 1
+	}
+	ip := netip.AddrFrom4([4]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)})
+	return ip.String(), true
+}
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"net/netip"
+	"testing"
+)
+
+// TestGeoBypassIP verifies GeoBypassIP returns addresses inside the country block.
+func TestGeoBypassIP(t *testing.T) {
+	for country, block := range countryBlocks {
+		prefix := netip.MustParsePrefix(block)
+		for i := 0; i < 10; i++ {
+			ip, ok := GeoBypassIP(country)
+			if !ok {
+				t.Fatalf("GeoBypassIP(%q) returned not ok", country)
+			}
+			addr, err := netip.ParseAddr(ip)
+			if err != nil {
+				t.Fatalf("GeoBypassIP(%q) = %q, not a valid IP: %v", country, ip, err)
+			}
+			if !prefix.Contains(addr) {
+				t.Errorf("GeoBypassIP(%q) = %q, not in %s", country, ip, block)
+			}
+		}
+	}
+	if _, ok := GeoBypassIP("cn"); !ok {
+		t.Errorf("GeoBypassIP should accept lower case country codes")
+	}
+	if _, ok := GeoBypassIP("XX"); ok {
+		t.Errorf("GeoBypassIP(%q) should not be ok", "XX")
+	}
+}
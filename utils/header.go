@@ -1,12 +1,50 @@
 package utils
 
-import "net/http"
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
 
 // MergeHeader merges two http.Header objects into one.
 func MergeHeader(original, additional http.Header) http.Header {
 	merged := original.Clone()
+	if merged == nil {
+		merged = make(http.Header)
+	}
 	for k, v := range additional {
 		merged[k] = v
 	}
 	return merged
 }
+
+// ParseContentRange parses a Content-Range header of the form "bytes start-end/total".
+// The total is -1 if the server reported it as unknown ("*").
+func ParseContentRange(header string) (start, end, total int64, err error) {
+	spec, ok := strings.CutPrefix(strings.TrimSpace(header), "bytes ")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("unsupported Content-Range: %q", header)
+	}
+	rng, size, ok := strings.Cut(spec, "/")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	first, last, ok := strings.Cut(rng, "-")
+	if !ok {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	if start, err = strconv.ParseInt(first, 10, 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	if end, err = strconv.ParseInt(last, 10, 64); err != nil || end < start {
+		return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+	}
+	total = -1
+	if size != "*" {
+		if total, err = strconv.ParseInt(size, 10, 64); err != nil || total <= end {
+			return 0, 0, 0, fmt.Errorf("malformed Content-Range: %q", header)
+		}
+	}
+	return start, end, total, nil
+}
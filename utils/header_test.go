@@ -0,0 +1,32 @@
+package utils
+
+import "testing"
+
+// TestParseContentRange verifies ParseContentRange accepts valid headers and rejects malformed ones.
+func TestParseContentRange(t *testing.T) {
+	tests := []struct {
+		input             string
+		start, end, total int64
+		wantErr           bool
+	}{
+		{"bytes 0-0/1024", 0, 0, 1024, false},
+		{"bytes 100-199/1000", 100, 199, 1000, false},
+		{"bytes 100-199/*", 100, 199, -1, false},
+		{"bytes */1000", 0, 0, 0, true},
+		{"bytes 200-100/1000", 0, 0, 0, true},
+		{"bytes 0-1000/1000", 0, 0, 0, true},
+		{"items 0-1/2", 0, 0, 0, true},
+		{"", 0, 0, 0, true},
+	}
+	for _, tt := range tests {
+		start, end, total, err := ParseContentRange(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseContentRange(%q) error = %v, wantErr %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (start != tt.start || end != tt.end || total != tt.total) {
+			t.Errorf("ParseContentRange(%q) = %d, %d, %d, want %d, %d, %d",
+				tt.input, start, end, total, tt.start, tt.end, tt.total)
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"net"
+	"net/url"
+	"strings"
+)
+
+// MatchHost reports whether host matches pattern. A pattern matches the host
+// itself and all of its subdomains, so "example.com" matches "cdn.example.com"
+// but not "badexample.com". A leading "*." or "." in pattern is ignored.
+// Ports are ignored and matching is case-insensitive.
+func MatchHost(pattern, host string) bool {
+	pattern = strings.ToLower(strings.TrimPrefix(strings.TrimPrefix(pattern, "*"), "."))
+	host = strings.ToLower(host)
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if pattern == "" {
+		return false
+	}
+	return host == pattern || strings.HasSuffix(host, "."+pattern)
+}
+
+// URLHost returns the host of rawURL without port, or "" if it can't be parsed.
+func URLHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// IsLoopbackHost reports whether host, with or without port, is localhost or
+// a loopback IP address.
+func IsLoopbackHost(host string) bool {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
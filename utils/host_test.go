@@ -0,0 +1,52 @@
+package utils
+
+import "testing"
+
+// TestMatchHost verifies domain suffix matching of host patterns.
+func TestMatchHost(t *testing.T) {
+	tests := []struct {
+		pattern, host string
+		want          bool
+	}{
+		{"example.com", "example.com", true},
+		{"example.com", "cdn.example.com", true},
+		{"example.com", "a.b.example.com", true},
+		{"example.com", "badexample.com", false},
+		{"example.com", "example.com.evil.org", false},
+		{"*.example.com", "cdn.example.com", true},
+		{".example.com", "example.com", true},
+		{"Example.COM", "CDN.example.com", true},
+		{"example.com", "example.com:8443", true},
+		{"", "example.com", false},
+	}
+	for _, tt := range tests {
+		if got := MatchHost(tt.pattern, tt.host); got != tt.want {
+			t.Errorf("MatchHost(%q, %q) = %v, want %v", tt.pattern, tt.host, got, tt.want)
+		}
+	}
+}
+
+// TestIsLoopbackHost verifies loopback hosts are told apart from others.
+func TestIsLoopbackHost(t *testing.T) {
+	tests := []struct {
+		host string
+		want bool
+	}{
+		{"localhost", true},
+		{"LOCALHOST:6800", true},
+		{"127.0.0.1", true},
+		{"127.1.2.3:80", true},
+		{"[::1]:6800", true},
+		{"::1", true},
+		{"0.0.0.0:6800", false},
+		{":6800", false},
+		{"192.168.1.2", false},
+		{"localhost.evil.org", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		if got := IsLoopbackHost(tt.host); got != tt.want {
+			t.Errorf("IsLoopbackHost(%q) = %v, want %v", tt.host, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,76 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+)
+
+// ErrLocked is returned by TryLock when another process holds the lock.
+var ErrLocked = errors.New("file is locked by another process")
+
+// FileLock is an advisory, cross-process lock backed by a lock file.
+type FileLock struct {
+	path string
+	file *os.File
+}
+
+// TryLock acquires an exclusive advisory lock on path without blocking.
+// The lock file is created if needed. It returns ErrLocked if another process
+// already holds the lock.
+func TryLock(path string) (*FileLock, error) {
+	for {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_RDWR, 0644)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open lock file: %w", err)
+		}
+		if err := lockFile(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+		// The previous holder may have removed the file after we opened it,
+		// whoever opens path now gets another file: lock that one instead
+		current, err := isCurrent(file, path)
+		if err == nil && current {
+			return &FileLock{path: path, file: file}, nil
+		}
+		unlockFile(file)
+		file.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// isCurrent reports whether file is still the file at path.
+func isCurrent(file *os.File, path string) (bool, error) {
+	opened, err := file.Stat()
+	if err != nil {
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	current, err := os.Stat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to stat lock file: %w", err)
+	}
+	return os.SameFile(opened, current), nil
+}
+
+// Unlock releases the lock and removes the lock file.
+func (l *FileLock) Unlock() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	// Remove while still holding the lock: processes waiting on the removed
+	// file notice it isn't at the path anymore once they get the lock, see
+	// TryLock
+	os.Remove(l.path)
+	err := unlockFile(l.file)
+	if cerr := l.file.Close(); err == nil {
+		err = cerr
+	}
+	l.file = nil
+	return err
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+// TestTryLock verifies a held lock can't be acquired twice and is released by Unlock.
+func TestTryLock(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4.lock")
+
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() error: %v", err)
+	}
+	if _, err := TryLock(path); !errors.Is(err, ErrLocked) {
+		t.Fatalf("second TryLock() error = %v, want ErrLocked", err)
+	}
+	if err := lock.Unlock(); err != nil {
+		t.Fatalf("Unlock() error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("lock file still exists after Unlock")
+	}
+
+	lock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() after Unlock error: %v", err)
+	}
+	lock.Unlock()
+}
+
+// TestTryLockRemovedFile verifies a lock acquired on a lock file removed by its
+// previous holder is not held, so that two processes can't both hold the lock.
+func TestTryLockRemovedFile(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("open files can't be removed on Windows")
+	}
+	path := filepath.Join(t.TempDir(), "video.mp4.lock")
+	lock, err := TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() error: %v", err)
+	}
+	// A process that opened the file before it was removed
+	stale, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stale.Close()
+	lock.Unlock()
+	if err := lockFile(stale); err != nil {
+		t.Fatalf("lockFile() of the removed file error: %v", err)
+	}
+	if current, err := isCurrent(stale, path); err != nil || current {
+		t.Errorf("isCurrent() of the removed file = %v, %v, want false", current, err)
+	}
+
+	lock, err = TryLock(path)
+	if err != nil {
+		t.Fatalf("TryLock() error: %v", err)
+	}
+	defer lock.Unlock()
+	if current, err := isCurrent(lock.file, path); err != nil || !current {
+		t.Errorf("isCurrent() of the new lock file = %v, %v, want true", current, err)
+	}
+}
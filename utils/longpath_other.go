@@ -0,0 +1,8 @@
+//go:build !windows
+
+package utils
+
+// LongPath returns path unchanged, only Windows limits path length to MAX_PATH.
+func LongPath(path string) string {
+	return path
+}
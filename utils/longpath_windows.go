@@ -0,0 +1,28 @@
+//go:build windows
+
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// maxPath is the classic Win32 MAX_PATH limit, including the terminating NUL.
+const maxPath = 260
+
+// LongPath returns path prefixed with \\?\ when it would exceed MAX_PATH, so deep
+// directory trees can be created without enabling long paths system wide.
+func LongPath(path string) string {
+	// Directories must leave room for an 8.3 file name, hence the margin
+	if strings.HasPrefix(path, `\\?\`) || len(path) < maxPath-12 {
+		return path
+	}
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	if strings.HasPrefix(abs, `\\`) {
+		return `\\?\UNC\` + abs[2:]
+	}
+	return `\\?\` + abs
+}
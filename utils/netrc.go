@@ -0,0 +1,149 @@
+package utils
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// NetrcMachine is the login of a machine of a .netrc file.
+type NetrcMachine struct {
+	Login    string
+	Password string
+}
+
+// Netrc holds the machines of a .netrc file, as read by curl and ftp.
+type Netrc struct {
+	machines map[string]NetrcMachine // By lower case host name
+	fallback *NetrcMachine           // The default entry, if any
+}
+
+// DefaultNetrcPath returns the .netrc file of the user: $NETRC if set,
+// otherwise .netrc in the home directory, _netrc on Windows.
+func DefaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "."
+	}
+	if runtime.GOOS == "windows" {
+		return filepath.Join(home, "_netrc")
+	}
+	return filepath.Join(home, ".netrc")
+}
+
+// LoadNetrc reads the .netrc file at path.
+func LoadNetrc(path string) (*Netrc, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netrc file: %w", err)
+	}
+	defer f.Close()
+	n, err := ParseNetrc(f)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read netrc file %s: %w", path, err)
+	}
+	return n, nil
+}
+
+// ParseNetrc parses the machine, default, login and password tokens of a
+// .netrc file. Macros defined with macdef are skipped, other tokens such as
+// account are ignored.
+func ParseNetrc(r io.Reader) (*Netrc, error) {
+	n := &Netrc{machines: make(map[string]NetrcMachine)}
+	var tokens []string
+	scanner := bufio.NewScanner(r)
+	inMacro := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		if inMacro {
+			// A macro ends with an empty line
+			inMacro = strings.TrimSpace(line) != ""
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		for i, field := range fields {
+			if field == "macdef" {
+				tokens = append(tokens, fields[:i]...)
+				inMacro = true
+				break
+			}
+		}
+		if !inMacro {
+			tokens = append(tokens, fields...)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	var current *NetrcMachine
+	var host string
+	flush := func() {
+		if current == nil {
+			return
+		}
+		if host == "" {
+			if n.fallback == nil {
+				n.fallback = current
+			}
+		} else if _, ok := n.machines[host]; !ok {
+			// The first entry of a machine wins, as with curl
+			n.machines[host] = *current
+		}
+		current = nil
+	}
+	for i := 0; i < len(tokens); i++ {
+		value := func() string {
+			if i+1 < len(tokens) {
+				i++
+				return tokens[i]
+			}
+			return ""
+		}
+		switch tokens[i] {
+		case "machine":
+			flush()
+			current, host = &NetrcMachine{}, strings.ToLower(value())
+		case "default":
+			flush()
+			current, host = &NetrcMachine{}, ""
+		case "login":
+			if v := value(); current != nil {
+				current.Login = v
+			}
+		case "password":
+			if v := value(); current != nil {
+				current.Password = v
+			}
+		case "account":
+			value()
+		}
+	}
+	flush()
+	return n, nil
+}
+
+// Lookup returns the login of host, a host name without port, falling back
+// to the default entry.
+func (n *Netrc) Lookup(host string) (NetrcMachine, bool) {
+	if n == nil {
+		return NetrcMachine{}, false
+	}
+	if m, ok := n.machines[strings.ToLower(host)]; ok {
+		return m, true
+	}
+	if n.fallback != nil {
+		return *n.fallback, true
+	}
+	return NetrcMachine{}, false
+}
@@ -0,0 +1,48 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseNetrc(t *testing.T) {
+	content := `# Work accounts
+machine example.com login alice password s3cret
+machine Files.Example.org
+	login bob
+	account ignored
+	password hunter2
+
+macdef init
+machine evil.com login mallory password nope
+
+machine example.com login second password ignored
+default login anonymous password guest
+`
+	n, err := ParseNetrc(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("ParseNetrc() error: %v", err)
+	}
+
+	tests := []struct {
+		host  string
+		login string
+		pass  string
+	}{
+		{"example.com", "alice", "s3cret"},
+		{"files.example.org", "bob", "hunter2"},
+		{"evil.com", "anonymous", "guest"},  // Inside a macro
+		{"other.net", "anonymous", "guest"}, // Default entry
+	}
+	for _, tt := range tests {
+		m, ok := n.Lookup(tt.host)
+		if !ok || m.Login != tt.login || m.Password != tt.pass {
+			t.Errorf("Lookup(%q) = %+v, %v, want %s/%s", tt.host, m, ok, tt.login, tt.pass)
+		}
+	}
+
+	n, _ = ParseNetrc(strings.NewReader("machine example.com login alice password s3cret\n"))
+	if _, ok := n.Lookup("example.net"); ok {
+		t.Errorf("Lookup() of a missing host without default succeeded")
+	}
+}
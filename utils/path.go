@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// SafeJoin joins untrusted path elements onto base and guarantees the result stays
+// inside base. Each element is split on both '/' and '\', every component is
+// sanitized, and empty, "." and ".." components are dropped, so absolute paths,
+// drive letters and traversal sequences can't escape base.
+func SafeJoin(base string, elem ...string) string {
+	parts := []string{base}
+	for _, e := range elem {
+		for _, p := range strings.FieldsFunc(e, isPathSeparator) {
+			p = SanitizeFilename(p)
+			if p == "" || p == "." || p == ".." {
+				continue
+			}
+			parts = append(parts, p)
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// IsWithin reports whether path is base itself or located inside base.
+func IsWithin(base, path string) bool {
+	rel, err := filepath.Rel(base, path)
+	if err != nil {
+		return false
+	}
+	return rel == "." || (rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)))
+}
+
+func isPathSeparator(r rune) bool {
+	return r == '/' || r == '\\'
+}
@@ -0,0 +1,54 @@
+package utils
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// TestSafeJoin verifies SafeJoin keeps hostile names inside the base directory.
+func TestSafeJoin(t *testing.T) {
+	base := filepath.Join("downloads")
+	tests := []struct {
+		elem []string
+		want string
+	}{
+		{[]string{"course", "lesson.mp4"}, filepath.Join(base, "course", "lesson.mp4")},
+		{[]string{"course/lesson.mp4"}, filepath.Join(base, "course", "lesson.mp4")},
+		{[]string{"../../etc/passwd"}, filepath.Join(base, "etc", "passwd")},
+		{[]string{"/etc/passwd"}, filepath.Join(base, "etc", "passwd")},
+		{[]string{`..\..\windows\system32`}, filepath.Join(base, "windows", "system32")},
+		{[]string{`C:\Users\foo`}, filepath.Join(base, "C_", "Users", "foo")},
+		{[]string{"a/./b/../c"}, filepath.Join(base, "a", "b", "c")},
+		{[]string{"...", ". .", ""}, base},
+	}
+	for _, tt := range tests {
+		got := SafeJoin(base, tt.elem...)
+		if got != tt.want {
+			t.Errorf("SafeJoin(%q, %q) = %q, want %q", base, tt.elem, got, tt.want)
+		}
+		if !IsWithin(base, got) {
+			t.Errorf("SafeJoin(%q, %q) = %q escapes base", base, tt.elem, got)
+		}
+	}
+}
+
+// TestIsWithin verifies IsWithin detects paths outside the base directory.
+func TestIsWithin(t *testing.T) {
+	tests := []struct {
+		base, path string
+		want       bool
+	}{
+		{"downloads", "downloads", true},
+		{"downloads", "downloads/a/b", true},
+		{"downloads", "downloads/..a", true},
+		{"downloads", "downloads/../a", false},
+		{"downloads", "other", false},
+		{"/srv/downloads", "/srv/downloads-evil/a", false},
+	}
+	for _, tt := range tests {
+		got := IsWithin(tt.base, tt.path)
+		if got != tt.want {
+			t.Errorf("IsWithin(%q, %q) = %v, want %v", tt.base, tt.path, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package utils
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// ProxyURL parses a proxy URL and sets user and password as its credentials if
+// user is not empty. Credentials are escaped properly, which is not the case
+// when passwords containing '@', ':' or '/' are pasted into the URL by hand.
+// A missing scheme defaults to http.
+func ProxyURL(raw, user, pass string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy URL: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy scheme: %s", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: missing host")
+	}
+	if user != "" {
+		u.User = url.UserPassword(user, pass)
+	}
+	return u, nil
+}
@@ -1,55 +1,180 @@
 package utils
 
 import (
+	"context"
+	"fmt"
 	"io"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 )
 
-// RateLimiter wraps an io.Reader and limits the read speed to the specified bytes per second.
+// Bucket is a token bucket limiting the combined throughput of every reader
+// sharing it. A nil *Bucket is valid and means unlimited.
+type Bucket struct {
+	mu       sync.Mutex
+	rate     float64 // bytes per second, 0 while unlimited
+	burst    float64 // maximum bytes accumulated while idle
+	tokens   float64
+	last     time.Time
+	base     int64        // Rate outside the periods of schedule
+	schedule RateSchedule // Rates by time of day, applied as time goes on
+}
+
+// NewBucket creates a bucket allowing rate bytes per second, or returns nil if rate <= 0.
+func NewBucket(rate int64) *Bucket {
+	return NewScheduledBucket(rate, nil)
+}
+
+// NewScheduledBucket creates a bucket allowing the rate of the period of
+// schedule the current time falls in, rate bytes per second outside them.
+// Long downloads switch rates as periods start and end. It returns nil if
+// the bucket would never limit anything.
+func NewScheduledBucket(rate int64, schedule RateSchedule) *Bucket {
+	if rate <= 0 && len(schedule) == 0 {
+		return nil
+	}
+	b := &Bucket{base: rate, schedule: schedule, last: time.Now()}
+	b.setRate(b.rateAt(b.last))
+	return b
+}
+
+// setRate changes the rate of b, starting afresh when it was unlimited.
+func (b *Bucket) setRate(rate int64) {
+	unlimited := b.rate == 0
+	b.rate = float64(max(rate, 0))
+	b.burst = max(b.rate/10, 1) // Allow 100ms worth of data at once
+	if unlimited {
+		b.tokens = b.burst
+	}
+	b.tokens = min(b.tokens, b.burst)
+}
+
+// rateAt returns the rate of b at t.
+func (b *Bucket) rateAt(t time.Time) int64 {
+	if rate, ok := b.schedule.RateAt(t); ok {
+		return rate
+	}
+	return b.base
+}
+
+// Rate returns the current rate of the bucket in bytes per second, 0 if unlimited.
+func (b *Bucket) Rate() int64 {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return max(b.rateAt(time.Now()), 0)
+}
+
+// take consumes n bytes worth of tokens and returns how long the caller must
+// wait before the bytes are within the rate. Debt is shared by all callers so
+// concurrent readers are serialized fairly.
+func (b *Bucket) take(n int) time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := time.Now()
+	if len(b.schedule) > 0 {
+		if rate := float64(max(b.rateAt(now), 0)); rate != b.rate {
+			b.setRate(int64(rate))
+		}
+	}
+	if b.rate == 0 {
+		b.last = now
+		return 0
+	}
+	b.tokens = min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+	b.tokens -= float64(n)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// RateLimiter wraps an io.Reader and limits the read speed with one or more buckets,
+// e.g. a global bucket plus a per-stream one. The slowest bucket wins.
 // RateLimiter implements io.ReadCloser for compatibility with io.ReadCloser chains.
 type RateLimiter struct {
 	io.Reader
-	closer    io.Closer
-	Rate      int64         // bytes per second
-	interval  time.Duration // sleep interval
-	chunkSize int           // bytes per interval
+	closer  io.Closer
+	Rate    int64 // bytes per second of the tightest bucket, as of the last read
+	buckets []*Bucket
+	ctx     context.Context // Interrupts waits for tokens, optional
 }
 
 // NewRateLimiter creates a new RateLimiter for the given reader and rate (bytes/sec).
 func NewRateLimiter(r io.Reader, rate int64) *RateLimiter {
-	var closer io.Closer
+	return NewSharedRateLimiter(r, NewBucket(rate))
+}
+
+// NewSharedRateLimiter creates a RateLimiter drawing from the given buckets, which
+// may be shared with other readers. Nil buckets are ignored.
+func NewSharedRateLimiter(r io.Reader, buckets ...*Bucket) *RateLimiter {
+	rl := &RateLimiter{Reader: r}
 	if c, ok := r.(io.Closer); ok {
-		closer = c
-	}
-	if rate <= 0 {
-		return &RateLimiter{Reader: r, closer: closer}
+		rl.closer = c
 	}
-	interval := 100 * time.Millisecond
-	chunkSize := int(rate / 10)
-	if chunkSize < 1 {
-		chunkSize = 1
+	for _, b := range buckets {
+		if b != nil {
+			rl.buckets = append(rl.buckets, b)
+		}
 	}
-	return &RateLimiter{
-		Reader:    r,
-		closer:    closer,
-		Rate:      rate,
-		interval:  interval,
-		chunkSize: chunkSize,
+	rl.Rate = rl.rate()
+	return rl
+}
+
+// rate returns the current rate of the tightest bucket, 0 if all are unlimited.
+func (rl *RateLimiter) rate() int64 {
+	var rate int64
+	for _, b := range rl.buckets {
+		if r := b.Rate(); r > 0 && (rate == 0 || r < rate) {
+			rate = r
+		}
 	}
+	return rate
+}
+
+// WithContext makes waits for tokens end when ctx is done, Read then
+// returning the error of ctx. It returns rl.
+func (rl *RateLimiter) WithContext(ctx context.Context) *RateLimiter {
+	rl.ctx = ctx
+	return rl
 }
 
 // Read reads data from the underlying reader, limiting the speed.
 func (rl *RateLimiter) Read(p []byte) (int, error) {
-	if rl.Rate <= 0 {
+	if len(rl.buckets) == 0 {
 		return rl.Reader.Read(p)
 	}
-	max := rl.chunkSize
-	if len(p) < max {
-		max = len(p)
+	// Small reads keep the throughput smooth, rates may change with a schedule
+	if rl.Rate = rl.rate(); rl.Rate > 0 {
+		if chunkSize := int(max(rl.Rate/10, 1)); len(p) > chunkSize {
+			p = p[:chunkSize]
+		}
 	}
-	n, err := rl.Reader.Read(p[:max])
+	n, err := rl.Reader.Read(p)
 	if n > 0 {
-		time.Sleep(rl.interval)
+		var wait time.Duration
+		for _, b := range rl.buckets {
+			wait = max(wait, b.take(n))
+		}
+		if rl.ctx == nil {
+			time.Sleep(wait)
+		} else if wait > 0 {
+			timer := time.NewTimer(wait)
+			defer timer.Stop()
+			select {
+			case <-timer.C:
+			case <-rl.ctx.Done():
+				return n, rl.ctx.Err()
+			}
+		}
 	}
 	return n, err
 }
@@ -61,3 +186,97 @@ func (rl *RateLimiter) Close() error {
 	}
 	return nil
 }
+
+// RateSchedule sets download rates by time of day, see ParseRateSchedule.
+type RateSchedule []ratePeriod
+
+// ratePeriod is a period of the day, from and to being times since midnight;
+// a period ending before it starts spans midnight.
+type ratePeriod struct {
+	from, to time.Duration
+	rate     int64 // Bytes per second, 0 for unlimited
+}
+
+// ParseRateSchedule parses comma separated periods of the day with their
+// rate in bytes per second, e.g. "09:00-18:00=1M,22:00-06:00=0". Rates may
+// end with K, M or G, multiples of 1024, and 0 means unlimited. Periods may
+// span midnight; the first one containing a time applies.
+func ParseRateSchedule(spec string) (RateSchedule, error) {
+	var schedule RateSchedule
+	for _, part := range strings.Split(spec, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		period, rate, ok := strings.Cut(part, "=")
+		from, to, ok2 := strings.Cut(period, "-")
+		if !ok || !ok2 {
+			return nil, fmt.Errorf("invalid rate period %q, use HH:MM-HH:MM=rate such as 09:00-18:00=1M", part)
+		}
+		var p ratePeriod
+		var err error
+		if p.from, err = parseTimeOfDay(from); err != nil {
+			return nil, fmt.Errorf("invalid rate period %q: %w", part, err)
+		}
+		if p.to, err = parseTimeOfDay(to); err != nil {
+			return nil, fmt.Errorf("invalid rate period %q: %w", part, err)
+		}
+		if p.rate, err = parseRate(rate); err != nil {
+			return nil, fmt.Errorf("invalid rate period %q: %w", part, err)
+		}
+		schedule = append(schedule, p)
+	}
+	if len(schedule) == 0 {
+		return nil, fmt.Errorf("no rate period in %q", spec)
+	}
+	return schedule, nil
+}
+
+// RateAt returns the rate of the first period containing the time of day of
+// t, in its location, false if none does.
+func (s RateSchedule) RateAt(t time.Time) (int64, bool) {
+	hour, minute, second := t.Clock()
+	now := time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute + time.Duration(second)*time.Second
+	for _, p := range s {
+		if p.from <= p.to && now >= p.from && now < p.to ||
+			p.from > p.to && (now >= p.from || now < p.to) {
+			return p.rate, true
+		}
+	}
+	return 0, false
+}
+
+// parseTimeOfDay parses HH:MM into the time since midnight, 24:00 included.
+func parseTimeOfDay(s string) (time.Duration, error) {
+	hours, minutes, ok := strings.Cut(strings.TrimSpace(s), ":")
+	h, err1 := strconv.Atoi(hours)
+	m, err2 := strconv.Atoi(minutes)
+	if !ok || err1 != nil || err2 != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+		return 0, fmt.Errorf("invalid time of day %q", s)
+	}
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// parseRate parses bytes per second with an optional K, M or G suffix.
+func parseRate(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	multiplier := int64(1)
+	if s != "" {
+		switch strings.ToUpper(s[len(s)-1:]) {
+		case "K":
+			multiplier = 1 << 10
+		case "M":
+			multiplier = 1 << 20
+		case "G":
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			s = s[:len(s)-1]
+		}
+	}
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || rate < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+	return int64(rate * float64(multiplier)), nil
+}
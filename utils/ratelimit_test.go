@@ -0,0 +1,121 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestSharedRateLimiter verifies readers sharing a bucket are limited in total.
+func TestSharedRateLimiter(t *testing.T) {
+	const rate = 100 * 1024
+	global := NewBucket(rate)
+
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := NewSharedRateLimiter(bytes.NewReader(make([]byte, rate/8)), global)
+			io.Copy(io.Discard, r)
+		}()
+	}
+	wg.Wait()
+
+	// 4 readers of rate/8 bytes share rate bytes per second: ~0.5s minus the initial burst
+	if elapsed := time.Since(start); elapsed < 300*time.Millisecond {
+		t.Errorf("shared limiter finished in %v, expected at least 300ms", elapsed)
+	}
+}
+
+// TestRateLimiterUnlimited verifies a limiter without buckets doesn't slow reads down.
+func TestRateLimiterUnlimited(t *testing.T) {
+	r := NewSharedRateLimiter(bytes.NewReader(make([]byte, 1<<20)), nil)
+	start := time.Now()
+	n, _ := io.Copy(io.Discard, r)
+	if n != 1<<20 {
+		t.Errorf("read %d bytes, want %d", n, 1<<20)
+	}
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited reader took %v", elapsed)
+	}
+}
+
+// TestRateLimiterContext verifies canceling the context ends a wait for tokens.
+func TestRateLimiterContext(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	r := NewRateLimiter(bytes.NewReader(make([]byte, 1<<20)), 1024).WithContext(ctx)
+
+	start := time.Now()
+	_, err := io.Copy(io.Discard, r)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("copy error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("canceled limiter kept reading for %v", elapsed)
+	}
+}
+
+// TestParseRateSchedule verifies periods, suffixes and periods spanning midnight.
+func TestParseRateSchedule(t *testing.T) {
+	schedule, err := ParseRateSchedule("09:00-18:00=1M, 22:00-06:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	at := func(hour, minute int) time.Time {
+		return time.Date(2024, 1, 1, hour, minute, 0, 0, time.Local)
+	}
+	tests := []struct {
+		time time.Time
+		rate int64
+		ok   bool
+	}{
+		{at(9, 0), 1 << 20, true},
+		{at(17, 59), 1 << 20, true},
+		{at(18, 0), 0, false},
+		{at(23, 30), 0, true},
+		{at(5, 0), 0, true},
+		{at(7, 0), 0, false},
+	}
+	for _, tt := range tests {
+		rate, ok := schedule.RateAt(tt.time)
+		if rate != tt.rate || ok != tt.ok {
+			t.Errorf("RateAt(%s) = %d, %v, want %d, %v", tt.time.Format("15:04"), rate, ok, tt.rate, tt.ok)
+		}
+	}
+
+	for _, spec := range []string{"", "09:00-18:00", "9-18=1M", "09:00-25:00=1M", "09:00-18:00=fast"} {
+		if _, err := ParseRateSchedule(spec); err == nil {
+			t.Errorf("ParseRateSchedule(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+// TestScheduledBucket verifies the period of the current time overrides the
+// base rate, and that an unlimited period doesn't slow reads down.
+func TestScheduledBucket(t *testing.T) {
+	allDay, err := ParseRateSchedule("00:00-24:00=0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := NewScheduledBucket(1024, allDay)
+	if b == nil || b.Rate() != 0 {
+		t.Fatalf("bucket rate = %d, want unlimited", b.Rate())
+	}
+	start := time.Now()
+	io.Copy(io.Discard, NewSharedRateLimiter(bytes.NewReader(make([]byte, 1<<20)), b))
+	if elapsed := time.Since(start); elapsed > 100*time.Millisecond {
+		t.Errorf("unlimited period took %v", elapsed)
+	}
+
+	limited, _ := ParseRateSchedule("00:00-24:00=100K")
+	if rate := NewScheduledBucket(0, limited).Rate(); rate != 100<<10 {
+		t.Errorf("bucket rate = %d, want %d", rate, 100<<10)
+	}
+}
@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRotatingFile verifies the file is rotated before it grows past its
+// maximum size and only the configured number of backups is kept.
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "grab.log")
+
+	f, err := OpenRotatingFile(path, 10, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() error: %v", err)
+	}
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("Write(%q) error: %v", line, err)
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() error: %v", err)
+	}
+
+	want := map[string]string{
+		path:        "fourth\n",
+		path + ".1": "third\n",
+		path + ".2": "second\n",
+	}
+	for file, content := range want {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("ReadFile(%s) error: %v", file, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", file, data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("%s.3 exists, want only 2 backups", path)
+	}
+
+	// Appends to the existing file
+	f, err = OpenRotatingFile(path, 100, 2)
+	if err != nil {
+		t.Fatalf("OpenRotatingFile() again error: %v", err)
+	}
+	f.Write([]byte("fifth\n"))
+	f.Close()
+	if data, _ := os.ReadFile(path); string(data) != "fourth\nfifth\n" {
+		t.Errorf("reopened file = %q, want appended lines", data)
+	}
+}
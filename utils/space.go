@@ -0,0 +1,12 @@
+package utils
+
+import "errors"
+
+// ErrFreeSpaceUnsupported is returned when the free space of a file system can't be queried on this platform.
+var ErrFreeSpaceUnsupported = errors.New("querying free disk space is not supported")
+
+// FreeSpace returns the bytes available to the current user on the file
+// system holding path, which must exist.
+func FreeSpace(path string) (int64, error) {
+	return freeSpace(path)
+}
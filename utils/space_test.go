@@ -0,0 +1,19 @@
+package utils
+
+import (
+	"errors"
+	"testing"
+)
+
+// TestFreeSpace verifies the free space of the temp directory is reported where supported.
+func TestFreeSpace(t *testing.T) {
+	free, err := FreeSpace(t.TempDir())
+	if errors.Is(err, ErrFreeSpaceUnsupported) {
+		t.Skip("free space not supported here")
+	} else if err != nil {
+		t.Fatalf("FreeSpace() error: %v", err)
+	}
+	if free <= 0 {
+		t.Errorf("FreeSpace() = %d, want > 0", free)
+	}
+}
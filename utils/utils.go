@@ -5,6 +5,10 @@ import (
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
 )
 
 // FormatBytes converts bytes to human readable string
@@ -21,6 +25,20 @@ func FormatBytes(bytes int64) string {
 	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGTPE"[exp])
 }
 
+// FormatBitrate converts bits per second to human readable string
+func FormatBitrate(bps int64) string {
+	const unit = 1000
+	if bps < unit {
+		return fmt.Sprintf("%d bps", bps)
+	}
+	div, exp := int64(unit), 0
+	for n := bps / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cbps", float64(bps)/float64(div), "kMGTPE"[exp])
+}
+
 // FormatDuration formats a duration in seconds to a human-readable string
 func FormatDuration(seconds time.Duration) string {
 	if seconds < 0 {
@@ -36,21 +54,115 @@ func FormatDuration(seconds time.Duration) string {
 	return fmt.Sprintf("%02d:%02d", minutes, secs)
 }
 
-// SanitizeFilename removes invalid characters from filename
+var (
+	invalidFilenameChars = regexp.MustCompile(`[<>:"/\\|?*\x00-\x1f]`)
+	reservedFilenames    = regexp.MustCompile(`(?i)^(con|prn|aux|nul|com[0-9]|lpt[0-9])(\..*)?$`)
+)
+
+// SanitizeFilename removes invalid characters from filename and shortens it to
+// MaxFilenameBytes.
+// The result is also valid on Windows: control characters are replaced, trailing
+// dots and spaces are removed and reserved device names such as CON or NUL.txt
+// are prefixed with an underscore.
 func SanitizeFilename(filename string) string {
 	// Replace invalid characters with underscore
-	invalid := regexp.MustCompile(`[<>:"/\\|?*]`)
-	filename = invalid.ReplaceAllString(filename, "_")
+	filename = invalidFilenameChars.ReplaceAllString(filename, "_")
 
 	// Remove leading/trailing spaces and dots
 	filename = strings.Trim(filename, " .")
 
-	// Limit length to 255 characters (common filesystem limit)
-	if len(filename) > 255 {
-		filename = filename[:255]
+	// Windows refuses to create files named after devices, whatever the extension
+	if reservedFilenames.MatchString(filename) {
+		filename = "_" + filename
+	}
+
+	// Limit length, leaving room for the suffixes of downloads
+	return TruncateFilename(filename, MaxFilenameBytes)
+}
+
+// asciiFold spells letters that don't decompose into an ASCII letter and accents.
+var asciiFold = map[rune]string{
+	'ß': "ss", 'æ': "ae", 'Æ': "AE", 'œ': "oe", 'Œ': "OE", 'ø': "o", 'Ø': "O",
+	'đ': "d", 'Đ': "D", 'ł': "l", 'Ł': "L", 'þ': "th", 'Þ': "TH", 'ð': "d", 'Ð': "D",
+	'ı': "i", '‘': "'", '’': "'", '“': "\"", '”': "\"", '–': "-", '—': "-",
+}
+
+// restrictedFilenameChar reports whether r may appear in a restricted filename.
+func restrictedFilenameChar(r rune) bool {
+	return r < utf8.RuneSelf && (r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_')
+}
+
+// RestrictFilename makes filename safe for FAT, SMB shares and shell scripts:
+// accented letters are transliterated to ASCII, e.g. "é" to "e", and any other
+// character except ASCII letters, digits, '.', '-' and '_' is replaced with
+// replacement, runs of replacements collapsing into one and none being kept at
+// either end or before a dot. An empty replacement
+// strips those characters; one that isn't made of allowed characters is taken
+// as "_". The result is then sanitized by SanitizeFilename.
+func RestrictFilename(filename, replacement string) string {
+	if strings.IndexFunc(replacement, func(r rune) bool { return !restrictedFilenameChar(r) }) >= 0 {
+		replacement = "_"
+	}
+
+	var b strings.Builder
+	pending := false // A replacement is due before the next allowed character
+	for _, r := range norm.NFKD.String(filename) {
+		if unicode.Is(unicode.Mn, r) {
+			continue // Accent of the previous letter
+		}
+		s := string(r)
+		if folded, ok := asciiFold[r]; ok {
+			s = folded
+		}
+		for _, c := range s {
+			if restrictedFilenameChar(c) {
+				if pending && b.Len() > 0 && c != '.' {
+					b.WriteString(replacement)
+				}
+				pending = false
+				b.WriteRune(c)
+			} else {
+				pending = true
+			}
+		}
+	}
+	return SanitizeFilename(b.String())
+}
+
+// maxFilenameBytes is the file name length limit of most filesystems, in bytes.
+const maxFilenameBytes = 255
+
+// MaxFilenameBytes is the longest name SanitizeFilename returns, leaving room
+// under maxFilenameBytes for what downloads append to it: a " (N)" number,
+// then the suffix of a temporary file such as ".part.state" or ".remux.ffconcat".
+const MaxFilenameBytes = maxFilenameBytes - 24
+
+// TruncateFilename normalizes filename to Unicode NFC, so the same title yields
+// the same bytes on macOS and Linux, then shortens it to at most maxBytes bytes.
+// Truncation happens on rune boundaries and keeps the extension when possible.
+func TruncateFilename(filename string, maxBytes int) string {
+	filename = norm.NFC.String(filename)
+	if len(filename) <= maxBytes {
+		return filename
 	}
 
-	return filename
+	ext := FileExtension(filename)
+	if len(ext) > maxBytes/2 || !utf8.ValidString(ext) {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(filename, ext)
+	limit := maxBytes - len(ext)
+	cut := 0
+	for i := range stem {
+		if i > limit {
+			break
+		}
+		cut = i
+	}
+	if len(stem) <= limit {
+		cut = len(stem)
+	}
+	return strings.TrimRight(stem[:cut], " .") + ext
 }
 
 // FileExtension returns the file extension (including the dot) from a filename or URL.
@@ -29,6 +29,25 @@ func TestFormatBytes(t *testing.T) {
 	}
 }
 
+// TestFormatBitrate verifies FormatBitrate uses decimal units.
+func TestFormatBitrate(t *testing.T) {
+	tests := []struct {
+		input int64
+		want  string
+	}{
+		{0, "0 bps"},
+		{999, "999 bps"},
+		{128000, "128.0 kbps"},
+		{2500000, "2.5 Mbps"},
+	}
+	for _, tt := range tests {
+		got := FormatBitrate(tt.input)
+		if got != tt.want {
+			t.Errorf("FormatBitrate(%d) = %q, want %q", tt.input, got, tt.want)
+		}
+	}
+}
+
 // TestFormatDuration verifies FormatDuration returns correct time strings.
 func TestFormatDuration(t *testing.T) {
 	tests := []struct {
@@ -60,7 +79,13 @@ func TestSanitizeFilename(t *testing.T) {
 		{"a<b>c:d|e?f*g.txt", "a_b_c_d_e_f_g.txt"},
 		{"  foo.txt ", "foo.txt"},
 		{"...bar...", "bar"},
-		{strings.Repeat("a", 300), strings.Repeat("a", 255)},
+		{strings.Repeat("a", 300), strings.Repeat("a", MaxFilenameBytes)},
+		{"CON", "_CON"},
+		{"nul.txt", "_nul.txt"},
+		{"com1.mp4", "_com1.mp4"},
+		{"console.mp4", "console.mp4"},
+		{"lesson. ", "lesson"},
+		{"tab\there", "tab_here"},
 	}
 	for _, tt := range tests {
 		got := SanitizeFilename(tt.input)
@@ -70,6 +95,32 @@ func TestSanitizeFilename(t *testing.T) {
 	}
 }
 
+// TestRestrictFilename verifies RestrictFilename keeps only portable ASCII characters.
+func TestRestrictFilename(t *testing.T) {
+	tests := []struct {
+		input       string
+		replacement string
+		want        string
+	}{
+		{"abc.txt", "_", "abc.txt"},
+		{"Café Olé.mp4", "_", "Cafe_Ole.mp4"},
+		{"Straße & Smørrebrød.mp4", "_", "Strasse_Smorrebrod.mp4"},
+		{"a  b;$(rm -rf).mp4", "_", "a_b_rm_-rf.mp4"},
+		{"第1集 开始.mp4", "_", "1.mp4"},
+		{"第1集 开始 第2集.mp4", "-", "1-2.mp4"},
+		{"  \"quoted\" name ", "", "quotedname"},
+		{"x y.mp4", "/", "x_y.mp4"},
+		{"ﬁle.txt", "_", "file.txt"},
+		{"CON", "_", "_CON"},
+	}
+	for _, tt := range tests {
+		got := RestrictFilename(tt.input, tt.replacement)
+		if got != tt.want {
+			t.Errorf("RestrictFilename(%q, %q) = %q, want %q", tt.input, tt.replacement, got, tt.want)
+		}
+	}
+}
+
 // TestFileExtension verifies GetFileExtension extracts the extension correctly.
 func TestFileExtension(t *testing.T) {
 	tests := []struct {
@@ -126,3 +177,29 @@ func TestExtractDomain(t *testing.T) {
 		}
 	}
 }
+
+// TestTruncateFilename verifies TruncateFilename normalizes to NFC and truncates on rune boundaries.
+func TestTruncateFilename(t *testing.T) {
+	tests := []struct {
+		input    string
+		maxBytes int
+		want     string
+	}{
+		{"abc.mp4", 255, "abc.mp4"},
+		{"e\u0301.mp4", 255, "\u00e9.mp4"},
+		{"abcdefgh.mp4", 8, "abcd.mp4"},
+		{"中文标题.mp4", 9, "中.mp4"},
+		{"中文标题.mp4", 10, "中文.mp4"},
+		{"abc. d.mp4", 9, "abc.mp4"},
+		{strings.Repeat("课", 100) + ".pdf", 255, strings.Repeat("课", 83) + ".pdf"},
+	}
+	for _, tt := range tests {
+		got := TruncateFilename(tt.input, tt.maxBytes)
+		if got != tt.want {
+			t.Errorf("TruncateFilename(%q, %d) = %q, want %q", tt.input, tt.maxBytes, got, tt.want)
+		}
+		if len(got) > tt.maxBytes {
+			t.Errorf("TruncateFilename(%q, %d) = %q exceeds %d bytes", tt.input, tt.maxBytes, got, tt.maxBytes)
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package utils
+
+import "errors"
+
+// ErrXattrUnsupported is returned when the platform or file system has no extended attributes.
+var ErrXattrUnsupported = errors.New("extended attributes are not supported")
+
+// SetXattrs sets the extended attributes of the file at path, names given
+// without the "user." namespace prefix required on Linux.
+func SetXattrs(path string, attrs map[string]string) error {
+	for name, value := range attrs {
+		if err := setXattr(path, "user."+name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Xattr returns the extended attribute name of the file at path, without "user." prefix.
+func Xattr(path, name string) (string, error) {
+	return getXattr(path, "user."+name)
+}
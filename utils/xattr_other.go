@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package utils
+
+func setXattr(path, name, value string) error {
+	return ErrXattrUnsupported
+}
+
+func getXattr(path, name string) (string, error) {
+	return "", ErrXattrUnsupported
+}
@@ -0,0 +1,31 @@
+package utils
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestSetXattrs verifies attributes round-trip where the file system supports them.
+func TestSetXattrs(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "video.mp4")
+	if err := os.WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := SetXattrs(path, map[string]string{"xdg.origin.url": "https://example.com/video/1"})
+	if errors.Is(err, ErrXattrUnsupported) {
+		t.Skip("extended attributes not supported here")
+	} else if err != nil {
+		t.Fatalf("SetXattrs() error: %v", err)
+	}
+
+	got, err := Xattr(path, "xdg.origin.url")
+	if err != nil {
+		t.Fatalf("Xattr() error: %v", err)
+	}
+	if got != "https://example.com/video/1" {
+		t.Errorf("Xattr() = %q, want %q", got, "https://example.com/video/1")
+	}
+}
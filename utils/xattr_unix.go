@@ -0,0 +1,32 @@
+//go:build linux || darwin
+
+package utils
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+func setXattr(path, name, value string) error {
+	err := unix.Setxattr(path, name, []byte(value), 0)
+	if errors.Is(err, unix.ENOTSUP) {
+		return ErrXattrUnsupported
+	}
+	return err
+}
+
+func getXattr(path, name string) (string, error) {
+	size, err := unix.Getxattr(path, name, nil)
+	if errors.Is(err, unix.ENOTSUP) {
+		return "", ErrXattrUnsupported
+	} else if err != nil {
+		return "", err
+	}
+	buf := make([]byte, size)
+	size, err = unix.Getxattr(path, name, buf)
+	if err != nil {
+		return "", err
+	}
+	return string(buf[:size]), nil
+}
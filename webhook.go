@@ -0,0 +1,177 @@
+package grab
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/go-resty/resty/v2"
+)
+
+// WebhookEvent is the JSON payload POSTed to the webhook URL.
+type WebhookEvent struct {
+	Event   string    `json:"event"` // "started", "progress", "finished" or "failed"
+	Job     string    `json:"job"`   // Stream ID
+	Media   string    `json:"media"`
+	Title   string    `json:"title"`
+	Current int64     `json:"current"`
+	Total   int64     `json:"total"`
+	Error   string    `json:"error,omitempty"`
+	Time    time.Time `json:"time"`
+}
+
+const (
+	webhookStarted  = "started"
+	webhookProgress = "progress"
+	webhookFinished = "finished"
+	webhookFailed   = "failed"
+)
+
+// webhook posts job events to a URL. Bodies are signed with HMAC-SHA256 in the
+// X-Grab-Signature header when a secret is configured.
+type webhook struct {
+	url      string
+	secret   []byte
+	interval time.Duration
+	client   *resty.Client
+	logger   *slog.Logger
+
+	mu   sync.Mutex
+	jobs map[string]*WebhookEvent // Last progress of running jobs, keyed by stream ID
+}
+
+// newWebhook returns nil if no webhook URL is configured.
+func newWebhook(o Option, logger *slog.Logger) *webhook {
+	if o.WebhookURL == "" {
+		return nil
+	}
+	interval := o.WebhookInterval
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return &webhook{
+		url:      o.WebhookURL,
+		secret:   []byte(o.WebhookSecret),
+		interval: interval,
+		client:   newWebhookClient(o),
+		logger:   logger,
+		jobs:     make(map[string]*WebhookEvent),
+	}
+}
+
+// newWebhookClient returns a client going through the proxy of the downloads,
+// see newClient, without their credentials, cookies and headers, which are
+// meant for the sites downloaded from.
+func newWebhookClient(o Option) *resty.Client {
+	o.AuthUser, o.AuthPass, o.AuthToken, o.AuthHeader = "", "", "", ""
+	o.Headers = nil
+	o.Cookie = ""
+	o.Netrc = false
+	o.GeoBypassCountry = ""
+	o.RetryCount = 0
+	o.Timeout = 10 * time.Second
+	return newClient(o)
+}
+
+// run posts progress snapshots of running jobs every interval until ctx is done.
+func (w *webhook) run(ctx context.Context) {
+	if w == nil {
+		return
+	}
+	ticker := time.NewTicker(w.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			w.mu.Lock()
+			snapshots := make([]WebhookEvent, 0, len(w.jobs))
+			for _, job := range w.jobs {
+				snapshots = append(snapshots, *job)
+			}
+			w.mu.Unlock()
+			for _, event := range snapshots {
+				event.Event = webhookProgress
+				w.post(event)
+			}
+		}
+	}
+}
+
+// started registers a job and posts its started event.
+func (w *webhook) started(media Media, stream Stream) {
+	if w == nil {
+		return
+	}
+	event := &WebhookEvent{Job: stream.ID, Media: media.Title, Title: stream.Title, Total: stream.Size}
+	w.mu.Lock()
+	w.jobs[stream.ID] = event
+	w.mu.Unlock()
+	snapshot := *event
+	snapshot.Event = webhookStarted
+	w.post(snapshot)
+}
+
+// progress records the latest progress of a job, sent with the next snapshot.
+func (w *webhook) progress(stream Stream, current, total int64) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if job, ok := w.jobs[stream.ID]; ok {
+		job.Current, job.Total = current, total
+	}
+}
+
+// done unregisters a job and posts its finished or failed event.
+func (w *webhook) done(stream Stream, err error) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	job, ok := w.jobs[stream.ID]
+	delete(w.jobs, stream.ID)
+	w.mu.Unlock()
+	if !ok {
+		return
+	}
+	event := *job
+	event.Event = webhookFinished
+	if err != nil {
+		event.Event = webhookFailed
+		event.Error = err.Error()
+	} else {
+		event.Current = max(event.Current, event.Total)
+	}
+	w.post(event)
+}
+
+// post sends a single event, logging instead of failing the download on errors.
+func (w *webhook) post(event WebhookEvent) {
+	event.Time = time.Now()
+	body, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	req := w.client.R().
+		SetHeader("Content-Type", "application/json").
+		SetBody(body)
+	if len(w.secret) > 0 {
+		mac := hmac.New(sha256.New, w.secret)
+		mac.Write(body)
+		req.SetHeader("X-Grab-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+	resp, err := req.Post(w.url)
+	if err != nil {
+		w.logger.Warn("Failed to post webhook event", "event", event.Event, "error", err)
+	} else if resp.IsError() {
+		w.logger.Warn("Webhook rejected event", "event", event.Event, "status", resp.Status())
+	}
+}
@@ -0,0 +1,42 @@
+package grab
+
+import (
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestWebhookProxy verifies events go through the proxy of the downloads,
+// without the credentials and headers meant for the sites.
+func TestWebhookProxy(t *testing.T) {
+	requests := make(chan *http.Request, 1)
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests <- r
+	}))
+	defer proxy.Close()
+
+	o := Option{
+		Proxy:      proxy.URL,
+		WebhookURL: "http://webhook.invalid/events",
+		AuthToken:  "site-token",
+		AuthHeader: "X-API-Key: site-key",
+		Headers:    http.Header{"Cookie": {"session=site"}},
+	}
+	w := newWebhook(o, slog.New(slog.DiscardHandler))
+	w.post(WebhookEvent{Event: webhookStarted, Job: "hd"})
+
+	select {
+	case r := <-requests:
+		if r.URL.String() != o.WebhookURL {
+			t.Errorf("proxy got a request for %s, want %s", r.URL, o.WebhookURL)
+		}
+		for _, name := range []string{"Authorization", "X-API-Key", "Cookie"} {
+			if value := r.Header.Get(name); value != "" {
+				t.Errorf("webhook request has %s: %s, want none", name, value)
+			}
+		}
+	default:
+		t.Error("webhook event didn't go through the proxy")
+	}
+}